@@ -0,0 +1,453 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+var brandColorRe = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// parseOptions parses command line and environment into options. If a config file is set with --config,
+// its values become option defaults and the arguments are parsed again, so the precedence is
+// command line > environment > config file > built-in defaults.
+// The returned parser is the one that produced the options, with the active subcommand, if any.
+func parseOptions(args []string) (options, *flags.Parser, error) {
+	var res options
+	p := newParser(&res)
+	if _, err := p.ParseArgs(args); err != nil {
+		return options{}, p, err
+	}
+	if res.Config == "" {
+		return res, p, nil
+	}
+
+	configFile := res.Config
+	values, err := readConfigFile(configFile)
+	if err != nil {
+		return options{}, p, err
+	}
+
+	res = options{}
+	p = newParser(&res)
+	if err := applyConfigDefaults(p, values); err != nil {
+		return options{}, p, fmt.Errorf("invalid config file %s: %w", configFile, err)
+	}
+	if _, err := p.ParseArgs(args); err != nil {
+		return options{}, p, err
+	}
+	return res, p, nil
+}
+
+// newParser makes command line parser for the given options
+func newParser(o *options) *flags.Parser {
+	p := flags.NewParser(o, flags.PrintErrors|flags.PassDoubleDash|flags.HelpFlag)
+	p.SubcommandsOptional = true
+	return p
+}
+
+// readConfigFile reads a YAML or TOML config file, picked by extension, into a flat map keyed by
+// long option names with namespaces, e.g. "sftp.enabled". Nested sections map to option groups.
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // config file path comes from the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q, use .yml, .yaml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	res := map[string]any{}
+	flattenConfig("", raw, res)
+	return res, nil
+}
+
+// flattenConfig copies nested config sections into res with keys joined by dots
+func flattenConfig(prefix string, src, res map[string]any) {
+	for k, v := range src {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]any); ok {
+			flattenConfig(key, nested, res)
+			continue
+		}
+		res[key] = v
+	}
+}
+
+// applyConfigDefaults sets config file values as option defaults. Unknown keys are rejected to catch typos.
+func applyConfigDefaults(p *flags.Parser, values map[string]any) error {
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		opt := p.FindOptionByLongName(key)
+		if opt == nil || key == "config" {
+			errs = append(errs, fmt.Errorf("unknown option %q", key))
+			continue
+		}
+		opt.Default = configValues(values[key])
+	}
+	return errors.Join(errs...)
+}
+
+// configValues converts a config value to the string form used by option defaults, lists become multiple values
+func configValues(v any) []string {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		res := make([]string, 0, len(val))
+		for _, item := range val {
+			res = append(res, fmt.Sprint(item))
+		}
+		return res
+	default:
+		return []string{fmt.Sprint(val)}
+	}
+}
+
+// validateOptions checks options up front and reports all problems at once
+func validateOptions(o *options) error {
+	var errs []error
+
+	// an invalid theme is not fatal, the default one is used instead
+	if o.Theme != "light" && o.Theme != "dark" {
+		log.Printf("[WARN] invalid theme %q, using light instead", o.Theme)
+		o.Theme = "light"
+	}
+	if o.HTMLMode != "sanitize" && o.HTMLMode != "sandbox" && o.HTMLMode != "text" {
+		errs = append(errs, fmt.Errorf("invalid html mode %q, must be sanitize, sandbox or text", o.HTMLMode))
+	}
+	if o.Branding.Color != "" && !brandColorRe.MatchString(o.Branding.Color) {
+		errs = append(errs, fmt.Errorf("invalid brand color %q, must be a hex color like 3498db or #3498db", o.Branding.Color))
+	}
+
+	if err := validateListenAddr(o.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address: %w", err))
+	}
+	if o.Metrics.Listen != "" {
+		if err := validateListenAddr(o.Metrics.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("invalid metrics listen address: %w", err))
+		}
+	}
+
+	// mounts replace the root directory, a bucket is checked on start only
+	switch {
+	case len(o.Mounts) > 0:
+		errs = append(errs, validateMounts(o)...)
+	case isS3Location(o.RootDir):
+		if _, _, err := parseS3Location(o.RootDir); err != nil {
+			errs = append(errs, fmt.Errorf("invalid root directory: %w", err))
+		}
+	case isGitLocation(o.RootDir):
+		repoPath, _, err := parseGitLocation(o.RootDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid root directory: %w", err))
+		} else if _, err := os.Stat(repoPath); err != nil {
+			errs = append(errs, fmt.Errorf("invalid root directory: %w", err))
+		}
+		if o.Upload.Enabled {
+			errs = append(errs, errors.New("upload is not supported for a git repository root"))
+		}
+	default:
+		if st, err := os.Stat(o.RootDir); err != nil {
+			errs = append(errs, fmt.Errorf("invalid root directory: %w", err))
+		} else if !st.IsDir() {
+			errs = append(errs, fmt.Errorf("invalid root directory: %s is not a directory", o.RootDir))
+		}
+	}
+
+	if o.SessionTTL <= 0 {
+		errs = append(errs, fmt.Errorf("session ttl must be positive, got %v", o.SessionTTL))
+	}
+	if o.RecursiveMtimeRefresh < 0 {
+		errs = append(errs, fmt.Errorf("recursive mtime refresh must not be negative, got %v", o.RecursiveMtimeRefresh))
+	}
+	if o.Upload.Enabled && o.Upload.MaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("upload max size must be positive, got %d", o.Upload.MaxSize))
+	}
+	if o.Audit.Enabled && o.Audit.MaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("audit max size must be positive, got %d", o.Audit.MaxSize))
+	}
+
+	errs = append(errs, validateTLS(o)...)
+	errs = append(errs, validateOIDC(o)...)
+	errs = append(errs, validateProxyAuth(o)...)
+	errs = append(errs, validateTOTP(o)...)
+	errs = append(errs, validateSessions(o)...)
+	errs = append(errs, validateLockout(o)...)
+	errs = append(errs, validateAccess(o)...)
+	if o.Throttle.Rate < 0 || o.Throttle.TotalRate < 0 || o.Throttle.MaxDownloads < 0 {
+		errs = append(errs, errors.New("throttle rates and max downloads must not be negative"))
+	}
+	if o.Compress.Enabled && o.Compress.MinSize < 0 {
+		errs = append(errs, fmt.Errorf("compression min size must not be negative, got %d", o.Compress.MinSize))
+	}
+
+	if o.SFTP.Enabled {
+		if err := validateListenAddr(o.SFTP.Address); err != nil {
+			errs = append(errs, fmt.Errorf("invalid SFTP address: %w", err))
+		}
+		if o.SFTP.User == "" {
+			errs = append(errs, errors.New("SFTP username (--sftp.user) is required for SFTP server"))
+		}
+		// for SFTP, either a password or an authorized_keys file must be provided
+		if o.Auth == "" && o.SFTP.Authorized == "" {
+			errs = append(errs, errors.New("either password (-a/--auth) or authorized keys file (--sftp.authorized) is required for SFTP server"))
+		}
+		if o.SFTP.Authorized != "" {
+			if _, err := os.Stat(o.SFTP.Authorized); err != nil {
+				errs = append(errs, fmt.Errorf("invalid SFTP authorized keys file: %w", err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateTLS checks TLS options, the certificate itself is loaded on start
+func validateTLS(o *options) (errs []error) {
+	tlsEnabled := o.TLS.Cert != "" || o.TLS.SelfSigned
+	switch {
+	case o.TLS.Cert != "" && o.TLS.SelfSigned:
+		errs = append(errs, errors.New("TLS certificate (--tls.cert) and self-signed certificate (--tls.self-signed) are mutually exclusive"))
+	case (o.TLS.Cert == "") != (o.TLS.Key == ""):
+		errs = append(errs, errors.New("both TLS certificate (--tls.cert) and key (--tls.key) are required"))
+	}
+	for _, f := range []string{o.TLS.Cert, o.TLS.Key, o.TLS.ClientCA} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			errs = append(errs, fmt.Errorf("invalid TLS file: %w", err))
+		}
+	}
+	if o.TLS.Redirect != "" {
+		if !tlsEnabled {
+			errs = append(errs, errors.New("HTTPS redirect (--tls.redirect) requires TLS certificate or self-signed certificate"))
+		} else if err := validateListenAddr(o.TLS.Redirect); err != nil {
+			errs = append(errs, fmt.Errorf("invalid TLS redirect address: %w", err))
+		}
+	}
+	if o.TLS.ClientCA != "" && !tlsEnabled {
+		errs = append(errs, errors.New("client CA (--tls.client-ca) requires TLS certificate or self-signed certificate"))
+	}
+	return errs
+}
+
+// validateOIDC checks OIDC options, the provider itself is discovered on start
+func validateOIDC(o *options) (errs []error) {
+	if o.OIDC.Issuer == "" {
+		return nil
+	}
+	if u, err := url.Parse(o.OIDC.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid OIDC issuer %q, expected http(s) URL", o.OIDC.Issuer))
+	}
+	if o.OIDC.ClientID == "" {
+		errs = append(errs, errors.New("OIDC client ID (--oidc.client-id) is required for OIDC login"))
+	}
+	if o.OIDC.RedirectURL != "" {
+		if u, err := url.Parse(o.OIDC.RedirectURL); err != nil || u.Host == "" || u.Path != "/oidc/callback" {
+			errs = append(errs, fmt.Errorf("invalid OIDC redirect URL %q, expected http(s)://host/oidc/callback", o.OIDC.RedirectURL))
+		}
+	}
+	return errs
+}
+
+// validateProxyAuth checks trusted proxies parse and the headers are set
+func validateProxyAuth(o *options) (errs []error) {
+	if len(o.ProxyAuth.Trusted) == 0 {
+		if len(o.ProxyAuth.Groups) > 0 {
+			errs = append(errs, errors.New("proxy auth groups require trusted proxy (--proxy-auth.trusted)"))
+		}
+		return errs
+	}
+	for _, t := range o.ProxyAuth.Trusted {
+		if !isAddrOrCIDR(t) {
+			errs = append(errs, fmt.Errorf("invalid trusted proxy %q, expected IP address or CIDR", t))
+		}
+	}
+	if o.ProxyAuth.UserHeader == "" {
+		errs = append(errs, errors.New("user header (--proxy-auth.user-header) is required for proxy auth"))
+	}
+	if len(o.ProxyAuth.Groups) > 0 && o.ProxyAuth.GroupsHeader == "" {
+		errs = append(errs, errors.New("groups header (--proxy-auth.groups-header) is required to check allowed groups"))
+	}
+	return errs
+}
+
+// accessCapabilities are capabilities address rules can be set for
+var accessCapabilities = []string{"browse", "download", "upload", "api", "sftp"}
+
+// validateAccess checks address rules and trusted proxies parse
+func validateAccess(o *options) (errs []error) {
+	rules := slices.Concat(o.Access.Allow, o.Access.Deny)
+	for _, rule := range rules {
+		capability, cidr, found := strings.Cut(rule, "=")
+		if !found {
+			capability, cidr = "", rule
+		}
+		if capability != "" && !slices.Contains(accessCapabilities, capability) {
+			errs = append(errs, fmt.Errorf("invalid access rule %q, capability must be one of %s", rule, strings.Join(accessCapabilities, ", ")))
+			continue
+		}
+		if !isAddrOrCIDR(cidr) {
+			errs = append(errs, fmt.Errorf("invalid access rule %q, expected IP address or CIDR", rule))
+		}
+	}
+	for _, t := range o.Access.Trusted {
+		if !isAddrOrCIDR(t) {
+			errs = append(errs, fmt.Errorf("invalid trusted proxy %q, expected IP address or CIDR", t))
+		}
+	}
+	return errs
+}
+
+// isAddrOrCIDR checks the string is an IP address or CIDR
+func isAddrOrCIDR(s string) bool {
+	if strings.Contains(s, "/") {
+		_, err := netip.ParsePrefix(s)
+		return err == nil
+	}
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// validateTOTP checks 2FA has a password login to protect and a directory to keep the file in
+func validateTOTP(o *options) (errs []error) {
+	if o.TOTP.File == "" {
+		return nil
+	}
+	if o.Auth == "" {
+		errs = append(errs, errors.New("2FA (--totp.file) requires password (-a/--auth)"))
+	}
+	if st, err := os.Stat(filepath.Dir(o.TOTP.File)); err != nil || !st.IsDir() {
+		errs = append(errs, fmt.Errorf("invalid 2FA file %q, directory doesn't exist", o.TOTP.File))
+	}
+	if o.SFTP.Enabled && o.SFTP.Authorized == "" {
+		errs = append(errs, errors.New("SFTP password login is disabled with 2FA, authorized keys file (--sftp.authorized) is required"))
+	}
+	return errs
+}
+
+// validateSessions checks the session store has logins to track and a directory to keep the file in
+func validateSessions(o *options) (errs []error) {
+	if !o.Sessions.Enabled {
+		if o.Sessions.File != "" || len(o.Sessions.Admins) > 0 {
+			errs = append(errs, errors.New("session options require session store (--sessions.enabled)"))
+		}
+		return errs
+	}
+	if o.Auth == "" && o.OIDC.Issuer == "" {
+		errs = append(errs, errors.New("session store (--sessions.enabled) requires password (-a/--auth) or OIDC login"))
+	}
+	if o.Sessions.File != "" {
+		if st, err := os.Stat(filepath.Dir(o.Sessions.File)); err != nil || !st.IsDir() {
+			errs = append(errs, fmt.Errorf("invalid sessions file %q, directory doesn't exist", o.Sessions.File))
+		}
+	}
+	return errs
+}
+
+// validateLockout checks brute-force thresholds, zero values fall back to defaults
+func validateLockout(o *options) (errs []error) {
+	if o.Lockout.IPFailures < 0 || o.Lockout.UserFailures < 0 {
+		errs = append(errs, errors.New("lockout failures (--lockout.ip-failures, --lockout.user-failures) must not be negative"))
+	}
+	if o.Lockout.Window < 0 || o.Lockout.Duration < 0 || o.Lockout.Delay < 0 {
+		errs = append(errs, errors.New("lockout window, duration and delay must not be negative"))
+	}
+	return errs
+}
+
+// validateListenAddr checks the address is in host:port form with a valid port
+func validateListenAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%q: invalid port %q", addr, port)
+	}
+	return nil
+}
+
+// isConfigCheck returns true if the active subcommand is "config check"
+func isConfigCheck(p *flags.Parser) bool {
+	if p.Active == nil || p.Active.Name != "config" || p.Active.Active == nil {
+		return false
+	}
+	return p.Active.Active.Name == "check"
+}
+
+// configCheck validates options for the "config check" subcommand and prints the result
+func configCheck(o *options) error {
+	if err := validateOptions(o); err != nil {
+		return err
+	}
+	src := "command line and environment"
+	if o.Config != "" {
+		src = o.Config + ", " + src
+	}
+	fmt.Printf("configuration is valid (%s)\n", src)
+	if enabled := enabledFeatures(o); len(enabled) > 0 {
+		fmt.Printf("enabled: %s\n", strings.Join(enabled, ", "))
+	}
+	return nil
+}
+
+// enabledFeatures lists optional features turned on in options
+func enabledFeatures(o *options) []string {
+	features := map[string]bool{
+		"auth":             o.Auth != "",
+		"oidc":             o.OIDC.Issuer != "",
+		"proxy-auth":       len(o.ProxyAuth.Trusted) > 0,
+		"2fa":              o.TOTP.File != "",
+		"sessions":         o.Sessions.Enabled,
+		"access-rules":     len(o.Access.Allow) > 0 || len(o.Access.Deny) > 0,
+		"throttle":         o.Throttle.Rate > 0 || o.Throttle.TotalRate > 0 || o.Throttle.MaxDownloads > 0,
+		"compress":         o.Compress.Enabled,
+		"tls":              o.TLS.Cert != "" || o.TLS.SelfSigned,
+		"mtls":             o.TLS.ClientCA != "",
+		"sftp":             o.SFTP.Enabled,
+		"upload":           o.Upload.Enabled,
+		"metrics":          o.Metrics.Enabled,
+		"audit":            o.Audit.Enabled,
+		"multi-select":     o.EnableMultiSelect,
+		"syntax-highlight": o.EnableSyntaxHighlighting,
+		"recursive-mtime":  o.RecursiveMtime,
+	}
+	res := []string{}
+	for name, on := range features {
+		if on {
+			res = append(res, name)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
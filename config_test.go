@@ -0,0 +1,295 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	writeConfig := func(t *testing.T, name, content string) string {
+		t.Helper()
+		file := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+		return file
+	}
+
+	yamlConfig := `
+listen: ":9090"
+theme: dark
+exclude: [".git", "node_modules"]
+session-ttl: 2h
+hide-footer: true
+sftp:
+  enabled: true
+  user: bob
+upload:
+  enabled: true
+  max-size: 16
+brand:
+  name: Acme
+  color: "3498db"
+`
+
+	t.Run("without config file", func(t *testing.T) {
+		o, p, err := parseOptions([]string{"--theme", "dark"})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", o.Theme)
+		assert.Equal(t, ":8080", o.Listen)
+		assert.Equal(t, 24*time.Hour, o.SessionTTL)
+		assert.False(t, isConfigCheck(p))
+	})
+
+	t.Run("yaml config file", func(t *testing.T) {
+		file := writeConfig(t, "weblist.yml", yamlConfig)
+		o, _, err := parseOptions([]string{"--config", file})
+		require.NoError(t, err)
+		assert.Equal(t, ":9090", o.Listen)
+		assert.Equal(t, "dark", o.Theme)
+		assert.Equal(t, []string{".git", "node_modules"}, o.Exclude)
+		assert.Equal(t, 2*time.Hour, o.SessionTTL)
+		assert.True(t, o.HideFooter)
+		assert.True(t, o.SFTP.Enabled)
+		assert.Equal(t, "bob", o.SFTP.User)
+		assert.Equal(t, ":2022", o.SFTP.Address, "built-in default for keys not in the file")
+		assert.True(t, o.Upload.Enabled)
+		assert.Equal(t, int64(16), o.Upload.MaxSize)
+		assert.Equal(t, "Acme", o.Branding.Name)
+		assert.Equal(t, "3498db", o.Branding.Color)
+	})
+
+	t.Run("toml config file", func(t *testing.T) {
+		file := writeConfig(t, "weblist.toml", `
+theme = "dark"
+exclude = [".git"]
+
+[metrics]
+enabled = true
+listen = "127.0.0.1:9100"
+`)
+		o, _, err := parseOptions([]string{"--config", file})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", o.Theme)
+		assert.Equal(t, []string{".git"}, o.Exclude)
+		assert.True(t, o.Metrics.Enabled)
+		assert.Equal(t, "127.0.0.1:9100", o.Metrics.Listen)
+	})
+
+	t.Run("env and flags take precedence over config file", func(t *testing.T) {
+		file := writeConfig(t, "weblist.yaml", yamlConfig)
+		t.Setenv("THEME", "light")
+		t.Setenv("CONFIG", file)
+		o, _, err := parseOptions([]string{"--listen", ":7070", "--exclude", "vendor", "--sftp.user", "alice"})
+		require.NoError(t, err)
+		assert.Equal(t, ":7070", o.Listen, "flag overrides file")
+		assert.Equal(t, "light", o.Theme, "env overrides file")
+		assert.Equal(t, []string{"vendor"}, o.Exclude, "flag replaces the list from file")
+		assert.Equal(t, "alice", o.SFTP.User)
+		assert.Equal(t, "Acme", o.Branding.Name, "file value kept")
+	})
+
+	t.Run("config check subcommand", func(t *testing.T) {
+		_, p, err := parseOptions([]string{"--theme", "dark", "config", "check"})
+		require.NoError(t, err)
+		assert.True(t, isConfigCheck(p))
+	})
+
+	t.Run("unknown keys", func(t *testing.T) {
+		file := writeConfig(t, "weblist.yml", "thme: dark\nsftp:\n  enable: true\nconfig: other.yml\n")
+		_, _, err := parseOptions([]string{"--config", file})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown option "thme"`)
+		assert.Contains(t, err.Error(), `unknown option "sftp.enable"`)
+		assert.Contains(t, err.Error(), `unknown option "config"`)
+	})
+
+	t.Run("bad values", func(t *testing.T) {
+		file := writeConfig(t, "weblist.yml", "session-ttl: forever\n")
+		_, _, err := parseOptions([]string{"--config", file})
+		require.Error(t, err)
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		file := writeConfig(t, "weblist.json", "{}")
+		_, _, err := parseOptions([]string{"--config", file})
+		require.ErrorContains(t, err, "unsupported config file format")
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, _, err := parseOptions([]string{"--config", filepath.Join(t.TempDir(), "missing.yml")})
+		require.ErrorContains(t, err, "failed to read config file")
+	})
+
+	t.Run("malformed file", func(t *testing.T) {
+		file := writeConfig(t, "weblist.yml", "theme: [dark\n")
+		_, _, err := parseOptions([]string{"--config", file})
+		require.ErrorContains(t, err, "failed to parse config file")
+	})
+}
+
+func TestValidateOptions(t *testing.T) {
+	rootDir := t.TempDir()
+	keysFile := filepath.Join(rootDir, "authorized_keys")
+	require.NoError(t, os.WriteFile(keysFile, nil, 0o600))
+
+	valid := func() options {
+		var o options
+		o.Listen = ":8080"
+		o.Theme = "light"
+		o.HTMLMode = "sanitize"
+		o.RootDir = rootDir
+		o.SessionTTL = time.Hour
+		return o
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(o *options)
+		wantErr []string
+	}{
+		{name: "defaults", modify: func(*options) {}},
+		{name: "full config", modify: func(o *options) {
+			o.Theme = "dark"
+			o.Branding.Color = "#3498DB"
+			o.Metrics.Listen = "127.0.0.1:9100"
+			o.SFTP.Enabled, o.SFTP.User, o.SFTP.Address, o.SFTP.Authorized = true, "bob", ":2022", keysFile
+			o.Upload.Enabled, o.Upload.MaxSize = true, 64
+		}},
+		{name: "short brand color", modify: func(o *options) { o.Branding.Color = "fff" }},
+		{name: "bad html mode", modify: func(o *options) { o.HTMLMode = "raw" }, wantErr: []string{`invalid html mode "raw"`}},
+		{name: "bad brand color", modify: func(o *options) { o.Branding.Color = "blue" },
+			wantErr: []string{`invalid brand color "blue"`}},
+		{name: "bad listen", modify: func(o *options) { o.Listen = "8080" }, wantErr: []string{"invalid listen address"}},
+		{name: "bad port", modify: func(o *options) { o.Listen = ":http-alt" }, wantErr: []string{`invalid port "http-alt"`}},
+		{name: "bad metrics listen", modify: func(o *options) { o.Metrics.Listen = "localhost" },
+			wantErr: []string{"invalid metrics listen address"}},
+		{name: "missing root", modify: func(o *options) { o.RootDir = filepath.Join(rootDir, "missing") },
+			wantErr: []string{"invalid root directory"}},
+		{name: "root is a file", modify: func(o *options) { o.RootDir = keysFile }, wantErr: []string{"is not a directory"}},
+		{name: "mounts replace root", modify: func(o *options) {
+			o.RootDir = filepath.Join(rootDir, "missing")
+			o.Mounts = []string{"docs=" + rootDir}
+		}},
+		{name: "bad mount", modify: func(o *options) { o.Mounts = []string{"docs=" + keysFile} },
+			wantErr: []string{"invalid directory of mount docs"}},
+		{name: "s3 root", modify: func(o *options) { o.RootDir = "s3://releases/builds" }},
+		{name: "bad s3 root", modify: func(o *options) { o.RootDir = "s3:///builds" }, wantErr: []string{"invalid S3 location"}},
+		{name: "git root", modify: func(o *options) { o.RootDir = "git:" + rootDir + "@v1.0" }},
+		{name: "git root with upload", modify: func(o *options) {
+			o.RootDir, o.Upload.Enabled, o.Upload.MaxSize = "git:"+rootDir, true, 10
+		}, wantErr: []string{"upload is not supported for a git repository root"}},
+		{name: "bad git root", modify: func(o *options) { o.RootDir = "git:" + rootDir + "@" }, wantErr: []string{"empty ref"}},
+		{name: "s3 mount", modify: func(o *options) { o.Mounts = []string{"builds=s3://releases/builds", "docs=" + rootDir} }},
+		{name: "tls", modify: func(o *options) {
+			o.TLS.Cert, o.TLS.Key, o.TLS.ClientCA, o.TLS.Redirect = keysFile, keysFile, keysFile, ":80"
+		}},
+		{name: "self-signed tls", modify: func(o *options) { o.TLS.SelfSigned, o.TLS.Redirect = true, ":80" }},
+		{name: "tls cert without key", modify: func(o *options) { o.TLS.Cert = keysFile },
+			wantErr: []string{"both TLS certificate (--tls.cert) and key (--tls.key) are required"}},
+		{name: "tls cert and self-signed", modify: func(o *options) { o.TLS.Cert, o.TLS.Key, o.TLS.SelfSigned = keysFile, keysFile, true },
+			wantErr: []string{"mutually exclusive"}},
+		{name: "missing tls files", modify: func(o *options) {
+			o.TLS.Cert, o.TLS.Key = filepath.Join(rootDir, "missing.crt"), filepath.Join(rootDir, "missing.key")
+		}, wantErr: []string{"missing.crt", "missing.key"}},
+		{name: "tls options without tls", modify: func(o *options) { o.TLS.Redirect, o.TLS.ClientCA = ":80", keysFile },
+			wantErr: []string{"HTTPS redirect (--tls.redirect) requires", "client CA (--tls.client-ca) requires"}},
+		{name: "bad tls redirect", modify: func(o *options) { o.TLS.SelfSigned, o.TLS.Redirect = true, "80" },
+			wantErr: []string{"invalid TLS redirect address"}},
+		{name: "oidc", modify: func(o *options) {
+			o.OIDC.Issuer, o.OIDC.ClientID, o.OIDC.RedirectURL = "https://accounts.example.com", "weblist", "https://files.example.com/oidc/callback"
+		}},
+		{name: "bad oidc", modify: func(o *options) {
+			o.OIDC.Issuer, o.OIDC.RedirectURL = "accounts.example.com", "https://files.example.com/callback"
+		}, wantErr: []string{"invalid OIDC issuer", "client ID (--oidc.client-id) is required", "invalid OIDC redirect URL"}},
+		{name: "proxy auth", modify: func(o *options) {
+			o.ProxyAuth.Trusted, o.ProxyAuth.UserHeader = []string{"10.0.0.0/8", "::1"}, "X-Forwarded-User"
+		}},
+		{name: "bad proxy auth", modify: func(o *options) {
+			o.ProxyAuth.Trusted, o.ProxyAuth.Groups = []string{"10.0.0.0/40", "proxy"}, []string{"admins"}
+		},
+			wantErr: []string{`invalid trusted proxy "10.0.0.0/40"`, `invalid trusted proxy "proxy"`, "user header", "groups header"}},
+		{name: "proxy auth groups without trusted", modify: func(o *options) { o.ProxyAuth.Groups = []string{"admins"} },
+			wantErr: []string{"require trusted proxy"}},
+		{name: "2fa", modify: func(o *options) {
+			o.Auth, o.TOTP.File = "secret", filepath.Join(rootDir, "2fa.json")
+			o.SFTP.Enabled, o.SFTP.User, o.SFTP.Address, o.SFTP.Authorized = true, "bob", ":2022", keysFile
+		}},
+		{name: "bad 2fa", modify: func(o *options) {
+			o.TOTP.File = filepath.Join(rootDir, "missing", "2fa.json")
+			o.SFTP.Enabled, o.SFTP.User, o.SFTP.Address = true, "bob", ":2022"
+		}, wantErr: []string{"requires password", "directory doesn't exist", "authorized keys file (--sftp.authorized) is required"}},
+		{name: "sessions", modify: func(o *options) {
+			o.Auth, o.Sessions.Enabled, o.Sessions.File, o.Sessions.Admins = "secret", true, filepath.Join(rootDir, "sessions.json"), []string{"weblist"}
+		}},
+		{name: "bad sessions", modify: func(o *options) {
+			o.Sessions.Enabled, o.Sessions.File = true, filepath.Join(rootDir, "missing", "sessions.json")
+		}, wantErr: []string{"requires password (-a/--auth) or OIDC login", "invalid sessions file"}},
+		{name: "sessions options without store", modify: func(o *options) { o.Sessions.Admins = []string{"weblist"} },
+			wantErr: []string{"require session store"}},
+		{name: "lockout", modify: func(o *options) {
+			o.Lockout.IPFailures, o.Lockout.UserFailures, o.Lockout.Window, o.Lockout.Duration = 5, 10, time.Minute, time.Hour
+		}},
+		{name: "bad lockout", modify: func(o *options) { o.Lockout.IPFailures, o.Lockout.Delay = -1, -time.Second },
+			wantErr: []string{"lockout failures", "lockout window, duration and delay must not be negative"}},
+		{name: "access", modify: func(o *options) {
+			o.Access.Allow = []string{"10.0.0.0/8", "upload=10.1.0.0/16", "sftp=2001:db8::/32"}
+			o.Access.Deny, o.Access.Trusted = []string{"10.9.9.9"}, []string{"127.0.0.1"}
+		}},
+		{name: "bad access", modify: func(o *options) {
+			o.Access.Allow, o.Access.Deny = []string{"files=10.0.0.0/8", "10.0.0.0/40"}, []string{"upload=office"}
+		}, wantErr: []string{`invalid access rule "files=10.0.0.0/8", capability must be one of`, `"10.0.0.0/40"`, `"upload=office"`}},
+		{name: "access trusted without rules", modify: func(o *options) { o.Access.Trusted = []string{"127.0.0.1"} }},
+		{name: "throttle", modify: func(o *options) { o.Throttle.Rate, o.Throttle.TotalRate, o.Throttle.MaxDownloads = 512, 10240, 2 }},
+		{name: "negative throttle", modify: func(o *options) { o.Throttle.MaxDownloads = -1 },
+			wantErr: []string{"throttle rates and max downloads must not be negative"}},
+		{name: "compress", modify: func(o *options) { o.Compress.Enabled, o.Compress.MinSize = true, 512 }},
+		{name: "negative compress min size", modify: func(o *options) { o.Compress.Enabled, o.Compress.MinSize = true, -1 },
+			wantErr: []string{"compression min size must not be negative, got -1"}},
+		{name: "zero session ttl", modify: func(o *options) { o.SessionTTL = 0 }, wantErr: []string{"session ttl must be positive"}},
+		{name: "zero upload size", modify: func(o *options) { o.Upload.Enabled = true }, wantErr: []string{"upload max size"}},
+		{name: "sftp without auth", modify: func(o *options) {
+			o.SFTP.Enabled, o.SFTP.User, o.SFTP.Address = true, "bob", ":2022"
+		}, wantErr: []string{"either password"}},
+		{name: "sftp with several problems", modify: func(o *options) {
+			o.SFTP.Enabled, o.SFTP.Address, o.SFTP.Authorized = true, "2022", filepath.Join(rootDir, "missing")
+		}, wantErr: []string{"invalid SFTP address", "SFTP username", "invalid SFTP authorized keys file"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := valid()
+			tc.modify(&o)
+			err := validateOptions(&o)
+			if len(tc.wantErr) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			for _, want := range tc.wantErr {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+
+	t.Run("bad theme falls back to light", func(t *testing.T) {
+		o := valid()
+		o.Theme = "blue"
+		require.NoError(t, validateOptions(&o))
+		assert.Equal(t, "light", o.Theme)
+	})
+}
+
+func TestEnabledFeatures(t *testing.T) {
+	var o options
+	assert.Empty(t, enabledFeatures(&o))
+
+	o.Auth = "secret"
+	o.SFTP.Enabled = true
+	o.Metrics.Enabled = true
+	o.TLS.SelfSigned = true
+	assert.Equal(t, []string{"auth", "metrics", "sftp", "tls"}, enabledFeatures(&o))
+}
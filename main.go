@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"time"
 
 	"github.com/fatih/color"
@@ -19,14 +21,18 @@ import (
 )
 
 type options struct {
+	Config string `long:"config" env:"CONFIG" description:"config file (yaml or toml), flags and env take precedence"`
+
 	Listen        string   `short:"l" long:"listen" env:"LISTEN" default:":8080" description:"address to listen on"`
 	Theme         string   `short:"t" long:"theme" env:"THEME" default:"light" description:"theme to use (light or dark)"`
-	RootDir       string   `short:"r" long:"root" env:"ROOT_DIR" default:"." description:"root directory to serve"`
+	RootDir       string   `short:"r" long:"root" env:"ROOT_DIR" default:"." description:"root directory to serve, s3://bucket[/prefix] or git:/path/repo[@ref]"`
 	Exclude       []string `short:"e" long:"exclude" env:"EXCLUDE" description:"files and directories to exclude (can be repeated)"`
+	Mounts        []string `long:"mount" env:"MOUNT" env-delim:";" description:"serve directory as top-level name, name=/path[,option...] (can be repeated)"`
 	Auth          string   `short:"a" long:"auth" env:"AUTH" description:"password for basic auth"`
 	AuthUser      string   `long:"auth-user" env:"AUTH_USER" default:"weblist" description:"username for basic auth"`
 	SessionSecret string   `long:"session-secret" env:"SESSION_SECRET" description:"secret key for session tokens (auto-generated if not set)"`
 	Title         string   `long:"title" env:"TITLE" description:"custom title for the site (used in browser title and home)"`
+	HTMLMode      string   `long:"html-mode" env:"HTML_MODE" default:"sanitize" description:"how HTML files are viewed: sanitize, sandbox or text"`
 
 	HideFooter               bool   `short:"f" long:"hide-footer" env:"HIDE_FOOTER"  description:"hide footer"`
 	CustomFooter             string `long:"custom-footer" env:"CUSTOM_FOOTER" description:"custom footer text (can contain HTML)"`
@@ -34,6 +40,8 @@ type options struct {
 	EnableMultiSelect        bool   `long:"multi" env:"MULTI_SELECT" description:"enable multi-file selection and download"`
 	RecursiveMtime           bool   `long:"recursive-mtime" env:"RECURSIVE_MTIME" description:"directory mtime from newest file"`
 
+	RecursiveMtimeRefresh time.Duration `long:"recursive-mtime-refresh" env:"RECURSIVE_MTIME_REFRESH" default:"5m" description:"rescan interval for recursive mtime"`
+
 	InsecureCookies bool          `long:"insecure-cookies" env:"INSECURE_COOKIES" description:"allow cookies without secure flag"`
 	SessionTTL      time.Duration `long:"session-ttl" env:"SESSION_TTL" default:"24h" description:"session timeout"`
 
@@ -51,11 +59,98 @@ type options struct {
 		Overwrite bool  `long:"overwrite" env:"OVERWRITE" description:"allow overwriting existing files"`
 	} `group:"Upload options" namespace:"upload" env-namespace:"UPLOAD"`
 
+	OIDC struct {
+		Issuer       string   `long:"issuer" env:"ISSUER" description:"OpenID Connect issuer URL, enables SSO login"`
+		ClientID     string   `long:"client-id" env:"CLIENT_ID" description:"OIDC client ID"`
+		ClientSecret string   `long:"client-secret" env:"CLIENT_SECRET" description:"OIDC client secret"`
+		RedirectURL  string   `long:"redirect-url" env:"REDIRECT_URL" description:"OIDC callback URL (built from request host if empty)"`
+		Name         string   `long:"name" env:"NAME" default:"SSO" description:"provider name on the login button"`
+		Scopes       []string `long:"scope" env:"SCOPE" env-delim:"," description:"additional scopes to request (can be repeated)"`
+		UserClaim    string   `long:"user-claim" env:"USER_CLAIM" default:"email" description:"claim with the user name"`
+		GroupsClaim  string   `long:"groups-claim" env:"GROUPS_CLAIM" default:"groups" description:"claim with user groups"`
+		Domains      []string `long:"domain" env:"DOMAIN" env-delim:"," description:"allowed email domains (can be repeated)"`
+		Groups       []string `long:"group" env:"GROUP" env-delim:"," description:"allowed groups (can be repeated)"`
+	} `group:"OIDC options" namespace:"oidc" env-namespace:"OIDC"`
+
+	ProxyAuth struct {
+		Trusted      []string `long:"trusted" env:"TRUSTED" env-delim:"," description:"address or CIDR of trusted proxy, enables proxy auth (can be repeated)"`
+		UserHeader   string   `long:"user-header" env:"USER_HEADER" default:"X-Forwarded-User" description:"header with the authenticated user"`
+		GroupsHeader string   `long:"groups-header" env:"GROUPS_HEADER" default:"X-Forwarded-Groups" description:"header with comma-separated user groups"`
+		Groups       []string `long:"group" env:"GROUP" env-delim:"," description:"allowed groups (can be repeated)"`
+	} `group:"Proxy auth options" namespace:"proxy-auth" env-namespace:"PROXY_AUTH"`
+
+	TOTP struct {
+		File string `long:"file" env:"FILE" description:"file with TOTP secrets and recovery codes, enables 2FA for password login"`
+	} `group:"2FA options" namespace:"totp" env-namespace:"TOTP"`
+
+	Sessions struct {
+		Enabled bool     `long:"enabled" env:"ENABLED" description:"track login sessions on the server, allows revoking them"`
+		File    string   `long:"file" env:"FILE" description:"file keeping sessions over restarts, in memory only if empty"`
+		Admins  []string `long:"admin" env:"ADMIN" env-delim:"," description:"user who can see and revoke sessions of all users (can be repeated)"`
+	} `group:"Session options" namespace:"sessions" env-namespace:"SESSIONS"`
+
+	Lockout struct {
+		IPFailures   int           `long:"ip-failures" env:"IP_FAILURES" default:"10" description:"failed logins from an address before it's locked out"`
+		UserFailures int           `long:"user-failures" env:"USER_FAILURES" default:"20" description:"failed logins for an account before it's locked out"`
+		Window       time.Duration `long:"window" env:"WINDOW" default:"15m" description:"time failed logins are counted in"`
+		Duration     time.Duration `long:"duration" env:"DURATION" default:"15m" description:"how long a locked out address or account is rejected"`
+		Delay        time.Duration `long:"delay" env:"DELAY" default:"1s" description:"delay after a failed login, doubled with each following one"`
+	} `group:"Lockout options" namespace:"lockout" env-namespace:"LOCKOUT"`
+
+	Access struct {
+		Allow   []string `long:"allow" env:"ALLOW" env-delim:"," description:"allowed address or CIDR, optionally for a capability, e.g. upload=10.0.0.0/8 (can be repeated)"`
+		Deny    []string `long:"deny" env:"DENY" env-delim:"," description:"denied address or CIDR, optionally for a capability, e.g. sftp=0.0.0.0/0 (can be repeated)"`
+		Trusted []string `long:"trusted" env:"TRUSTED" env-delim:"," description:"address or CIDR of proxy whose X-Real-IP/X-Forwarded-For is trusted (can be repeated)"`
+	} `group:"Access options" namespace:"access" env-namespace:"ACCESS"`
+
+	Throttle struct {
+		Rate         int64 `long:"rate" env:"RATE" description:"max speed of a single download in KB/s, unlimited if 0"`
+		TotalRate    int64 `long:"total-rate" env:"TOTAL_RATE" description:"max speed of all downloads together in KB/s, unlimited if 0"`
+		MaxDownloads int   `long:"max-downloads" env:"MAX_DOWNLOADS" description:"max concurrent downloads per user or address, unlimited if 0"`
+	} `group:"Throttle options" namespace:"throttle" env-namespace:"THROTTLE"`
+
+	Compress struct {
+		Enabled bool `long:"enabled" env:"ENABLED" description:"compress HTML, JSON and text responses, serve precompressed .br and .gz files"`
+		MinSize int  `long:"min-size" env:"MIN_SIZE" default:"1024" description:"smallest response to compress in bytes"`
+	} `group:"Compression options" namespace:"compress" env-namespace:"COMPRESS"`
+
+	TLS struct {
+		Cert       string `long:"cert" env:"CERT" description:"TLS certificate file, reloaded on change"`
+		Key        string `long:"key" env:"KEY" description:"TLS private key file"`
+		SelfSigned bool   `long:"self-signed" env:"SELF_SIGNED" description:"serve HTTPS with generated self-signed certificate"`
+		Redirect   string `long:"redirect" env:"REDIRECT" description:"address of HTTP listener redirecting to HTTPS, e.g. :80"`
+		ClientCA   string `long:"client-ca" env:"CLIENT_CA" description:"CA file verifying client certificates (mutual TLS)"`
+	} `group:"TLS options" namespace:"tls" env-namespace:"TLS"`
+
+	Metrics struct {
+		Enabled bool   `long:"enabled" env:"ENABLED" description:"enable prometheus metrics on /metrics"`
+		Listen  string `long:"listen" env:"LISTEN" description:"separate address for metrics endpoint (served on main listener if empty)"`
+	} `group:"Metrics options" namespace:"metrics" env-namespace:"METRICS"`
+
+	Audit struct {
+		Enabled    bool   `long:"enabled" env:"ENABLED" description:"enable audit log of file access"`
+		File       string `long:"file" env:"FILE" default:"-" description:"audit log file, - for stdout"`
+		MaxSize    int    `long:"max-size" env:"MAX_SIZE" default:"100" description:"max audit log size in MB before rotation"`
+		MaxBackups int    `long:"max-backups" env:"MAX_BACKUPS" default:"10" description:"max number of rotated audit logs to keep"`
+	} `group:"Audit options" namespace:"audit" env-namespace:"AUDIT"`
+
+	S3 struct {
+		Endpoint  string `long:"endpoint" env:"ENDPOINT" default:"s3.amazonaws.com" description:"S3 endpoint for s3:// locations"`
+		Region    string `long:"region" env:"REGION" description:"S3 region (detected if empty)"`
+		AccessKey string `long:"access-key" env:"ACCESS_KEY" description:"S3 access key (AWS env or credentials file if empty)"`
+		SecretKey string `long:"secret-key" env:"SECRET_KEY" description:"S3 secret key"`
+		Insecure  bool   `long:"insecure" env:"INSECURE" description:"use plain HTTP for S3 endpoint"`
+	} `group:"S3 options" namespace:"s3" env-namespace:"S3"`
+
 	Branding struct {
 		Name  string `long:"name" env:"NAME" description:"company or organization name to display in navbar"`
 		Color string `long:"color" env:"COLOR" description:"color for navbar (e.g. #3498db or 3498db)"`
 	} `group:"Branding options" namespace:"brand" env-namespace:"BRAND"`
 
+	ConfigCmd struct {
+		Check struct{} `command:"check" description:"validate configuration and exit"`
+	} `command:"config" description:"configuration commands"`
+
 	Version bool `short:"v" long:"version" env:"VERSION" description:"show version and exit"`
 	Dbg     bool `long:"dbg" env:"DEBUG" description:"debug mode"`
 }
@@ -67,10 +162,16 @@ func main() {
 	if os.Getenv("GO_FLAGS_COMPLETION") == "" {
 		fmt.Printf("weblist %s\n", versionInfo())
 	}
-	p := flags.NewParser(&opts, flags.PrintErrors|flags.PassDoubleDash|flags.HelpFlag)
-	if _, err := p.Parse(); err != nil {
-		if !errors.Is(err.(*flags.Error).Type, flags.ErrHelp) {
-			fmt.Printf("%v", err)
+	var p *flags.Parser
+	var err error
+	if opts, p, err = parseOptions(os.Args[1:]); err != nil {
+		var flagsErr *flags.Error
+		if errors.As(err, &flagsErr) {
+			if !errors.Is(flagsErr.Type, flags.ErrHelp) {
+				fmt.Printf("%v", err)
+			}
+		} else {
+			fmt.Printf("%v\n", err)
 		}
 		os.Exit(1)
 	}
@@ -81,10 +182,17 @@ func main() {
 		os.Exit(0)
 	}
 
-	// validate theme
-	if opts.Theme != "light" && opts.Theme != "dark" {
-		log.Printf("WARN: invalid theme '%s'. Using 'light' instead.", opts.Theme)
-		opts.Theme = "light"
+	if isConfigCheck(p) {
+		if err := configCheck(&opts); err != nil {
+			fmt.Printf("configuration is invalid:\n%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if err := validateOptions(&opts); err != nil {
+		log.Printf("[ERROR] invalid configuration:\n%v", err)
+		os.Exit(1)
 	}
 
 	defer func() {
@@ -102,23 +210,47 @@ func main() {
 }
 
 func runServer(ctx context.Context, opts *options) error {
-	// get the absolute path for root directory
-	absRootDir, err := filepath.Abs(opts.RootDir)
+	// get the absolute path for root directory, a bucket or repository has no local directory for temp files
+	localRootDir := ""
+	if !isS3Location(opts.RootDir) && !isGitLocation(opts.RootDir) {
+		absRootDir, err := filepath.Abs(opts.RootDir)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for root directory: %w", err)
+		}
+		opts.RootDir = absRootDir
+		localRootDir = absRootDir
+	}
+
+	// mounts replace the root directory, each locked to its own storage
+	mounts, err := makeMounts(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path for root directory: %w", err)
+		return err
 	}
-	opts.RootDir = absRootDir
 
 	// ensure temp directory exists for multipart uploads in minimal containers (e.g., scratch).
 	// tries the system temp dir first, then .tmp under root dir.
-	if opts.Upload.Enabled {
-		ensureTempDir(opts.RootDir, &opts.Exclude)
+	if opts.Upload.Enabled || slices.ContainsFunc(mounts, func(m server.Mount) bool { return m.EnableUpload }) {
+		ensureTempDir(localRootDir, &opts.Exclude)
 	}
 
-	// create OS filesystem locked to the root directory
-	fs, err := newRootFS(opts.RootDir)
-	if err != nil {
-		return fmt.Errorf("failed to create root filesystem: %w", err)
+	// create storage locked to the root directory or bucket, a repository is served read-only at its default ref
+	var fsys fs.FS
+	var gitRepo *server.GitRepo
+	switch {
+	case len(mounts) > 0: // mounts have their own storage instead
+	case isGitLocation(opts.RootDir):
+		if gitRepo, err = newGitRepo(opts.RootDir); err != nil {
+			return fmt.Errorf("failed to open root repository: %w", err)
+		}
+		if fsys, err = gitRepo.FS(gitRepo.DefaultRef); err != nil {
+			return fmt.Errorf("failed to open root repository: %w", err)
+		}
+	default:
+		storage, err := newStorage(ctx, opts.RootDir, opts)
+		if err != nil {
+			return fmt.Errorf("failed to create root storage: %w", err)
+		}
+		fsys = storage
 	}
 
 	// prepare common configuration
@@ -135,6 +267,7 @@ func runServer(ctx context.Context, opts *options) error {
 		SessionSecret:            opts.SessionSecret,
 		Title:                    opts.Title,
 		CustomFooter:             opts.CustomFooter,
+		HTMLMode:                 opts.HTMLMode,
 		SFTPUser:                 opts.SFTP.User,
 		SFTPAddress:              opts.SFTP.Address,
 		SFTPKeyFile:              opts.SFTP.KeyFile,
@@ -145,15 +278,132 @@ func runServer(ctx context.Context, opts *options) error {
 		SessionTTL:               opts.SessionTTL,
 		EnableMultiSelect:        opts.EnableMultiSelect,
 		RecursiveMtime:           opts.RecursiveMtime,
+		RecursiveMtimeRefresh:    opts.RecursiveMtimeRefresh,
 		EnableUpload:             opts.Upload.Enabled,
 		UploadMaxSize:            opts.Upload.MaxSize * 1024 * 1024, // convert MB to bytes
 		UploadOverwrite:          opts.Upload.Overwrite,
+		MetricsListen:            opts.Metrics.Listen,
+		TLSCert:                  opts.TLS.Cert,
+		TLSKey:                   opts.TLS.Key,
+		TLSSelfSigned:            opts.TLS.SelfSigned,
+		TLSRedirect:              opts.TLS.Redirect,
+		TLSClientCA:              opts.TLS.ClientCA,
+		Compress:                 opts.Compress.Enabled,
+		CompressMinSize:          opts.Compress.MinSize,
+	}
+
+	// metrics and audit log are shared by HTTP and SFTP servers
+	var metrics *server.Metrics
+	if opts.Metrics.Enabled {
+		metrics = server.NewMetrics()
+	}
+
+	var audit *server.AuditLogger
+	if opts.Audit.Enabled {
+		audit, err = server.NewAuditLogger(server.AuditOpts{
+			File:       opts.Audit.File,
+			MaxSize:    opts.Audit.MaxSize,
+			MaxBackups: opts.Audit.MaxBackups,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create audit log: %w", err)
+		}
+		defer audit.Close()
+	}
+
+	var oidcAuth *server.OIDCAuth
+	if opts.OIDC.Issuer != "" {
+		oidcAuth, err = server.NewOIDCAuth(ctx, server.OIDCOpts{
+			Issuer:         opts.OIDC.Issuer,
+			ClientID:       opts.OIDC.ClientID,
+			ClientSecret:   opts.OIDC.ClientSecret,
+			RedirectURL:    opts.OIDC.RedirectURL,
+			Name:           opts.OIDC.Name,
+			Scopes:         opts.OIDC.Scopes,
+			UserClaim:      opts.OIDC.UserClaim,
+			GroupsClaim:    opts.OIDC.GroupsClaim,
+			AllowedDomains: opts.OIDC.Domains,
+			AllowedGroups:  opts.OIDC.Groups,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set up OIDC login: %w", err)
+		}
+	}
+
+	var proxyAuth *server.ProxyAuth
+	if len(opts.ProxyAuth.Trusted) > 0 {
+		proxyAuth, err = server.NewProxyAuth(server.ProxyAuthOpts{
+			UserHeader:    opts.ProxyAuth.UserHeader,
+			GroupsHeader:  opts.ProxyAuth.GroupsHeader,
+			AllowedGroups: opts.ProxyAuth.Groups,
+			Trusted:       opts.ProxyAuth.Trusted,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set up proxy auth: %w", err)
+		}
+	}
+
+	var totpStore *server.TOTPStore
+	if opts.TOTP.File != "" {
+		if totpStore, err = server.NewTOTPStore(opts.TOTP.File); err != nil {
+			return fmt.Errorf("failed to set up 2FA: %w", err)
+		}
+	}
+
+	var sessions *server.SessionStore
+	if opts.Sessions.Enabled {
+		sessions, err = server.NewSessionStore(server.SessionOpts{File: opts.Sessions.File, TTL: opts.SessionTTL, Admins: opts.Sessions.Admins})
+		if err != nil {
+			return fmt.Errorf("failed to set up session store: %w", err)
+		}
+		go sessions.Run(ctx)
+	}
+
+	// failed logins are tracked together for HTTP and SFTP
+	guard := server.NewAuthGuard(server.AuthGuardOpts{
+		MaxIPFailures:   opts.Lockout.IPFailures,
+		MaxUserFailures: opts.Lockout.UserFailures,
+		Window:          opts.Lockout.Window,
+		Lockout:         opts.Lockout.Duration,
+		Delay:           opts.Lockout.Delay,
+		Metrics:         metrics,
+		Audit:           audit,
+	})
+	go guard.Run(ctx)
+
+	// trusted proxies are used without access rules as well, they set the client address of login lockouts
+	var access *server.AccessList
+	if len(opts.Access.Allow) > 0 || len(opts.Access.Deny) > 0 || len(opts.Access.Trusted) > 0 {
+		access, err = server.NewAccessList(server.AccessOpts{Allow: opts.Access.Allow, Deny: opts.Access.Deny, Trusted: opts.Access.Trusted})
+		if err != nil {
+			return fmt.Errorf("failed to set up access rules: %w", err)
+		}
+	}
+
+	var throttle *server.Throttle
+	if opts.Throttle.Rate > 0 || opts.Throttle.TotalRate > 0 || opts.Throttle.MaxDownloads > 0 {
+		throttle = server.NewThrottle(server.ThrottleOpts{
+			Rate:         opts.Throttle.Rate * 1024, // convert KB/s to bytes per second
+			TotalRate:    opts.Throttle.TotalRate * 1024,
+			MaxDownloads: opts.Throttle.MaxDownloads,
+		})
 	}
 
 	// create HTTP server
 	srv := &server.Web{
-		Config: config,
-		FS:     fs,
+		Config:   config,
+		FS:       fsys,
+		Mounts:   mounts,
+		Git:      gitRepo,
+		OIDC:     oidcAuth,
+		Proxy:    proxyAuth,
+		TOTP:     totpStore,
+		Sessions: sessions,
+		Guard:    guard,
+		Access:   access,
+		Throttle: throttle,
+		Metrics:  metrics,
+		Audit:    audit,
 	}
 
 	// create error channel for goroutines
@@ -167,15 +417,20 @@ func runServer(ctx context.Context, opts *options) error {
 	}()
 
 	// if SFTP is enabled, start SFTP server
-	if opts.SFTP.Enabled && opts.SFTP.User != "" {
-		// for SFTP, either a password or an authorized_keys file must be provided
-		if opts.Auth == "" && opts.SFTP.Authorized == "" {
-			return fmt.Errorf("either password (-a/--auth) or authorized keys file (--sftp-authorized) is required for SFTP server")
+	if opts.SFTP.Enabled {
+		sftpConfig := config
+		if totpStore != nil {
+			sftpConfig.Auth = "" // password can't pass the second factor, public keys only
 		}
-
 		sftpSrv := &server.SFTP{
-			Config: config,
-			FS:     fs,
+			Config:   sftpConfig,
+			FS:       fsys,
+			Mounts:   mounts,
+			Metrics:  metrics,
+			Audit:    audit,
+			Guard:    guard,
+			Access:   access,
+			Throttle: throttle,
 		}
 
 		go func() {
@@ -201,11 +456,14 @@ func runServer(ctx context.Context, opts *options) error {
 
 // ensureTempDir makes sure a writable temp directory exists for multipart uploads.
 // in minimal containers (scratch/distroless), /tmp may not exist and the filesystem root
-// may be read-only. this function tries the system temp dir and then .tmp under rootDir.
+// may be read-only. this function tries the system temp dir and then .tmp under rootDir, if set.
 // when a fallback under rootDir is used, it is added to the exclude list to hide it from listings.
 func ensureTempDir(rootDir string, exclude *[]string) {
 	defaultTmp := os.TempDir()
-	rootTmp := filepath.Join(rootDir, ".tmp")
+	rootTmp := ""
+	if rootDir != "" {
+		rootTmp = filepath.Join(rootDir, ".tmp")
+	}
 	candidates := []string{defaultTmp, rootTmp}
 	for _, dir := range candidates {
 		if dir == "" {
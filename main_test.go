@@ -17,7 +17,6 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/umputun/go-flags"
 
 	"github.com/umputun/weblist/server"
 )
@@ -197,9 +196,9 @@ func TestParseCommandLineArgs(t *testing.T) {
 			// set command line args
 			os.Args = tc.args
 
-			// parse flags directly using the flags package
-			p := flags.NewParser(&opts, flags.PrintErrors|flags.PassDoubleDash|flags.HelpFlag)
-			_, err := p.Parse()
+			// parse flags the same way main does
+			var err error
+			opts, _, err = parseOptions(os.Args[1:])
 			require.NoError(t, err, "Flag parsing should not produce an error")
 
 			// check if options match expected values
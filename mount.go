@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/umputun/weblist/server"
+)
+
+var mountNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// reservedMountNames can't be used as mount names, they collide with top-level routes
+var reservedMountNames = []string{"api", "assets", "csv-export", "diff", "download-selected", "events", "favicon.ico",
+	"login", "logout", "metrics", "oidc", "partials", "ping", "ref", "sessions", "upload", "view"}
+
+// parseMount parses a mount spec in the form name=/path[,option...], the path can be s3://bucket[/prefix]. Options are exclude=pattern (repeatable),
+// auth=password, user=name (repeatable), upload[=true|false], upload-max-size=MB and upload-overwrite[=true|false]. Upload settings
+// not given in the spec are inherited from the global upload options. The returned mount has no FS yet.
+func parseMount(spec string, o *options) (server.Mount, error) {
+	parts := strings.Split(spec, ",")
+	name, dir, ok := strings.Cut(parts[0], "=")
+	if !ok || name == "" || dir == "" {
+		return server.Mount{}, fmt.Errorf("invalid mount %q, expected name=/path", spec)
+	}
+	if !mountNameRe.MatchString(name) || slices.Contains(reservedMountNames, strings.ToLower(name)) {
+		return server.Mount{}, fmt.Errorf("invalid mount name %q", name)
+	}
+
+	res := server.Mount{
+		Name:            name,
+		RootDir:         dir,
+		EnableUpload:    o.Upload.Enabled,
+		UploadMaxSize:   o.Upload.MaxSize * 1024 * 1024, // convert MB to bytes
+		UploadOverwrite: o.Upload.Overwrite,
+	}
+
+	for _, opt := range parts[1:] {
+		key, val, hasVal := strings.Cut(strings.TrimSpace(opt), "=")
+		var err error
+		switch key {
+		case "exclude":
+			if val == "" {
+				return server.Mount{}, fmt.Errorf("mount %s: empty exclude", name)
+			}
+			res.Exclude = append(res.Exclude, val)
+		case "auth":
+			if val == "" {
+				return server.Mount{}, fmt.Errorf("mount %s: empty auth", name)
+			}
+			res.Auth = val
+		case "user":
+			if val == "" {
+				return server.Mount{}, fmt.Errorf("mount %s: empty user", name)
+			}
+			res.Users = append(res.Users, val)
+		case "upload":
+			res.EnableUpload, err = mountBool(val, hasVal)
+		case "upload-overwrite":
+			res.UploadOverwrite, err = mountBool(val, hasVal)
+		case "upload-max-size":
+			var mb int64
+			if mb, err = strconv.ParseInt(val, 10, 64); err == nil && mb <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+			res.UploadMaxSize = mb * 1024 * 1024
+		default:
+			return server.Mount{}, fmt.Errorf("mount %s: unknown option %q", name, key)
+		}
+		if err != nil {
+			return server.Mount{}, fmt.Errorf("mount %s: invalid %s value %q: %w", name, key, val, err)
+		}
+	}
+	return res, nil
+}
+
+// mountBool parses a boolean mount option, a bare option name means true
+func mountBool(val string, hasVal bool) (bool, error) {
+	if !hasVal {
+		return true, nil
+	}
+	return strconv.ParseBool(val)
+}
+
+// parseMounts parses all mount specs and checks names are unique
+func parseMounts(o *options) ([]server.Mount, error) {
+	res := make([]server.Mount, 0, len(o.Mounts))
+	seen := map[string]bool{}
+	for _, spec := range o.Mounts {
+		m, err := parseMount(spec, o)
+		if err != nil {
+			return nil, err
+		}
+		if seen[strings.ToLower(m.Name)] {
+			return nil, fmt.Errorf("duplicate mount name %q", m.Name)
+		}
+		seen[strings.ToLower(m.Name)] = true
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// makeMounts parses mount specs and locks each mount to its own storage, a directory or a bucket
+func makeMounts(ctx context.Context, o *options) ([]server.Mount, error) {
+	mounts, err := parseMounts(o)
+	if err != nil {
+		return nil, err
+	}
+	for i := range mounts {
+		if !isS3Location(mounts[i].RootDir) {
+			absDir, err := filepath.Abs(mounts[i].RootDir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get absolute path for mount %s: %w", mounts[i].Name, err)
+			}
+			mounts[i].RootDir = absDir
+		}
+		storage, err := newStorage(ctx, mounts[i].RootDir, o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create storage for mount %s: %w", mounts[i].Name, err)
+		}
+		mounts[i].FS = storage
+	}
+	return mounts, nil
+}
+
+// validateMounts checks mount specs and their directories, buckets are checked on start only
+func validateMounts(o *options) []error {
+	mounts, err := parseMounts(o)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, m := range mounts {
+		if isS3Location(m.RootDir) {
+			if _, _, err := parseS3Location(m.RootDir); err != nil {
+				errs = append(errs, fmt.Errorf("invalid location of mount %s: %w", m.Name, err))
+			}
+			continue
+		}
+		if st, err := os.Stat(m.RootDir); err != nil {
+			errs = append(errs, fmt.Errorf("invalid directory of mount %s: %w", m.Name, err))
+		} else if !st.IsDir() {
+			errs = append(errs, fmt.Errorf("invalid directory of mount %s: %s is not a directory", m.Name, m.RootDir))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/weblist/server"
+)
+
+func TestParseMount(t *testing.T) {
+	var o options
+	o.Upload.Enabled, o.Upload.MaxSize = true, 64
+
+	tests := []struct {
+		name    string
+		spec    string
+		check   func(t *testing.T, m server.Mount)
+		wantErr string
+	}{
+		{name: "plain", spec: "releases=/srv/releases", check: func(t *testing.T, m server.Mount) {
+			assert.Equal(t, "releases", m.Name)
+			assert.Equal(t, "/srv/releases", m.RootDir)
+			assert.True(t, m.EnableUpload, "inherited from global upload options")
+			assert.Equal(t, int64(64*1024*1024), m.UploadMaxSize)
+			assert.False(t, m.UploadOverwrite)
+		}},
+		{name: "all options", spec: "logs=/var/log,exclude=*.gz,exclude=old,auth=secret,user=alice,user=bob,upload=false,upload-max-size=8,upload-overwrite",
+			check: func(t *testing.T, m server.Mount) {
+				assert.Equal(t, []string{"*.gz", "old"}, m.Exclude)
+				assert.Equal(t, "secret", m.Auth)
+				assert.Equal(t, []string{"alice", "bob"}, m.Users)
+				assert.False(t, m.EnableUpload)
+				assert.Equal(t, int64(8*1024*1024), m.UploadMaxSize)
+				assert.True(t, m.UploadOverwrite)
+			}},
+		{name: "dotted name", spec: "releases.v1=/srv/releases,auth=secret", check: func(t *testing.T, m server.Mount) {
+			assert.Equal(t, "releases.v1", m.Name)
+		}},
+		{name: "no path", spec: "releases", wantErr: "expected name=/path"},
+		{name: "empty name", spec: "=/srv", wantErr: "expected name=/path"},
+		{name: "bad name", spec: "a/b=/srv", wantErr: "invalid mount name"},
+		{name: "reserved name", spec: "API=/srv", wantErr: "invalid mount name"},
+		{name: "unknown option", spec: "docs=/srv,readonly", wantErr: `unknown option "readonly"`},
+		{name: "bad bool", spec: "docs=/srv,upload=maybe", wantErr: "invalid upload value"},
+		{name: "bad size", spec: "docs=/srv,upload-max-size=0", wantErr: "invalid upload-max-size value"},
+		{name: "empty auth", spec: "docs=/srv,auth=", wantErr: "empty auth"},
+		{name: "empty user", spec: "docs=/srv,user=", wantErr: "empty user"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := parseMount(tc.spec, &o)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			tc.check(t, m)
+		})
+	}
+}
+
+func TestReservedMountNames(t *testing.T) {
+	// every top-level route of the server is reserved, a mount with the same name would be shadowed by it
+	var routes []string
+	for _, file := range []string{"server/server.go", "server/oidc.go"} {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		for _, m := range regexp.MustCompile(`"(?:(?:GET|POST) )?/([A-Za-z0-9._-]+)`).FindAllStringSubmatch(string(data), -1) {
+			routes = append(routes, m[1])
+		}
+	}
+	require.Contains(t, routes, "csv-export", "routes found")
+	for _, route := range routes {
+		assert.Contains(t, reservedMountNames, route)
+	}
+}
+
+func TestMakeMounts(t *testing.T) {
+	base := t.TempDir()
+	docs := filepath.Join(base, "docs")
+	require.NoError(t, os.Mkdir(docs, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(docs, "readme.md"), []byte("# docs"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "file.txt"), nil, 0o600))
+
+	var o options
+	o.Mounts = []string{"docs=" + docs, "Docs=" + base}
+	_, err := makeMounts(context.Background(), &o)
+	require.ErrorContains(t, err, `duplicate mount name "Docs"`)
+
+	o.Mounts = []string{"docs=" + docs}
+	mounts, err := makeMounts(context.Background(), &o)
+	require.NoError(t, err)
+	require.Len(t, mounts, 1)
+	data, err := fs.ReadFile(mounts[0].FS, "readme.md")
+	require.NoError(t, err)
+	assert.Equal(t, "# docs", string(data))
+
+	o.Mounts = []string{"docs=" + docs, "missing=" + filepath.Join(base, "missing"), "file=" + filepath.Join(base, "file.txt")}
+	errs := validateMounts(&o)
+	require.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "invalid directory of mount missing")
+	assert.Contains(t, errs[1].Error(), "is not a directory")
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// capabilities access rules can be set for, rules without a capability apply to all requests and SFTP
+const (
+	accessBrowse   = "browse"
+	accessDownload = "download"
+	accessUpload   = "upload"
+	accessAPI      = "api"
+	accessSFTP     = "sftp"
+)
+
+// AccessOpts defines address rules, each rule is an address or CIDR, optionally prefixed with a capability
+// (browse, download, upload, api or sftp) and "=", e.g. "10.0.0.0/8" or "upload=10.1.0.0/16"
+type AccessOpts struct {
+	Allow   []string // only matching clients are allowed, anyone if empty
+	Deny    []string // matching clients are rejected, even if allowed
+	Trusted []string // addresses or CIDRs of proxies whose forwarding headers set the client address
+}
+
+// AccessList allows or denies clients by address, globally and per capability.
+// a client must pass the global rules and the rules of the capability: it's rejected if it matches any deny
+// rule, and if there are allow rules, it must match one of them.
+// All methods are safe to call on a nil receiver, which makes access rules optional for callers.
+type AccessList struct {
+	rules   map[string]*accessRules // by capability, empty key for global rules
+	trusted []netip.Prefix
+}
+
+// accessRules are allow and deny rules of a capability
+type accessRules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewAccessList makes the access list from the rules
+func NewAccessList(opts AccessOpts) (*AccessList, error) {
+	res := &AccessList{rules: map[string]*accessRules{}}
+	add := func(rule string, deny bool) error {
+		capability, cidr, found := strings.Cut(rule, "=")
+		if !found {
+			capability, cidr = "", rule
+		}
+		if capability != "" && !slices.Contains([]string{accessBrowse, accessDownload, accessUpload, accessAPI, accessSFTP}, capability) {
+			return fmt.Errorf("invalid access rule %q, unknown capability %q", rule, capability)
+		}
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid access rule %q: %w", rule, err)
+		}
+		rules, ok := res.rules[capability]
+		if !ok {
+			rules = &accessRules{}
+			res.rules[capability] = rules
+		}
+		if deny {
+			rules.deny = append(rules.deny, prefix)
+			return nil
+		}
+		rules.allow = append(rules.allow, prefix)
+		return nil
+	}
+	for _, rule := range opts.Allow {
+		if err := add(rule, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range opts.Deny {
+		if err := add(rule, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range opts.Trusted {
+		prefix, err := parseTrustedProxy(t)
+		if err != nil {
+			return nil, err
+		}
+		res.trusted = append(res.trusted, prefix)
+	}
+	return res, nil
+}
+
+// allowed checks the address passes the rules of the capability, global rules for empty capability
+func (a *AccessList) allowed(capability string, addr netip.Addr) bool {
+	if a == nil {
+		return true
+	}
+	rules, ok := a.rules[capability]
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	contains := func(prefix netip.Prefix) bool { return prefix.Contains(addr) }
+	if slices.ContainsFunc(rules.deny, contains) {
+		return false
+	}
+	return len(rules.allow) == 0 || slices.ContainsFunc(rules.allow, contains)
+}
+
+// middleware rejects requests of clients not allowed to use the capability, or by global rules if it's empty
+func (a *AccessList) middleware(capability string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if a == nil || a.rules[capability] == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, ok := a.clientAddr(r)
+			if !ok || !a.allowed(capability, addr) {
+				log.Printf("[WARN] access denied for %s to %s %s", addr, r.Method, r.URL.Path)
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientAddr returns the client address of the request. the address from forwarding headers is used only
+// for requests from trusted proxies, as any client could set them. without the list, it's the peer address.
+func (a *AccessList) clientAddr(r *http.Request) (netip.Addr, bool) {
+	peer, ok := parseAddrPort(peerAddr(r))
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if a == nil || !slices.ContainsFunc(a.trusted, func(prefix netip.Prefix) bool { return prefix.Contains(peer) }) {
+		return peer, true
+	}
+	return parseAddrPort(r.RemoteAddr)
+}
+
+// allowedConn checks the client of an SFTP connection passes global and SFTP rules
+func (a *AccessList) allowedConn(remote net.Addr) bool {
+	if a == nil {
+		return true
+	}
+	addr, ok := parseAddrPort(remote.String())
+	return ok && a.allowed("", addr) && a.allowed(accessSFTP, addr)
+}
+
+// parseAddrPort parses the address in host:port or host form
+func parseAddrPort(s string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		host = s
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
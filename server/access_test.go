@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAccessList(t *testing.T) {
+	_, err := NewAccessList(AccessOpts{Allow: []string{"files=10.0.0.0/8"}})
+	require.ErrorContains(t, err, `unknown capability "files"`)
+	_, err = NewAccessList(AccessOpts{Deny: []string{"upload=office"}})
+	require.ErrorContains(t, err, `invalid access rule "upload=office"`)
+	_, err = NewAccessList(AccessOpts{Allow: []string{"10.0.0.0/8"}, Trusted: []string{"proxy"}})
+	require.ErrorContains(t, err, `invalid trusted proxy "proxy"`)
+
+	a, err := NewAccessList(AccessOpts{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32", "upload=10.1.0.0/16"},
+		Deny:  []string{"10.9.0.0/16", "upload=10.1.2.3", "sftp=0.0.0.0/0"},
+	})
+	require.NoError(t, err)
+
+	tbl := []struct {
+		capability, addr string
+		want             bool
+	}{
+		{"", "10.2.3.4", true},
+		{"", "::ffff:10.2.3.4", true},
+		{"", "2001:db8::1", true},
+		{"", "192.168.1.1", false},
+		{"", "10.9.1.1", false},
+		{accessUpload, "10.1.5.5", true},
+		{accessUpload, "10.2.3.4", false},
+		{accessUpload, "10.1.2.3", false},
+		{accessSFTP, "10.2.3.4", false},
+		{accessDownload, "192.168.1.1", true},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.want, a.allowed(tt.capability, netip.MustParseAddr(tt.addr)), "%s %s", tt.capability, tt.addr)
+	}
+
+	assert.False(t, a.allowedConn(&net.TCPAddr{IP: net.ParseIP("10.2.3.4"), Port: 2022}), "sftp denied for all")
+	var nilList *AccessList
+	assert.True(t, nilList.allowed(accessUpload, netip.MustParseAddr("192.168.1.1")))
+	assert.True(t, nilList.allowedConn(&net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 2022}))
+}
+
+func TestAccessMiddleware(t *testing.T) {
+	access, err := NewAccessList(AccessOpts{
+		Allow:   []string{"10.0.0.0/8", "8.8.8.0/24", "upload=10.1.0.0/16", "api=10.2.0.0/16"},
+		Deny:    []string{"download=10.3.0.0/16"},
+		Trusted: []string{"127.0.0.1"},
+	})
+	require.NoError(t, err)
+	srv := &Web{Config: Config{RootDir: "testdata", Title: "Test", EnableUpload: true, UploadMaxSize: 1024},
+		FS: os.DirFS("testdata"), Access: access}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	do := func(method, target, remoteAddr string, hdr http.Header) int {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		require.NoError(t, mw.Close())
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		for k, v := range hdr {
+			req.Header[k] = v
+		}
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/", "10.5.0.1:1000", nil))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/", "192.168.1.1:1000", nil), "global allow")
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/login", "192.168.1.1:1000", nil), "global rules apply to all routes")
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/file1.txt", "10.5.0.1:1000", nil))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/file1.txt", "10.3.0.1:1000", nil), "download denied")
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/", "10.3.0.1:1000", nil), "browsing allowed")
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/list", "10.2.0.1:1000", nil))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/list", "10.5.0.1:1000", nil))
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/upload", "10.5.0.1:1000", nil), "upload limited")
+	assert.NotEqual(t, http.StatusForbidden, do(http.MethodPost, "/upload", "10.1.0.1:1000", nil))
+
+	// forwarding headers are used only from trusted proxies
+	fwd := http.Header{"X-Real-Ip": {"8.8.8.8"}}
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/", "127.0.0.1:1000", fwd))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/", "192.168.1.1:1000", fwd), "untrusted peer can't claim another address")
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/", "127.0.0.1:1000", http.Header{"X-Real-Ip": {"1.1.1.1"}}))
+}
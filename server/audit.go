@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// AuditLogger writes one JSON record per line for every file access over HTTP and SFTP.
+// All methods are safe to call on a nil receiver, which makes auditing optional for callers.
+type AuditLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	out io.Writer
+}
+
+// AuditRecord is a single audit log entry
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user,omitempty"` // empty for anonymous access
+	IP       string    `json:"ip"`
+	Protocol string    `json:"protocol"` // http or sftp
+	Action   string    `json:"action"`   // download, view, download_selected, upload, read, delete, rename, ...
+	Path     string    `json:"path,omitempty"`
+	Files    []string  `json:"files,omitempty"` // requested paths for multi-file actions
+	Bytes    int64     `json:"bytes"`           // bytes sent to or received from the client
+	Result   string    `json:"result"`          // ok, denied, not_found, rejected or error
+}
+
+// AuditOpts defines audit log destination and rotation
+type AuditOpts struct {
+	File       string // destination file, stdout if empty or "-"
+	MaxSize    int    // max size in megabytes before rotation
+	MaxBackups int    // max number of rotated files to keep, all kept if zero
+}
+
+// NewAuditLogger makes an audit logger writing to stdout or to a file rotated by size
+func NewAuditLogger(opts AuditOpts) (*AuditLogger, error) {
+	if opts.File == "" || opts.File == "-" {
+		return &AuditLogger{enc: json.NewEncoder(os.Stdout), out: os.Stdout}, nil
+	}
+
+	// make sure the file can be written before handing it to the rotator, which opens it lazily
+	f, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", opts.File, err)
+	}
+	_ = f.Close()
+
+	out := &lumberjack.Logger{Filename: opts.File, MaxSize: opts.MaxSize, MaxBackups: opts.MaxBackups}
+	return &AuditLogger{enc: json.NewEncoder(out), out: out}, nil
+}
+
+// Log writes the record, setting its time if not set
+func (a *AuditLogger) Log(rec AuditRecord) {
+	if a == nil {
+		return
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(rec); err != nil {
+		log.Printf("[WARN] failed to write audit record: %v", err)
+	}
+}
+
+// Close closes the audit log file, stdout is left alone
+func (a *AuditLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.out.(io.Closer); ok && a.out != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
+
+// auditResult maps an HTTP status code to the audit result
+func auditResult(status int) string {
+	switch {
+	case status < http.StatusBadRequest:
+		return "ok"
+	case status == http.StatusForbidden || status == http.StatusUnauthorized:
+		return "denied"
+	case status == http.StatusNotFound:
+		return "not_found"
+	case status < http.StatusInternalServerError:
+		return "rejected"
+	default:
+		return "error"
+	}
+}
+
+// auditHTTP records an HTTP file access with the outcome taken from the response writer.
+// redirects are not file accesses and are skipped. bytes are counted only for successful responses.
+func (wb *Web) auditHTTP(r *http.Request, rec AuditRecord, sw *statusWriter) {
+	if wb.Audit == nil || (sw.status >= http.StatusMultipleChoices && sw.status < http.StatusBadRequest) {
+		return
+	}
+	rec.User = wb.currentUser(r)
+	rec.IP = clientIP(r.RemoteAddr)
+	rec.Protocol = "http"
+	rec.Result = auditResult(sw.status)
+	if rec.Result == "ok" && rec.Bytes == 0 {
+		rec.Bytes = sw.written
+	}
+	wb.Audit.Log(rec)
+}
+
+// clientIP strips the port from a remote address. rest.RealIP already leaves a bare IP there,
+// while direct connections and SSH sessions carry host:port.
+func clientIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAuditLogger makes an audit logger writing into a buffer
+func newTestAuditLogger(buf *bytes.Buffer) *AuditLogger {
+	return &AuditLogger{enc: json.NewEncoder(buf), out: buf}
+}
+
+// readAuditRecords decodes all JSON lines from the buffer
+func readAuditRecords(t *testing.T, buf *bytes.Buffer) []AuditRecord {
+	t.Helper()
+	var res []AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var rec AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec), scanner.Text())
+		res = append(res, rec)
+	}
+	return res
+}
+
+func TestNewAuditLogger(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "audit.log")
+		al, err := NewAuditLogger(AuditOpts{File: file, MaxSize: 1, MaxBackups: 1})
+		require.NoError(t, err)
+		al.Log(AuditRecord{User: "bob", IP: "10.0.0.1", Protocol: "http", Action: "download", Path: "a.txt", Bytes: 5, Result: "ok"})
+		al.Log(AuditRecord{Protocol: "sftp", Action: "read", Path: "/b.txt", Result: "not_found"})
+		require.NoError(t, al.Close())
+
+		data, err := os.ReadFile(file) //nolint:gosec // test file
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		require.Len(t, lines, 2)
+
+		var rec AuditRecord
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+		assert.Equal(t, "bob", rec.User)
+		assert.Equal(t, "download", rec.Action)
+		assert.Equal(t, int64(5), rec.Bytes)
+		assert.False(t, rec.Time.IsZero(), "time is set automatically")
+		assert.NotContains(t, lines[1], `"user"`, "anonymous access has no user field")
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		al, err := NewAuditLogger(AuditOpts{File: "-"})
+		require.NoError(t, err)
+		assert.Equal(t, os.Stdout, al.out)
+		require.NoError(t, al.Close())
+	})
+
+	t.Run("unwritable file", func(t *testing.T) {
+		_, err := NewAuditLogger(AuditOpts{File: filepath.Join(t.TempDir(), "missing", "audit.log")})
+		require.Error(t, err)
+	})
+
+	t.Run("nil logger", func(t *testing.T) {
+		var al *AuditLogger
+		assert.NotPanics(t, func() { al.Log(AuditRecord{}) })
+		assert.NoError(t, al.Close())
+	})
+}
+
+func TestAuditResult(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{http.StatusOK, "ok"},
+		{http.StatusPartialContent, "ok"},
+		{http.StatusForbidden, "denied"},
+		{http.StatusUnauthorized, "denied"},
+		{http.StatusNotFound, "not_found"},
+		{http.StatusConflict, "rejected"},
+		{http.StatusRequestEntityTooLarge, "rejected"},
+		{http.StatusInternalServerError, "error"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, auditResult(tc.status), "status %d", tc.status)
+	}
+}
+
+func TestAudit_HTTP(t *testing.T) {
+	var buf bytes.Buffer
+	srv := setupTestServer(t)
+	srv.Audit = newTestAuditLogger(&buf)
+	srv.Auth = "secret"
+	srv.AuthUser = "alice"
+	srv.Exclude = []string{"dir2"}
+
+	get := func(path string) {
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		req.RemoteAddr = "192.0.2.10:12345"
+		rr := httptest.NewRecorder()
+		if strings.HasPrefix(path, "/view/") {
+			srv.handleViewFile(rr, req)
+			return
+		}
+		srv.handleDownload(rr, req)
+	}
+
+	get("/file1.txt")
+	get("/missing.txt")
+	get("/dir2/index.html")
+	get("/dir1")                // redirect, not audited
+	get("/view/dir1/file3.txt") // view
+
+	form := url.Values{"selected-files": {"file1.txt", "dir1"}}
+	req := httptest.NewRequest(http.MethodPost, "/download-selected", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "192.0.2.11"
+	srv.handleDownloadSelected(httptest.NewRecorder(), req)
+
+	recs := readAuditRecords(t, &buf)
+	require.Len(t, recs, 5)
+
+	assert.Equal(t, "alice", recs[0].User)
+	assert.Equal(t, "192.0.2.10", recs[0].IP)
+	assert.Equal(t, "http", recs[0].Protocol)
+	assert.Equal(t, "download", recs[0].Action)
+	assert.Equal(t, "file1.txt", recs[0].Path)
+	assert.Equal(t, int64(13), recs[0].Bytes)
+	assert.Equal(t, "ok", recs[0].Result)
+
+	assert.Equal(t, "not_found", recs[1].Result)
+	assert.Zero(t, recs[1].Bytes)
+	assert.Equal(t, "dir2/index.html", recs[2].Path)
+	assert.Equal(t, "denied", recs[2].Result)
+
+	assert.Equal(t, "view", recs[3].Action)
+	assert.Equal(t, "dir1/file3.txt", recs[3].Path)
+	assert.Equal(t, "ok", recs[3].Result)
+	assert.Positive(t, recs[3].Bytes)
+
+	assert.Equal(t, "download_selected", recs[4].Action)
+	assert.Equal(t, []string{"file1.txt", "dir1"}, recs[4].Files)
+	assert.Equal(t, "192.0.2.11", recs[4].IP)
+	assert.Equal(t, "ok", recs[4].Result)
+	assert.Positive(t, recs[4].Bytes)
+}
+
+func TestAudit_Upload(t *testing.T) {
+	var buf bytes.Buffer
+	tmpDir := t.TempDir()
+	srv := &Web{Config: Config{RootDir: tmpDir, EnableUpload: true, UploadMaxSize: 10 << 20}, Audit: newTestAuditLogger(&buf)}
+
+	req := createMultipartRequest(t, map[string]string{"a.txt": "hello"}, map[string]string{"path": "."})
+	rr := httptest.NewRecorder()
+	srv.handleUpload(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	req = createMultipartRequest(t, map[string]string{"a.txt": "again"}, map[string]string{"path": "."})
+	rr = httptest.NewRecorder()
+	srv.handleUpload(rr, req)
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	recs := readAuditRecords(t, &buf)
+	require.Len(t, recs, 2)
+	assert.Equal(t, "upload", recs[0].Action)
+	assert.Equal(t, "a.txt", recs[0].Path)
+	assert.Equal(t, int64(5), recs[0].Bytes)
+	assert.Equal(t, "ok", recs[0].Result)
+	assert.Empty(t, recs[0].User, "no auth configured")
+
+	assert.Equal(t, "upload", recs[1].Action)
+	assert.Equal(t, ".", recs[1].Path)
+	assert.Equal(t, "rejected", recs[1].Result)
+}
+
+func TestAudit_SFTP(t *testing.T) {
+	var buf bytes.Buffer
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte("content"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "secret.txt"), []byte("secret"), 0o600))
+
+	jailed := &jailedFilesystem{
+		rootDir:  tmpDir,
+		excludes: []string{"secret.txt"},
+		fsys:     os.DirFS(tmpDir),
+		auditLog: newTestAuditLogger(&buf),
+		user:     "sftpuser",
+		remoteIP: "198.51.100.7",
+	}
+
+	// the read is recorded on close, with the amount transferred
+	ra, err := jailed.Fileread(&sftp.Request{Method: "Get", Filepath: "/file.txt"})
+	require.NoError(t, err)
+	buf3 := make([]byte, 3)
+	_, err = ra.ReadAt(buf3, 2)
+	require.NoError(t, err)
+	assert.Empty(t, buf.String(), "nothing recorded before close")
+	require.NoError(t, ra.(io.Closer).Close())
+	require.NoError(t, ra.(io.Closer).Close(), "recorded once")
+	_, err = jailed.Fileread(&sftp.Request{Method: "Get", Filepath: "/secret.txt"})
+	require.Error(t, err)
+	_, err = jailed.Fileread(&sftp.Request{Method: "Get", Filepath: "/missing.txt"})
+	require.Error(t, err)
+	require.Error(t, jailed.Filecmd(&sftp.Request{Method: "Remove", Filepath: "/file.txt"}))
+	require.Error(t, jailed.Filecmd(&sftp.Request{Method: "Rename", Filepath: "/file.txt", Target: "/other.txt"}))
+	_, err = jailed.Filewrite(&sftp.Request{Method: "Put", Filepath: "/new.txt"})
+	require.Error(t, err)
+
+	recs := readAuditRecords(t, &buf)
+	require.Len(t, recs, 6)
+	for _, rec := range recs {
+		assert.Equal(t, "sftpuser", rec.User)
+		assert.Equal(t, "198.51.100.7", rec.IP)
+		assert.Equal(t, "sftp", rec.Protocol)
+	}
+	assert.Equal(t, AuditRecord{Action: "read", Path: "/file.txt", Bytes: 3, Result: "ok"}, stripAuditIdentity(recs[0]))
+	assert.Equal(t, AuditRecord{Action: "read", Path: "/secret.txt", Result: "denied"}, stripAuditIdentity(recs[1]))
+	assert.Equal(t, AuditRecord{Action: "read", Path: "/missing.txt", Result: "not_found"}, stripAuditIdentity(recs[2]))
+	assert.Equal(t, AuditRecord{Action: "delete", Path: "/file.txt", Result: "denied"}, stripAuditIdentity(recs[3]))
+	assert.Equal(t, AuditRecord{Action: "rename", Path: "/file.txt", Result: "denied"}, stripAuditIdentity(recs[4]))
+	assert.Equal(t, AuditRecord{Action: "upload", Path: "/new.txt", Result: "denied"}, stripAuditIdentity(recs[5]))
+}
+
+// stripAuditIdentity clears fields common to all records of a session, leaving the access details
+func stripAuditIdentity(rec AuditRecord) AuditRecord {
+	return AuditRecord{Action: rec.Action, Path: rec.Path, Files: rec.Files, Bytes: rec.Bytes, Result: rec.Result}
+}
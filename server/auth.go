@@ -6,7 +6,9 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -16,32 +18,51 @@ import (
 
 // loginTemplateData holds data for login page template rendering
 type loginTemplateData struct {
-	Theme        string
-	HideFooter   bool
-	Title        string
-	Error        string
-	BrandName    string
-	BrandColor   string
-	CustomFooter string
+	Theme         string
+	HideFooter    bool
+	Title         string
+	Error         string
+	BrandName     string
+	BrandColor    string
+	CustomFooter  string
+	Mount         string          // name of the password-protected mount to log in to, empty for the global login
+	Password      bool            // password login is available
+	OIDC          string          // name of the OIDC provider to log in with, empty if disabled
+	TOTP          string          // pending token of the second factor step, the code form is shown if set
+	Enroll        *totpEnrollment // second factor to set up, shown with the code form on the first login
+	RecoveryCodes []string        // recovery codes shown once after enrollment
 }
 
-// handleLoginPage renders the login page
-func (wb *Web) handleLoginPage(w http.ResponseWriter, _ *http.Request) {
-	data := loginTemplateData{
+// loginData returns login page data of the global login
+func (wb *Web) loginData() loginTemplateData {
+	return loginTemplateData{
 		Theme:        wb.Theme,
 		HideFooter:   wb.HideFooter,
 		Title:        wb.Title,
 		BrandName:    wb.BrandName,
 		BrandColor:   wb.BrandColor,
 		CustomFooter: wb.CustomFooter,
+		Password:     wb.Auth != "" || wb.OIDC == nil, // the form is hidden with OIDC login only
+		OIDC:         wb.OIDC.Name(),
 	}
+}
 
+// renderLogin renders the login page with the data
+func (wb *Web) renderLogin(w http.ResponseWriter, data loginTemplateData) {
 	if err := wb.templates.loginTemplate.Execute(w, data); err != nil {
 		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
-		return
 	}
 }
 
+// handleLoginPage renders the login page, for a password-protected mount if the mount query parameter is set
+func (wb *Web) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	data := wb.loginData()
+	if m, _ := findMount(wb.Mounts, r.URL.Query().Get("mount")); m != nil && m.Auth != "" {
+		data.Mount, data.Password, data.OIDC = m.Name, true, ""
+	}
+	wb.renderLogin(w, data)
+}
+
 // handleLoginSubmit handles the login form submission. CSRF protection is
 // applied at the router level via http.CrossOriginProtection.
 func (wb *Web) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
@@ -50,49 +71,117 @@ func (wb *Web) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if mount := r.FormValue("mount"); mount != "" {
+		wb.handleMountLogin(w, r, mount)
+		return
+	}
+	if wb.Auth == "" {
+		// only mounts have passwords, there is no global session to log in to
+		wb.renderLoginError(w, "Invalid username or password")
+		return
+	}
+	if pending := r.FormValue("pending"); pending != "" && wb.TOTP != nil {
+		wb.handleTOTPSubmit(w, r, pending)
+		return
+	}
+
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	if wb.loginLocked(w, r, username) {
+		return
+	}
 
 	usernameCorrect := subtle.ConstantTimeCompare([]byte(username), []byte(wb.getAuthUser())) == 1
 	passwordCorrect := subtle.ConstantTimeCompare([]byte(password), []byte(wb.Auth)) == 1
 
 	if !usernameCorrect || !passwordCorrect {
+		wb.loginFailed(r, username)
 		wb.renderLoginError(w, "Invalid username or password")
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
-		Name:     "auth",
-		Value:    wb.generateSessionToken(),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   wb.isRequestSecure(r),
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   wb.getSessionMaxAge(),
-	})
+	// with 2FA, the session is set only after the code step, failures are forgotten there as well
+	if wb.TOTP != nil {
+		wb.startTOTP(w, wb.getAuthUser())
+		return
+	}
 
+	wb.Guard.succeeded(wb.guardIP(r), username)
+	wb.setSessionCookie(w, r, "")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// handleMountLogin checks the password of a mount and sets the mount session cookie
+func (wb *Web) handleMountLogin(w http.ResponseWriter, r *http.Request, mount string) {
+	m, rel := findMount(wb.Mounts, mount)
+	if m == nil || rel != "." || m.Auth == "" {
+		wb.renderLoginError(w, "Unknown mount")
+		return
+	}
+	// mount passwords are not accounts, only the address is locked out
+	if wb.Guard.locked(wb.guardIP(r), "") {
+		wb.renderMountLoginError(w, m.Name, lockedOutMessage)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("password")), []byte(m.Auth)) != 1 {
+		wb.loginFailed(r, "")
+		wb.renderMountLoginError(w, m.Name, "Invalid password")
+		return
+	}
+	wb.Guard.succeeded(wb.guardIP(r), "")
+	wb.setMountSession(w, r, m.Name)
+	http.Redirect(w, r, "/?path="+url.QueryEscape(m.Name), http.StatusSeeOther)
+}
+
+// lockedOutMessage is shown on login attempts from a locked out address or for a locked out account
+const lockedOutMessage = "Too many failed attempts, please try again later"
+
+// loginLocked renders the login error and returns true if the address or the account is locked out
+func (wb *Web) loginLocked(w http.ResponseWriter, r *http.Request, user string) bool {
+	if !wb.Guard.locked(wb.guardIP(r), user) {
+		return false
+	}
+	log.Printf("[WARN] login of %q from %s rejected, locked out", user, wb.guardIP(r))
+	wb.renderLoginError(w, lockedOutMessage)
+	return true
+}
+
+// guardIP returns the client address failed logins are counted for. forwarding headers are used only from
+// trusted proxies, otherwise a client could change its address with each attempt, or lock out another one.
+func (wb *Web) guardIP(r *http.Request) string {
+	if addr, ok := wb.Access.clientAddr(r); ok {
+		return addr.String()
+	}
+	return clientIP(peerAddr(r))
+}
+
+// loginFailed records a failed login of the user and waits the progressive delay
+func (wb *Web) loginFailed(r *http.Request, user string) {
+	time.Sleep(wb.Guard.failed(wb.guardIP(r), user, "http"))
+}
+
 // renderLoginError renders the login page with an error message
 func (wb *Web) renderLoginError(w http.ResponseWriter, errorMsg string) {
-	data := loginTemplateData{
-		Theme:        wb.Theme,
-		HideFooter:   wb.HideFooter,
-		Title:        wb.Title,
-		BrandName:    wb.BrandName,
-		BrandColor:   wb.BrandColor,
-		Error:        errorMsg,
-		CustomFooter: wb.CustomFooter,
-	}
+	wb.renderMountLoginError(w, "", errorMsg)
+}
 
-	if err := wb.templates.loginTemplate.Execute(w, data); err != nil {
-		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+// renderMountLoginError renders the login page for the mount with an error message
+func (wb *Web) renderMountLoginError(w http.ResponseWriter, mount, errorMsg string) {
+	data := wb.loginData()
+	data.Error = errorMsg
+	if mount != "" {
+		data.Mount, data.Password, data.OIDC = mount, true, ""
 	}
+	wb.renderLogin(w, data)
 }
 
 // handleLogout handles the logout request
 func (wb *Web) handleLogout(w http.ResponseWriter, r *http.Request) {
+	// end the session on the server, so a copy of the cookie stops working as well
+	if cookie, err := r.Cookie("auth"); err == nil && wb.Sessions != nil && wb.validateSessionToken(cookie.Value) {
+		wb.Sessions.revoke(sessionID(cookie.Value))
+	}
+
 	// clear the auth cookie
 	http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
 		Name:     "auth",
@@ -104,28 +193,47 @@ func (wb *Web) handleLogout(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   -1, // delete the cookie
 	})
 
-	// redirect to the login page
+	// clear sessions of password-protected mounts as well
+	for _, m := range wb.Mounts {
+		if m.Auth == "" {
+			continue
+		}
+		http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
+			Name:     mountAuthCookie(m.Name),
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   wb.isRequestSecure(r),
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   -1,
+		})
+	}
+
+	// redirect to the login page, or home if only mounts have passwords
+	if !wb.authEnabled() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
 // isAuthenticatedByCookie checks if the user is authenticated via cookie
 func (wb *Web) isAuthenticatedByCookie(r *http.Request) bool {
-	cookie, err := r.Cookie("auth")
-	if err != nil {
-		return false
-	}
-
-	// validate the session token
-	return wb.validateSessionToken(cookie.Value)
+	_, _, ok := wb.activeSession(r)
+	return ok
 }
 
 // tryBasicAuth checks if the user is authenticated via basic auth
-// and sets a cookie on success
+// and sets a cookie on success, without the session store
 func (wb *Web) tryBasicAuth(w http.ResponseWriter, r *http.Request) bool {
 	username, password, ok := r.BasicAuth()
 
-	// if basic auth is not provided or invalid
-	if !ok {
+	// if basic auth is not provided or invalid, or there is no password, e.g. with OIDC login only.
+	// basic auth can't pass the second factor, so it's disabled with 2FA.
+	if !ok || wb.Auth == "" || wb.TOTP != nil {
+		return false
+	}
+	if wb.Guard.locked(wb.guardIP(r), username) {
 		return false
 	}
 
@@ -134,23 +242,69 @@ func (wb *Web) tryBasicAuth(w http.ResponseWriter, r *http.Request) bool {
 
 	// if credentials don't match
 	if !usernameCorrect || !passwordCorrect {
+		wb.loginFailed(r, username)
 		return false
 	}
+	wb.Guard.succeeded(wb.guardIP(r), username)
 
-	// set cookie for future requests
-	http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
-		Name:     "auth",
-		Value:    wb.generateSessionToken(),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   wb.isRequestSecure(r),
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   wb.getSessionMaxAge(),
-	})
+	// set cookie for future requests. with the session store, basic auth is checked on each request instead,
+	// as scripts don't keep cookies and would start a stored session with every request.
+	if wb.Sessions == nil {
+		wb.setSessionCookie(w, r, "")
+	}
 
 	return true
 }
 
+// currentUser returns the name of the user making the request, empty when auth is disabled.
+// protected routes run only after authMiddleware accepted the request, so it is the identified user
+// or the configured one of the password login.
+func (wb *Web) currentUser(r *http.Request) string {
+	if user := wb.identifiedUser(r); user != "" {
+		return user
+	}
+	if wb.Auth == "" {
+		return ""
+	}
+	return wb.getAuthUser()
+}
+
+// identifiedUser returns the user of the client certificate, the trusted proxy or the session,
+// empty for the shared user of the password login and anonymous users
+func (wb *Web) identifiedUser(r *http.Request) string {
+	if user := clientCertUser(r); user != "" {
+		return user
+	}
+	if user := wb.Proxy.user(r); user != "" {
+		return user
+	}
+	return wb.sessionUser(r)
+}
+
+// authEnabled returns true if the whole site requires login, with password, OIDC or a trusted proxy
+func (wb *Web) authEnabled() bool {
+	return wb.Auth != "" || wb.OIDC != nil || wb.Proxy != nil
+}
+
+// sessionUser returns the user of a valid session cookie issued for a named user, e.g. by OIDC login.
+// sessions of the password login have no user, the configured one applies.
+func (wb *Web) sessionUser(r *http.Request) string {
+	token, _, ok := wb.activeSession(r)
+	if !ok {
+		return ""
+	}
+	tokenID, _, _ := strings.Cut(token, ".")
+	_, encUser, found := strings.Cut(tokenID, "!")
+	if !found {
+		return ""
+	}
+	user, err := base64.RawURLEncoding.DecodeString(encUser)
+	if err != nil {
+		return ""
+	}
+	return string(user)
+}
+
 // getAuthUser returns the configured auth username or "weblist" as default
 func (wb *Web) getAuthUser() string {
 	if wb.AuthUser == "" {
@@ -185,8 +339,31 @@ func (wb *Web) normalizeBrandColor(color string) string {
 // generateSessionToken creates a secure session token based on a random value
 // and the current timestamp, signed with a secret key
 func (wb *Web) generateSessionToken() string {
+	return wb.generateScopedToken("")
+}
+
+// generateUserSessionToken creates a session token of the named user, the user is a part of the signed token ID
+func (wb *Web) generateUserSessionToken(user string) string {
+	return wb.generateToken("", user)
+}
+
+// generateScopedToken creates a session token valid only for the given scope, e.g. a single mount.
+// the scope is part of the signed token ID, the global session uses an empty scope.
+func (wb *Web) generateScopedToken(scope string) string {
+	return wb.generateToken(scope, "")
+}
+
+// generateToken creates a session token for the scope and the user, both optional
+func (wb *Web) generateToken(scope, user string) string {
 	// create a unique random ID
 	tokenID := uuid.NewString()
+	if user != "" {
+		tokenID += "!" + base64.RawURLEncoding.EncodeToString([]byte(user))
+	}
+	if scope != "" {
+		// encoded, as scopes made of mount names may contain the token separator
+		tokenID = base64.RawURLEncoding.EncodeToString([]byte(scope)) + "~" + tokenID
+	}
 
 	// use SessionSecret as the signing key
 	secret := []byte(wb.SessionSecret)
@@ -211,12 +388,24 @@ func (wb *Web) generateSessionToken() string {
 
 // validateSessionToken validates the session token
 func (wb *Web) validateSessionToken(token string) bool {
+	return wb.validateScopedToken(token, "")
+}
+
+// validateScopedToken validates the session token and checks it was issued for the given scope
+func (wb *Web) validateScopedToken(token, scope string) bool {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
 		return false
 	}
 
 	tokenID := parts[0]
+	tokenScope, _, found := strings.Cut(tokenID, "~")
+	if !found {
+		tokenScope = ""
+	}
+	if tokenScope != base64.RawURLEncoding.EncodeToString([]byte(scope)) {
+		return false
+	}
 	timestamp := parts[1]
 	signatureB64 := parts[2]
 
@@ -245,6 +434,11 @@ func (wb *Web) validateSessionToken(token string) bool {
 		return false
 	}
 
+	// with the session store, global sessions expire after inactivity instead, tracked by the store
+	if scope == "" && wb.Sessions != nil {
+		return true
+	}
+
 	// get session TTL, default to 24 hours if not set
 	maxAge := wb.SessionTTL
 	if maxAge == 0 {
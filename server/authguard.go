@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// authGuardMaxDelay caps the progressive delay after failed authentication
+const authGuardMaxDelay = 10 * time.Second
+
+// AuthGuardOpts defines thresholds of the brute-force protection
+type AuthGuardOpts struct {
+	MaxIPFailures   int           // failures from an address before it's locked out, 10 if zero
+	MaxUserFailures int           // failures for an account before it's locked out, 20 if zero
+	Window          time.Duration // failures older than this are forgotten, 15 minutes if zero
+	Lockout         time.Duration // how long a locked address or account is rejected, 15 minutes if zero
+	Delay           time.Duration // delay after a failure, doubled with each following one, no delay if zero
+	Metrics         *Metrics      // optional, lockouts are counted if set
+	Audit           *AuditLogger  // optional, lockouts are recorded if set
+}
+
+// AuthGuard tracks failed authentication over HTTP and SFTP by client address and by account.
+// failures slow down following attempts, too many of them lock the address or the account out for a while.
+// All methods are safe to call on a nil receiver, which makes the protection optional for callers.
+type AuthGuard struct {
+	opts AuthGuardOpts
+
+	mu    sync.Mutex
+	ips   map[string]*authFailures
+	users map[string]*authFailures
+}
+
+// authFailures keeps recent failures of an address or an account
+type authFailures struct {
+	count       int       // failures in the current window
+	first       time.Time // first failure of the window
+	last        time.Time // most recent failure
+	lockedUntil time.Time // attempts are rejected until this time
+}
+
+// NewAuthGuard makes the brute-force protection, zero thresholds are set to defaults
+func NewAuthGuard(opts AuthGuardOpts) *AuthGuard {
+	if opts.MaxIPFailures <= 0 {
+		opts.MaxIPFailures = 10
+	}
+	if opts.MaxUserFailures <= 0 {
+		opts.MaxUserFailures = 20
+	}
+	if opts.Window <= 0 {
+		opts.Window = 15 * time.Minute
+	}
+	if opts.Lockout <= 0 {
+		opts.Lockout = 15 * time.Minute
+	}
+	return &AuthGuard{opts: opts, ips: map[string]*authFailures{}, users: map[string]*authFailures{}}
+}
+
+// Run removes stale records periodically until the context is canceled
+func (g *AuthGuard) Run(ctx context.Context) {
+	if g == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.cleanup(time.Now())
+		}
+	}
+}
+
+// locked checks the address or the account is locked out, the attempt should be rejected without checking credentials
+func (g *AuthGuard) locked(ip, user string) bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	if rec, ok := g.ips[ip]; ok && now.Before(rec.lockedUntil) {
+		return true
+	}
+	if rec, ok := g.users[user]; ok && user != "" && now.Before(rec.lockedUntil) {
+		return true
+	}
+	return false
+}
+
+// failed records a failed attempt of the protocol (http or sftp) and locks the address or the account out if
+// it reached the threshold. it returns the progressive delay the caller should wait before responding.
+func (g *AuthGuard) failed(ip, user, protocol string) time.Duration {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	now := time.Now()
+	ipCount := g.record(g.ips, ip, now)
+	userCount := 0
+	if user != "" {
+		userCount = g.record(g.users, user, now)
+	}
+	var lockouts []string
+	if ipCount >= g.opts.MaxIPFailures {
+		g.ips[ip].lockedUntil, g.ips[ip].count = now.Add(g.opts.Lockout), 0
+		lockouts = append(lockouts, "ip")
+	}
+	if user != "" && userCount >= g.opts.MaxUserFailures {
+		g.users[user].lockedUntil, g.users[user].count = now.Add(g.opts.Lockout), 0
+		lockouts = append(lockouts, "user")
+	}
+	g.mu.Unlock()
+
+	for _, kind := range lockouts {
+		log.Printf("[WARN] %s authentication locked out for %s, user %q from %s", protocol, g.opts.Lockout, user, ip)
+		g.opts.Metrics.authLockout(kind, protocol)
+		g.opts.Audit.Log(AuditRecord{User: user, IP: ip, Protocol: protocol, Action: "lockout_" + kind, Result: "denied"})
+	}
+	return g.delay(max(ipCount, userCount))
+}
+
+// succeeded forgets failures of the address and the account after a successful attempt
+func (g *AuthGuard) succeeded(ip, user string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if rec, ok := g.ips[ip]; ok && !time.Now().Before(rec.lockedUntil) {
+		delete(g.ips, ip)
+	}
+	if rec, ok := g.users[user]; ok && !time.Now().Before(rec.lockedUntil) {
+		delete(g.users, user)
+	}
+}
+
+// record counts a failure of the key in the current window and returns the count, the caller holds the lock
+func (g *AuthGuard) record(records map[string]*authFailures, key string, now time.Time) int {
+	rec, ok := records[key]
+	if !ok {
+		rec = &authFailures{}
+		records[key] = rec
+	}
+	if now.Sub(rec.first) > g.opts.Window {
+		rec.count, rec.first = 0, now
+	}
+	rec.count++
+	rec.last = now
+	return rec.count
+}
+
+// delay returns the wait after the given number of recent failures, doubled with each of them
+func (g *AuthGuard) delay(failures int) time.Duration {
+	if g.opts.Delay <= 0 || failures <= 0 {
+		return 0
+	}
+	res := g.opts.Delay
+	for range failures - 1 {
+		if res *= 2; res >= authGuardMaxDelay {
+			return authGuardMaxDelay
+		}
+	}
+	return min(res, authGuardMaxDelay)
+}
+
+// cleanup removes records without recent failures and active lockouts
+func (g *AuthGuard) cleanup(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, records := range []map[string]*authFailures{g.ips, g.users} {
+		for key, rec := range records {
+			if now.Sub(rec.last) > g.opts.Window && now.After(rec.lockedUntil) {
+				delete(records, key)
+			}
+		}
+	}
+}
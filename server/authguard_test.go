@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthGuard(t *testing.T) {
+	var buf bytes.Buffer
+	audit := &AuditLogger{enc: json.NewEncoder(&buf), out: &buf}
+	m := NewMetrics()
+	g := NewAuthGuard(AuthGuardOpts{MaxIPFailures: 3, MaxUserFailures: 5, Lockout: time.Minute, Delay: 100 * time.Millisecond,
+		Metrics: m, Audit: audit})
+
+	assert.Equal(t, 100*time.Millisecond, g.failed("10.0.0.1", "alice", "http"))
+	assert.Equal(t, 200*time.Millisecond, g.failed("10.0.0.1", "alice", "http"), "delay doubles")
+	g.succeeded("10.0.0.1", "alice")
+	assert.Equal(t, 100*time.Millisecond, g.failed("10.0.0.1", "alice", "http"), "success resets failures")
+
+	g.failed("10.0.0.1", "alice", "http")
+	assert.False(t, g.locked("10.0.0.1", "alice"))
+	g.failed("10.0.0.1", "alice", "sftp")
+	assert.True(t, g.locked("10.0.0.1", "bob"), "address locked out")
+	assert.False(t, g.locked("10.0.0.2", "alice"))
+	g.succeeded("10.0.0.1", "")
+	assert.True(t, g.locked("10.0.0.1", ""), "success doesn't lift a lockout")
+
+	// account is locked out for failures from many addresses
+	g.failed("10.0.0.3", "alice", "sftp")
+	g.failed("10.0.0.4", "alice", "http")
+	assert.True(t, g.locked("10.0.0.5", "alice"), "account locked out")
+	assert.False(t, g.locked("10.0.0.5", "bob"))
+
+	assert.Contains(t, scrapeMetrics(t, m), `weblist_auth_lockouts_total{kind="ip",protocol="sftp"} 1`)
+	assert.Contains(t, scrapeMetrics(t, m), `weblist_auth_lockouts_total{kind="user",protocol="http"} 1`)
+	assert.Contains(t, buf.String(), `"action":"lockout_ip"`)
+	assert.Contains(t, buf.String(), `"user":"alice","ip":"10.0.0.4","protocol":"http","action":"lockout_user"`)
+
+	// lockouts expire, stale records are cleaned up
+	g.cleanup(time.Now().Add(time.Hour))
+	assert.False(t, g.locked("10.0.0.1", "alice"))
+	assert.Empty(t, g.ips)
+	assert.Empty(t, g.users)
+
+	assert.Equal(t, authGuardMaxDelay, g.delay(20), "delay is capped")
+	assert.Zero(t, NewAuthGuard(AuthGuardOpts{}).failed("10.0.0.1", "alice", "http"), "no delay by default")
+}
+
+func TestAuthGuard_NilSafe(t *testing.T) {
+	var g *AuthGuard
+	assert.NotPanics(t, func() {
+		assert.False(t, g.locked("10.0.0.1", "alice"))
+		assert.Zero(t, g.failed("10.0.0.1", "alice", "http"))
+		g.succeeded("10.0.0.1", "alice")
+		g.Run(context.Background())
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	srv := &Web{Config: Config{RootDir: "testdata", Auth: "password", SessionSecret: "session-secret", Title: "Test"},
+		FS: os.DirFS("testdata"), Guard: NewAuthGuard(AuthGuardOpts{MaxIPFailures: 2, MaxUserFailures: 3})}
+	require.NoError(t, srv.initTemplates())
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	login := func(addr, password string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"weblist"}, "password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Contains(t, login("10.0.0.1:1000", "wrong").Body.String(), "Invalid username or password")
+	assert.Equal(t, http.StatusSeeOther, login("10.0.0.1:1000", "password").Code)
+
+	login("10.0.0.1:1000", "wrong")
+	login("10.0.0.1:1000", "wrong")
+	rr := login("10.0.0.1:1000", "password")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), lockedOutMessage, "address locked out")
+
+	// basic auth is rejected from the locked out address as well
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.RemoteAddr = "10.0.0.1:1000"
+	req.SetBasicAuth("weblist", "password")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	// third failure of the account locks it out for all addresses
+	login("10.0.0.2:1000", "wrong")
+	assert.Contains(t, login("10.0.0.3:1000", "password").Body.String(), lockedOutMessage, "account locked out")
+}
+
+func TestLoginLockoutForwardedFor(t *testing.T) {
+	newRouter := func(access *AccessList) http.Handler {
+		srv := &Web{Config: Config{RootDir: "testdata", Auth: "password", SessionSecret: "session-secret", Title: "Test"},
+			FS: os.DirFS("testdata"), Guard: NewAuthGuard(AuthGuardOpts{MaxIPFailures: 2, MaxUserFailures: 100}), Access: access}
+		require.NoError(t, srv.initTemplates())
+		router, err := srv.router()
+		require.NoError(t, err)
+		return router
+	}
+	login := func(router http.Handler, peer, forwarded, password string) string {
+		form := url.Values{"username": {"weblist"}, "password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", forwarded)
+		req.RemoteAddr = peer
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	t.Run("forwarding headers of clients ignored", func(t *testing.T) {
+		router := newRouter(nil)
+		login(router, "10.0.0.1:1000", "1.1.1.1", "wrong")
+		login(router, "10.0.0.1:1000", "1.1.1.2", "wrong")
+		assert.Contains(t, login(router, "10.0.0.1:1000", "1.1.1.3", "password"), lockedOutMessage,
+			"address locked out with a new forwarded address")
+
+		// failures with the address of another client in the header don't lock it out
+		login(router, "10.0.0.2:1000", "8.8.8.8", "wrong")
+		login(router, "10.0.0.2:1000", "8.8.8.8", "wrong")
+		assert.NotContains(t, login(router, "8.8.8.8:1000", "", "password"), lockedOutMessage)
+	})
+
+	t.Run("forwarding headers of trusted proxy used", func(t *testing.T) {
+		access, err := NewAccessList(AccessOpts{Trusted: []string{"10.0.0.100"}})
+		require.NoError(t, err)
+		router := newRouter(access)
+		login(router, "10.0.0.100:1000", "1.1.1.1", "wrong")
+		login(router, "10.0.0.100:1000", "1.1.1.1", "wrong")
+		assert.Contains(t, login(router, "10.0.0.100:1000", "1.1.1.1", "password"), lockedOutMessage)
+		assert.NotContains(t, login(router, "10.0.0.100:1000", "1.1.1.2", "password"), lockedOutMessage,
+			"other clients of the proxy are not locked out")
+	})
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// etagSalt makes tags of generated responses change on restart, as they depend on the configuration too
+var etagSalt = uuid.NewString()
+
+// assetMaxAge is the lifetime of embedded assets requested with their content hash
+const assetMaxAge = 365 * 24 * time.Hour
+
+// etag returns a weak ETag of a generated response, built from the parts of its content and the request
+// details responses depend on: the URL, cookies with sorting, session and git ref, htmx requests and the user
+func (wb *Web) etag(r *http.Request, parts ...string) string {
+	h := sha256.New()
+	for _, s := range []string{etagSalt, wb.Version, r.URL.Path, r.URL.RawQuery, r.Header.Get("HX-Request"),
+		r.Header.Get("Cookie"), wb.identifiedUser(r)} {
+		_, _ = io.WriteString(h, s)
+		_, _ = h.Write([]byte{0})
+	}
+	for _, s := range parts {
+		_, _ = io.WriteString(h, s)
+		_, _ = h.Write([]byte{0})
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// dirSignature describes the listing of the directory: the directory itself, its parent shown as "..",
+// and the names, sizes and mtimes of visible entries, so it changes with anything the listing shows
+func (wb *Web) dirSignature(dir string) (string, error) {
+	info, err := fs.Stat(wb.FS, dir)
+	if err != nil {
+		return "", fmt.Errorf("stat %s: %w", dir, err)
+	}
+	entries, err := fs.ReadDir(wb.FS, dir)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", dir, err)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s:%d\n", dir, info.ModTime().UnixNano())
+	if dir != "." {
+		if parent, err := fs.Stat(wb.FS, filepath.Dir(dir)); err == nil {
+			fmt.Fprintf(&sb, "..:%d\n", parent.ModTime().UnixNano())
+		}
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.Name())
+		if wb.shouldExclude(entryPath) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fi := FileInfo{Name: entry.Name(), Path: entryPath, IsDir: entry.IsDir(), Size: info.Size(), LastModified: info.ModTime()}
+		if commit, ok := info.Sys().(*CommitInfo); ok {
+			fi.Commit = commit
+		}
+		if fi.IsDir && wb.RecursiveMtime {
+			wb.applyRecursiveStats(&fi)
+		}
+		fmt.Fprintf(&sb, "%s:%t:%d:%d:%d", fi.Name, fi.IsDir, fi.Size, fi.TotalSize, fi.LastModified.UnixNano())
+		if fi.Commit != nil {
+			fmt.Fprintf(&sb, ":%s", fi.Commit.Hash)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
+
+// listingNotModified responds with 304 if the client has the current listing of the directory.
+// listings have no Last-Modified, as mtimes don't cover every change, e.g. a deleted entry on S3.
+func (wb *Web) listingNotModified(w http.ResponseWriter, r *http.Request, dir string, parts ...string) bool {
+	sig, err := wb.dirSignature(dir)
+	if err != nil {
+		return false // the listing reports the error
+	}
+	return notModified(w, r, wb.etag(r, append(parts, sig)...), time.Time{})
+}
+
+// notModified sets validators of a generated response and responds with 304 if the client has the current
+// version. the response is revalidated on every use, and only by the client, as it may depend on the user.
+// lastModified is ignored if zero.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	hdr := w.Header()
+	hdr.Set("ETag", etag)
+	hdr.Set("Cache-Control", "private, no-cache")
+	if !lastModified.IsZero() {
+		hdr.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	switch {
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		return false
+	case r.Header.Get("If-None-Match") != "":
+		if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+			return false
+		}
+	case r.Header.Get("If-Modified-Since") != "" && !lastModified.IsZero():
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	default:
+		return false
+	}
+	hdr.Del("Content-Type")
+	hdr.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches checks If-None-Match header lists the tag, with weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// fileETag returns the strong ETag of a file served as is, from its mtime and size. the encoding of a
+// precompressed sibling is added, as its bytes differ from the file.
+func fileETag(info fs.FileInfo, encoding string) string {
+	if encoding != "" {
+		encoding = "-" + encoding
+	}
+	return fmt.Sprintf(`"%x-%x%s"`, info.ModTime().UnixNano(), info.Size(), encoding)
+}
+
+// assetHashes keeps content hashes of embedded assets by path, they are computed on first use
+var assetHashes = struct {
+	once   sync.Once
+	hashes map[string]string
+}{}
+
+// assetHash returns the content hash of the embedded asset, empty if there is no such asset
+func assetHash(assetsFS fs.FS, path string) string {
+	assetHashes.once.Do(func() {
+		assetHashes.hashes = map[string]string{}
+		_ = fs.WalkDir(assetsFS, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := fs.ReadFile(assetsFS, p)
+			if err != nil {
+				return fmt.Errorf("read asset %s: %w", p, err)
+			}
+			sum := sha256.Sum256(data)
+			assetHashes.hashes[p] = hex.EncodeToString(sum[:6])
+			return nil
+		})
+	})
+	return assetHashes.hashes[path]
+}
+
+// assetURL returns the URL of the embedded asset with its content hash, such URLs are cached by browsers
+// for good, as a changed asset gets a new one
+func assetURL(assetsFS fs.FS, path string) string {
+	if hash := assetHash(assetsFS, path); hash != "" {
+		return "/assets/" + path + "?v=" + hash
+	}
+	return "/assets/" + path
+}
+
+// serveAsset serves the embedded asset. requested with its current content hash, it's cached as immutable,
+// otherwise revalidated with its ETag on every use.
+func serveAsset(w http.ResponseWriter, r *http.Request, assetsFS fs.FS, path string) {
+	if hash := assetHash(assetsFS, path); hash != "" {
+		w.Header().Set("ETag", `"`+hash+`"`)
+		if r.URL.Query().Get("v") == hash {
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(assetMaxAge.Seconds())))
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+	}
+	http.ServeFileFS(w, r, assetsFS, path)
+}
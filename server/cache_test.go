@@ -0,0 +1,180 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lcw/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`W/"abc"`, `W/"abc"`))
+	assert.True(t, etagMatches(`"abc"`, `W/"abc"`), "weak comparison")
+	assert.True(t, etagMatches(`"x", W/"abc"`, `W/"abc"`))
+	assert.True(t, etagMatches(`*`, `"abc"`))
+	assert.False(t, etagMatches(`W/"abd"`, `W/"abc"`))
+}
+
+func TestNotModified(t *testing.T) {
+	mtime := time.Date(2025, 5, 1, 10, 0, 0, 500, time.UTC)
+	check := func(hdr map[string]string, lastModified time.Time) (bool, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		return notModified(rr, req, `W/"v1"`, lastModified), rr
+	}
+
+	ok, rr := check(nil, mtime)
+	assert.False(t, ok)
+	assert.Equal(t, `W/"v1"`, rr.Header().Get("ETag"))
+	assert.Equal(t, "private, no-cache", rr.Header().Get("Cache-Control"))
+	assert.Equal(t, "Thu, 01 May 2025 10:00:00 GMT", rr.Header().Get("Last-Modified"))
+
+	ok, rr = check(map[string]string{"If-None-Match": `W/"v1"`}, mtime)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	ok, _ = check(map[string]string{"If-None-Match": `W/"v0"`, "If-Modified-Since": "Thu, 01 May 2025 10:00:00 GMT"}, mtime)
+	assert.False(t, ok, "If-None-Match takes precedence")
+
+	ok, _ = check(map[string]string{"If-Modified-Since": "Thu, 01 May 2025 10:00:00 GMT"}, mtime)
+	assert.True(t, ok)
+	ok, _ = check(map[string]string{"If-Modified-Since": "Thu, 01 May 2025 09:59:59 GMT"}, mtime)
+	assert.False(t, ok)
+	ok, rr = check(map[string]string{"If-Modified-Since": "Thu, 01 May 2025 10:00:00 GMT"}, time.Time{})
+	assert.False(t, ok, "no Last-Modified")
+	assert.Empty(t, rr.Header().Get("Last-Modified"))
+}
+
+func TestConditionalListingsAndViews(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o750))
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test"}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	get := func(target string, hdr ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, target := range []string{"/", "/api/list", "/partials/dir-contents?path=sub", "/view/a.txt"} {
+		rr := get(target, "HX-Request", "true")
+		require.Equal(t, http.StatusOK, rr.Code, target)
+		etag := rr.Header().Get("ETag")
+		require.Regexp(t, `^W/"[0-9a-f]{32}"$`, etag, target)
+
+		rr = get(target, "HX-Request", "true", "If-None-Match", etag)
+		assert.Equal(t, http.StatusNotModified, rr.Code, target)
+		assert.Empty(t, rr.Body.String(), target)
+
+		// another sorting, user or request kind has its own tag
+		rr = get(target, "HX-Request", "true", "If-None-Match", etag, "Cookie", "sortBy=size")
+		assert.Equal(t, http.StatusOK, rr.Code, target)
+	}
+
+	// listing changes with its entries
+	rr := get("/api/list")
+	etag := rr.Header().Get("ETag")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("new"), 0o600))
+	assert.Equal(t, http.StatusOK, get("/api/list", "If-None-Match", etag).Code)
+
+	// view changes with the file and honours If-Modified-Since
+	rr = get("/view/a.txt")
+	etag, lastModified := rr.Header().Get("ETag"), rr.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified)
+	assert.Equal(t, http.StatusNotModified, get("/view/a.txt", "If-Modified-Since", lastModified).Code)
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a.txt"), later, later))
+	assert.Equal(t, http.StatusOK, get("/view/a.txt", "If-None-Match", etag).Code)
+	assert.Equal(t, http.StatusOK, get("/view/a.txt", "If-Modified-Since", lastModified).Code)
+
+	// files served as is get a strong tag, checked by http.ServeContent
+	rr = get("/a.txt")
+	require.Equal(t, http.StatusOK, rr.Code)
+	etag = rr.Header().Get("ETag")
+	require.Regexp(t, `^"[0-9a-f]+-5"$`, etag)
+	assert.Equal(t, http.StatusNotModified, get("/a.txt", "If-None-Match", etag).Code)
+}
+
+func TestViewCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "readme.md")
+	require.NoError(t, os.WriteFile(path, []byte("# Title"), 0o600))
+	cache, err := lcw.NewLruCache(lcw.NewOpts[viewFileData]().MaxKeys(10))
+	require.NoError(t, err)
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test"}, FS: os.DirFS(dir), viewCache: cache}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	view := func() string {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/view/readme.md", http.NoBody))
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Contains(t, view(), "Title</h1>")
+
+	// same size and mtime, rendered view is served from cache
+	require.NoError(t, os.WriteFile(path, []byte("# Other"), 0o600))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	assert.Contains(t, view(), "Title</h1>")
+
+	later := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+	assert.Contains(t, view(), "Other</h1>")
+}
+
+func TestAssetCaching(t *testing.T) {
+	srv := &Web{Config: Config{RootDir: "testdata", Title: "Test"}, FS: os.DirFS("testdata")}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	get := func(target string, hdr ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// pages refer to assets by content hashed URLs
+	page := get("/").Body.String()
+	m := regexp.MustCompile(`href="(/assets/css/weblist-app\.css\?v=([0-9a-f]+))"`).FindStringSubmatch(page)
+	require.Len(t, m, 3, "hashed css url in page")
+	assetsFS, err := fs.Sub(content, "assets")
+	require.NoError(t, err)
+	assert.Equal(t, assetHash(assetsFS, "css/weblist-app.css"), m[2])
+
+	rr := get(m[1])
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "public, max-age=31536000, immutable", rr.Header().Get("Cache-Control"))
+	assert.Equal(t, `"`+m[2]+`"`, rr.Header().Get("ETag"))
+
+	rr = get("/assets/css/weblist-app.css")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"), "revalidated without the hash")
+	assert.Equal(t, http.StatusNotModified, get("/assets/css/weblist-app.css", "If-None-Match", `"`+m[2]+`"`).Code)
+
+	assert.Equal(t, "/assets/unknown.css", assetURL(assetsFS, "unknown.css"))
+}
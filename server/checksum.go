@@ -0,0 +1,228 @@
+package server
+
+import (
+	"crypto/md5" //nolint:gosec // G501: md5 is offered for compatibility with published checksums, not for security
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/zeebo/blake3"
+)
+
+// checksumsFile is the name of the manifest generated for each directory, in the format of sha256sum
+const checksumsFile = "SHA256SUMS"
+
+// defaultChecksumAlgo is used if the algorithm is not requested
+const defaultChecksumAlgo = "sha256"
+
+// checksumAlgos are the supported checksum algorithms by name
+var checksumAlgos = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"md5":    md5.New,
+	"blake3": func() hash.Hash { return blake3.New() },
+}
+
+// errUnknownChecksumAlgo is returned for algorithms not in checksumAlgos
+var errUnknownChecksumAlgo = errors.New("unknown checksum algorithm")
+
+// checksumResponse is the checksum of a file for JSON response
+type checksumResponse struct {
+	Path     string `json:"path"`
+	Algo     string `json:"algo"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// versionedInfo is file info of storage identifying the content, e.g. by the git blob hash or the S3 ETag
+type versionedInfo interface {
+	contentVersion() string
+}
+
+// contentVersion identifies the content of the file for caches, by the version reported by the storage,
+// or by mtime and size otherwise
+func contentVersion(info fs.FileInfo) string {
+	if v, ok := info.(versionedInfo); ok {
+		if version := v.contentVersion(); version != "" {
+			return version
+		}
+	}
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// checksum returns the hex encoded checksum of the file, cached by algorithm, path and content version,
+// so changed files are hashed again, and files of other git refs are not mixed up
+func (wb *Web) checksum(p, algo, version string) (string, error) {
+	newHash, ok := checksumAlgos[algo]
+	if !ok {
+		return "", fmt.Errorf("%w %q", errUnknownChecksumAlgo, algo)
+	}
+	compute := func() (string, error) {
+		f, err := wb.FS.Open(p)
+		if err != nil {
+			return "", fmt.Errorf("open %s: %w", p, err)
+		}
+		defer func() { _ = f.Close() }()
+		h := newHash()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", fmt.Errorf("read %s: %w", p, err)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	// fallback to direct computation if cache not initialized (e.g., in tests)
+	if wb.checksumCache == nil {
+		return compute()
+	}
+	key := fmt.Sprintf("%s:%s:%s", algo, p, version)
+	return wb.checksumCache.Get(key, compute)
+}
+
+// checksumAlgo returns the algorithm requested by the algo query parameter, sha256 by default
+func checksumAlgo(r *http.Request) (string, error) {
+	algo := strings.ToLower(r.URL.Query().Get("algo"))
+	if algo == "" {
+		return defaultChecksumAlgo, nil
+	}
+	if _, ok := checksumAlgos[algo]; !ok {
+		return "", fmt.Errorf("%w %q, supported: %s", errUnknownChecksumAlgo, algo, strings.Join(checksumAlgoNames(), ", "))
+	}
+	return algo, nil
+}
+
+// checksumAlgoNames returns the names of the supported algorithms, sorted
+func checksumAlgoNames() []string {
+	res := make([]string, 0, len(checksumAlgos))
+	for name := range checksumAlgos {
+		res = append(res, name)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// requestChecksum computes the checksum of the file requested by path and algo query parameters.
+// errors are reported with fail, except for the mount login which is handled by requireMountAuth.
+func (wb *Web) requestChecksum(w http.ResponseWriter, r *http.Request, fail func(status int, msg string)) (checksumResponse, bool) {
+	algo, err := checksumAlgo(r)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return checksumResponse{}, false
+	}
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		fail(http.StatusBadRequest, "file path not provided")
+		return checksumResponse{}, false
+	}
+	// clean the path to avoid directory traversal
+	p = filepath.ToSlash(filepath.Clean(p))
+
+	if wb.shouldExclude(p) {
+		fail(http.StatusForbidden, "access denied to requested file")
+		return checksumResponse{}, false
+	}
+	if !wb.requireMountAuth(w, r, p) {
+		return checksumResponse{}, false
+	}
+
+	info, err := fs.Stat(wb.FS, p)
+	if err != nil {
+		fail(http.StatusNotFound, "file not found")
+		return checksumResponse{}, false
+	}
+	if info.IsDir() {
+		fail(http.StatusBadRequest, "not a file")
+		return checksumResponse{}, false
+	}
+
+	sum, err := wb.checksum(p, algo, contentVersion(info))
+	if err != nil {
+		log.Printf("[WARN] failed to compute %s checksum of %s: %v", algo, p, err)
+		fail(http.StatusInternalServerError, "error computing checksum")
+		return checksumResponse{}, false
+	}
+	return checksumResponse{Path: p, Algo: algo, Checksum: sum, Size: info.Size()}, true
+}
+
+// handleAPIChecksum handles API requests for the checksum of a file with JSON response
+// It supports query parameters:
+// - path: the file path
+// - algo: checksum algorithm, sha256 (default), sha512, md5 or blake3
+func (wb *Web) handleAPIChecksum(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
+	fail := func(status int, msg string) { wb.writeJSONError(w, status, msg) }
+	res, ok := wb.requestChecksum(w, r, fail)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("[ERROR] failed to encode checksum response: %v", err)
+	}
+}
+
+// handleChecksumPartial renders the checksum of a file for the file modal
+func (wb *Web) handleChecksumPartial(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
+	fail := func(status int, msg string) { http.Error(w, msg, status) }
+	res, ok := wb.requestChecksum(w, r, fail)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := wb.templates.fileTemplate.ExecuteTemplate(w, "file-checksum", res); err != nil {
+		log.Printf("[ERROR] failed to execute file-checksum template: %v", err)
+		http.Error(w, "error rendering checksum", http.StatusInternalServerError)
+	}
+}
+
+// serveChecksums responds with the SHA256SUMS manifest of the directory, generated for its visible files
+func (wb *Web) serveChecksums(w http.ResponseWriter, r *http.Request, dir string) {
+	if wb.shouldExclude(dir) {
+		http.Error(w, "access denied to requested file", http.StatusForbidden)
+		return
+	}
+	if !wb.requireMountAuth(w, r, dir) {
+		return
+	}
+	entries, err := fs.ReadDir(wb.FS, dir)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	var sb strings.Builder
+	for _, entry := range entries {
+		entryPath := filepath.ToSlash(filepath.Join(dir, entry.Name()))
+		if entry.IsDir() || wb.shouldExclude(entryPath) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		sum, err := wb.checksum(entryPath, defaultChecksumAlgo, contentVersion(info))
+		if err != nil {
+			log.Printf("[WARN] failed to compute checksum of %s: %v", entryPath, err)
+			http.Error(w, "error computing checksums", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(&sb, "%s  %s\n", sum, entry.Name())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", checksumsFile))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", sb.Len()))
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		log.Printf("[WARN] failed to write checksums of %s: %v", dir, err)
+	}
+}
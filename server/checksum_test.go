@@ -0,0 +1,266 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-pkgz/lcw/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func setupChecksumServer(t *testing.T) (*Web, http.Handler) {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.bin"), []byte("world"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.key"), []byte("key"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "c.txt"), []byte("nested"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "private"), 0o750))
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Exclude: []string{"secret.key", "private"}}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	return srv, router
+}
+
+func TestChecksum(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o600))
+	cache, err := lcw.NewLruCache(lcw.NewOpts[string]().MaxKeys(10))
+	require.NoError(t, err)
+	srv := &Web{FS: os.DirFS(dir), checksumCache: cache}
+
+	tbl := []struct {
+		algo, want string
+	}{
+		{"sha256", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{"sha512", "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043"},
+		{"md5", "5d41402abc4b2a76b9719d911017c592"},
+		{"blake3", "ea8f163db38682925e4491c5e58d4bb3506ef8c14eb78a86e908c5624a67200f"},
+	}
+	for _, tt := range tbl {
+		sum, err := srv.checksum("a.txt", tt.algo, "v1")
+		require.NoError(t, err, tt.algo)
+		assert.Equal(t, tt.want, sum, tt.algo)
+	}
+
+	// cached by path and content version, a changed version computes the checksum again
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("world"), 0o600))
+	sum, err := srv.checksum("a.txt", "sha256", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, sha256Hex("hello"), sum, "cached")
+	sum, err = srv.checksum("a.txt", "sha256", "v2")
+	require.NoError(t, err)
+	assert.Equal(t, sha256Hex("world"), sum)
+
+	_, err = srv.checksum("a.txt", "crc32", "v1")
+	require.ErrorIs(t, err, errUnknownChecksumAlgo)
+	_, err = srv.checksum("missing.txt", "sha256", "v1")
+	require.Error(t, err)
+}
+
+func TestContentVersion(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, fmt.Sprintf("%d:5", mtime.UnixNano()), contentVersion(&virtualFileInfo{size: 5, modTime: mtime}))
+	assert.Equal(t, "etag:abc:5", contentVersion(&s3ObjectInfo{virtualFileInfo: virtualFileInfo{size: 5}, etag: "abc"}))
+	hash := plumbing.ComputeHash(plumbing.BlobObject, []byte("hello"))
+	assert.Equal(t, "git:"+hash.String(), contentVersion(&gitFileInfo{size: 5, hash: hash, commit: &CommitInfo{}}))
+}
+
+func TestChecksum_SameSecondChanges(t *testing.T) {
+	newCache := func(t *testing.T) lcw.LoadingCache[string] {
+		cache, err := lcw.NewLruCache(lcw.NewOpts[string]().MaxKeys(10))
+		require.NoError(t, err)
+		return cache
+	}
+	checksum := func(t *testing.T, router http.Handler, cookies ...*http.Cookie) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/checksum?path=a.txt", http.NoBody)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp checksumResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return resp.Checksum
+	}
+
+	t.Run("git refs", func(t *testing.T) {
+		// branches with files of the same size committed in the same second
+		dir := t.TempDir()
+		repo, err := git.PlainInit(dir, false)
+		require.NoError(t, err)
+		wt, err := repo.Worktree()
+		require.NoError(t, err)
+		sig := &object.Signature{Name: "Test Author", Email: "test@example.com", When: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)}
+		hashes := map[string]plumbing.Hash{}
+		for _, content := range []string{"hello", "world"} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte(content), 0o600))
+			_, err = wt.Add("a.txt")
+			require.NoError(t, err)
+			hashes[content], err = wt.Commit(content, &git.CommitOptions{Author: sig, Committer: sig})
+			require.NoError(t, err)
+		}
+		require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("old"), hashes["hello"])))
+
+		g, err := OpenGitRepo(dir, "master")
+		require.NoError(t, err)
+		fsys, err := g.FS(g.DefaultRef)
+		require.NoError(t, err)
+		srv := &Web{Config: Config{RootDir: "git:" + dir + "@master", Title: "Test"}, FS: fsys, Git: g, checksumCache: newCache(t)}
+		router, err := srv.router()
+		require.NoError(t, err)
+
+		assert.Equal(t, sha256Hex("world"), checksum(t, router))
+		assert.Equal(t, sha256Hex("hello"), checksum(t, router, &http.Cookie{Name: gitRefCookie, Value: "old"}))
+	})
+
+	t.Run("s3 overwrite", func(t *testing.T) {
+		s := setupS3Storage(t, "", map[string]string{"a.txt": "hello"})
+		srv := &Web{Config: Config{Title: "Test"}, FS: s, checksumCache: newCache(t)}
+		router, err := srv.router()
+		require.NoError(t, err)
+		assert.Equal(t, sha256Hex("hello"), checksum(t, router))
+
+		w, err := s.Create("b.txt")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("world"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.NoError(t, s.Rename("b.txt", "a.txt"))
+		assert.Equal(t, sha256Hex("world"), checksum(t, router))
+	})
+}
+
+func TestHandleAPIChecksum(t *testing.T) {
+	_, router := setupChecksumServer(t)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	rr := get("/api/checksum?path=a.txt")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var resp checksumResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, checksumResponse{Path: "a.txt", Algo: "sha256", Checksum: sha256Hex("hello"), Size: 5}, resp)
+
+	rr = get("/api/checksum?path=sub/c.txt&algo=MD5")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, "md5", resp.Algo)
+	assert.Len(t, resp.Checksum, 32)
+
+	tbl := []struct {
+		target string
+		status int
+	}{
+		{"/api/checksum?path=a.txt&algo=crc32", http.StatusBadRequest},
+		{"/api/checksum", http.StatusBadRequest},
+		{"/api/checksum?path=sub", http.StatusBadRequest},
+		{"/api/checksum?path=missing.txt", http.StatusNotFound},
+		{"/api/checksum?path=secret.key", http.StatusForbidden},
+		{"/api/checksum?path=../a.txt", http.StatusNotFound},
+	}
+	for _, tt := range tbl {
+		rr := get(tt.target)
+		assert.Equal(t, tt.status, rr.Code, tt.target)
+		assert.Contains(t, rr.Body.String(), `"error"`, tt.target)
+	}
+
+	// checksum in the file modal
+	rr = get("/partials/checksum?path=a.txt")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), sha256Hex("hello"))
+	rr = get("/partials/file-modal?path=a.txt")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `hx-get="/partials/checksum"`)
+}
+
+func TestHandleAPIListChecksum(t *testing.T) {
+	_, router := setupChecksumServer(t)
+
+	list := func(target string) (int, map[string]fileResponse) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		if rr.Code != http.StatusOK {
+			return rr.Code, nil
+		}
+		var resp struct {
+			Files []fileResponse `json:"files"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		res := map[string]fileResponse{}
+		for _, f := range resp.Files {
+			res[f.Name] = f
+		}
+		return rr.Code, res
+	}
+
+	_, files := list("/api/list")
+	assert.Empty(t, files["a.txt"].Checksum, "not computed unless requested")
+
+	_, files = list("/api/list?checksum=sha256")
+	assert.Equal(t, sha256Hex("hello"), files["a.txt"].Checksum)
+	assert.Equal(t, sha256Hex("world"), files["b.bin"].Checksum)
+	assert.Empty(t, files["sub"].Checksum)
+
+	code, _ := list("/api/list?checksum=crc32")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestChecksumsManifest(t *testing.T) {
+	srv, router := setupChecksumServer(t)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	rr := get("/SHA256SUMS")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+	want := sha256Hex("hello") + "  a.txt\n" + sha256Hex("world") + "  b.bin\n"
+	assert.Equal(t, want, rr.Body.String(), "excluded files and directories are skipped")
+
+	rr = get("/sub/SHA256SUMS")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, sha256Hex("nested")+"  c.txt\n", rr.Body.String())
+
+	assert.Equal(t, http.StatusForbidden, get("/private/SHA256SUMS").Code)
+	assert.Equal(t, http.StatusNotFound, get("/missing/SHA256SUMS").Code)
+
+	// a real manifest is served as is
+	require.NoError(t, os.WriteFile(filepath.Join(srv.RootDir, "sub", "SHA256SUMS"), []byte("published"), 0o600))
+	rr = get("/sub/SHA256SUMS")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "published", rr.Body.String())
+
+	// the listing links to the manifest of the directory
+	rr = get("/?path=sub")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `href="/sub/SHA256SUMS"`)
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultCompressMinSize is the smallest response compressed if the minimal size is not set
+const defaultCompressMinSize = 1024
+
+// compressEncodings are encodings of compressed responses, in the order of preference on equal quality
+var compressEncodings = []string{"zstd", "br", "gzip"}
+
+// precompressedFiles are encodings of precompressed siblings of files with their extensions,
+// in the order of preference
+var precompressedFiles = []struct{ encoding, ext string }{{"br", ".br"}, {"gzip", ".gz"}}
+
+// encoder is a compressor reused for responses
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPools keep encoders by encoding, making an encoder is expensive, especially zstd
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any { return gzip.NewWriter(io.Discard) }},
+	"br":   {New: func() any { return brotli.NewWriterLevel(io.Discard, 4) }},
+	"zstd": {New: func() any {
+		// error ignored: options are valid
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return enc
+	}},
+}
+
+// compressMiddleware compresses HTML, JSON and text responses with the best encoding the client accepts.
+// responses of other types, e.g. file downloads sent as application/octet-stream or already compressed
+// images and archives, are passed as is, as well as range requests and responses with an encoding.
+func (wb *Web) compressMiddleware(next http.Handler) http.Handler {
+	if !wb.Compress {
+		return next
+	}
+	minSize := wb.CompressMinSize
+	if minSize <= 0 {
+		minSize = defaultCompressMinSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), compressEncodings)
+		if encoding == "" || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize, status: http.StatusOK}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// parseAcceptEncoding returns the quality of each encoding in Accept-Encoding header, including "*"
+func parseAcceptEncoding(header string) map[string]float64 {
+	res := map[string]float64{}
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		res[name] = q
+	}
+	return res
+}
+
+// encodingQuality returns the quality of the encoding in parsed Accept-Encoding, zero if it's not accepted
+func encodingQuality(accepted map[string]float64, encoding string) float64 {
+	if q, ok := accepted[encoding]; ok {
+		return q
+	}
+	return accepted["*"]
+}
+
+// negotiateEncoding picks the accepted encoding of the highest quality from supported ones, the earlier
+// in supported on a tie. it returns an empty string if none is accepted.
+func negotiateEncoding(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+	accepted := parseAcceptEncoding(header)
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		if q := encodingQuality(accepted, encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressibleType checks the content type benefits from compression. event streams are excluded,
+// as compression would delay events until enough of them are buffered.
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "application/x-ndjson", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter compresses the response if its type is compressible and it's at least minSize bytes.
+// the beginning of a response with unknown length is buffered until it reaches minSize.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status      int
+	wroteHeader bool   // WriteHeader was called by the handler
+	decided     bool   // the response is either compressed or passed as is
+	buf         []byte // beginning of the response before the decision
+	enc         encoder
+}
+
+// WriteHeader keeps the status and decides on compression if the response type or length allows
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < http.StatusOK { // informational responses are passed as is
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader, w.status = true, code
+
+	hdr := w.Header()
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent ||
+		hdr.Get("Content-Encoding") != "" || !compressibleType(hdr.Get("Content-Type")) {
+		w.passThrough()
+		return
+	}
+	if cl := hdr.Get("Content-Length"); cl != "" {
+		if size, err := strconv.Atoi(cl); err == nil && size < w.minSize {
+			w.passThrough()
+			return
+		}
+		w.startCompression()
+	}
+}
+
+// Write compresses or buffers the data, as decided on the response
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.enc != nil:
+		return w.enc.Write(p)
+	case w.decided:
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		w.startCompression()
+		if err := w.writeBuffered(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the data written so far, a buffered response is compressed from here
+func (w *compressWriter) Flush() {
+	if w.wroteHeader && !w.decided {
+		w.startCompression()
+		if err := w.writeBuffered(); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// passThrough sends the response as is
+func (w *compressWriter) passThrough() {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// startCompression sends the headers of the compressed response and sets up the encoder
+func (w *compressWriter) startCompression() {
+	w.decided = true
+	hdr := w.Header()
+	hdr.Del("Content-Length")
+	hdr.Del("Accept-Ranges")
+	hdr.Set("Content-Encoding", w.encoding)
+	// the compressed response is a different representation, so its tag can't be strong
+	if etag := hdr.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		hdr.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.enc = encoderPools[w.encoding].Get().(encoder)
+	w.enc.Reset(w.ResponseWriter)
+}
+
+// writeBuffered writes the buffered beginning of the response
+func (w *compressWriter) writeBuffered() error {
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close finishes the response: a response smaller than minSize is sent as is, the encoder is returned to its pool
+func (w *compressWriter) close() {
+	if !w.wroteHeader {
+		return
+	}
+	if !w.decided {
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		w.passThrough()
+		if err := w.writeBuffered(); err != nil {
+			log.Printf("[DEBUG] failed to write response: %v", err)
+		}
+		return
+	}
+	if w.enc == nil {
+		return
+	}
+	if err := w.enc.Close(); err != nil {
+		log.Printf("[DEBUG] failed to finish %s response: %v", w.encoding, err)
+	}
+	w.enc.Reset(io.Discard)
+	encoderPools[w.encoding].Put(w.enc)
+	w.enc = nil
+}
+
+// precompressedContent returns the precompressed sibling of the file to serve instead of it, e.g. name.br
+// or name.gz, and sets Content-Encoding of the response. the sibling is used if compression is enabled, the
+// client accepts its encoding, it's not excluded and not older than the file. range requests get the file
+// itself, as a resumed download may have started without the encoding. it returns the content to serve,
+// its info and a function to call when it's served.
+func (wb *Web) precompressedContent(w http.ResponseWriter, r *http.Request, p string, file fs.File,
+	info fs.FileInfo) (content io.ReadSeeker, contentInfo fs.FileInfo, done func()) {
+	content, contentInfo, done = file.(io.ReadSeeker), info, func() {}
+	if !wb.Compress || r.Header.Get("Range") != "" {
+		return content, contentInfo, done
+	}
+	accepted := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+	for _, pf := range precompressedFiles {
+		sibling := p + pf.ext
+		if encodingQuality(accepted, pf.encoding) <= 0 || wb.shouldExclude(sibling) {
+			continue
+		}
+		sinfo, err := fs.Stat(wb.FS, sibling)
+		if err != nil || !sinfo.Mode().IsRegular() || sinfo.ModTime().Before(info.ModTime()) {
+			continue
+		}
+		sf, err := wb.FS.Open(sibling)
+		if err != nil {
+			continue
+		}
+		rs, ok := sf.(io.ReadSeeker)
+		if !ok {
+			_ = sf.Close()
+			continue
+		}
+		w.Header().Set("Content-Encoding", pf.encoding)
+		return rs, sinfo, func() { _ = sf.Close() }
+	}
+	return content, contentInfo, done
+}
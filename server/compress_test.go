@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tbl := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"zstd;q=0.5, br;q=0.8, gzip", "gzip"},
+		{"br;q=0, gzip;q=0.1", "gzip"},
+		{"*", "zstd"},
+		{"*;q=0.5, zstd;q=0, GZIP", "gzip"},
+		{"gzip;q=bad", ""},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.want, negotiateEncoding(tt.header, compressEncodings), tt.header)
+	}
+}
+
+func TestCompressibleType(t *testing.T) {
+	for _, ct := range []string{"text/html; charset=utf-8", "text/plain", "application/json", "image/svg+xml",
+		"application/vnd.api+json", "text/css"} {
+		assert.True(t, compressibleType(ct), ct)
+	}
+	for _, ct := range []string{"", "application/octet-stream", "image/png", "application/zip", "text/event-stream"} {
+		assert.False(t, compressibleType(ct), ct)
+	}
+}
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		r = gr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	default:
+		return string(body)
+	}
+	res, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(res)
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	large := strings.Repeat("weblist compresses text responses. ", 100)
+	handler := func(contentType, body string, withLength bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			if withLength {
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+			// written in parts, to check buffering of the beginning
+			half := len(body) / 2
+			_, _ = w.Write([]byte(body[:half]))
+			_, _ = w.Write([]byte(body[half:]))
+		})
+	}
+	srv := &Web{Config: Config{Compress: true}}
+
+	tbl := []struct {
+		name, accept, contentType, body string
+		withLength                      bool
+		wantEncoding                    string
+	}{
+		{name: "gzip", accept: "gzip", contentType: "text/html", body: large, wantEncoding: "gzip"},
+		{name: "brotli", accept: "gzip, br", contentType: "application/json", body: large, wantEncoding: "br"},
+		{name: "zstd", accept: "gzip, br, zstd", contentType: "text/plain", body: large, wantEncoding: "zstd"},
+		{name: "known length", accept: "gzip", contentType: "text/plain", body: large, withLength: true, wantEncoding: "gzip"},
+		{name: "sniffed type", accept: "gzip", body: "<html>" + large, wantEncoding: "gzip"},
+		{name: "not accepted", accept: "identity", contentType: "text/html", body: large},
+		{name: "small", accept: "gzip", contentType: "text/html", body: "short"},
+		{name: "small known length", accept: "gzip", contentType: "text/html", body: "short", withLength: true},
+		{name: "binary", accept: "gzip", contentType: "application/octet-stream", body: large},
+		{name: "image", accept: "gzip", contentType: "image/png", body: large},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			rr := httptest.NewRecorder()
+			srv.compressMiddleware(handler(tt.contentType, tt.body, tt.withLength)).ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.wantEncoding, rr.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+			assert.Equal(t, tt.body, decompress(t, tt.wantEncoding, rr.Body.Bytes()))
+			if tt.wantEncoding != "" {
+				assert.Empty(t, rr.Header().Get("Content-Length"))
+				assert.Less(t, rr.Body.Len(), len(tt.body))
+				return
+			}
+			if tt.body == "short" {
+				assert.Equal(t, "5", rr.Header().Get("Content-Length"))
+			}
+		})
+	}
+
+	t.Run("range request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Range", "bytes=0-10")
+		rr := httptest.NewRecorder()
+		srv.compressMiddleware(handler("text/plain", large, false)).ServeHTTP(rr, req)
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, large, rr.Body.String())
+	})
+
+	t.Run("flush starts compression", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("first"))
+			require.NoError(t, http.NewResponseController(w).Flush())
+			assert.True(t, rr.Flushed)
+			_, _ = w.Write([]byte(" second"))
+		})
+		srv.compressMiddleware(h).ServeHTTP(rr, req)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, "first second", decompress(t, "gzip", rr.Body.Bytes()))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		(&Web{}).compressMiddleware(handler("text/plain", large, false)).ServeHTTP(rr, req)
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Empty(t, rr.Header().Get("Vary"))
+		assert.Equal(t, large, rr.Body.String())
+	})
+}
+
+func TestCompressRoutes(t *testing.T) {
+	dir := t.TempDir()
+	text := strings.Repeat("log line\n", 500)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.log"), []byte(text), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.bin"), []byte(text), 0o600))
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte(text))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.log.gz"), gz.Bytes(), 0o600))
+
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Compress: true, CompressMinSize: 64}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	get := func(target, accept string, hdr ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.Header.Set("Accept-Encoding", accept)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// listings, api and text views are compressed
+	for _, target := range []string{"/", "/api/list", "/view/app.log"} {
+		rr := get(target, "gzip")
+		require.Equal(t, http.StatusOK, rr.Code, target)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"), target)
+		assert.NotEmpty(t, decompress(t, "gzip", rr.Body.Bytes()), target)
+	}
+	rr := get("/view/app.log", "gzip")
+	assert.Contains(t, decompress(t, "gzip", rr.Body.Bytes()), "log line")
+
+	// downloads are not compressed on the fly
+	rr = get("/data.bin", "gzip, br, zstd")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, text, rr.Body.String())
+
+	// precompressed sibling is served if accepted
+	rr = get("/app.log", "br, gzip")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(gz.Len()), rr.Header().Get("Content-Length"))
+	assert.Equal(t, text, decompress(t, "gzip", rr.Body.Bytes()))
+
+	rr = get("/app.log", "br")
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, text, rr.Body.String())
+
+	rr = get("/app.log", "gzip", "Range", "bytes=0-7")
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "log line", rr.Body.String())
+
+	// stale sibling is ignored
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "app.log.gz"), old, old))
+	rr = get("/app.log", "gzip")
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, text, rr.Body.String())
+}
+
+func TestViewContentLength(t *testing.T) {
+	dir := t.TempDir()
+	img := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0, 1, 2, 3}, 200)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pic.png"), img, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "doc.pdf"), img[:300], 0o600))
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write(img)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pic.png.gz"), gz.Bytes(), 0o600))
+
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Compress: true, CompressMinSize: 64}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/view/pic.png", http.NoBody)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(gz.Len()), rr.Header().Get("Content-Length"), "size of the precompressed sibling")
+	assert.Equal(t, gz.Len(), rr.Body.Len())
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/view/doc.pdf", http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "300", rr.Header().Get("Content-Length"), "size of the file")
+	assert.Equal(t, 300, rr.Body.Len())
+}
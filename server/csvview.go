@@ -0,0 +1,587 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxCSVSize         = 16 * 1024 * 1024 // larger files are shown as text, the table view keeps them parsed in memory
+	csvPageSize        = 100              // rows on a page of the table view
+	csvTableCacheCount = 10               // parsed tables kept for paging, sorting and filtering
+	csvDetectSize      = 64 * 1024        // bytes sampled to detect the delimiter
+	csvDetectRecords   = 20               // records sampled to detect the delimiter
+)
+
+// csvDelimiters are the delimiters tried by detectDelimiter, in order of preference
+var csvDelimiters = []rune{',', '\t', ';', '|'}
+
+// csvDelimiterNames are shown in the table view
+var csvDelimiterNames = map[rune]string{',': "comma", '\t': "tab", ';': "semicolon", '|': "pipe"}
+
+// csvDateLayouts are the formats of values inferred as dates
+var csvDateLayouts = []string{time.DateOnly, time.DateTime, "2006-01-02T15:04:05", time.RFC3339}
+
+// csvTable is a parsed delimited file
+type csvTable struct {
+	Header    []string   // names of columns, padded to the widest row
+	Rows      [][]string // data rows as they are in the file, rows may be shorter or longer than the header
+	Types     []string   // inferred type of each column: integer, number, boolean, date or text
+	Delimiter rune
+	size      int
+}
+
+// memory taken by parsed rows in addition to the content: a slice header per row and a string header per field
+const (
+	csvRowOverhead   = 24
+	csvFieldOverhead = 16
+)
+
+// Size returns the memory of the parsed content with rows and fields overhead, limiting the memory of cached tables
+func (t *csvTable) Size() int { return t.size }
+
+// cell returns the value of the row in the column, empty if the row is shorter
+func (t *csvTable) cell(row, col int) string {
+	if col < len(t.Rows[row]) {
+		return t.Rows[row][col]
+	}
+	return ""
+}
+
+// csvQuery selects and orders rows of the table view
+type csvQuery struct {
+	Filter string // case-insensitive substring of the matched rows
+	Col    int    // column searched by the filter, -1 for any
+	Sort   int    // column to sort by, -1 for the order of the file
+	Desc   bool
+	Page   int // one-based
+}
+
+// parseCSVQuery reads the query from the filter, col, sort, desc and page parameters, invalid values are ignored
+func parseCSVQuery(v url.Values) csvQuery {
+	q := csvQuery{Filter: v.Get("filter"), Col: -1, Sort: -1, Desc: v.Get("desc") == "true", Page: 1}
+	if col, err := strconv.Atoi(v.Get("col")); err == nil && col >= 0 {
+		q.Col = col
+	}
+	if col, err := strconv.Atoi(v.Get("sort")); err == nil && col >= 0 {
+		q.Sort = col
+	}
+	if page, err := strconv.Atoi(v.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+	return q
+}
+
+// values returns the query parameters of the query for the file
+func (q csvQuery) values(p string) url.Values {
+	v := url.Values{"path": {p}}
+	if q.Filter != "" {
+		v.Set("filter", q.Filter)
+	}
+	if q.Col >= 0 {
+		v.Set("col", strconv.Itoa(q.Col))
+	}
+	if q.Sort >= 0 {
+		v.Set("sort", strconv.Itoa(q.Sort))
+	}
+	if q.Desc {
+		v.Set("desc", "true")
+	}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	return v
+}
+
+// detectDelimiter guesses the delimiter of the file from its first records: the one splitting them into
+// the same number of fields, the most fields winning. tsv files are always tab separated, comma is used if
+// nothing else fits.
+func detectDelimiter(data []byte, name string) rune {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tsv", ".tab":
+		return '\t'
+	}
+	sample := data[:min(len(data), csvDetectSize)]
+	if i := bytes.LastIndexByte(sample, '\n'); i > 0 && len(sample) < len(data) {
+		sample = sample[:i+1] // don't break the last sampled line
+	}
+
+	best, bestFields := ',', 1
+	for _, delim := range csvDelimiters {
+		reader := csv.NewReader(bytes.NewReader(sample))
+		reader.Comma, reader.FieldsPerRecord = delim, -1
+		fields, consistent := 0, true
+		for range csvDetectRecords {
+			rec, err := reader.Read()
+			if err != nil {
+				consistent = consistent && errors.Is(err, io.EOF) && fields > 0
+				break
+			}
+			if fields == 0 {
+				fields = len(rec)
+			}
+			if len(rec) != fields {
+				consistent = false
+				break
+			}
+		}
+		if consistent && fields > bestFields {
+			best, bestFields = delim, fields
+		}
+	}
+	return best
+}
+
+// parseCSV parses the delimited content, the first row is the header. all rows are kept, rows may have
+// a different number of fields.
+func parseCSV(data []byte, delim rune) (*csvTable, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma, reader.FieldsPerRecord = delim, -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv parse: %w", err)
+	}
+	t := &csvTable{Header: header, Delimiter: delim, size: len(data)}
+	width := len(header)
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv parse: %w", err)
+		}
+		t.Rows = append(t.Rows, row)
+		t.size += csvRowOverhead + len(row)*csvFieldOverhead
+		width = max(width, len(row))
+	}
+	for len(t.Header) < width {
+		t.Header = append(t.Header, "")
+	}
+	t.Types = make([]string, width)
+	for col := range width {
+		t.Types[col] = t.inferType(col)
+	}
+	return t, nil
+}
+
+// inferType returns the narrowest type of all non-empty values of the column
+func (t *csvTable) inferType(col int) string {
+	isInt, isNum, isBool, isDate, seen := true, true, true, true, false
+	for row := range t.Rows {
+		v := strings.TrimSpace(t.cell(row, col))
+		if v == "" {
+			continue
+		}
+		seen = true
+		if isInt {
+			_, err := strconv.ParseInt(v, 10, 64)
+			isInt = err == nil
+		}
+		if isNum {
+			_, isNum = parseCSVNumber(v)
+		}
+		if isBool {
+			isBool = strings.EqualFold(v, "true") || strings.EqualFold(v, "false")
+		}
+		if isDate {
+			_, isDate = parseCSVDate(v)
+		}
+		if !isNum && !isBool && !isDate {
+			return "text"
+		}
+	}
+	switch {
+	case !seen:
+		return "text"
+	case isInt:
+		return "integer"
+	case isNum:
+		return "number"
+	case isBool:
+		return "boolean"
+	case isDate:
+		return "date"
+	}
+	return "text"
+}
+
+// parseCSVNumber parses a finite number
+func parseCSVNumber(v string) (float64, bool) {
+	f, err := strconv.ParseFloat(v, 64)
+	return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// parseCSVDate parses a date in one of csvDateLayouts
+func parseCSVDate(v string) (time.Time, bool) {
+	for _, layout := range csvDateLayouts {
+		if ts, err := time.Parse(layout, v); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// csvSortKey is the value of a cell for sorting, by its column type
+type csvSortKey struct {
+	empty bool
+	num   float64
+	text  string
+}
+
+// match returns indexes of the rows matching the filter of the query, in its sort order. empty cells are
+// sorted last in both directions.
+func (t *csvTable) match(q csvQuery) []int {
+	needle := strings.ToLower(q.Filter)
+	idx := make([]int, 0, len(t.Rows))
+	for row := range t.Rows {
+		if needle == "" || t.rowContains(row, q.Col, needle) {
+			idx = append(idx, row)
+		}
+	}
+	if q.Sort < 0 || q.Sort >= len(t.Header) {
+		return idx
+	}
+
+	keys := make([]csvSortKey, len(t.Rows))
+	for _, row := range idx {
+		v := strings.TrimSpace(t.cell(row, q.Sort))
+		key := csvSortKey{empty: v == ""}
+		switch t.Types[q.Sort] {
+		case "integer", "number":
+			key.num, _ = parseCSVNumber(v)
+		case "date":
+			ts, _ := parseCSVDate(v)
+			key.num = float64(ts.Unix()) + float64(ts.Nanosecond())/1e9
+		default:
+			key.text = strings.ToLower(v)
+		}
+		keys[row] = key
+	}
+	slices.SortStableFunc(idx, func(a, b int) int {
+		ka, kb := keys[a], keys[b]
+		if ka.empty || kb.empty {
+			switch {
+			case ka.empty && kb.empty:
+				return 0
+			case ka.empty:
+				return 1
+			}
+			return -1
+		}
+		res := cmp.Compare(ka.num, kb.num)
+		if res == 0 {
+			res = strings.Compare(ka.text, kb.text)
+		}
+		if q.Desc {
+			return -res
+		}
+		return res
+	})
+	return idx
+}
+
+// rowContains checks the column of the row, or any column if col is negative, contains the lowercase needle
+func (t *csvTable) rowContains(row, col int, needle string) bool {
+	if col >= 0 {
+		return strings.Contains(strings.ToLower(t.cell(row, col)), needle)
+	}
+	for _, v := range t.Rows[row] {
+		if strings.Contains(strings.ToLower(v), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonKeys returns unique names of columns for the JSON export, unnamed columns are named by their position
+func (t *csvTable) jsonKeys() []string {
+	keys, used := make([]string, len(t.Header)), map[string]bool{}
+	for i, name := range t.Header {
+		if name = strings.TrimSpace(name); name == "" {
+			name = fmt.Sprintf("column%d", i+1)
+		}
+		key := name
+		for n := 2; used[key]; n++ {
+			key = fmt.Sprintf("%s_%d", name, n)
+		}
+		used[key], keys[i] = true, key
+	}
+	return keys
+}
+
+// jsonValue converts the value to the JSON value of the column type, empty values are null
+func (t *csvTable) jsonValue(v string, col int) any {
+	trimmed := strings.TrimSpace(v)
+	if trimmed == "" {
+		return nil
+	}
+	switch t.Types[col] {
+	case "integer":
+		if i, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+			return i
+		}
+	case "number":
+		if f, ok := parseCSVNumber(trimmed); ok {
+			return f
+		}
+	case "boolean":
+		return strings.EqualFold(trimmed, "true")
+	}
+	return v
+}
+
+// writeJSON writes the rows as a JSON array of objects, with keys in the order of columns
+func (t *csvTable) writeJSON(w io.Writer, rows []int) error {
+	keys := t.jsonKeys()
+	encodedKeys := make([][]byte, len(keys))
+	for i, k := range keys {
+		encodedKeys[i], _ = json.Marshal(k) //nolint:errchkjson // strings are always encoded
+	}
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("[")
+	for n, row := range rows {
+		if n > 0 {
+			_, _ = bw.WriteString(",")
+		}
+		_, _ = bw.WriteString("\n  {")
+		for col := range t.Header {
+			if col > 0 {
+				_, _ = bw.WriteString(", ")
+			}
+			val, err := json.Marshal(t.jsonValue(t.cell(row, col), col))
+			if err != nil {
+				return fmt.Errorf("encode row %d: %w", row+1, err)
+			}
+			_, _ = bw.Write(encodedKeys[col])
+			_, _ = bw.WriteString(": ")
+			_, _ = bw.Write(val)
+		}
+		_, _ = bw.WriteString("}")
+	}
+	if len(rows) > 0 {
+		_, _ = bw.WriteString("\n")
+	}
+	_, _ = bw.WriteString("]\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write json: %w", err)
+	}
+	return nil
+}
+
+// csvLink is a link of the table view, loading the partial with htmx and the view page without scripts
+type csvLink struct {
+	Partial string
+	View    string
+}
+
+// csvColumn is a column header of the table view
+type csvColumn struct {
+	Label   string // name of the column, or its position if it has no name
+	Type    string
+	Numeric bool    // values are aligned to the right
+	Sorted  string  // asc or desc if the view is sorted by the column
+	Sort    csvLink // sorting by the column, in the other direction if already sorted by it
+}
+
+// csvPageData is a page of the table view of a delimited file
+type csvPageData struct {
+	FileName  string
+	FilePath  string
+	Theme     string
+	Delimiter string
+	Query     csvQuery
+	Columns   []csvColumn
+	Rows      [][]string // cells of the page rows, padded to the number of columns
+	Total     int        // rows in the file
+	Matched   int        // rows matching the filter
+	First     int        // one-based number of the first row on the page, in the matched rows
+	Last      int
+	Page      int
+	Pages     int
+	Prev      *csvLink // previous page, nil if there is none
+	Next      *csvLink
+	FirstPage *csvLink
+	LastPage  *csvLink
+	ExportURL string // JSON export of the matched rows
+}
+
+// csvPage makes the page of the table view selected by the query
+func csvPage(t *csvTable, q csvQuery, p, theme string) csvPageData {
+	rows := t.match(q)
+	pages := max(1, (len(rows)+csvPageSize-1)/csvPageSize)
+	q.Page = min(q.Page, pages)
+	data := csvPageData{FileName: filepath.Base(p), FilePath: p, Theme: theme, Query: q, Total: len(t.Rows),
+		Matched: len(rows), Page: q.Page, Pages: pages, Delimiter: csvDelimiterNames[t.Delimiter]}
+
+	link := func(q csvQuery) *csvLink {
+		v := q.values(p)
+		if theme != "" {
+			v.Set("theme", theme)
+		}
+		partial := "/partials/csv-table?" + v.Encode()
+		v.Del("path")
+		return &csvLink{Partial: partial, View: (&url.URL{Path: "/view/" + p, RawQuery: v.Encode()}).String()}
+	}
+	for col, name := range t.Header {
+		c := csvColumn{Label: name, Type: t.Types[col], Numeric: t.Types[col] == "integer" || t.Types[col] == "number"}
+		if strings.TrimSpace(name) == "" {
+			c.Label = fmt.Sprintf("column %d", col+1)
+		}
+		sq := q
+		sq.Sort, sq.Desc, sq.Page = col, false, 1
+		if q.Sort == col {
+			c.Sorted, sq.Desc = "asc", !q.Desc
+			if q.Desc {
+				c.Sorted = "desc"
+			}
+		}
+		c.Sort = *link(sq)
+		data.Columns = append(data.Columns, c)
+	}
+
+	from, to := (q.Page-1)*csvPageSize, min(q.Page*csvPageSize, len(rows))
+	if from < to {
+		data.First, data.Last = from+1, to
+	}
+	for _, row := range rows[from:to] {
+		cells := make([]string, len(t.Header))
+		for col := range cells {
+			cells[col] = t.cell(row, col)
+		}
+		data.Rows = append(data.Rows, cells)
+	}
+
+	pq := q
+	if q.Page > 1 {
+		pq.Page = q.Page - 1
+		data.Prev = link(pq)
+		pq.Page = 1
+		data.FirstPage = link(pq)
+	}
+	if q.Page < pages {
+		pq.Page = q.Page + 1
+		data.Next = link(pq)
+		pq.Page = pages
+		data.LastPage = link(pq)
+	}
+	pq.Page = 1
+	data.ExportURL = "/csv-export?" + pq.values(p).Encode()
+	return data
+}
+
+// csvTableOf returns the parsed table of the file. tables are cached by git ref, path, mtime and size,
+// so paging, sorting and filtering don't parse the file again.
+func (wb *Web) csvTableOf(r *http.Request, p string, info fs.FileInfo) (*csvTable, error) {
+	load := func() (*csvTable, error) {
+		data, err := fs.ReadFile(wb.FS, p)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", p, err)
+		}
+		return parseCSV(data, detectDelimiter(data, p))
+	}
+	// fallback to direct parsing if cache not initialized (e.g., in tests)
+	if wb.csvTables == nil {
+		return load()
+	}
+	return wb.csvTables.Get(fmt.Sprintf("%s:%s:%d:%d", wb.selectedRef(r), p, info.ModTime().UnixNano(), info.Size()), load)
+}
+
+// renderCSVView renders the table view of a delimited file, with the page, sorting and filter of the
+// request query. it returns false without writing the response if the file can't be parsed, so the
+// caller shows it as text.
+func (wb *Web) renderCSVView(w http.ResponseWriter, r *http.Request, filePath string, info fs.FileInfo, theme string) bool {
+	t, err := wb.csvTableOf(r, filePath, info)
+	if err != nil {
+		log.Printf("[WARN] failed to render csv %s: %v", filePath, err)
+		return false
+	}
+	data := csvPage(t, parseCSVQuery(r.URL.Query()), filePath, theme)
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := wb.templates.fileTemplate.ExecuteTemplate(w, "file-csv", data); err != nil {
+		log.Printf("[ERROR] failed to execute file-csv template: %v", err)
+		http.Error(w, "error rendering file view", http.StatusInternalServerError)
+	}
+	return true
+}
+
+// csvFile checks the file of the path query parameter can be shown as a table and returns it parsed.
+// errors are reported with http.Error.
+func (wb *Web) csvFile(w http.ResponseWriter, r *http.Request) (string, *csvTable, bool) {
+	p, file, info, ok := wb.pagerFile(w, r)
+	if !ok {
+		return "", nil, false
+	}
+	_ = file.Close() // the table is read by csvTableOf, possibly from the cache
+	if !DetermineContentType(p).IsCSV || info.Size() > maxCSVSize {
+		http.Error(w, "not a table file", http.StatusBadRequest)
+		return "", nil, false
+	}
+	t, err := wb.csvTableOf(r, p, info)
+	if err != nil {
+		http.Error(w, "invalid table file: "+err.Error(), http.StatusBadRequest)
+		return "", nil, false
+	}
+	return p, t, true
+}
+
+// handleCSVTable renders the table of the table view for htmx requests
+// It supports query parameters:
+// - path: the file path
+// - filter: text the matched rows contain, case-insensitive
+// - col: column searched by the filter, zero-based, any column by default
+// - sort: column to sort by, zero-based, the order of the file by default
+// - desc: true to sort in descending order
+// - page: one-based page of csvPageSize rows
+// - theme: light or dark, kept in the links of the table
+func (wb *Web) handleCSVTable(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
+	p, t, ok := wb.csvFile(w, r)
+	if !ok {
+		return
+	}
+	data := csvPage(t, parseCSVQuery(r.URL.Query()), p, r.URL.Query().Get("theme"))
+	w.Header().Set("Content-Type", "text/html")
+	if err := wb.templates.fileTemplate.ExecuteTemplate(w, "csv-table", data); err != nil {
+		log.Printf("[ERROR] failed to execute csv-table template: %v", err)
+		http.Error(w, "error rendering table", http.StatusInternalServerError)
+	}
+}
+
+// handleCSVExport sends the rows of the table view matching the filter as a JSON array, in the sort order.
+// It supports the query parameters of handleCSVTable, except for page.
+func (wb *Web) handleCSVExport(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	w = sw
+	defer func() { wb.auditHTTP(r, AuditRecord{Action: "export", Path: r.URL.Query().Get("path")}, sw) }()
+
+	p, t, ok := wb.csvFile(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)) + ".json"
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if err := t.writeJSON(w, t.match(parseCSVQuery(r.URL.Query()))); err != nil {
+		log.Printf("[DEBUG] failed to send json export of %s: %v", p, err)
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectDelimiter(t *testing.T) {
+	tbl := []struct {
+		name, data string
+		want       rune
+	}{
+		{"data.csv", "a,b,c\n1,2,3\n", ','},
+		{"data.csv", "a;b;c\n1;2,5;3\n", ';'},
+		{"data.csv", "a\tb\n1\t2\n", '\t'},
+		{"data.csv", "a|b|c\n1|2|3\n", '|'},
+		{"data.csv", "name,note\nx,\"a;b;c;d\"\n", ','},
+		{"data.csv", "single\nvalue\n", ','},
+		{"data.csv", "a,b,c\n1,2\n3,4,5,6\n", ','},
+		{"data.tsv", "a,b,c\n1,2,3\n", '\t'},
+		{"data.psv", "a|b\n1|2\n", '|'},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, string(tt.want), string(detectDelimiter([]byte(tt.data), tt.name)), tt.data)
+	}
+
+	// the sample is cut at the last full line
+	long := "a;b\n" + strings.Repeat("1;2\n", csvDetectSize/4)
+	assert.Equal(t, ";", string(detectDelimiter([]byte(long), "data.csv")))
+}
+
+func TestParseCSV(t *testing.T) {
+	t.Run("header and rows", func(t *testing.T) {
+		tb, err := parseCSV([]byte("Name,Age,City\nAlice,30,New York\nBob,25,London\n"), ',')
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Name", "Age", "City"}, tb.Header)
+		assert.Equal(t, [][]string{{"Alice", "30", "New York"}, {"Bob", "25", "London"}}, tb.Rows)
+		assert.Equal(t, []string{"text", "integer", "text"}, tb.Types)
+	})
+
+	t.Run("header only", func(t *testing.T) {
+		tb, err := parseCSV([]byte("Col1,Col2,Col3\n"), ',')
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Col1", "Col2", "Col3"}, tb.Header)
+		assert.Empty(t, tb.Rows)
+		assert.Equal(t, []string{"text", "text", "text"}, tb.Types)
+	})
+
+	t.Run("variable field count", func(t *testing.T) {
+		tb, err := parseCSV([]byte("a,b,c\n1,2\n3,4,5,6\n"), ',')
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c", ""}, tb.Header, "padded to the widest row")
+		assert.Equal(t, "", tb.cell(0, 2))
+		assert.Equal(t, "6", tb.cell(1, 3))
+	})
+
+	t.Run("size includes rows overhead", func(t *testing.T) {
+		data := "a,b\n" + strings.Repeat(",\n", 1000)
+		tb, err := parseCSV([]byte(data), ',')
+		require.NoError(t, err)
+		assert.Equal(t, len(data)+1000*(csvRowOverhead+2*csvFieldOverhead), tb.Size())
+	})
+
+	t.Run("tab separated with quotes", func(t *testing.T) {
+		tb, err := parseCSV([]byte("k\tv\nx\t\"a\tb\"\n"), '\t')
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"x", "a\tb"}}, tb.Rows)
+	})
+
+	for name, data := range map[string]string{
+		"empty":              "",
+		"unbalanced quotes":  "a,\"b\nc\n",
+		"malformed data row": "h1,h2\n1,2\n3,\"4\n5,6\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseCSV([]byte(data), ',')
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "csv parse")
+		})
+	}
+}
+
+func TestCSVInferType(t *testing.T) {
+	tb, err := parseCSV([]byte("int,num,bool,date,text,empty,mixed\n"+
+		"1,1.5,true,2024-01-02,abc,,1\n"+
+		"-20,3,FALSE,2024-01-02 10:00:00,12,,x\n"+
+		",-0.25e3,,2024-01-02T10:00:00Z,,,\n"), ',')
+	require.NoError(t, err)
+	assert.Equal(t, []string{"integer", "number", "boolean", "date", "text", "text", "text"}, tb.Types)
+
+	tb, err = parseCSV([]byte("v\nNaN\nInf\n"), ',')
+	require.NoError(t, err)
+	assert.Equal(t, []string{"text"}, tb.Types, "not finite numbers are text")
+}
+
+func TestCSVJSONKeys(t *testing.T) {
+	tests := []struct {
+		header, want []string
+	}{
+		{header: []string{"id", "name"}, want: []string{"id", "name"}},
+		{header: []string{"a", "a", "a"}, want: []string{"a", "a_2", "a_3"}},
+		{header: []string{"a", "a", "a_2"}, want: []string{"a", "a_2", "a_2_2"}},
+		{header: []string{"", " column1 ", "x"}, want: []string{"column1", "column1_2", "x"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, (&csvTable{Header: tt.header}).jsonKeys(), tt.header)
+	}
+}
+
+func TestCSVMatch(t *testing.T) {
+	tb, err := parseCSV([]byte("name,size,date\nbeta,10,2024-03-01\nAlpha,9,\ngamma,,2023-12-31\ndelta,100,2024-01-15\n"), ',')
+	require.NoError(t, err)
+	names := func(q csvQuery) []string {
+		var res []string
+		for _, row := range tb.match(q) {
+			res = append(res, tb.Rows[row][0])
+		}
+		return res
+	}
+
+	assert.Equal(t, []string{"beta", "Alpha", "gamma", "delta"}, names(csvQuery{Col: -1, Sort: -1}), "file order")
+	assert.Equal(t, []string{"Alpha", "beta", "delta", "gamma"}, names(csvQuery{Col: -1, Sort: 0}), "text, case-insensitive")
+	assert.Equal(t, []string{"Alpha", "beta", "delta", "gamma"}, names(csvQuery{Col: -1, Sort: 1}), "numeric, empty last")
+	assert.Equal(t, []string{"delta", "beta", "Alpha", "gamma"}, names(csvQuery{Col: -1, Sort: 1, Desc: true}), "empty last in desc")
+	assert.Equal(t, []string{"gamma", "delta", "beta", "Alpha"}, names(csvQuery{Col: -1, Sort: 2}), "dates")
+
+	assert.Equal(t, []string{"Alpha", "delta"}, names(csvQuery{Filter: "L", Col: 0, Sort: 0}), "filter in a column")
+	assert.Equal(t, []string{"beta", "delta"}, names(csvQuery{Filter: "2024", Col: -1, Sort: -1}), "filter in any column")
+	assert.Empty(t, names(csvQuery{Filter: "2024", Col: 0, Sort: -1}))
+	assert.Equal(t, []string{"beta", "Alpha", "gamma", "delta"}, names(csvQuery{Col: -1, Sort: 7}), "unknown column ignored")
+}
+
+func TestCSVPage(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,value\n")
+	for i := range 250 {
+		fmt.Fprintf(&sb, "%d,val%d\n", i, i)
+	}
+	tb, err := parseCSV([]byte(sb.String()), ',')
+	require.NoError(t, err)
+
+	page := csvPage(tb, csvQuery{Col: -1, Sort: -1, Page: 1}, "dir/data file.csv", "dark")
+	assert.Equal(t, 250, page.Total)
+	assert.Equal(t, 250, page.Matched)
+	assert.Equal(t, 3, page.Pages)
+	assert.Equal(t, 1, page.First)
+	assert.Equal(t, 100, page.Last)
+	assert.Len(t, page.Rows, 100)
+	assert.Nil(t, page.Prev)
+	require.NotNil(t, page.Next)
+	assert.Equal(t, "/partials/csv-table?page=2&path=dir%2Fdata+file.csv&theme=dark", page.Next.Partial)
+	assert.Equal(t, "/view/dir/data%20file.csv?page=2&theme=dark", page.Next.View)
+	assert.Equal(t, "/partials/csv-table?page=3&path=dir%2Fdata+file.csv&theme=dark", page.LastPage.Partial)
+	assert.Equal(t, "integer", page.Columns[0].Type)
+	assert.True(t, page.Columns[0].Numeric)
+	assert.Equal(t, "/partials/csv-table?path=dir%2Fdata+file.csv&sort=0&theme=dark", page.Columns[0].Sort.Partial)
+
+	// last page, past the end is limited to it
+	page = csvPage(tb, csvQuery{Col: -1, Sort: 0, Desc: true, Page: 9}, "data.csv", "")
+	assert.Equal(t, 3, page.Page)
+	assert.Equal(t, 201, page.First)
+	assert.Equal(t, 250, page.Last)
+	assert.Equal(t, []string{"49", "val49"}, page.Rows[0])
+	assert.Nil(t, page.Next)
+	assert.Equal(t, "desc", page.Columns[0].Sorted)
+	assert.Equal(t, "/partials/csv-table?path=data.csv&sort=0", page.Columns[0].Sort.Partial, "sorted column toggles the order")
+	assert.Equal(t, "/partials/csv-table?desc=true&page=2&path=data.csv&sort=0", page.Prev.Partial)
+	assert.Equal(t, "/csv-export?desc=true&path=data.csv&sort=0", page.ExportURL)
+
+	// filter with no matches
+	page = csvPage(tb, csvQuery{Filter: "nothing", Col: -1, Sort: -1, Page: 1}, "data.csv", "")
+	assert.Equal(t, 0, page.Matched)
+	assert.Equal(t, 1, page.Pages)
+	assert.Equal(t, 0, page.First)
+	assert.Empty(t, page.Rows)
+}
+
+func TestCSVView(t *testing.T) {
+	dir := t.TempDir()
+	var sb strings.Builder
+	sb.WriteString("id;name;score\n")
+	for i := range 150 {
+		fmt.Fprintf(&sb, "%d;name %d;%d.5\n", i, i, i%7)
+	}
+	sb.WriteString("150;<script>alert('xss')</script>;\n")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.csv"), []byte(sb.String()), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.tsv"), []byte("k\tv\n,x\t1\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.csv"), []byte("a,\"b\nc\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("text"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.csv"), []byte("a\n1\n"), 0o600))
+
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Exclude: []string{"secret.csv"}}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	rr := get("/view/data.csv")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `<form id="csv-filter"`)
+	assert.Contains(t, body, "Rows 1-100 of 151")
+	assert.Contains(t, body, "semicolon separated")
+	assert.Contains(t, body, `<span class="csv-type">number</span>`)
+	assert.NotContains(t, body, "<td>name 100</td>")
+
+	// the view page takes the same query as the partial
+	rr = get("/view/data.csv?filter=xss")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body = rr.Body.String()
+	assert.Contains(t, body, "Rows 1-1 of 1, filtered from 151")
+	assert.Contains(t, body, "&lt;script&gt;alert(&#39;xss&#39;)&lt;/script&gt;")
+	assert.NotContains(t, body, "<script>alert")
+
+	rr = get("/partials/csv-table?path=data.csv&page=2&sort=2&desc=true")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body = rr.Body.String()
+	assert.True(t, strings.HasPrefix(strings.TrimSpace(body), `<div id="csv-table"`), "table only")
+	assert.Contains(t, body, "Rows 101-151 of 151")
+	assert.Contains(t, body, `<input type="hidden" name="sort" form="csv-filter" value="2">`)
+	assert.Contains(t, body, `<input type="hidden" name="desc" form="csv-filter" value="true">`)
+	assert.Contains(t, body, "Page 2 of 2")
+
+	rr = get("/view/data.tsv")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "tab separated")
+	assert.Contains(t, rr.Body.String(), "<td>,x</td>")
+
+	rr = get("/view/broken.csv")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<pre>a,&#34;b\nc\n</pre>", "invalid file shown as text")
+
+	assert.Equal(t, http.StatusBadRequest, get("/partials/csv-table?path=broken.csv").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/partials/csv-table?path=notes.txt").Code)
+	assert.Equal(t, http.StatusForbidden, get("/partials/csv-table?path=secret.csv").Code)
+	assert.Equal(t, http.StatusNotFound, get("/partials/csv-table?path=missing.csv").Code)
+	assert.Equal(t, http.StatusForbidden, get("/csv-export?path=secret.csv").Code)
+}
+
+func TestCSVExport(t *testing.T) {
+	dir := t.TempDir()
+	data := "id,name,,score,ok,name\n1,alpha,x,1.5,true,a2\n2,beta,,,false,b2\n3,gamma,y,7,TRUE,c2\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.csv"), []byte(data), 0o600))
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test"}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	q := url.Values{"path": {"data.csv"}, "filter": {"a"}, "col": {"1"}, "sort": {"0"}, "desc": {"true"}, "page": {"5"}}
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/csv-export?"+q.Encode(), http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="data.json"`, rr.Header().Get("Content-Disposition"))
+	want := `[
+  {"id": 3, "name": "gamma", "column3": "y", "score": 7, "ok": true, "name_2": "c2"},
+  {"id": 2, "name": "beta", "column3": null, "score": null, "ok": false, "name_2": "b2"},
+  {"id": 1, "name": "alpha", "column3": "x", "score": 1.5, "ok": true, "name_2": "a2"}
+]
+`
+	assert.Equal(t, want, rr.Body.String(), "all filtered rows in the sort order, not a page")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/csv-export?path=data.csv&filter=nothing", http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "[]\n", rr.Body.String())
+}
@@ -0,0 +1,850 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	treeOpenDepth = 2    // tree nodes deeper than this are collapsed
+	maxTreeDepth  = 1000 // deeper nesting is rejected, it's not shown usefully and parsing it recursively is costly
+)
+
+// viewMode is a mode of the view of structured data, selected with the mode query parameter
+type viewMode struct {
+	Name  string
+	Label string
+}
+
+// dataModes are the view modes of structured data formats, the first one is the default
+var dataModes = map[string][]viewMode{
+	"json":   {{"tree", "Tree"}, {"pretty", "Pretty"}, {"raw", "Raw"}},
+	"xml":    {{"tree", "Tree"}, {"pretty", "Pretty"}, {"raw", "Raw"}},
+	"yaml":   {{"tree", "Tree"}, {"raw", "Raw"}},
+	"toml":   {{"tree", "Tree"}, {"raw", "Raw"}},
+	"ndjson": {{"table", "Table"}, {"raw", "Raw"}},
+	"svg":    {{"image", "Image"}, {"tree", "Tree"}, {"raw", "Raw"}},
+}
+
+// yamlErrorLine matches the line yaml errors are reported at, e.g. "yaml: line 3: did not find expected key"
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// dataMode returns the view mode requested for a structured data format, or its default mode if the format
+// has no such mode. it's empty for other files.
+func dataMode(format, mode string) string {
+	modes := dataModes[format]
+	if len(modes) == 0 {
+		return ""
+	}
+	for _, m := range modes {
+		if m.Name == mode {
+			return mode
+		}
+	}
+	return modes[0].Name
+}
+
+// dataError is an error in structured data at a line and column of the file, both starting at 1.
+// the column is zero if the parser doesn't report it, the line is zero if the position is unknown.
+type dataError struct {
+	Message string
+	Line    int
+	Column  int
+	Source  string // the line with the error, tabs replaced by spaces
+	Caret   string // points at the column under the source line
+}
+
+// Error returns the message with the position of the error
+func (e *dataError) Error() string {
+	switch {
+	case e.Line == 0:
+		return e.Message
+	case e.Column == 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	default:
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+	}
+}
+
+// newDataError makes an error at the line and column of the content
+func newDataError(content []byte, line, col int, msg string) *dataError {
+	res := &dataError{Message: msg, Line: line, Column: col}
+	if line < 1 {
+		return res
+	}
+	lines := bytes.SplitN(content, []byte("\n"), line+1)
+	if line > len(lines) {
+		return res
+	}
+	res.Source = strings.ReplaceAll(strings.TrimRight(string(lines[line-1]), "\r"), "\t", " ")
+	if col > 0 {
+		res.Caret = strings.Repeat(" ", col-1) + "^"
+	}
+	return res
+}
+
+// dataErrorAt makes an error at the byte offset of the content
+func dataErrorAt(content []byte, offset int64, msg string) *dataError {
+	offset = max(0, min(offset, int64(len(content))))
+	before := content[:offset]
+	start := bytes.LastIndexByte(before, '\n') + 1
+	return newDataError(content, bytes.Count(before, []byte("\n"))+1, utf8.RuneCount(before[start:])+1, msg)
+}
+
+// treeNode is a node of the tree view of structured data
+type treeNode struct {
+	Key      string // key, index or element name the node is shown with, empty for the root
+	Path     string // JSONPath of the node, or XPath for XML
+	Kind     string // object, array, string, number, boolean, null, date or alias, and element, attribute or text for XML
+	Value    string // shown value of a leaf
+	Children []*treeNode
+}
+
+// renderDataView renders structured data in the mode of the view, as a tree, a table, pretty-printed text or
+// a sanitized SVG image. pretty-printed text is highlighted as the raw file is.
+func (wb *Web) renderDataView(data *viewFileData, format string, content []byte) error {
+	switch data.Mode {
+	case "image":
+		if _, err := parseXMLTree(content); err != nil {
+			return err
+		}
+		svg, err := sanitizeSVG(content)
+		if err != nil {
+			return &dataError{Message: err.Error()}
+		}
+		// shown as an image, not inlined in the page: scripts of images are not run, their styles don't apply
+		// to the page, and they can't load other resources
+		data.Content = fmt.Sprintf(`<div class="svg-content"><img src="data:image/svg+xml;base64,%s" alt="%s"></div>`,
+			base64.StdEncoding.EncodeToString([]byte(svg)), template.HTMLEscapeString(data.FileName))
+		data.IsData = true
+	case "table":
+		rendered, err := renderNDJSON(content)
+		if err != nil {
+			return err
+		}
+		data.Content, data.IsData = rendered, true
+	case "pretty":
+		pretty, err := prettyData(format, content)
+		if err != nil {
+			return err
+		}
+		data.Content = pretty
+		if wb.EnableSyntaxHighlighting {
+			if highlighted, err := wb.highlightCode(pretty, data.FileName, data.Theme); err == nil {
+				data.Content = highlighted
+			}
+		}
+	default:
+		roots, err := parseDataTree(format, content)
+		if err != nil {
+			return err
+		}
+		data.Content, data.IsData = renderTree(roots), true
+	}
+	return nil
+}
+
+// parseDataTree parses structured data as trees, one per document
+func parseDataTree(format string, content []byte) ([]*treeNode, error) {
+	switch format {
+	case "json":
+		root, err := parseJSONTree(content)
+		if err != nil {
+			return nil, err
+		}
+		return []*treeNode{root}, nil
+	case "yaml":
+		return parseYAMLTree(content)
+	case "toml":
+		root, err := parseTOMLTree(content)
+		if err != nil {
+			return nil, err
+		}
+		return []*treeNode{root}, nil
+	case "xml", "svg":
+		root, err := parseXMLTree(content)
+		if err != nil {
+			return nil, err
+		}
+		return []*treeNode{root}, nil
+	}
+	return nil, fmt.Errorf("no tree view for %s", format)
+}
+
+// prettyData reformats JSON or XML with indentation
+func prettyData(format string, content []byte) (string, error) {
+	switch format {
+	case "json":
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, content, "", "  "); err != nil {
+			return "", jsonDataError(content, 0, err)
+		}
+		return strings.TrimSpace(buf.String()) + "\n", nil
+	case "xml":
+		if _, err := parseXMLTree(content); err != nil {
+			return "", err
+		}
+		return prettyXML(content)
+	}
+	return "", fmt.Errorf("no pretty view for %s", format)
+}
+
+// renderTree renders trees of structured data as nested collapsible elements. each node has a button
+// copying its path.
+func renderTree(roots []*treeNode) string {
+	var buf strings.Builder
+	buf.WriteString(`<div class="data-tree">`)
+	if len(roots) == 0 {
+		buf.WriteString(`<div class="tree-empty">Empty</div>`)
+	}
+	for _, root := range roots {
+		writeTreeNode(&buf, root, 0)
+	}
+	buf.WriteString("</div>")
+	return buf.String()
+}
+
+// writeTreeNode writes a node with its children, nodes up to treeOpenDepth are expanded
+func writeTreeNode(buf *strings.Builder, n *treeNode, depth int) {
+	key := ""
+	if n.Key != "" {
+		key = fmt.Sprintf(`<span class="tree-key">%s</span>`, template.HTMLEscapeString(n.Key))
+	}
+	path := template.HTMLEscapeString(n.Path)
+	copyButton := fmt.Sprintf(`<button type="button" class="tree-copy" data-path="%s" title="Copy path %s">copy</button>`, path, path)
+
+	if len(n.Children) == 0 {
+		value := n.Value
+		switch {
+		case value != "":
+		case n.Kind == "object":
+			value = "{}"
+		case n.Kind == "array":
+			value = "[]"
+		case n.Kind == "element":
+			value = "empty"
+		}
+		fmt.Fprintf(buf, `<div class="tree-leaf">%s<span class="tree-%s">%s</span>%s</div>`,
+			key, n.Kind, template.HTMLEscapeString(value), copyButton)
+		return
+	}
+
+	meta := fmt.Sprintf("{%d}", len(n.Children))
+	switch n.Kind {
+	case "array":
+		meta = fmt.Sprintf("[%d]", len(n.Children))
+	case "element":
+		meta = fmt.Sprintf("(%d)", len(n.Children))
+	}
+	open := ""
+	if depth < treeOpenDepth {
+		open = " open"
+	}
+	fmt.Fprintf(buf, `<details%s><summary>%s<span class="tree-meta">%s</span>%s</summary><div class="tree-children">`,
+		open, key, meta, copyButton)
+	for _, child := range n.Children {
+		writeTreeNode(buf, child, depth+1)
+	}
+	buf.WriteString("</div></details>")
+}
+
+// jsonPathKey returns the JSONPath of a key of an object, in dot notation if the key allows it
+func jsonPathKey(parent, key string) string {
+	ident := key != ""
+	for i, c := range key {
+		if c != '_' && c != '$' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			ident = false
+			break
+		}
+	}
+	if ident {
+		return parent + "." + key
+	}
+	return parent + "['" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(key) + "']"
+}
+
+// parseJSONTree parses a JSON document keeping the order of object keys. the document is validated first,
+// as errors of the decoder reading tokens point at where it stopped rather than at the invalid character.
+func parseJSONTree(content []byte) (*treeNode, error) {
+	var raw json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, jsonDataError(content, 0, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	root, err := jsonTreeValue(dec, "", "$", 0)
+	if err != nil {
+		return nil, &dataError{Message: err.Error()}
+	}
+	return root, nil
+}
+
+// jsonTreeValue reads the next value of the decoder as a tree node
+func jsonTreeValue(dec *json.Decoder, key, path string, depth int) (*treeNode, error) {
+	if depth > maxTreeDepth {
+		return nil, fmt.Errorf("nesting deeper than %d levels", maxTreeDepth)
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	node := &treeNode{Key: key, Path: path}
+	switch v := tok.(type) {
+	case json.Delim:
+		node.Kind = "object"
+		if v == '[' {
+			node.Kind = "array"
+		}
+		for i := 0; dec.More(); i++ {
+			childKey, childPath := strconv.Itoa(i), fmt.Sprintf("%s[%d]", path, i)
+			if v == '{' {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				childKey, _ = keyTok.(string)
+				childPath = jsonPathKey(path, childKey)
+			}
+			child, err := jsonTreeValue(dec, childKey, childPath, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return nil, err
+		}
+	case string:
+		node.Kind, node.Value = "string", strconv.Quote(v)
+	case json.Number:
+		node.Kind, node.Value = "number", v.String()
+	case bool:
+		node.Kind, node.Value = "boolean", strconv.FormatBool(v)
+	case nil:
+		node.Kind, node.Value = "null", "null"
+	}
+	return node, nil
+}
+
+// jsonDataError converts a JSON error to an error at its position. syntax errors have their own offset, the
+// offset is used for other errors.
+func jsonDataError(content []byte, offset int64, err error) *dataError {
+	var syntaxErr *json.SyntaxError
+	isSyntaxErr := errors.As(err, &syntaxErr)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), isSyntaxErr && syntaxErr.Error() == "unexpected end of JSON input":
+		return dataErrorAt(content, int64(len(content)), "unexpected end of data")
+	case isSyntaxErr:
+		return dataErrorAt(content, syntaxErr.Offset-1, syntaxErr.Error())
+	default:
+		return dataErrorAt(content, offset, err.Error())
+	}
+}
+
+// parseYAMLTree parses YAML documents, aliases are shown as references and not expanded
+func parseYAMLTree(content []byte) ([]*treeNode, error) {
+	var roots []*treeNode
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+				line, _ := strconv.Atoi(m[1])
+				return nil, newDataError(content, line, 0, m[2])
+			}
+			return nil, &dataError{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		}
+		root, err := yamlTreeNode(&doc, "", "$", 0)
+		if err != nil {
+			return nil, &dataError{Message: err.Error()}
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) > 1 {
+		for i, root := range roots {
+			root.Key = fmt.Sprintf("document %d", i+1)
+		}
+	}
+	return roots, nil
+}
+
+// yamlTreeNode converts a YAML node to a tree node
+func yamlTreeNode(n *yaml.Node, key, path string, depth int) (*treeNode, error) {
+	if depth > maxTreeDepth {
+		return nil, fmt.Errorf("nesting deeper than %d levels", maxTreeDepth)
+	}
+	node := &treeNode{Key: key, Path: path}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			node.Kind, node.Value = "null", "null"
+			return node, nil
+		}
+		return yamlTreeNode(n.Content[0], key, path, depth)
+	case yaml.MappingNode:
+		node.Kind = "object"
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			childKey := n.Content[i].Value
+			child, err := yamlTreeNode(n.Content[i+1], childKey, jsonPathKey(path, childKey), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	case yaml.SequenceNode:
+		node.Kind = "array"
+		for i, item := range n.Content {
+			child, err := yamlTreeNode(item, strconv.Itoa(i), fmt.Sprintf("%s[%d]", path, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	case yaml.AliasNode:
+		node.Kind, node.Value = "alias", "*"+n.Value
+	default:
+		switch n.ShortTag() {
+		case "!!int", "!!float":
+			node.Kind, node.Value = "number", n.Value
+		case "!!bool":
+			node.Kind, node.Value = "boolean", n.Value
+		case "!!null":
+			node.Kind, node.Value = "null", "null"
+		case "!!timestamp":
+			node.Kind, node.Value = "date", n.Value
+		default:
+			node.Kind, node.Value = "string", strconv.Quote(n.Value)
+		}
+	}
+	return node, nil
+}
+
+// parseTOMLTree parses a TOML document, keys are in the order they are defined in
+func parseTOMLTree(content []byte) (*treeNode, error) {
+	var data map[string]any
+	md, err := toml.Decode(string(content), &data)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, newDataError(content, parseErr.Position.Line, parseErr.Position.Col, parseErr.Message)
+		}
+		return nil, &dataError{Message: err.Error()}
+	}
+	order := map[string]int{}
+	for i, k := range md.Keys() {
+		if _, ok := order[strings.Join(k, "\x00")]; !ok {
+			order[strings.Join(k, "\x00")] = i
+		}
+	}
+	return tomlTreeNode(data, "", "$", nil, order), nil
+}
+
+// tomlTreeNode converts a decoded TOML value to a tree node. keys of tables are ordered by the position
+// of their definition, or by name if it's unknown, e.g. for inline tables in arrays.
+func tomlTreeNode(v any, key, path string, keyPath []string, order map[string]int) *treeNode {
+	node := &treeNode{Key: key, Path: path}
+	switch v := v.(type) {
+	case map[string]any:
+		node.Kind = "object"
+		position := func(k string) int {
+			if pos, ok := order[strings.Join(append(slices.Clip(keyPath), k), "\x00")]; ok {
+				return pos
+			}
+			return len(order)
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.SortFunc(keys, func(a, b string) int {
+			if pa, pb := position(a), position(b); pa != pb {
+				return pa - pb
+			}
+			return strings.Compare(a, b)
+		})
+		for _, k := range keys {
+			node.Children = append(node.Children,
+				tomlTreeNode(v[k], k, jsonPathKey(path, k), append(slices.Clip(keyPath), k), order))
+		}
+	case []map[string]any: // array of tables
+		node.Kind = "array"
+		for i, item := range v {
+			node.Children = append(node.Children, tomlTreeNode(item, strconv.Itoa(i), fmt.Sprintf("%s[%d]", path, i), keyPath, order))
+		}
+	case []any:
+		node.Kind = "array"
+		for i, item := range v {
+			node.Children = append(node.Children, tomlTreeNode(item, strconv.Itoa(i), fmt.Sprintf("%s[%d]", path, i), keyPath, order))
+		}
+	case string:
+		node.Kind, node.Value = "string", strconv.Quote(v)
+	case int64:
+		node.Kind, node.Value = "number", strconv.FormatInt(v, 10)
+	case float64:
+		node.Kind, node.Value = "number", strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		node.Kind, node.Value = "boolean", strconv.FormatBool(v)
+	case time.Time:
+		// local dates and times are decoded with zones named after their kind
+		layout := time.RFC3339Nano
+		switch v.Location().String() {
+		case "datetime-local":
+			layout = "2006-01-02T15:04:05.999999999"
+		case "date-local":
+			layout = "2006-01-02"
+		case "time-local":
+			layout = "15:04:05.999999999"
+		}
+		node.Kind, node.Value = "date", v.Format(layout)
+	default:
+		node.Kind, node.Value = "string", fmt.Sprint(v)
+	}
+	return node
+}
+
+// xmlName returns the name of an element or attribute as written, with its namespace prefix
+func xmlName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// parseXMLTree parses an XML document into a tree of elements, attributes and texts. comments, processing
+// instructions and directives are not shown.
+func parseXMLTree(content []byte) (*treeNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	var root *treeNode
+	var stack []*treeNode
+	for {
+		start := dec.InputOffset() // errors in the document structure point at the start of the token
+		errAt := func(msg string) error { return dataErrorAt(content, start, msg) }
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, dataErrorAt(content, dec.InputOffset()-1, syntaxErr.Msg)
+			}
+			return nil, dataErrorAt(content, dec.InputOffset(), err.Error())
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 && root != nil {
+				return nil, errAt("more than one root element")
+			}
+			if len(stack) >= maxTreeDepth {
+				return nil, errAt(fmt.Sprintf("nesting deeper than %d levels", maxTreeDepth))
+			}
+			node := &treeNode{Key: xmlName(t.Name), Kind: "element"}
+			for _, attr := range t.Attr {
+				node.Children = append(node.Children, &treeNode{Key: "@" + xmlName(attr.Name), Kind: "attribute", Value: strconv.Quote(attr.Value)})
+			}
+			if len(stack) == 0 {
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			// raw tokens are not checked to match, unlike tokens with resolved namespaces
+			if len(stack) == 0 || stack[len(stack)-1].Key != xmlName(t.Name) {
+				return nil, errAt(fmt.Sprintf("unexpected end element </%s>", xmlName(t.Name)))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" {
+				continue
+			}
+			if len(stack) == 0 {
+				return nil, errAt("text outside of the root element")
+			}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, &treeNode{Key: "#text", Kind: "text", Value: strconv.Quote(text)})
+		}
+	}
+	if len(stack) > 0 {
+		return nil, dataErrorAt(content, int64(len(content)), fmt.Sprintf("element <%s> is not closed", stack[len(stack)-1].Key))
+	}
+	if root == nil {
+		return nil, dataErrorAt(content, int64(len(content)), "no root element")
+	}
+	setXPath(root, "/"+root.Key)
+	return root, nil
+}
+
+// setXPath sets the XPath of an element and its children. elements sharing the name with their siblings
+// get positions, and elements with nothing but a text are shown as the text.
+func setXPath(n *treeNode, path string) {
+	n.Path = path
+	counts := map[string]int{}
+	for _, child := range n.Children {
+		counts[child.Key]++
+	}
+	seen := map[string]int{}
+	for _, child := range n.Children {
+		seen[child.Key]++
+		name := child.Key
+		switch child.Kind {
+		case "attribute":
+			child.Path = path + "/" + name
+			continue
+		case "text":
+			name = "text()"
+		}
+		if counts[child.Key] > 1 {
+			name += "[" + strconv.Itoa(seen[child.Key]) + "]"
+		}
+		if child.Kind == "text" {
+			child.Path = path + "/" + name
+			continue
+		}
+		setXPath(child, path+"/"+name)
+	}
+	if len(n.Children) == 1 && n.Children[0].Key == "#text" {
+		n.Kind, n.Value, n.Children = "text", n.Children[0].Value, nil
+	}
+}
+
+// prettyXML reformats a valid XML document with indentation. texts of elements are kept on the element line
+// if the element has nothing else, whitespace between elements is replaced by the indentation.
+func prettyXML(content []byte) (string, error) {
+	var toks []xml.Token
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("xml token: %w", err)
+		}
+		if text, ok := tok.(xml.CharData); ok && len(bytes.TrimSpace(text)) == 0 {
+			continue
+		}
+		toks = append(toks, xml.CopyToken(tok))
+	}
+
+	var buf strings.Builder
+	escape := func(s []byte) {
+		_ = xml.EscapeText(&buf, s) // writes to strings.Builder never fail
+	}
+	indent := func(depth int) {
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(strings.Repeat("  ", depth))
+	}
+	depth := 0
+	for i := 0; i < len(toks); i++ {
+		switch t := toks[i].(type) {
+		case xml.StartElement:
+			indent(depth)
+			buf.WriteString("<" + xmlName(t.Name))
+			for _, attr := range t.Attr {
+				buf.WriteString(" " + xmlName(attr.Name) + `="`)
+				escape([]byte(attr.Value))
+				buf.WriteString(`"`)
+			}
+			next := func(j int) xml.Token {
+				if i+j < len(toks) {
+					return toks[i+j]
+				}
+				return nil
+			}
+			if _, ok := next(1).(xml.EndElement); ok {
+				buf.WriteString("/>")
+				i++
+				continue
+			}
+			if text, ok := next(1).(xml.CharData); ok {
+				if _, ok := next(2).(xml.EndElement); ok {
+					buf.WriteString(">")
+					escape(bytes.TrimSpace(text))
+					buf.WriteString("</" + xmlName(t.Name) + ">")
+					i += 2
+					continue
+				}
+			}
+			buf.WriteString(">")
+			depth++
+		case xml.EndElement:
+			depth--
+			indent(depth)
+			buf.WriteString("</" + xmlName(t.Name) + ">")
+		case xml.CharData:
+			indent(depth)
+			escape(bytes.TrimSpace(t))
+		case xml.Comment:
+			indent(depth)
+			buf.WriteString("<!--" + string(t) + "-->")
+		case xml.ProcInst:
+			indent(depth)
+			buf.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+		case xml.Directive:
+			indent(depth)
+			buf.WriteString("<!" + string(t) + ">")
+		}
+	}
+	buf.WriteByte('\n')
+	return buf.String(), nil
+}
+
+// renderNDJSON renders newline delimited JSON as a table. objects are rows with columns for their keys in
+// the order they are first seen, other values are in the value column. invalid lines are shown with their
+// errors, the data is invalid if no line is valid.
+func renderNDJSON(content []byte) (string, error) {
+	type ndjsonRow struct {
+		line  int
+		cells map[string]string
+		err   *dataError
+	}
+	var rows []ndjsonRow
+	var columns []string
+	seen := map[string]bool{}
+	addColumn := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			columns = append(columns, name)
+		}
+	}
+
+	valid := 0
+	var firstErr *dataError
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue
+		}
+		cells, err := ndjsonCells(trimmed)
+		if err != nil {
+			// the position in the line is moved to the line of the file
+			lead := utf8.RuneCount(line[:bytes.Index(line, trimmed)])
+			err = newDataError(content, i+1, lead+err.Column, err.Message)
+			if firstErr == nil {
+				firstErr = err
+			}
+			rows = append(rows, ndjsonRow{line: i + 1, err: err})
+			continue
+		}
+		for _, name := range cells.keys {
+			addColumn(name)
+		}
+		rows = append(rows, ndjsonRow{line: i + 1, cells: cells.values})
+		valid++
+	}
+	if valid == 0 && firstErr != nil {
+		return "", firstErr
+	}
+	if len(rows) == 0 {
+		return `<div class="data-tree"><div class="tree-empty">Empty</div></div>`, nil
+	}
+
+	var buf strings.Builder
+	buf.WriteString(`<div class="data-table"><table><thead><tr><th>#</th>`)
+	for _, col := range columns {
+		fmt.Fprintf(&buf, "<th>%s</th>", template.HTMLEscapeString(col))
+	}
+	buf.WriteString("</tr></thead><tbody>")
+	for _, row := range rows {
+		if row.err != nil {
+			fmt.Fprintf(&buf, `<tr class="data-invalid"><td class="data-line">%d</td><td colspan="%d">%s</td></tr>`,
+				row.line, max(1, len(columns)), template.HTMLEscapeString(row.err.Error()))
+			continue
+		}
+		fmt.Fprintf(&buf, `<tr><td class="data-line">%d</td>`, row.line)
+		for _, col := range columns {
+			fmt.Fprintf(&buf, "<td>%s</td>", template.HTMLEscapeString(row.cells[col]))
+		}
+		buf.WriteString("</tr>")
+	}
+	buf.WriteString("</tbody></table></div>")
+	return buf.String(), nil
+}
+
+// ndjsonLine is a parsed line of newline delimited JSON, its keys in order and the text of their values
+type ndjsonLine struct {
+	keys   []string
+	values map[string]string
+}
+
+// ndjsonCells parses a line of newline delimited JSON. strings are shown unquoted, other values as compact JSON.
+// errors are at their position in the line.
+func ndjsonCells(line []byte) (ndjsonLine, *dataError) {
+	res := ndjsonLine{values: map[string]string{}}
+	cell := func(raw json.RawMessage) string {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return string(raw)
+		}
+		return buf.String()
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	fail := func(err error) (ndjsonLine, *dataError) {
+		return res, jsonDataError(line, dec.InputOffset(), err)
+	}
+	if line[0] != '{' {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fail(err)
+		}
+		res.keys, res.values["value"] = []string{"value"}, cell(raw)
+	} else {
+		if _, err := dec.Token(); err != nil {
+			return fail(err)
+		}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return fail(err)
+			}
+			key, _ := keyTok.(string)
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return fail(err)
+			}
+			if _, ok := res.values[key]; !ok {
+				res.keys = append(res.keys, key)
+			}
+			res.values[key] = cell(raw)
+		}
+		if _, err := dec.Token(); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return fail(err)
+		}
+		return res, dataErrorAt(line, dec.InputOffset()-1, "unexpected data after the value")
+	}
+	return res, nil
+}
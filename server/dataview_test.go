@@ -0,0 +1,336 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// treePaths returns the keys, kinds, values and paths of the tree nodes in order, one line per node
+func treePaths(n *treeNode) []string {
+	res := []string{strings.Join(strings.Fields(n.Key+" "+n.Kind+" "+n.Value+" "+n.Path), " ")}
+	for _, child := range n.Children {
+		res = append(res, treePaths(child)...)
+	}
+	return res
+}
+
+func TestDataMode(t *testing.T) {
+	assert.Equal(t, "tree", dataMode("json", ""))
+	assert.Equal(t, "pretty", dataMode("json", "pretty"))
+	assert.Equal(t, "tree", dataMode("yaml", "pretty"), "no pretty view for yaml")
+	assert.Equal(t, "table", dataMode("ndjson", "tree"))
+	assert.Equal(t, "raw", dataMode("toml", "raw"))
+	assert.Empty(t, dataMode("", "raw"))
+}
+
+func TestParseJSONTree(t *testing.T) {
+	root, err := parseJSONTree([]byte(`{"z": 1, "a": [true, null, {"x y": "s\"q"}], "it's": 1.5e3, "_ok$": {}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"object $",
+		"z number 1 $.z",
+		"a array $.a",
+		"0 boolean true $.a[0]",
+		"1 null null $.a[1]",
+		"2 object $.a[2]",
+		`x y string "s\"q" $.a[2]['x y']`,
+		`it's number 1.5e3 $['it\'s']`,
+		"_ok$ object $._ok$",
+	}, treePaths(root), "keys in order of the file")
+
+	tbl := []struct {
+		data, msg    string
+		line, column int
+	}{
+		{"{\n  \"a\": 1,\n  \"b\" 2\n}", "invalid character '2' after object key", 3, 7},
+		{"[1, 2", "unexpected end of data", 1, 6},
+		{"", "unexpected end of data", 1, 1},
+		{"{}\n  {}", "invalid character '{' after top-level value", 2, 3},
+		{"{\n  \"a\": 1,\n}", "invalid character '}' looking for beginning of object key string", 3, 1},
+		{"{\"a\":\t\"\x01\"}", "invalid character '\\x01' in string", 1, 8},
+	}
+	for _, tt := range tbl {
+		_, err := parseJSONTree([]byte(tt.data))
+		var de *dataError
+		require.ErrorAs(t, err, &de, tt.data)
+		assert.Equal(t, tt.msg, de.Message, tt.data)
+		assert.Equal(t, tt.line, de.Line, tt.data)
+		assert.Equal(t, tt.column, de.Column, tt.data)
+	}
+
+	_, err = parseJSONTree([]byte(`  "b" 2`))
+	var de *dataError
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, `  "b" 2`, de.Source)
+	assert.Equal(t, "      ^", de.Caret)
+	assert.Equal(t, "line 1, column 7: invalid character '2' after top-level value", de.Error())
+
+	_, err = parseJSONTree([]byte(strings.Repeat("[", maxTreeDepth+2) + strings.Repeat("]", maxTreeDepth+2)))
+	require.ErrorAs(t, err, &de)
+	assert.Contains(t, de.Message, "nesting deeper than")
+}
+
+func TestParseYAMLTree(t *testing.T) {
+	roots, err := parseYAMLTree([]byte("name: app\nports: [80, 443]\nbase: &base\n  debug: false\nprod:\n  <<: *base\n" +
+		"  started: 2024-01-02\n  empty: ~\n---\n- one\n"))
+	require.NoError(t, err)
+	require.Len(t, roots, 2)
+	assert.Equal(t, []string{
+		"document 1 object $",
+		`name string "app" $.name`,
+		"ports array $.ports",
+		"0 number 80 $.ports[0]",
+		"1 number 443 $.ports[1]",
+		"base object $.base",
+		"debug boolean false $.base.debug",
+		"prod object $.prod",
+		"<< alias *base $.prod['<<']",
+		"started date 2024-01-02 $.prod.started",
+		"empty null null $.prod.empty",
+	}, treePaths(roots[0]))
+	assert.Equal(t, []string{"document 2 array $", `0 string "one" $[0]`}, treePaths(roots[1]))
+
+	roots, err = parseYAMLTree([]byte("key: value\n"))
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	assert.Empty(t, roots[0].Key, "single document is not named")
+
+	roots, err = parseYAMLTree([]byte(""))
+	require.NoError(t, err)
+	assert.Empty(t, roots)
+
+	_, err = parseYAMLTree([]byte("a: 1\nb: c: d\n"))
+	var de *dataError
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, 2, de.Line)
+	assert.Zero(t, de.Column)
+	assert.Equal(t, "b: c: d", de.Source)
+	assert.Empty(t, de.Caret)
+	assert.Equal(t, "mapping values are not allowed in this context", de.Message)
+}
+
+func TestParseTOMLTree(t *testing.T) {
+	root, err := parseTOMLTree([]byte(`title = "app"
+zeta = 1.5
+alpha = 2024-01-02
+[server]
+port = 8080
+host = "localhost"
+started = 2024-01-02T10:00:00Z
+at = 07:30:00
+[[users]]
+name = "bob"
+admin = true
+[[users]]
+name = "alice"
+points = [{x = 1, b = 2}]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"object $",
+		`title string "app" $.title`,
+		"zeta number 1.5 $.zeta",
+		"alpha date 2024-01-02 $.alpha",
+		"server object $.server",
+		"port number 8080 $.server.port",
+		`host string "localhost" $.server.host`,
+		"started date 2024-01-02T10:00:00Z $.server.started",
+		"at date 07:30:00 $.server.at",
+		"users array $.users",
+		"0 object $.users[0]",
+		`name string "bob" $.users[0].name`,
+		"admin boolean true $.users[0].admin",
+		"1 object $.users[1]",
+		`name string "alice" $.users[1].name`,
+		"points array $.users[1].points",
+		"0 object $.users[1].points[0]",
+		"x number 1 $.users[1].points[0].x",
+		"b number 2 $.users[1].points[0].b",
+	}, treePaths(root), "keys in order of definition")
+
+	_, err = parseTOMLTree([]byte("a = 1\nb = \"x\ny = 2\n"))
+	var de *dataError
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, 2, de.Line)
+	assert.Equal(t, 7, de.Column, "at the end of the unterminated string")
+	assert.Equal(t, "b = \"x", de.Source)
+}
+
+func TestParseXMLTree(t *testing.T) {
+	root, err := parseXMLTree([]byte(`<?xml version="1.0"?>
+<!-- catalog -->
+<catalog xmlns:m="urn:meta" version="2">
+  <book id="1"><title>Go &amp; XML</title><m:tag>a</m:tag><m:tag>b</m:tag></book>
+  <book id="2"/>
+  <note>text <b>bold</b> tail</note>
+</catalog>`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"catalog element /catalog",
+		`@xmlns:m attribute "urn:meta" /catalog/@xmlns:m`,
+		`@version attribute "2" /catalog/@version`,
+		"book element /catalog/book[1]",
+		`@id attribute "1" /catalog/book[1]/@id`,
+		`title text "Go & XML" /catalog/book[1]/title`,
+		`m:tag text "a" /catalog/book[1]/m:tag[1]`,
+		`m:tag text "b" /catalog/book[1]/m:tag[2]`,
+		"book element /catalog/book[2]",
+		`@id attribute "2" /catalog/book[2]/@id`,
+		"note element /catalog/note",
+		`#text text "text" /catalog/note/text()[1]`,
+		`b text "bold" /catalog/note/b`,
+		`#text text "tail" /catalog/note/text()[2]`,
+	}, treePaths(root))
+
+	tbl := []struct {
+		data, msg    string
+		line, column int
+	}{
+		{"<a>\n  <b></c>\n</a>", "unexpected end element </c>", 2, 6},
+		{"<a>\n  <b>", "element <b> is not closed", 2, 6},
+		{"<a/><b/>", "more than one root element", 1, 5},
+		{"<!-- only -->", "no root element", 1, 14},
+		{"<a>\n<b x=1/></a>", "unquoted or missing attribute value in element", 2, 6},
+		{"text<a/>", "text outside of the root element", 1, 1},
+	}
+	for _, tt := range tbl {
+		_, err := parseXMLTree([]byte(tt.data))
+		var de *dataError
+		require.ErrorAs(t, err, &de, tt.data)
+		assert.Equal(t, tt.msg, de.Message, tt.data)
+		assert.Equal(t, tt.line, de.Line, tt.data)
+		assert.Equal(t, tt.column, de.Column, tt.data)
+	}
+}
+
+func TestPrettyData(t *testing.T) {
+	res, err := prettyData("json", []byte("\n {\"a\":[1,2],\"b\":{}}  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": {}\n}\n", res)
+
+	_, err = prettyData("json", []byte("{\n\"a\":}"))
+	var de *dataError
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, 2, de.Line)
+	assert.Equal(t, 5, de.Column)
+
+	res, err = prettyData("xml", []byte(`<?xml version="1.0"?><!DOCTYPE r><r a="x&quot;y"><!--c--><i>one &lt;1&gt;</i>`+
+		`<i/><g><i>two</i></g>mixed</r>`))
+	require.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0"?>
+<!DOCTYPE r>
+<r a="x&#34;y">
+  <!--c-->
+  <i>one &lt;1&gt;</i>
+  <i/>
+  <g>
+    <i>two</i>
+  </g>
+  mixed
+</r>
+`, res)
+
+	_, err = prettyData("xml", []byte("<r><i></r>"))
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, "unexpected end element </r>", de.Message)
+
+	_, err = prettyData("yaml", []byte("a: 1"))
+	require.Error(t, err)
+}
+
+func TestRenderTree(t *testing.T) {
+	root, err := parseJSONTree([]byte(`{"<b>": "<script>", "list": [1], "deep": {"a": {"b": {"c": 1}}}, "none": []}`))
+	require.NoError(t, err)
+	res := renderTree([]*treeNode{root})
+
+	assert.Contains(t, res, `<span class="tree-key">&lt;b&gt;</span><span class="tree-string">&#34;&lt;script&gt;&#34;</span>`)
+	assert.Contains(t, res, `data-path="$[&#39;&lt;b&gt;&#39;]"`, "path escaped in attributes")
+	assert.NotContains(t, res, "<script>")
+	assert.Contains(t, res, `<details open><summary><span class="tree-key">list</span><span class="tree-meta">[1]</span>`)
+	assert.Contains(t, res, `<details><summary><span class="tree-key">b</span><span class="tree-meta">{1}</span>`, "deep nodes collapsed")
+	assert.Contains(t, res, `<span class="tree-key">none</span><span class="tree-array">[]</span>`)
+
+	assert.Equal(t, `<div class="data-tree"><div class="tree-empty">Empty</div></div>`, renderTree(nil))
+}
+
+func TestRenderNDJSON(t *testing.T) {
+	res, err := renderNDJSON([]byte(`{"level":"info","msg":"started <app>","n":1}` + "\n\n" +
+		`{"level":"warn","extra":{"a":[1, 2]}}` + "\n" +
+		`  {"level": oops}` + "\n" +
+		`42` + "\n"))
+	require.NoError(t, err)
+	assert.Contains(t, res, "<thead><tr><th>#</th><th>level</th><th>msg</th><th>n</th><th>extra</th><th>value</th></tr></thead>")
+	assert.Contains(t, res, `<tr><td class="data-line">1</td><td>info</td><td>started &lt;app&gt;</td><td>1</td><td></td><td></td></tr>`)
+	assert.Contains(t, res, `<tr><td class="data-line">3</td><td>warn</td><td></td><td></td><td>{&#34;a&#34;:[1,2]}</td><td></td></tr>`)
+	assert.Contains(t, res, `<tr class="data-invalid"><td class="data-line">4</td><td colspan="5">line 4, column 13: invalid character &#39;o&#39;`)
+	assert.Contains(t, res, `<tr><td class="data-line">5</td><td></td><td></td><td></td><td></td><td>42</td></tr>`)
+
+	_, err = renderNDJSON([]byte("{\n  \"a\": 1\n}\n"))
+	var de *dataError
+	require.ErrorAs(t, err, &de, "no valid line")
+	assert.Equal(t, 1, de.Line)
+	assert.Equal(t, 2, de.Column)
+
+	_, err = renderNDJSON([]byte(`{"a": 1} {"b": 2}`))
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, "line 1, column 10: unexpected data after the value", de.Error())
+
+	res, err = renderNDJSON([]byte("\n"))
+	require.NoError(t, err)
+	assert.Contains(t, res, "Empty")
+}
+
+func TestViewStructuredData(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"name": "app", "ports": [80]}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{\n  \"a\": 1,\n}"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "events.jsonl"), []byte(`{"a":1}`+"\n"+`{"a":2}`+"\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "feed.xml"), []byte(`<feed><entry>x</entry></feed>`), 0o600))
+
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Theme: "light", EnableSyntaxHighlighting: true}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) string {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		require.Equal(t, http.StatusOK, rr.Code, target)
+		return rr.Body.String()
+	}
+
+	body := get("/view/config.json")
+	assert.Contains(t, body, `<span class="data-format">JSON</span>`)
+	assert.Contains(t, body, `<a href="?mode=tree&theme=light" class="active">Tree</a>`)
+	assert.Contains(t, body, `<a href="?mode=raw&theme=light">Raw</a>`)
+	assert.Contains(t, body, `<div class="data-tree">`)
+	assert.Contains(t, body, `data-path="$.ports[0]"`)
+
+	body = get("/view/config.json?mode=raw&theme=dark")
+	assert.NotContains(t, body, `<div class="data-tree">`)
+	assert.Contains(t, body, `<a href="?mode=raw&theme=dark" class="active">Raw</a>`)
+	assert.Contains(t, body, `<div class="highlight-wrapper">`)
+
+	body = get("/view/config.json?mode=pretty")
+	assert.Contains(t, body, `<div class="highlight-wrapper">`)
+	assert.Contains(t, body, "\n  ")
+
+	body = get("/view/broken.json")
+	assert.Contains(t, body, `<div class="data-error">`)
+	assert.Contains(t, body, "Invalid JSON, line 3, column 1: invalid character &#39;}&#39; looking for beginning of object key string")
+	assert.Contains(t, body, "<pre>}\n^</pre>")
+	assert.Contains(t, body, `<div class="highlight-wrapper">`, "shown as text")
+
+	body = get("/view/events.jsonl")
+	assert.Contains(t, body, `<span class="data-format">NDJSON</span>`)
+	assert.Contains(t, body, `<div class="data-table">`)
+
+	body = get("/view/feed.xml")
+	assert.Contains(t, body, `<span class="tree-key">feed</span><span class="tree-meta">(1)</span>`)
+	assert.Contains(t, body, `data-path="/feed/entry"`)
+}
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	maxDiffSize  = 2 * 1024 * 1024 // larger files are only checked for being the same, not compared line by line
+	diffContext  = 3               // unchanged lines shown around changes
+	diffDeadline = 2 * time.Second // a diff taking longer is made coarser, not minimal
+)
+
+// diffSide is one of the compared files
+type diffSide struct {
+	Path string
+	Ref  string // git ref of the file, empty if no repository is served
+	Size string
+	fsys fs.FS
+	info fs.FileInfo
+}
+
+// diffLine is a line of the unified diff, its number is zero on the side it's missing from
+type diffLine struct {
+	Kind  string // ctx, del or add
+	ANum  int
+	BNum  int
+	Code  template.HTML
+	Empty bool // the line is only in the other file, for split view
+}
+
+// diffRow is a row of the side by side diff
+type diffRow struct {
+	Left, Right diffLine
+}
+
+// diffHunk is a group of changes with lines around them
+type diffHunk struct {
+	Header string
+	Lines  []diffLine
+	Rows   []diffRow
+}
+
+// diffViewData is the comparison of two files
+type diffViewData struct {
+	A, B       diffSide
+	Mode       string // unified or split
+	Theme      string
+	UnifiedURL string
+	SplitURL   string
+	Identical  bool
+	Binary     bool   // either file is binary, only shown if they differ
+	TooLarge   bool   // either file is larger than the limit, only shown if they differ
+	Limit      string // size limit of compared files
+	Added      int
+	Removed    int
+	Hunks      []diffHunk
+}
+
+// diffOp is a line of the edit script, kind is one of diffmatchpatch operations, a and b are line
+// indexes of the files, one of them is -1 for deleted and inserted lines
+type diffOp struct {
+	kind diffmatchpatch.Operation
+	a, b int
+}
+
+// handleDiff compares two files, or two versions of a file in a git repository
+// It supports query parameters:
+// - a, b: paths of the files
+// - aref, bref: git refs of the files, the selected ref by default
+// - mode: unified (default) or split for side by side
+// - theme: light or dark, for syntax highlighting
+func (wb *Web) handleDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	data := diffViewData{Mode: query.Get("mode"), Theme: query.Get("theme"), Limit: FileInfo{Size: maxDiffSize}.SizeToString()}
+	if data.Mode != "split" {
+		data.Mode = "unified"
+	}
+	if data.Theme == "" {
+		data.Theme = wb.Theme
+	}
+	for mode, u := range map[string]*string{"unified": &data.UnifiedURL, "split": &data.SplitURL} {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("mode", mode)
+		*u = "/diff?" + q.Encode()
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	w = sw
+	defer func() {
+		wb.auditHTTP(r, AuditRecord{Action: "diff", Files: []string{query.Get("a"), query.Get("b")}}, sw)
+	}()
+
+	var ok bool
+	if data.A, ok = wb.diffSide(w, r, query.Get("a"), query.Get("aref")); !ok {
+		return
+	}
+	if data.B, ok = wb.diffSide(w, r, query.Get("b"), query.Get("bref")); !ok {
+		return
+	}
+
+	if err := wb.compareFiles(&data); err != nil {
+		log.Printf("[WARN] failed to compare %s and %s: %v", data.A.Path, data.B.Path, err)
+		http.Error(w, "error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := wb.templates.fileTemplate.ExecuteTemplate(w, "file-diff", data); err != nil {
+		log.Printf("[ERROR] failed to execute file-diff template: %v", err)
+		http.Error(w, "error rendering diff", http.StatusInternalServerError)
+	}
+}
+
+// diffSide checks the compared file can be read by the user and returns it. ref selects the git
+// version of the file, the ref selected by the user if empty. errors are reported with http.Error,
+// except for the mount login which is handled by requireMountAuth.
+func (wb *Web) diffSide(w http.ResponseWriter, r *http.Request, p, ref string) (diffSide, bool) {
+	if p == "" {
+		http.Error(w, "file path not provided", http.StatusBadRequest)
+		return diffSide{}, false
+	}
+	// clean the path to avoid directory traversal
+	p = filepath.ToSlash(filepath.Clean(p))
+
+	side := diffSide{Path: p, fsys: wb.forRequest(r).FS}
+	switch {
+	case wb.Git != nil && ref != "":
+		fsys, err := wb.Git.FS(ref)
+		if err != nil {
+			http.Error(w, "unknown git ref", http.StatusBadRequest)
+			return diffSide{}, false
+		}
+		side.fsys, side.Ref = fsys, ref
+	case wb.Git != nil:
+		side.Ref = wb.selectedRef(r)
+	}
+
+	if wb.shouldExclude(p) {
+		http.Error(w, "access denied to requested file", http.StatusForbidden)
+		return diffSide{}, false
+	}
+	if !wb.requireMountAuth(w, r, p) {
+		return diffSide{}, false
+	}
+	info, err := fs.Stat(side.fsys, p)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("file not found: %s", p), http.StatusNotFound)
+		return diffSide{}, false
+	}
+	if info.IsDir() {
+		http.Error(w, fmt.Sprintf("not a file: %s", p), http.StatusBadRequest)
+		return diffSide{}, false
+	}
+	side.info, side.Size = info, FileInfo{Size: info.Size()}.SizeToString()
+	return side, true
+}
+
+// compareFiles fills the data with the differences of its files. binary files and files over the size
+// limit are only checked for being the same.
+func (wb *Web) compareFiles(data *diffViewData) error {
+	if data.A.info.Size() > maxDiffSize || data.B.info.Size() > maxDiffSize {
+		same, err := sameContent(data.A, data.B)
+		data.Identical, data.TooLarge = same, !same
+		return err
+	}
+
+	a, err := fs.ReadFile(data.A.fsys, data.A.Path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", data.A.Path, err)
+	}
+	b, err := fs.ReadFile(data.B.fsys, data.B.Path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", data.B.Path, err)
+	}
+	if bytes.Equal(a, b) {
+		data.Identical = true
+		return nil
+	}
+	if isBinaryData(a) || isBinaryData(b) {
+		data.Binary = true
+		return nil
+	}
+
+	aLines, bLines := splitLines(string(a)), splitLines(string(b))
+	ops := diffLines(a, b)
+	aCode := wb.highlightLines(aLines, filepath.Base(data.A.Path), data.Theme)
+	bCode := wb.highlightLines(bLines, filepath.Base(data.B.Path), data.Theme)
+	for _, op := range ops {
+		switch op.kind {
+		case diffmatchpatch.DiffDelete:
+			data.Removed++
+		case diffmatchpatch.DiffInsert:
+			data.Added++
+		}
+	}
+	data.Hunks = diffHunks(ops, aCode, bCode)
+	return nil
+}
+
+// sameContent checks both files have the same bytes, without reading them whole
+func sameContent(a, b diffSide) (bool, error) {
+	if a.info.Size() != b.info.Size() {
+		return false, nil
+	}
+	fa, err := a.fsys.Open(a.Path)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", a.Path, err)
+	}
+	defer func() { _ = fa.Close() }()
+	fb, err := b.fsys.Open(b.Path)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", b.Path, err)
+	}
+	defer func() { _ = fb.Close() }()
+
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		doneA := errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF)
+		doneB := errors.Is(errB, io.EOF) || errors.Is(errB, io.ErrUnexpectedEOF)
+		switch {
+		case errA != nil && !doneA:
+			return false, fmt.Errorf("read %s: %w", a.Path, errA)
+		case errB != nil && !doneB:
+			return false, fmt.Errorf("read %s: %w", b.Path, errB)
+		case doneA || doneB:
+			return doneA == doneB, nil
+		}
+	}
+}
+
+// isBinaryData checks the content is not text, the same way as the binary detection of listings
+func isBinaryData(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	return !isTextLikeMIME(http.DetectContentType(data[:min(len(data), 512)]))
+}
+
+// splitLines splits the text into lines without line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// diffLines returns the edit script turning lines of a into lines of b
+func diffLines(a, b []byte) []diffOp {
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = diffDeadline
+	ra, rb, _ := dmp.DiffLinesToRunes(string(a), string(b))
+	var ops []diffOp
+	ai, bi := 0, 0
+	for _, d := range dmp.DiffMainRunes(ra, rb, false) {
+		for range []rune(d.Text) { // each rune is a line
+			switch d.Type {
+			case diffmatchpatch.DiffEqual:
+				ops = append(ops, diffOp{kind: d.Type, a: ai, b: bi})
+				ai, bi = ai+1, bi+1
+			case diffmatchpatch.DiffDelete:
+				ops = append(ops, diffOp{kind: d.Type, a: ai, b: -1})
+				ai++
+			case diffmatchpatch.DiffInsert:
+				ops = append(ops, diffOp{kind: d.Type, a: -1, b: bi})
+				bi++
+			}
+		}
+	}
+	return ops
+}
+
+// diffHunks groups changes of the edit script with diffContext lines around them, changes closer than
+// twice the context get into the same hunk. aCode and bCode are rendered lines of the files.
+func diffHunks(ops []diffOp, aCode, bCode []template.HTML) []diffHunk {
+	var hunks []diffHunk
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffmatchpatch.DiffEqual {
+			i++
+			continue
+		}
+		start, end := max(0, i-diffContext), i
+		for end < len(ops) {
+			if ops[end].kind != diffmatchpatch.DiffEqual {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == diffmatchpatch.DiffEqual {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				end = min(len(ops), end+diffContext)
+				break
+			}
+			end = next
+		}
+		hunks = append(hunks, makeHunk(ops[start:end], aCode, bCode))
+		i = end
+	}
+	return hunks
+}
+
+// makeHunk renders lines of the hunk for both unified and side by side views
+func makeHunk(ops []diffOp, aCode, bCode []template.HTML) diffHunk {
+	var hunk diffHunk
+	aStart, bStart, aLen, bLen := -1, -1, 0, 0
+	var dels, adds []diffLine
+	flush := func() {
+		for i := range max(len(dels), len(adds)) {
+			row := diffRow{Left: diffLine{Kind: "del", Empty: true}, Right: diffLine{Kind: "add", Empty: true}}
+			if i < len(dels) {
+				row.Left = dels[i]
+			}
+			if i < len(adds) {
+				row.Right = adds[i]
+			}
+			hunk.Rows = append(hunk.Rows, row)
+		}
+		dels, adds = nil, nil
+	}
+
+	for _, op := range ops {
+		if op.a >= 0 {
+			aLen++
+			if aStart < 0 {
+				aStart = op.a
+			}
+		}
+		if op.b >= 0 {
+			bLen++
+			if bStart < 0 {
+				bStart = op.b
+			}
+		}
+		switch op.kind {
+		case diffmatchpatch.DiffEqual:
+			flush()
+			line := diffLine{Kind: "ctx", ANum: op.a + 1, BNum: op.b + 1, Code: aCode[op.a]}
+			hunk.Lines = append(hunk.Lines, line)
+			hunk.Rows = append(hunk.Rows, diffRow{Left: line, Right: diffLine{Kind: "ctx", BNum: op.b + 1, Code: bCode[op.b]}})
+		case diffmatchpatch.DiffDelete:
+			line := diffLine{Kind: "del", ANum: op.a + 1, Code: aCode[op.a]}
+			hunk.Lines = append(hunk.Lines, line)
+			dels = append(dels, line)
+		case diffmatchpatch.DiffInsert:
+			line := diffLine{Kind: "add", BNum: op.b + 1, Code: bCode[op.b]}
+			hunk.Lines = append(hunk.Lines, line)
+			adds = append(adds, line)
+		}
+	}
+	flush()
+	hunk.Header = fmt.Sprintf("@@ -%s +%s @@", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+	return hunk
+}
+
+// hunkRange formats the range of lines of a hunk as unified diff does, start is zero-based or -1 if
+// the hunk has no lines of the file
+func hunkRange(start, n int) string {
+	if start < 0 {
+		return "0,0"
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// highlightLines renders each line of the code, highlighted with the same lexer and style as
+// highlightCode if syntax highlighting is enabled, escaped otherwise
+func (wb *Web) highlightLines(lines []string, filename, theme string) []template.HTML {
+	res := make([]template.HTML, len(lines))
+	for i, line := range lines {
+		res[i] = template.HTML(template.HTMLEscapeString(line)) //nolint:gosec // escaped
+	}
+	if !wb.EnableSyntaxHighlighting || len(lines) == 0 {
+		return res
+	}
+	code := strings.Join(lines, "\n") + "\n"
+	lexer := codeLexer(code, filename)
+	if lexer == nil {
+		return res
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return res
+	}
+	tokenLines := chroma.SplitTokensIntoLines(iterator.Tokens())
+	if len(tokenLines) != len(lines) {
+		return res // the lexer changed the lines, e.g. dropped or added a line ending
+	}
+
+	formatter := html.New(html.WithClasses(true), html.PreventSurroundingPre(true))
+	style := codeStyle(theme)
+	highlighted := make([]template.HTML, len(lines))
+	for i, tokens := range tokenLines {
+		// drop the line ending, it's not a part of the rendered line
+		if n := len(tokens); n > 0 {
+			last := chroma.Token{Type: tokens[n-1].Type, Value: strings.TrimSuffix(tokens[n-1].Value, "\n")}
+			tokens = slices.Clip(tokens[:n-1])
+			if last.Value != "" {
+				tokens = append(tokens, last)
+			}
+		}
+		var buf strings.Builder
+		if err := formatter.Format(&buf, style, chroma.Literator(tokens...)); err != nil {
+			return res
+		}
+		highlighted[i] = template.HTML(buf.String()) //nolint:gosec // escaped by chroma
+	}
+	return highlighted
+}
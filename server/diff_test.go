@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffHunks(t *testing.T) {
+	a := numberedLines(1, 20)
+	b := strings.Replace(strings.Replace(a, "line 3\n", "line three\n", 1), "line 18\n", "", 1) + "new\n"
+	aLines, bLines := splitLines(a), splitLines(b)
+	escape := func(lines []string) []template.HTML {
+		res := make([]template.HTML, len(lines))
+		for i, l := range lines {
+			res[i] = template.HTML(template.HTMLEscapeString(l)) //nolint:gosec // escaped
+		}
+		return res
+	}
+
+	hunks := diffHunks(diffLines([]byte(a), []byte(b)), escape(aLines), escape(bLines))
+	require.Len(t, hunks, 2, "changes far apart are separate hunks")
+	assert.Equal(t, "@@ -1,6 +1,6 @@", hunks[0].Header)
+	assert.Equal(t, "@@ -15,6 +15,6 @@", hunks[1].Header)
+
+	var unified []string
+	for _, l := range hunks[0].Lines {
+		unified = append(unified, l.Kind+" "+string(l.Code))
+	}
+	assert.Equal(t, []string{"ctx line 1", "ctx line 2", "del line 3", "add line three", "ctx line 4", "ctx line 5", "ctx line 6"}, unified)
+
+	// side by side pairs the changed lines
+	require.Len(t, hunks[0].Rows, 6)
+	assert.Equal(t, diffLine{Kind: "del", ANum: 3, Code: "line 3"}, hunks[0].Rows[2].Left)
+	assert.Equal(t, diffLine{Kind: "add", BNum: 3, Code: "line three"}, hunks[0].Rows[2].Right)
+	assert.True(t, hunks[1].Rows[3].Right.Empty, "deleted line has no pair")
+	assert.Equal(t, 18, hunks[1].Rows[3].Left.ANum)
+	assert.True(t, hunks[1].Rows[6].Left.Empty, "added line has no pair")
+	assert.Equal(t, 20, hunks[1].Rows[6].Right.BNum)
+
+	// close changes are merged into one hunk
+	b = strings.Replace(strings.Replace(a, "line 3\n", "", 1), "line 9\n", "", 1)
+	hunks = diffHunks(diffLines([]byte(a), []byte(b)), escape(aLines), escape(splitLines(b)))
+	require.Len(t, hunks, 1)
+	assert.Equal(t, "@@ -1,12 +1,10 @@", hunks[0].Header)
+
+	// new file
+	hunks = diffHunks(diffLines(nil, []byte("one\n")), nil, escape([]string{"one"}))
+	require.Len(t, hunks, 1)
+	assert.Equal(t, "@@ -0,0 +1 @@", hunks[0].Header)
+}
+
+func TestHandleDiff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o750))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+	write("old.go", []byte("package main\n\nfunc main() {\n\tprintln(\"old\")\n}\n"))
+	write("new.go", []byte("package main\n\nfunc main() {\n\tprintln(\"new\")\n}\n"))
+	write("copy.go", []byte("package main\n\nfunc main() {\n\tprintln(\"old\")\n}\n"))
+	write("a.bin", []byte{0, 1, 2, 3})
+	write("b.bin", []byte{0, 1, 2, 4})
+	big := bytes.Repeat([]byte("0123456789abcde\n"), maxDiffSize/16+1)
+	write("big1.txt", big)
+	write("big2.txt", big)
+	big = bytes.Clone(big)
+	big[len(big)-2] = 'x'
+	write("big3.txt", big)
+	write("secret.txt", []byte("secret"))
+	write("sub/file.txt", []byte("file"))
+
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Exclude: []string{"secret.txt"}, EnableSyntaxHighlighting: true},
+		FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	rr := get("/diff?a=old.go&b=new.go")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `<table class="diff unified">`)
+	assert.Contains(t, body, "@@ -1,5 &#43;1,5 @@")
+	assert.Contains(t, body, `<span class="s">&#34;old&#34;</span><span class="p">)</span></td>`, "highlighted, no line endings")
+	assert.Contains(t, body, `<span class="added">+1</span> <span class="removed">-1</span>`)
+	assert.Contains(t, body, `href="/diff?a=old.go&amp;b=new.go&amp;mode=split"`)
+
+	rr = get("/diff?a=old.go&b=new.go&mode=split")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<table class="diff split">`)
+	assert.Contains(t, rr.Body.String(), `<td class="num right add">4</td>`)
+
+	assert.Contains(t, get("/diff?a=old.go&b=copy.go").Body.String(), "Files are identical")
+	assert.Contains(t, get("/diff?a=a.bin&b=b.bin").Body.String(), "Binary files differ")
+	assert.Contains(t, get("/diff?a=big1.txt&b=big2.txt").Body.String(), "Files are identical")
+	assert.Contains(t, get("/diff?a=big1.txt&b=big3.txt").Body.String(), "too large to compare")
+	assert.Contains(t, get("/diff?a=big1.txt&b=old.go").Body.String(), "too large to compare")
+
+	assert.Equal(t, http.StatusBadRequest, get("/diff?a=old.go").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/diff?a=old.go&b=sub").Code)
+	assert.Equal(t, http.StatusNotFound, get("/diff?a=old.go&b=missing.go").Code)
+	assert.Equal(t, http.StatusForbidden, get("/diff?a=secret.txt&b=old.go").Code)
+	assert.Equal(t, http.StatusNotFound, get("/diff?a=old.go&b=../../etc/passwd").Code)
+
+	// without highlighting lines are escaped
+	srv.EnableSyntaxHighlighting = false
+	body = get("/diff?a=old.go&b=new.go").Body.String()
+	assert.Contains(t, body, `println(&#34;old&#34;)`)
+	assert.NotContains(t, body, `<span class="s">`)
+}
+
+func TestHandleDiffGitRefs(t *testing.T) {
+	dir, first, _ := setupGitRepo(t)
+	g, err := OpenGitRepo(dir, "master")
+	require.NoError(t, err)
+	fsys, err := g.FS(g.DefaultRef)
+	require.NoError(t, err)
+	srv := &Web{Config: Config{Theme: "light", RootDir: "git:" + dir + "@master"}, FS: fsys, Git: g}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	rr := get("/diff?a=readme.md&aref=v1&b=readme.md")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "@v1")
+	assert.Contains(t, body, "@master")
+	assert.Contains(t, body, "# readme v1")
+	assert.Contains(t, body, "# readme v2")
+
+	rr = get("/diff?a=readme.md&aref=" + first.String() + "&b=readme.md&bref=feature")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Files are identical")
+
+	assert.Equal(t, http.StatusBadRequest, get("/diff?a=readme.md&aref=nope&b=readme.md").Code)
+	assert.Equal(t, http.StatusNotFound, get("/diff?a=new.txt&aref=v1&b=new.txt").Code)
+}
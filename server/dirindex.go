@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"path"
+	"sync"
+	"time"
+)
+
+// dirIndex keeps the newest nested file mtime and the total nested file size for every directory,
+// so listings with RecursiveMtime don't walk the whole subtree of each directory row on every request.
+// The index is built in the background on startup and rebuilt periodically. Until the first build
+// completes, lookups miss and callers fall back to walking the directory themselves.
+type dirIndex struct {
+	fsys    fs.FS
+	exclude func(path string) bool // reports paths hidden from listings
+
+	mu    sync.RWMutex
+	stats map[string]dirStats // keyed by slash-separated path relative to root, "." for the root itself
+	ready bool                // true once the first build has completed
+}
+
+// dirStats holds aggregated information about the visible files nested in a directory
+type dirStats struct {
+	newest time.Time // modification time of the newest nested file, zero if there are no files
+	size   int64     // total size of all nested files
+}
+
+// newDirIndex makes an empty index for the given filesystem, skipping paths reported by exclude
+func newDirIndex(fsys fs.FS, exclude func(path string) bool) *dirIndex {
+	return &dirIndex{fsys: fsys, exclude: exclude, stats: map[string]dirStats{}}
+}
+
+// run builds the index and keeps rebuilding it every interval until the context is canceled.
+// a non-positive interval builds the index once.
+func (d *dirIndex) run(ctx context.Context, interval time.Duration) {
+	d.build()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.build()
+		}
+	}
+}
+
+// build walks the whole filesystem once and replaces the index with the fresh result.
+// excluded files and directories are skipped, matching what the listing shows.
+func (d *dirIndex) build() {
+	st := time.Now()
+	stats := map[string]dirStats{}
+	files := 0
+	_ = fs.WalkDir(d.fsys, ".", func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip errors, continue walking
+		}
+		if p != "." && d.exclude(p) {
+			if de.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if de.IsDir() {
+			if _, ok := stats[p]; !ok {
+				stats[p] = dirStats{}
+			}
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil
+		}
+		files++
+		addToAncestors(stats, p, info.Size(), info.ModTime())
+		return nil
+	})
+
+	d.mu.Lock()
+	d.stats = stats
+	d.ready = true
+	d.mu.Unlock()
+	log.Printf("[DEBUG] directory index built, %d dirs, %d files in %v", len(stats), files, time.Since(st))
+}
+
+// lookup returns aggregated stats for the directory. ok is false if the index is not built yet
+// or the directory is unknown to it.
+func (d *dirIndex) lookup(dir string) (stats dirStats, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if !d.ready {
+		return dirStats{}, false
+	}
+	stats, ok = d.stats[path.Clean(dir)]
+	return stats, ok
+}
+
+// touch records a newly written file without waiting for the next rebuild.
+// the size of an overwritten file is added again and gets corrected by the next rebuild.
+func (d *dirIndex) touch(filePath string, size int64, modTime time.Time) {
+	if d.exclude(filePath) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ready {
+		return // the pending build will pick the file up
+	}
+	addToAncestors(d.stats, path.Clean(filePath), size, modTime)
+}
+
+// addToAncestors adds the file size and mtime to every directory containing the file, up to the root
+func addToAncestors(stats map[string]dirStats, filePath string, size int64, modTime time.Time) {
+	for dir := path.Dir(filePath); ; dir = path.Dir(dir) {
+		s := stats[dir]
+		s.size += size
+		if modTime.After(s.newest) {
+			s.newest = modTime
+		}
+		stats[dir] = s
+		if dir == "." || dir == "/" {
+			return
+		}
+	}
+}
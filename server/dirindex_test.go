@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeIndexTree creates a tree with files of known size and mtime:
+// a/old.txt (3 bytes, -48h), a/b/new.txt (5 bytes, -1h), a/.hidden/secret.txt (7 bytes, now), top.txt (1 byte)
+func makeIndexTree(t *testing.T) (root string, oldTime, newTime time.Time) {
+	t.Helper()
+	root = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", ".hidden"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "empty"), 0o755))
+
+	oldTime = time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	newTime = time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+	write := func(rel, body string, mtime time.Time) {
+		p := filepath.Join(root, rel)
+		require.NoError(t, os.WriteFile(p, []byte(body), 0o600))
+		require.NoError(t, os.Chtimes(p, mtime, mtime))
+	}
+	write("a/old.txt", "old", oldTime)
+	write("a/b/new.txt", "newer", newTime)
+	write("a/.hidden/secret.txt", "secret!", time.Now())
+	write("top.txt", "t", oldTime)
+	return root, oldTime, newTime
+}
+
+func TestDirIndex_Build(t *testing.T) {
+	root, _, newTime := makeIndexTree(t)
+	idx := newDirIndex(os.DirFS(root), func(p string) bool { return matchesExcludes(p, []string{".hidden"}) })
+
+	_, ok := idx.lookup("a")
+	assert.False(t, ok, "lookup should miss before the first build")
+
+	idx.build()
+
+	stats, ok := idx.lookup("a")
+	require.True(t, ok)
+	assert.Equal(t, newTime, stats.newest, "newest nested file, excluded dir ignored")
+	assert.Equal(t, int64(8), stats.size, "old.txt + b/new.txt, excluded dir ignored")
+
+	stats, ok = idx.lookup("a/b")
+	require.True(t, ok)
+	assert.Equal(t, newTime, stats.newest)
+	assert.Equal(t, int64(5), stats.size)
+
+	stats, ok = idx.lookup(".")
+	require.True(t, ok)
+	assert.Equal(t, newTime, stats.newest)
+	assert.Equal(t, int64(9), stats.size)
+
+	stats, ok = idx.lookup("empty")
+	require.True(t, ok, "empty directories are known to the index")
+	assert.True(t, stats.newest.IsZero())
+	assert.Zero(t, stats.size)
+
+	_, ok = idx.lookup("a/.hidden")
+	assert.False(t, ok, "excluded directory is not indexed")
+	_, ok = idx.lookup("missing")
+	assert.False(t, ok)
+}
+
+func TestDirIndex_Touch(t *testing.T) {
+	root, _, newTime := makeIndexTree(t)
+	idx := newDirIndex(os.DirFS(root), func(p string) bool { return matchesExcludes(p, []string{".hidden"}) })
+
+	idx.touch("a/b/early.txt", 10, time.Now())
+	_, ok := idx.lookup("a/b")
+	assert.False(t, ok, "touch before the first build is ignored")
+
+	idx.build()
+	touched := newTime.Add(time.Minute)
+	idx.touch("a/b/added.txt", 10, touched)
+	idx.touch("a/.hidden/more.txt", 100, touched.Add(time.Hour))
+
+	stats, ok := idx.lookup("a/b")
+	require.True(t, ok)
+	assert.Equal(t, touched, stats.newest)
+	assert.Equal(t, int64(15), stats.size)
+
+	stats, ok = idx.lookup(".")
+	require.True(t, ok)
+	assert.Equal(t, touched, stats.newest, "excluded file touch is ignored")
+	assert.Equal(t, int64(19), stats.size)
+}
+
+func TestDirIndex_Run(t *testing.T) {
+	root, _, newTime := makeIndexTree(t)
+	idx := newDirIndex(os.DirFS(root), func(string) bool { return false })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		idx.run(ctx, 50*time.Millisecond)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		_, ok := idx.lookup("a")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	// a file written outside of weblist shows up after the next rescan
+	later := newTime.Add(time.Hour)
+	p := filepath.Join(root, "a", "b", "later.txt")
+	require.NoError(t, os.WriteFile(p, []byte("x"), 0o600))
+	require.NoError(t, os.Chtimes(p, later, later))
+	require.Eventually(t, func() bool {
+		stats, _ := idx.lookup("a/b")
+		return stats.newest.Equal(later)
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run did not stop after context cancellation")
+	}
+}
+
+func TestGetFileListWithDirIndex(t *testing.T) {
+	root, oldTime, newTime := makeIndexTree(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "c"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "c", "big.txt"), make([]byte, 100), 0o600))
+	require.NoError(t, os.Chtimes(filepath.Join(root, "c", "big.txt"), oldTime, oldTime))
+
+	wb := &Web{Config: Config{RootDir: root, RecursiveMtime: true, Exclude: []string{".hidden"}}, FS: os.DirFS(root)}
+	wb.dirIndex = newDirIndex(wb.FS, wb.shouldExclude)
+	wb.dirIndex.build()
+
+	files, err := wb.getFileList(".", "size", "desc")
+	require.NoError(t, err)
+	names := make([]string, 0, len(files))
+	byName := map[string]FileInfo{}
+	for _, f := range files {
+		names = append(names, f.Name)
+		byName[f.Name] = f
+	}
+
+	assert.Equal(t, []string{"c", "a", "empty", "top.txt"}, names, "directories ordered by total size")
+	assert.Equal(t, newTime, byName["a"].LastModified)
+	assert.Equal(t, int64(8), byName["a"].TotalSize)
+	assert.Equal(t, "8 B", byName["a"].SizeToString())
+	assert.Equal(t, int64(100), byName["c"].TotalSize)
+	assert.Zero(t, byName["empty"].TotalSize)
+	assert.Equal(t, "-", byName["empty"].SizeToString())
+}
@@ -1,10 +1,8 @@
 package server
 
 import (
-	"encoding/csv"
 	"fmt"
 	"html/template"
-	"io"
 	"io/fs"
 	"log"
 	"net/http"
@@ -26,12 +24,14 @@ import (
 
 // getTemplateFuncs returns the common template functions map
 func (wb *Web) getTemplateFuncs() template.FuncMap {
+	assetsFS, _ := fs.Sub(content, "assets") // error ignored: the directory name is valid
 	return template.FuncMap{
 		"safe": func(s string) template.HTML {
 			return template.HTML(s) // nolint:gosec // safe to use with local embedded templates
 		},
 		"contains":  strings.Contains,
 		"hasPrefix": strings.HasPrefix,
+		"asset":     func(path string) string { return assetURL(assetsFS, path) },
 	}
 }
 
@@ -108,6 +108,7 @@ func (wb *Web) getSortParamsFromCookies(r *http.Request) (sortBy, sortDir string
 
 // renderFullPage renders the complete HTML page
 func (wb *Web) renderFullPage(w http.ResponseWriter, r *http.Request, path string) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
 	// clean the path to avoid directory traversal attacks
 	path = filepath.ToSlash(filepath.Clean(path))
 
@@ -116,6 +117,9 @@ func (wb *Web) renderFullPage(w http.ResponseWriter, r *http.Request, path strin
 		http.Error(w, "access denied to requested path", http.StatusForbidden)
 		return
 	}
+	if !wb.requireMountAuth(w, r, path) {
+		return
+	}
 
 	// check if the path exists
 	fileInfo, err := fs.Stat(wb.FS, path)
@@ -138,6 +142,11 @@ func (wb *Web) renderFullPage(w http.ResponseWriter, r *http.Request, path strin
 	// get sort parameters from query or cookies
 	sortBy, sortDir := wb.getSortParams(w, r)
 
+	// the page is not regenerated if the client has the current one
+	if wb.listingNotModified(w, r, path, sortBy, sortDir) {
+		return
+	}
+
 	fileList, err := wb.getFileList(path, sortBy, sortDir)
 	if err != nil {
 		http.Error(w, "error reading directory: "+err.Error(), http.StatusInternalServerError)
@@ -152,10 +161,12 @@ func (wb *Web) renderFullPage(w http.ResponseWriter, r *http.Request, path strin
 
 	// check if user is authenticated (for showing logout button)
 	isAuthenticated := false
-	if wb.Auth != "" {
-		isAuthenticated = wb.isAuthenticatedByCookie(r)
+	if wb.authEnabled() || wb.hasMountAuth() {
+		isAuthenticated = wb.isAuthenticatedByCookie(r) || wb.hasMountSession(r)
 	}
 
+	upload := wb.uploadSettingsFor(path)
+	gitRefs, gitRef := wb.gitRefs(r)
 	data := struct {
 		Files             []FileInfo
 		Path              string
@@ -172,7 +183,12 @@ func (wb *Web) renderFullPage(w http.ResponseWriter, r *http.Request, path strin
 		CustomFooter      string
 		EnableMultiSelect bool
 		EnableUpload      bool
+		UploadAvailable   bool
 		UploadMaxSize     int64
+		GitRefs           []GitRef
+		GitRef            string
+		User              string
+		SessionsPage      bool
 	}{
 		Files:             fileList,
 		Path:              path,
@@ -188,8 +204,13 @@ func (wb *Web) renderFullPage(w http.ResponseWriter, r *http.Request, path strin
 		BrandColor:        wb.BrandColor,
 		CustomFooter:      wb.CustomFooter,
 		EnableMultiSelect: wb.EnableMultiSelect,
-		EnableUpload:      wb.EnableUpload,
-		UploadMaxSize:     wb.UploadMaxSize,
+		EnableUpload:      upload.enabled,
+		UploadAvailable:   wb.uploadAvailable(),
+		UploadMaxSize:     upload.maxSize,
+		GitRefs:           gitRefs,
+		GitRef:            gitRef,
+		User:              wb.identifiedUser(r),
+		SessionsPage:      wb.Sessions != nil && wb.isAuthenticatedByCookie(r),
 	}
 
 	// execute the entire template
@@ -264,20 +285,20 @@ func (wb *Web) getFileList(path, sortBy, sortDir string) ([]FileInfo, error) {
 			continue
 		}
 
-		lastModified := info.ModTime()
-		// for directories, calculate recursive mtime if enabled
-		if entry.IsDir() && wb.RecursiveMtime {
-			if recursiveMtime := wb.getRecursiveMtime(entryPath); !recursiveMtime.IsZero() {
-				lastModified = recursiveMtime
-			}
-		}
-
 		fi := FileInfo{
 			Name:         entry.Name(),
 			Size:         info.Size(),
-			LastModified: lastModified,
+			LastModified: info.ModTime(),
 			IsDir:        entry.IsDir(),
 			Path:         entryPath,
+			version:      contentVersion(info),
+		}
+		if commit, ok := info.Sys().(*CommitInfo); ok {
+			fi.Commit = commit
+		}
+		// for directories, use recursive mtime and total size if enabled
+		if entry.IsDir() && wb.RecursiveMtime {
+			wb.applyRecursiveStats(&fi)
 		}
 		wb.detectBinary(&fi)
 		files = append(files, fi)
@@ -289,6 +310,24 @@ func (wb *Web) getFileList(path, sortBy, sortDir string) ([]FileInfo, error) {
 	return files, nil
 }
 
+// applyRecursiveStats sets the directory mtime to the newest nested file and fills its total size.
+// it uses the background directory index when available and walks the directory otherwise,
+// in which case the total size stays unknown.
+func (wb *Web) applyRecursiveStats(fi *FileInfo) {
+	if wb.dirIndex != nil {
+		if stats, ok := wb.dirIndex.lookup(filepath.ToSlash(fi.Path)); ok {
+			if !stats.newest.IsZero() {
+				fi.LastModified = stats.newest
+			}
+			fi.TotalSize = stats.size
+			return
+		}
+	}
+	if recursiveMtime := wb.getRecursiveMtime(fi.Path); !recursiveMtime.IsZero() {
+		fi.LastModified = recursiveMtime
+	}
+}
+
 // getRecursiveMtime returns the most recent modification time of any file
 // within the directory tree. This is useful for sorting directories by
 // when their content was last modified, not just direct children.
@@ -321,9 +360,10 @@ func (wb *Web) getRecursiveMtime(path string) time.Time {
 	return newest
 }
 
-// shouldExclude checks if a path should be excluded based on the Exclude patterns.
+// shouldExclude checks if a path should be excluded based on the Exclude patterns and the excludes of its mount.
+// files being uploaded are excluded as well.
 func (wb *Web) shouldExclude(path string) bool {
-	return matchesExcludes(path, wb.Exclude)
+	return matchesExcludes(path, wb.Exclude) || mountExcluded(wb.Mounts, path) || isUploadTemp(path)
 }
 
 // matchesExcludes reports whether the path matches any of the exclusion patterns. A pattern matches
@@ -380,7 +420,8 @@ func pathComponents(path string) []string {
 // The sort maintains several important properties:
 // 1. The ".." parent directory entry always appears first
 // 2. Directories are always grouped before files, regardless of sort field
-// 3. When directories are sorted by size, they're sorted by name instead for consistency
+// 3. When directories are sorted by size, they're sorted by name instead for consistency,
+// unless their total size is known from the directory index
 // 4. Files are sorted by the requested field with case-insensitive name comparison
 // 5. The sortDir parameter (asc/desc) reverses the sort order when set to "desc"
 func (wb *Web) sortFiles(files []FileInfo, sortBy, sortDir string) {
@@ -412,8 +453,12 @@ func (wb *Web) sortFiles(files []FileInfo, sortBy, sortDir string) {
 			result = files[i].LastModified.Before(files[j].LastModified)
 		case "size":
 			if files[i].IsDir && files[j].IsDir {
-				// if both are directories, sort by name in ascending order regardless of sortDir
-				return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
+				if files[i].TotalSize == 0 && files[j].TotalSize == 0 {
+					// if both are directories without known size, sort by name in ascending order regardless of sortDir
+					return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
+				}
+				result = files[i].TotalSize < files[j].TotalSize
+				break
 			}
 			result = files[i].Size < files[j].Size
 		default:
@@ -467,24 +512,12 @@ func (wb *Web) getPathParts(path, sortBy, sortDir string) []map[string]string {
 
 // highlightCode applies syntax highlighting to the given code content
 func (wb *Web) highlightCode(code, filename, theme string) (string, error) {
-	// get lexer for the file
-	lexer := lexers.Get(filename)
+	lexer := codeLexer(code, filename)
 	if lexer == nil {
-		// try to detect language from content if filename doesn't help
-		lexer = lexers.Analyse(code)
-		if lexer == nil {
-			// fall back to plain text if no lexer found
-			return fmt.Sprintf(`<div class="highlight-wrapper"><pre class="chroma">%s</pre></div>`, template.HTMLEscapeString(code)), nil
-		}
-	}
-
-	// get style based on theme
-	var style *chroma.Style
-	if theme == "dark" {
-		style = styles.Get("monokai")
-	} else {
-		style = styles.Get("github")
+		// fall back to plain text if no lexer found
+		return fmt.Sprintf(`<div class="highlight-wrapper"><pre class="chroma">%s</pre></div>`, template.HTMLEscapeString(code)), nil
 	}
+	style := codeStyle(theme)
 
 	// create HTML formatter with line numbers
 	formatter := html.New(html.WithClasses(true))
@@ -510,68 +543,21 @@ func (wb *Web) highlightCode(code, filename, theme string) (string, error) {
 	return buf.String(), nil
 }
 
-// renderCSV parses CSV content incrementally and renders it as an HTML table.
-// the first row is treated as a header. output is capped at maxCSVRows data rows.
-// rows beyond the limit are counted but not stored, avoiding unbounded memory allocation.
-// all cell values are HTML-escaped.
-func (wb *Web) renderCSV(content string) (string, error) {
-	const maxCSVRows = 1000
-
-	reader := csv.NewReader(strings.NewReader(content))
-	reader.FieldsPerRecord = -1 // allow variable number of fields
-
-	// read header row
-	header, err := reader.Read()
-	if err != nil {
-		return "", fmt.Errorf("csv parse: %w", err)
+// codeLexer returns the lexer for the file by its name, or detected from the code if the name doesn't help.
+// it returns nil if the language is unknown.
+func codeLexer(code, filename string) chroma.Lexer {
+	if lexer := lexers.Get(filename); lexer != nil {
+		return lexer
 	}
+	return lexers.Analyse(code)
+}
 
-	var buf strings.Builder
-	buf.WriteString(`<div class="csv-content"><table>`)
-
-	// render header
-	buf.WriteString("<thead><tr>")
-	for _, cell := range header {
-		buf.WriteString("<th>")
-		buf.WriteString(template.HTMLEscapeString(cell))
-		buf.WriteString("</th>")
-	}
-	buf.WriteString("</tr></thead>")
-
-	// render body rows incrementally, only store rendered output
-	buf.WriteString("<tbody>")
-	totalDataRows := 0
-	for {
-		row, err := reader.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", fmt.Errorf("csv parse: %w", err)
-		}
-		totalDataRows++
-		if totalDataRows > maxCSVRows {
-			continue // count remaining rows but don't render
-		}
-		buf.WriteString("<tr>")
-		for _, cell := range row {
-			buf.WriteString("<td>")
-			buf.WriteString(template.HTMLEscapeString(cell))
-			buf.WriteString("</td>")
-		}
-		buf.WriteString("</tr>")
-	}
-	buf.WriteString("</tbody>")
-
-	buf.WriteString("</table>")
-
-	if totalDataRows > maxCSVRows {
-		fmt.Fprintf(&buf, `<p class="csv-truncated">showing %d of %d rows</p>`, maxCSVRows, totalDataRows)
+// codeStyle returns the highlighting style for the theme
+func codeStyle(theme string) *chroma.Style {
+	if theme == "dark" {
+		return styles.Get("monokai")
 	}
-
-	buf.WriteString("</div>")
-
-	return buf.String(), nil
+	return styles.Get("github")
 }
 
 // renderMarkdown converts markdown content to HTML using goldmark with GFM extensions.
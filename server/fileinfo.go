@@ -20,7 +20,10 @@ type FileInfo struct {
 	Size         int64
 	LastModified time.Time
 	Path         string
-	isBinary     bool // true if content detection indicates binary file despite text-like extension
+	TotalSize    int64       // total size of nested files for directories, zero if unknown
+	Commit       *CommitInfo // last commit changing the file, set for files served from a git repository
+	isBinary     bool        // true if content detection indicates binary file despite text-like extension
+	version      string      // content version for caches, see contentVersion
 }
 
 // ContentTypeInfo holds content type information for a file
@@ -31,12 +34,17 @@ type ContentTypeInfo struct {
 	IsPDF      bool   // true for PDF documents
 	IsImage    bool   // true for all image formats
 	IsMarkdown bool   // true for markdown files (.md, .markdown)
-	IsCSV      bool   // true for CSV files (.csv)
+	IsCSV      bool   // true for delimited files shown as tables (.csv, .tsv, .tab, .psv)
+	IsNotebook bool   // true for Jupyter notebooks (.ipynb)
+	DataFormat string // json, ndjson, yaml, toml, xml or svg for structured data shown as a tree or a table, empty otherwise
 }
 
 // SizeToString converts file size to human-readable format
 func (f FileInfo) SizeToString() string {
 	if f.IsDir {
+		if f.TotalSize > 0 {
+			return humanize.Bytes(uint64(f.TotalSize)) // #nosec G115 - checked for positive value above
+		}
 		return "-"
 	}
 
@@ -95,7 +103,7 @@ var commonTextExtensions = func() map[string]bool {
 		"ps1", "psm1", "r", "m", "mat", "sas", "sql", "vb", "vbs", "cs", "fs", "fsx",
 		"dart", "kotlin", "scala", "groovy", "lua", "rust", "rs", "vue", "elm", "ex", "exs",
 		"hs", "clj", "d", "jl", "nim", "svg", "graphql", "gql", "proto", "avro", "diff", "patch",
-		"properties", "cfg", "htaccess", "gitignore", "dockerignore", "rtf", "sdoc",
+		"properties", "cfg", "htaccess", "gitignore", "dockerignore", "rtf", "sdoc", "ipynb", "ndjson", "jsonl",
 	}
 
 	res := make(map[string]bool, len(exts))
@@ -105,6 +113,12 @@ var commonTextExtensions = func() map[string]bool {
 	return res
 }()
 
+// dataFormats maps extensions of structured data files to their formats
+var dataFormats = map[string]string{
+	".json": "json", ".ndjson": "ndjson", ".jsonl": "ndjson",
+	".yaml": "yaml", ".yml": "yaml", ".toml": "toml", ".xml": "xml", ".svg": "svg",
+}
+
 // DetermineContentType analyzes a file to determine its content type and common format flags.
 // It uses a multi-step detection process:
 // 1. Checks against a predefined list of known text file extensions
@@ -140,7 +154,9 @@ func DetermineContentType(filePath string) ContentTypeInfo {
 		IsPDF:      mimeType == "application/pdf",
 		IsImage:    strings.HasPrefix(mimeType, "image/"),
 		IsMarkdown: extLower == ".md" || extLower == ".markdown",
-		IsCSV:      extLower == ".csv",
+		IsCSV:      extLower == ".csv" || extLower == ".tsv" || extLower == ".tab" || extLower == ".psv",
+		IsNotebook: extLower == ".ipynb",
+		DataFormat: dataFormats[extLower],
 	}
 }
 
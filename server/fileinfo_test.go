@@ -115,6 +115,8 @@ func TestDetermineContentType(t *testing.T) {
 		wantIsImage    bool
 		wantIsMarkdown bool
 		wantIsCSV      bool
+		wantIsNotebook bool
+		wantFormat     string
 	}{
 		{
 			name:        "plain text file",
@@ -206,6 +208,41 @@ func TestDetermineContentType(t *testing.T) {
 			wantIsText: true,
 			wantIsCSV:  true,
 		},
+		{
+			name:           "jupyter notebook",
+			filePath:       "analysis.ipynb",
+			wantType:       "text/plain",
+			wantIsText:     true,
+			wantIsNotebook: true,
+		},
+		{
+			name:       "json file",
+			filePath:   "config.JSON",
+			wantType:   "text/plain",
+			wantIsText: true,
+			wantFormat: "json",
+		},
+		{
+			name:       "json lines file",
+			filePath:   "events.jsonl",
+			wantType:   "text/plain",
+			wantIsText: true,
+			wantFormat: "ndjson",
+		},
+		{
+			name:       "yaml file",
+			filePath:   "compose.yml",
+			wantType:   "text/plain",
+			wantIsText: true,
+			wantFormat: "yaml",
+		},
+		{
+			name:       "svg image",
+			filePath:   "logo.svg",
+			wantType:   "text/plain",
+			wantIsText: true,
+			wantFormat: "svg",
+		},
 	}
 
 	for _, tt := range tests {
@@ -232,6 +269,8 @@ func TestDetermineContentType(t *testing.T) {
 			assert.Equal(t, tt.wantIsImage, ctInfo.IsImage)
 			assert.Equal(t, tt.wantIsMarkdown, ctInfo.IsMarkdown)
 			assert.Equal(t, tt.wantIsCSV, ctInfo.IsCSV)
+			assert.Equal(t, tt.wantIsNotebook, ctInfo.IsNotebook)
+			assert.Equal(t, tt.wantFormat, ctInfo.DataFormat)
 		})
 	}
 }
@@ -0,0 +1,552 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	lcw "github.com/go-pkgz/lcw/v2"
+)
+
+// gitMaxWalk limits the number of commits walked back to find the last commit of directory entries.
+// entries not changed within the limit get the time of the served commit.
+const gitMaxWalk = 5000
+
+// GitRepo is a git repository served read-only at a ref, users can switch to other branches and tags
+type GitRepo struct {
+	DefaultRef string // ref served unless the user selects another one
+
+	repo        *git.Repository
+	lastCommits lcw.LoadingCache[map[string]*CommitInfo] // last commits of directory entries by commit and directory
+}
+
+// GitRef is a branch or tag of the repository
+type GitRef struct {
+	Name  string
+	IsTag bool
+}
+
+// CommitInfo describes the last commit changing a file served from a git repository
+type CommitInfo struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Message string    `json:"message"` // first line of the commit message
+	Time    time.Time `json:"time"`
+}
+
+// ShortHash returns the abbreviated commit hash
+func (c *CommitInfo) ShortHash() string {
+	if len(c.Hash) < 7 {
+		return c.Hash
+	}
+	return c.Hash[:7]
+}
+
+// OpenGitRepo opens a bare or working repository with a pure-Go git implementation, nothing is checked out.
+// the ref is a branch, tag or commit, HEAD if empty.
+func OpenGitRepo(repoPath, ref string) (*GitRepo, error) {
+	repo, err := git.PlainOpenWithOptions(repoPath, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open git repository %s: %w", repoPath, err)
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	cache, err := lcw.NewLruCache(lcw.NewOpts[map[string]*CommitInfo]().MaxKeys(1000))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make commits cache: %w", err)
+	}
+	res := &GitRepo{DefaultRef: ref, repo: repo, lastCommits: cache}
+	if _, err := res.FS(ref); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// FS returns the read-only filesystem of the tree at the ref
+func (g *GitRepo) FS(ref string) (fs.FS, error) {
+	hash, err := g.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve git ref %q: %w", ref, err)
+	}
+	commit, err := g.repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit of git ref %q: %w", ref, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of git ref %q: %w", ref, err)
+	}
+	return &gitFS{repo: g, commit: commit, tree: tree}, nil
+}
+
+// Refs returns branches and tags sorted by name, branches first
+func (g *GitRepo) Refs() ([]GitRef, error) {
+	refs, err := g.repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list git refs: %w", err)
+	}
+	var res []GitRef
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		switch {
+		case r.Name().IsBranch():
+			res = append(res, GitRef{Name: r.Name().Short()})
+		case r.Name().IsTag():
+			res = append(res, GitRef{Name: r.Name().Short(), IsTag: true})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list git refs: %w", err)
+	}
+	slices.SortFunc(res, func(a, b GitRef) int {
+		if a.IsTag != b.IsTag {
+			if a.IsTag {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res, nil
+}
+
+// gitFS is the tree of a commit, files report the last commit changing them as mtime and in Sys
+type gitFS struct {
+	repo   *GitRepo
+	commit *object.Commit
+	tree   *object.Tree
+}
+
+// Open implements fs.FS, file content is streamed from the blob
+func (g *gitFS) Open(name string) (fs.File, error) {
+	info, err := g.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := g.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &virtualDir{info: info, entries: entries}, nil
+	}
+
+	entry, err := g.tree.FindEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	blob, err := g.repo.repo.BlobObject(entry.Hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	rd, err := blob.Reader()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &gitFile{blob: blob, info: info, rd: rd}, nil
+}
+
+// Stat implements fs.StatFS
+func (g *gitFS) Stat(name string) (fs.FileInfo, error) {
+	return g.stat("stat", name)
+}
+
+// ReadDir implements fs.ReadDirFS, submodules are skipped as their content is not in the repository
+func (g *gitFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	tree, err := g.subtree(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	commits := g.lastCommits(name, tree)
+
+	res := make([]fs.DirEntry, 0, len(tree.Entries))
+	for _, e := range tree.Entries {
+		if e.Mode == filemode.Submodule {
+			continue
+		}
+		res = append(res, fs.FileInfoToDirEntry(g.entryInfo(e, commits[e.Name])))
+	}
+	slices.SortFunc(res, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return res, nil
+}
+
+func (g *gitFS) stat(op, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &gitFileInfo{name: ".", mode: fs.ModeDir | 0o555, commit: commitInfo(g.commit)}, nil
+	}
+	dir := path.Dir(name)
+	tree, err := g.subtree(dir)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	idx := slices.IndexFunc(tree.Entries, func(e object.TreeEntry) bool { return e.Name == path.Base(name) })
+	if idx < 0 || tree.Entries[idx].Mode == filemode.Submodule {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	e := tree.Entries[idx]
+	return g.entryInfo(e, g.lastCommits(dir, tree)[e.Name]), nil
+}
+
+// subtree returns the tree of the directory
+func (g *gitFS) subtree(dir string) (*object.Tree, error) {
+	if dir == "." {
+		return g.tree, nil
+	}
+	tree, err := g.tree.Tree(dir)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	return tree, nil
+}
+
+// entryInfo makes file info of a tree entry, the served commit is used if the last commit is unknown
+func (g *gitFS) entryInfo(e object.TreeEntry, commit *CommitInfo) fs.FileInfo {
+	if commit == nil {
+		commit = commitInfo(g.commit)
+	}
+	info := &gitFileInfo{name: e.Name, commit: commit, hash: e.Hash}
+	switch e.Mode {
+	case filemode.Dir:
+		info.mode = fs.ModeDir | 0o555
+		return info
+	case filemode.Executable:
+		info.mode = 0o555
+	default:
+		info.mode = 0o444
+	}
+	if size, err := g.repo.repo.Storer.EncodedObjectSize(e.Hash); err == nil {
+		info.size = size
+	}
+	return info
+}
+
+// lastCommits finds the last commit changing each entry of the directory by walking history back
+// from the served commit. an entry changed in a commit if its hash differs from the one in the parent.
+// history is walked in log order, so the result is approximate for entries changed on merged branches.
+func (g *gitFS) lastCommits(dir string, tree *object.Tree) map[string]*CommitInfo {
+	key := g.commit.Hash.String() + ":" + dir
+	res, _ := g.repo.lastCommits.Get(key, func() (map[string]*CommitInfo, error) {
+		want := make(map[string]plumbing.Hash, len(tree.Entries))
+		for _, e := range tree.Entries {
+			want[e.Name] = e.Hash
+		}
+		found := make(map[string]*CommitInfo, len(want))
+
+		iter, err := g.repo.repo.Log(&git.LogOptions{From: g.commit.Hash})
+		if err != nil {
+			return found, nil //nolint:nilerr // entries without a known commit get the served commit
+		}
+		defer iter.Close()
+
+		var newer *object.Commit
+		walked, exhausted := 0, true
+		err = iter.ForEach(func(c *object.Commit) error {
+			if newer != nil {
+				hashes := dirHashes(c, dir)
+				for name, h := range want {
+					if _, ok := found[name]; !ok && hashes[name] != h {
+						found[name] = commitInfo(newer)
+					}
+				}
+				if len(found) == len(want) {
+					return storer.ErrStop
+				}
+			}
+			newer = c
+			if walked++; walked >= gitMaxWalk {
+				exhausted = false
+				return storer.ErrStop
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, storer.ErrStop) {
+			return found, nil //nolint:nilerr // entries without a known commit get the served commit
+		}
+
+		// entries unchanged since the first commit were added by it
+		if exhausted && newer != nil {
+			for name := range want {
+				if _, ok := found[name]; !ok {
+					found[name] = commitInfo(newer)
+				}
+			}
+		}
+		return found, nil
+	})
+	return res
+}
+
+// dirHashes returns hashes of directory entries in the commit, empty if the directory doesn't exist
+func dirHashes(c *object.Commit, dir string) map[string]plumbing.Hash {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil
+	}
+	if dir != "." {
+		if tree, err = tree.Tree(dir); err != nil {
+			return nil
+		}
+	}
+	res := make(map[string]plumbing.Hash, len(tree.Entries))
+	for _, e := range tree.Entries {
+		res[e.Name] = e.Hash
+	}
+	return res
+}
+
+func commitInfo(c *object.Commit) *CommitInfo {
+	msg, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+	return &CommitInfo{Hash: c.Hash.String(), Author: c.Author.Name, Message: msg, Time: c.Committer.When}
+}
+
+// gitFileInfo describes a tree entry, Sys returns its last commit
+type gitFileInfo struct {
+	name   string
+	size   int64
+	mode   fs.FileMode
+	commit *CommitInfo
+	hash   plumbing.Hash // hash of the blob or tree
+}
+
+func (i *gitFileInfo) Name() string       { return i.name }
+func (i *gitFileInfo) Size() int64        { return i.size }
+func (i *gitFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *gitFileInfo) ModTime() time.Time { return i.commit.Time }
+func (i *gitFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *gitFileInfo) Sys() any           { return i.commit }
+
+// contentVersion returns the hash of the blob, the same on every ref and commit with the same content
+func (i *gitFileInfo) contentVersion() string {
+	if i.hash.IsZero() {
+		return ""
+	}
+	return "git:" + i.hash.String()
+}
+
+// gitMaxBuffer is the size of blobs read into memory when seeking back, larger ones are read again from the start
+const gitMaxBuffer = 4 << 20
+
+// gitFile is an open blob, it supports Seek and ReadAt needed for ranges and SFTP.
+// the blob is streamed, seeking forward skips the content, seeking back buffers small blobs
+// and reopens larger ones.
+type gitFile struct {
+	blob *object.Blob
+	info fs.FileInfo
+
+	mu     sync.Mutex
+	rd     io.ReadCloser // blob stream positioned at pos, nil if closed or buffered
+	pos    int64
+	offset int64         // position of the next Read, set by Seek
+	buf    *bytes.Reader // whole content, once buffered
+}
+
+func (f *gitFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// Read implements io.Reader
+func (f *gitFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt, sequential calls are read from the stream
+func (f *gitFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	for n < len(p) {
+		m, err := f.readAt(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker, the stream is moved to the offset by the next read
+func (f *gitFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.blob.Size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close releases the blob stream
+func (f *gitFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rd == nil {
+		return nil
+	}
+	err := f.rd.Close()
+	f.rd = nil
+	return err
+}
+
+// readAt reads from the offset, moving the stream to it. the caller holds the lock.
+func (f *gitFile) readAt(p []byte, off int64) (int, error) {
+	if f.buf != nil {
+		return f.buf.ReadAt(p, off)
+	}
+	if off >= f.blob.Size {
+		return 0, io.EOF
+	}
+	if f.rd == nil || off < f.pos {
+		if err := f.rewind(); err != nil {
+			return 0, err
+		}
+		if f.buf != nil {
+			return f.buf.ReadAt(p, off)
+		}
+	}
+	if off > f.pos {
+		n, err := io.CopyN(io.Discard, f.rd, off-f.pos)
+		f.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.rd.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+// rewind reopens the blob stream, small blobs are read into memory to not reopen them on every seek back
+func (f *gitFile) rewind() error {
+	if f.rd != nil {
+		_ = f.rd.Close()
+		f.rd = nil
+	}
+	rd, err := f.blob.Reader()
+	if err != nil {
+		return err
+	}
+	if f.blob.Size > gitMaxBuffer {
+		f.rd, f.pos = rd, 0
+		return nil
+	}
+	defer rd.Close()
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	f.buf = bytes.NewReader(data)
+	return nil
+}
+
+// gitRefCookie keeps the git ref selected by the user
+const gitRefCookie = "git-ref"
+
+// selectedRef returns the git ref selected by the user, or the default ref
+func (wb *Web) selectedRef(r *http.Request) string {
+	if wb.Git == nil {
+		return ""
+	}
+	if c, err := r.Cookie(gitRefCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	return wb.Git.DefaultRef
+}
+
+// forRequest returns the server with FS of the git ref selected by the user, wb itself for the default ref.
+// the copy shares everything else, except the recursive mtime index built for the default ref.
+func (wb *Web) forRequest(r *http.Request) *Web {
+	ref := wb.selectedRef(r)
+	if wb.Git == nil || ref == wb.Git.DefaultRef {
+		return wb
+	}
+	fsys, err := wb.Git.FS(ref)
+	if err != nil {
+		log.Printf("[WARN] failed to serve selected git ref, using %s: %v", wb.Git.DefaultRef, err)
+		return wb
+	}
+	res := *wb
+	res.FS = fsys
+	res.dirIndex = nil
+	return &res
+}
+
+// gitRefs returns refs for the ref switcher and the selected one, nothing if no repository is served
+func (wb *Web) gitRefs(r *http.Request) (refs []GitRef, selected string) {
+	if wb.Git == nil {
+		return nil, ""
+	}
+	refs, err := wb.Git.Refs()
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	selected = wb.selectedRef(r)
+	if !slices.ContainsFunc(refs, func(ref GitRef) bool { return ref.Name == selected }) {
+		refs = append([]GitRef{{Name: selected}}, refs...) // HEAD or a commit, not a branch or tag
+	}
+	return refs, selected
+}
+
+// handleSelectRef switches the git ref for the user and shows the same directory in it, or the root
+// if the directory doesn't exist there
+func (wb *Web) handleSelectRef(w http.ResponseWriter, r *http.Request) {
+	if wb.Git == nil {
+		http.Error(w, "git repository is not served", http.StatusNotFound)
+		return
+	}
+	ref := r.URL.Query().Get("name")
+	fsys, err := wb.Git.FS(ref)
+	if ref == "" || err != nil {
+		http.Error(w, "unknown git ref", http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
+		Name:     gitRefCookie,
+		Value:    ref,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   wb.isRequestSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	dir := filepath.ToSlash(filepath.Clean(r.URL.Query().Get("path")))
+	if info, err := fs.Stat(fsys, dir); err != nil || !info.IsDir() || wb.shouldExclude(dir) {
+		dir = "."
+	}
+	target := url.URL{Path: "/", RawQuery: url.Values{"path": {dir}}.Encode()}
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
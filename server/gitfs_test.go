@@ -0,0 +1,266 @@
+package server
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupGitRepo makes a repository with two commits on master, a v1 tag at the first one and a feature branch
+func setupGitRepo(t *testing.T) (dir string, first, second plumbing.Hash) {
+	t.Helper()
+	dir = t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(msg string, when time.Time, files map[string]string) plumbing.Hash {
+		for name, content := range files {
+			require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o750))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+			_, err := wt.Add(name)
+			require.NoError(t, err)
+		}
+		sig := &object.Signature{Name: "Test Author", Email: "test@example.com", When: when}
+		hash, err := wt.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig})
+		require.NoError(t, err)
+		return hash
+	}
+
+	first = commit("initial commit\n\nwith details", time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		map[string]string{"readme.md": "# readme v1", "docs/guide.txt": "guide", "docs/deep/a.txt": "deep"})
+	_, err = repo.CreateTag("v1", first, nil)
+	require.NoError(t, err)
+	second = commit("update readme", time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC),
+		map[string]string{"readme.md": "# readme v2", "new.txt": "new"})
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), first)))
+	return dir, first, second
+}
+
+func TestGitRepo(t *testing.T) {
+	dir, first, second := setupGitRepo(t)
+
+	g, err := OpenGitRepo(filepath.Join(dir, "docs"), "") // repository is found from a subdirectory
+	require.NoError(t, err)
+	assert.Equal(t, "HEAD", g.DefaultRef)
+
+	refs, err := g.Refs()
+	require.NoError(t, err)
+	assert.Equal(t, []GitRef{{Name: "feature"}, {Name: "master"}, {Name: "v1", IsTag: true}}, refs)
+
+	fsys, err := g.FS("HEAD")
+	require.NoError(t, err)
+	require.NoError(t, fstest.TestFS(fsys, "readme.md", "new.txt", "docs/guide.txt", "docs/deep/a.txt"))
+
+	data, err := fs.ReadFile(fsys, "readme.md")
+	require.NoError(t, err)
+	assert.Equal(t, "# readme v2", string(data))
+
+	t.Run("last commits", func(t *testing.T) {
+		info, err := fs.Stat(fsys, "readme.md")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("# readme v2")), info.Size())
+		commit, ok := info.Sys().(*CommitInfo)
+		require.True(t, ok)
+		assert.Equal(t, second.String(), commit.Hash)
+		assert.Equal(t, "update readme", commit.Message)
+		assert.Equal(t, "Test Author", commit.Author)
+		assert.Equal(t, second.String()[:7], commit.ShortHash())
+		assert.True(t, info.ModTime().Equal(time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)))
+
+		entries, err := fs.ReadDir(fsys, "docs")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		for _, e := range entries {
+			info, err := e.Info()
+			require.NoError(t, err)
+			assert.Equal(t, first.String(), info.Sys().(*CommitInfo).Hash, e.Name())
+			assert.Equal(t, "initial commit", info.Sys().(*CommitInfo).Message, "first line only")
+		}
+	})
+
+	t.Run("other refs", func(t *testing.T) {
+		for _, ref := range []string{"v1", "feature", first.String()} {
+			fsys, err := g.FS(ref)
+			require.NoError(t, err, ref)
+			data, err := fs.ReadFile(fsys, "readme.md")
+			require.NoError(t, err, ref)
+			assert.Equal(t, "# readme v1", string(data), ref)
+			_, err = fs.Stat(fsys, "new.txt")
+			require.ErrorIs(t, err, fs.ErrNotExist, ref)
+		}
+		_, err := g.FS("missing")
+		require.Error(t, err)
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		f, err := fsys.Open("docs/guide.txt")
+		require.NoError(t, err)
+		defer f.Close()
+		_, err = f.(io.Seeker).Seek(2, io.SeekStart)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "ide", string(data))
+	})
+
+	_, err = OpenGitRepo(dir, "missing")
+	require.Error(t, err)
+	_, err = OpenGitRepo(t.TempDir(), "")
+	require.Error(t, err)
+}
+
+func TestGitFile(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	small := strings.Repeat("0123456789", 100)
+	large := strings.Repeat("0123456789", gitMaxBuffer/10+100)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "small.txt"), []byte(small), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "large.txt"), []byte(large), 0o600))
+	_, err = wt.Add(".")
+	require.NoError(t, err)
+	sig := &object.Signature{Name: "Test Author", Email: "test@example.com", When: time.Now()}
+	_, err = wt.Commit("files", &git.CommitOptions{Author: sig, Committer: sig})
+	require.NoError(t, err)
+
+	g, err := OpenGitRepo(dir, "")
+	require.NoError(t, err)
+	fsys, err := g.FS("HEAD")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name, content string
+		buffered      bool
+	}{{"small.txt", small, true}, {"large.txt", large, false}} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := fsys.Open(tt.name)
+			require.NoError(t, err)
+			defer f.Close()
+			gf := f.(*gitFile)
+
+			// size lookup and forward reads are streamed
+			end, err := gf.Seek(0, io.SeekEnd)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tt.content)), end)
+			_, err = gf.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(gf, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "01234", string(buf))
+			_, err = gf.Seek(int64(len(tt.content))-3, io.SeekStart)
+			require.NoError(t, err)
+			data, err := io.ReadAll(gf)
+			require.NoError(t, err)
+			assert.Equal(t, "789", string(data))
+			assert.Nil(t, gf.buf, "not buffered reading forward")
+
+			// seeking back buffers only small blobs
+			n, err := gf.ReadAt(buf, 13)
+			require.NoError(t, err)
+			assert.Equal(t, 5, n)
+			assert.Equal(t, "34567", string(buf))
+			assert.Equal(t, tt.buffered, gf.buf != nil)
+
+			n, err = gf.ReadAt(buf, int64(len(tt.content))-2)
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, "89", string(buf[:n]))
+
+			_, err = gf.Seek(-1, io.SeekStart)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestGitRepo_WebServer(t *testing.T) {
+	dir, _, second := setupGitRepo(t)
+	g, err := OpenGitRepo(dir, "master")
+	require.NoError(t, err)
+	fsys, err := g.FS(g.DefaultRef)
+	require.NoError(t, err)
+
+	srv := &Web{Config: Config{ListenAddr: ":0", Theme: "light", RootDir: "git:" + dir + "@master"}, FS: fsys, Git: g}
+	require.NoError(t, srv.initTemplates())
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	get := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `action="/ref"`)
+	assert.Contains(t, rr.Body.String(), `<option value="master" selected>master</option>`)
+	assert.Contains(t, rr.Body.String(), `tag: v1`)
+	assert.Contains(t, rr.Body.String(), second.String()[:7]+" Test Author: update readme", "commit in the date tooltip")
+
+	rr = get("/readme.md")
+	assert.Equal(t, "# readme v2", rr.Body.String())
+
+	rr = get("/api/list")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"commit":{"hash":"`+second.String())
+
+	t.Run("switch ref", func(t *testing.T) {
+		rr := get("/ref?name=v1&path=docs")
+		require.Equal(t, http.StatusSeeOther, rr.Code)
+		assert.Equal(t, "/?path=docs", rr.Header().Get("Location"))
+		cookies := rr.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, gitRefCookie, cookies[0].Name)
+		assert.Equal(t, "v1", cookies[0].Value)
+
+		rr = get("/readme.md", cookies[0])
+		assert.Equal(t, "# readme v1", rr.Body.String())
+		rr = get("/view/readme.md", cookies[0])
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "readme v1")
+		rr = get("/new.txt", cookies[0])
+		assert.Equal(t, http.StatusNotFound, rr.Code, "file added after the tag")
+		rr = get("/", cookies[0])
+		assert.Contains(t, rr.Body.String(), `<option value="v1" selected>tag: v1</option>`)
+
+		// the directory is missing in the ref, so the root is shown
+		rr = get("/ref?name=master&path=missing")
+		assert.Equal(t, "/?path=.", rr.Header().Get("Location"))
+
+		rr = get("/ref?name=unknown")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		// a ref which can't be resolved anymore falls back to the default one
+		rr = get("/readme.md", &http.Cookie{Name: gitRefCookie, Value: "deleted"})
+		assert.Equal(t, "# readme v2", rr.Body.String())
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		srv.EnableUpload, srv.UploadMaxSize = true, 1<<20
+		defer func() { srv.EnableUpload = false }()
+		rr := httptest.NewRecorder()
+		srv.handleUpload(rr, createMultipartRequest(t, map[string]string{"x.txt": "x"}, map[string]string{"path": "."}))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"archive/zip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -30,6 +31,7 @@ func (wb *Web) handleRoot(w http.ResponseWriter, r *http.Request) {
 
 // handleDirContents renders partial directory contents for HTMX requests
 func (wb *Web) handleDirContents(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
 	// redirect non-HTMX requests to main page for proper full-page rendering
 	if !wb.isHTMXRequest(r) {
 		// same origin: everything after the literal ? is query, so the target is always "/"
@@ -54,6 +56,9 @@ func (wb *Web) handleDirContents(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "access denied to requested path", http.StatusForbidden)
 		return
 	}
+	if !wb.requireMountAuth(w, r, path) {
+		return
+	}
 
 	// check if the path exists and is a directory
 	fileInfo, err := fs.Stat(wb.FS, path)
@@ -67,7 +72,10 @@ func (wb *Web) handleDirContents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// use cached template
+	// the listing is not regenerated if the client has the current one
+	if wb.listingNotModified(w, r, path, sortBy, sortDir) {
+		return
+	}
 
 	// get the directory file list
 	fileList, err := wb.getFileList(path, sortBy, sortDir)
@@ -84,10 +92,12 @@ func (wb *Web) handleDirContents(w http.ResponseWriter, r *http.Request) {
 
 	// prepare data with struct directly in this function
 	isAuthenticated := false
-	if wb.Auth != "" {
-		isAuthenticated = wb.isAuthenticatedByCookie(r)
+	if wb.authEnabled() || wb.hasMountAuth() {
+		isAuthenticated = wb.isAuthenticatedByCookie(r) || wb.hasMountSession(r)
 	}
 
+	upload := wb.uploadSettingsFor(path)
+	gitRefs, gitRef := wb.gitRefs(r)
 	data := struct {
 		Files             []FileInfo
 		Path              string
@@ -103,7 +113,12 @@ func (wb *Web) handleDirContents(w http.ResponseWriter, r *http.Request) {
 		CustomFooter      string
 		EnableMultiSelect bool
 		EnableUpload      bool
+		UploadAvailable   bool
 		UploadMaxSize     int64
+		GitRefs           []GitRef
+		GitRef            string
+		User              string
+		SessionsPage      bool
 	}{
 		Files:             fileList,
 		Path:              path,
@@ -118,8 +133,13 @@ func (wb *Web) handleDirContents(w http.ResponseWriter, r *http.Request) {
 		IsAuthenticated:   isAuthenticated,
 		CustomFooter:      wb.CustomFooter,
 		EnableMultiSelect: wb.EnableMultiSelect,
-		EnableUpload:      wb.EnableUpload,
-		UploadMaxSize:     wb.UploadMaxSize,
+		EnableUpload:      upload.enabled,
+		UploadAvailable:   wb.uploadAvailable(),
+		UploadMaxSize:     upload.maxSize,
+		GitRefs:           gitRefs,
+		GitRef:            gitRef,
+		User:              wb.identifiedUser(r),
+		SessionsPage:      wb.Sessions != nil && wb.isAuthenticatedByCookie(r),
 	}
 
 	// execute just the page-content template
@@ -135,12 +155,63 @@ type viewFileData struct {
 	Theme      string
 	IsHTML     bool
 	IsMarkdown bool
-	IsCSV      bool
+	IsNotebook bool
+	IsData     bool       // content is a rendered tree or table of structured data
+	DataFormat string     // name of the structured data format, empty for other files
+	Mode       string     // view mode of structured data, e.g. tree or raw
+	Modes      []viewMode // view modes of the structured data format
+	DataError  *dataError // error in structured data shown as text
+}
+
+// Size returns the size of the rendered content, limiting the memory of cached views
+func (d viewFileData) Size() int { return len(d.Content) }
+
+// viewData reads and renders the text file for the view. rendered views are cached by git ref, path, mtime,
+// size, theme and view mode, as rendering markdown and highlighting code is expensive.
+func (wb *Web) viewData(r *http.Request, file fs.File, filePath string, info fs.FileInfo, ctInfo ContentTypeInfo,
+	theme string) (viewFileData, error) {
+	mode := dataMode(ctInfo.DataFormat, r.URL.Query().Get("mode"))
+	render := func() (viewFileData, error) {
+		fileContent, err := io.ReadAll(file)
+		if err != nil {
+			return viewFileData{}, fmt.Errorf("read %s: %w", info.Name(), err)
+		}
+		data := viewFileData{
+			FileName:   info.Name(),
+			Content:    string(fileContent),
+			Theme:      theme,
+			IsHTML:     ctInfo.IsHTML,
+			IsMarkdown: ctInfo.IsMarkdown,
+			Mode:       mode,
+		}
+		wb.renderViewContent(&data, ctInfo, fileContent)
+		return data, nil
+	}
+	// fallback to direct rendering if cache not initialized (e.g., in tests)
+	if wb.viewCache == nil {
+		return render()
+	}
+	key := fmt.Sprintf("%s:%s:%d:%d:%s:%s", wb.selectedRef(r), filePath, info.ModTime().UnixNano(), info.Size(), theme, mode)
+	return wb.viewCache.Get(key, render)
 }
 
-// renderViewContent applies format-specific rendering (markdown, csv, syntax highlighting) to view data.
-// on rendering failure, falls back to plain text display.
+// renderViewContent applies format-specific rendering (markdown, sanitized HTML, notebooks, structured data,
+// syntax highlighting) to view data. on rendering failure, falls back to plain text display.
 func (wb *Web) renderViewContent(data *viewFileData, ctInfo ContentTypeInfo, rawContent []byte) {
+	if ctInfo.DataFormat != "" {
+		data.DataFormat, data.Modes = strings.ToUpper(ctInfo.DataFormat), dataModes[ctInfo.DataFormat]
+		if data.Mode != "raw" {
+			err := wb.renderDataView(data, ctInfo.DataFormat, rawContent)
+			if err == nil {
+				return
+			}
+			// invalid data is shown as text with the error pointing at it
+			if !errors.As(err, &data.DataError) {
+				data.DataError = &dataError{Message: err.Error()}
+			}
+		}
+	}
+
 	switch {
 	case ctInfo.IsMarkdown:
 		rendered, err := wb.renderMarkdown(data.Content, data.Theme)
@@ -150,14 +221,16 @@ func (wb *Web) renderViewContent(data *viewFileData, ctInfo ContentTypeInfo, raw
 		} else {
 			data.Content = rendered
 		}
-	case ctInfo.IsCSV:
-		rendered, err := wb.renderCSV(data.Content)
+	case ctInfo.IsHTML:
+		data.Content = htmlPolicy.Sanitize(data.Content)
+	case ctInfo.IsNotebook:
+		rendered, err := wb.renderNotebook(data.Content, data.Theme)
 		if err != nil {
-			log.Printf("[WARN] failed to render csv: %v", err)
+			log.Printf("[WARN] failed to render notebook: %v", err)
 			// fall through to plain text
 		} else {
 			data.Content = rendered
-			data.IsCSV = true
+			data.IsNotebook = true
 		}
 	case !ctInfo.IsHTML && wb.EnableSyntaxHighlighting:
 		highlighted, err := wb.highlightCode(string(rawContent), data.FileName, data.Theme)
@@ -171,17 +244,25 @@ func (wb *Web) renderViewContent(data *viewFileData, ctInfo ContentTypeInfo, raw
 
 // handleViewFile serves a file view for text files with optional syntax highlighting and markdown rendering
 func (wb *Web) handleViewFile(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
 	// extract the file path from the URL
 	filePath := strings.TrimPrefix(r.URL.Path, "/view/")
 
 	// clean the path to avoid directory traversal
 	filePath = filepath.ToSlash(filepath.Clean(filePath))
 
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	w = sw
+	defer func() { wb.auditHTTP(r, AuditRecord{Action: "view", Path: filePath}, sw) }()
+
 	// check if the path should be excluded
 	if wb.shouldExclude(filePath) {
 		http.Error(w, "access denied to requested file", http.StatusForbidden)
 		return
 	}
+	if !wb.requireMountAuth(w, r, filePath) {
+		return
+	}
 
 	// check if the file exists and is not a directory
 	fileInfo, err := fs.Stat(wb.FS, filePath)
@@ -206,46 +287,88 @@ func (wb *Web) handleViewFile(w http.ResponseWriter, r *http.Request) {
 
 	// determine content type and file properties
 	ctInfo := DetermineContentType(filePath)
+	if ctInfo.IsHTML && wb.HTMLMode == "text" {
+		ctInfo.IsHTML = false // shown as code
+	}
+
+	// any file can be viewed as hex, e.g. to check the header of a binary
+	if r.URL.Query().Get("mode") == "hex" {
+		theme := r.URL.Query().Get("theme")
+		if theme == "" {
+			theme = wb.Theme
+		}
+		wb.renderHexView(w, r, file, filePath, fileInfo, theme)
+		return
+	}
 
 	// handle non-text files (images, PDFs, etc.)
 	if !ctInfo.IsText {
+		tw, done, ok := wb.throttleDownload(w, r)
+		if !ok {
+			return
+		}
+		defer done()
+		content, contentInfo, served := wb.precompressedContent(w, r, filePath, file, fileInfo)
+		defer served()
 		w.Header().Set("Content-Type", ctInfo.MIMEType)
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
-		http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file.(io.ReadSeeker))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", contentInfo.Size()))
+		w.Header().Set("ETag", fileETag(contentInfo, w.Header().Get("Content-Encoding")))
+		http.ServeContent(tw, r, fileInfo.Name(), fileInfo.ModTime(), content)
+		wb.Metrics.addDownloadBytes("view", sw.written)
 		return
 	}
 
 	// from here, we're only handling text files
 
-	// read file content
-	fileContent, err := io.ReadAll(file)
-	if err != nil {
-		http.Error(w, "error reading file", http.StatusInternalServerError)
-		return
-	}
-
-	// use template for viewing
-	w.Header().Set("Content-Type", "text/html")
-
 	// determine theme from query param, fall back to server default
 	theme := r.URL.Query().Get("theme")
 	if theme == "" {
 		theme = wb.Theme
 	}
 
-	// parse templates
+	// the view is not rendered again if the client has the current one
+	if notModified(w, r, wb.etag(r, fileETag(fileInfo, "")), fileInfo.ModTime()) {
+		return
+	}
 
-	// prepare data for the template
-	data := viewFileData{
-		FileName:   fileInfo.Name(),
-		Content:    string(fileContent),
-		Theme:      theme,
-		IsHTML:     ctInfo.IsHTML,
-		IsMarkdown: ctInfo.IsMarkdown,
+	// HTML is served as it is in sandbox mode, its scripts run in an origin of their own
+	if ctInfo.IsHTML && wb.HTMLMode == "sandbox" {
+		rs, ok := file.(io.ReadSeeker)
+		if !ok {
+			http.Error(w, "error reading file", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Security-Policy", htmlSandboxPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Type", ctInfo.MIMEType)
+		http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), rs)
+		return
 	}
 
-	// render content based on type
-	wb.renderViewContent(&data, ctInfo, fileContent)
+	// delimited files are shown as tables, or as text if they can't be parsed
+	if ctInfo.IsCSV && fileInfo.Size() <= maxCSVSize && wb.renderCSVView(w, r, filePath, fileInfo, theme) {
+		return
+	}
+
+	// large files are viewed in windows of lines, they are not read whole. notebooks are larger with
+	// their images, they are rendered up to a limit of their own.
+	if fileInfo.Size() > pagedViewSize && (!ctInfo.IsNotebook || fileInfo.Size() > maxNotebookSize) {
+		wb.renderPagedView(w, filePath, fileInfo, theme)
+		return
+	}
+
+	data, err := wb.viewData(r, file, filePath, fileInfo, ctInfo, theme)
+	if err != nil {
+		http.Error(w, "error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	// use template for viewing
+	w.Header().Set("Content-Type", "text/html")
+	if data.IsHTML || data.Mode == "image" {
+		// sanitized HTML and SVG images have no scripts, the added policy blocks any the sanitizer missed
+		w.Header().Add("Content-Security-Policy", "script-src 'none'")
+	}
 
 	// execute the file-view template
 	if err := wb.templates.fileTemplate.ExecuteTemplate(w, "file-view", data); err != nil {
@@ -256,6 +379,7 @@ func (wb *Web) handleViewFile(w http.ResponseWriter, r *http.Request) {
 
 // handleDownload serves file downloads
 func (wb *Web) handleDownload(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
 	// extract the file path from the URL
 	filePath := strings.TrimPrefix(r.URL.Path, "/")
 
@@ -269,15 +393,27 @@ func (wb *Web) handleDownload(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("[DEBUG] download request for: %s", filePath)
 
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	w = sw
+	defer func() { wb.auditHTTP(r, AuditRecord{Action: "download", Path: filePath}, sw) }()
+
 	// check if the file should be excluded
 	if wb.shouldExclude(filePath) {
 		http.Error(w, "access denied to requested file", http.StatusForbidden)
 		return
 	}
+	if !wb.requireMountAuth(w, r, filePath) {
+		return
+	}
 
 	// check if the file exists and is not a directory
 	fileInfo, err := fs.Stat(wb.FS, filePath)
 	if err != nil {
+		// a checksums manifest is generated for directories without a real one
+		if errors.Is(err, fs.ErrNotExist) && filepath.Base(filePath) == checksumsFile {
+			wb.serveChecksums(w, r, filepath.ToSlash(filepath.Dir(filePath)))
+			return
+		}
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
@@ -300,17 +436,37 @@ func (wb *Web) handleDownload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer func() { _ = file.Close() }()
 
+	tw, done, ok := wb.throttleDownload(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	// a byte range selected in the hex view is downloaded as a file of its own
+	if r.URL.Query().Has("from") || r.URL.Query().Has("to") {
+		serveByteRange(tw, r, file, fileInfo)
+		wb.Metrics.addDownloadBytes("download", sw.written)
+		return
+	}
+
+	// a precompressed sibling is sent instead of the file if the client accepts its encoding
+	content, contentInfo, served := wb.precompressedContent(w, r, filePath, file, fileInfo)
+	defer served()
+
 	// force all files to download instead of being displayed in browser
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileInfo.Name()))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", contentInfo.Size()))
+	w.Header().Set("ETag", fileETag(contentInfo, w.Header().Get("Content-Encoding")))
 
 	// copy the file to the response - directly use file as ReadSeeker
-	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file.(io.ReadSeeker))
+	http.ServeContent(tw, r, fileInfo.Name(), fileInfo.ModTime(), content)
+	wb.Metrics.addDownloadBytes("download", sw.written)
 }
 
 // handleFileModal renders the modal with embedded file content
 func (wb *Web) handleFileModal(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
 	// get file path from query parameter
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -326,6 +482,9 @@ func (wb *Web) handleFileModal(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("access denied: %s", filepath.Base(path)), http.StatusForbidden)
 		return
 	}
+	if !wb.requireMountAuth(w, r, path) {
+		return
+	}
 
 	// check if the file exists and is not a directory
 	fileInfo, err := fs.Stat(wb.FS, path)
@@ -360,7 +519,9 @@ func (wb *Web) handleFileModal(w http.ResponseWriter, r *http.Request) {
 		IsPDF       bool
 		IsText      bool
 		IsHTML      bool
+		IsHex       bool
 		Theme       string
+		HTMLSandbox string // sandbox of the HTML preview frame, scripts run only in an origin of their own
 	}{
 		FileName:    fileInfo.Name(),
 		FilePath:    path,
@@ -369,9 +530,18 @@ func (wb *Web) handleFileModal(w http.ResponseWriter, r *http.Request) {
 		IsImage:     ctInfo.IsImage,
 		IsPDF:       ctInfo.IsPDF,
 		IsText:      ctInfo.IsText,
-		IsHTML:      ctInfo.IsHTML,
+		IsHTML:      ctInfo.IsHTML && wb.HTMLMode != "text",
 		Theme:       wb.Theme,
+		HTMLSandbox: "allow-same-origin allow-popups",
 	}
+	if wb.HTMLMode == "sandbox" {
+		data.HTMLSandbox = "allow-scripts allow-forms allow-popups"
+	}
+
+	// binary files are shown as hex, as well as any file on request
+	fi := FileInfo{Name: fileInfo.Name(), Path: path, LastModified: fileInfo.ModTime()}
+	wb.detectBinary(&fi)
+	data.IsHex = r.URL.Query().Get("mode") == "hex" || fi.isBinary || !(ctInfo.IsImage || ctInfo.IsPDF || ctInfo.IsText)
 
 	// parse templates
 
@@ -451,6 +621,7 @@ func (wb *Web) handleSelectionStatus(w http.ResponseWriter, r *http.Request) {
 
 // handleDownloadSelected creates a zip file of selected files and sends it to the client
 func (wb *Web) handleDownloadSelected(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
@@ -463,17 +634,33 @@ func (wb *Web) handleDownloadSelected(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	w = sw
+
+	tw, done, ok := wb.throttleDownload(w, r)
+	if !ok {
+		wb.auditHTTP(r, AuditRecord{Action: "download_selected", Files: selectedFiles}, sw)
+		return
+	}
+	defer done()
+
 	// set up response headers for the ZIP file
 	timestamp := time.Now().Format("20060102-150405")
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="weblist-files-%s.zip"`, timestamp))
 
 	// create the ZIP file directly on the response writer
-	zipWriter := zip.NewWriter(w)
+	started := time.Now()
+	zipWriter := zip.NewWriter(tw)
 	defer func() {
-		if err := zipWriter.Close(); err != nil {
+		err := zipWriter.Close()
+		if err != nil {
 			log.Printf("[WARN] failed to finalize zip: %v", err)
+			sw.status = http.StatusInternalServerError // headers are gone already, for metrics and audit only
 		}
+		wb.Metrics.observeZip(started, err)
+		wb.Metrics.addDownloadBytes("zip", sw.written)
+		wb.auditHTTP(r, AuditRecord{Action: "download_selected", Files: selectedFiles}, sw)
 	}()
 
 	// process each selected file
@@ -486,6 +673,10 @@ func (wb *Web) handleDownloadSelected(w http.ResponseWriter, r *http.Request) {
 			log.Printf("[WARN] skipping excluded file in ZIP: %s", filePath)
 			continue
 		}
+		if !wb.mountAllowed(w, r, filePath) {
+			log.Printf("[WARN] skipping file of a mount not allowed for the request in ZIP: %s", filePath)
+			continue
+		}
 
 		// check if the file exists
 		fileInfo, err := fs.Stat(wb.FS, filePath)
@@ -649,21 +840,26 @@ func (wb *Web) parseSortParams(sortParam string) (sortBy, sortDir string) {
 
 // fileResponse represents a file entry for JSON response
 type fileResponse struct {
-	Name         string    `json:"name"`
-	Path         string    `json:"path"`
-	IsDir        bool      `json:"is_dir"`
-	Size         int64     `json:"size"`
-	SizeHuman    string    `json:"size_human,omitempty"`
-	LastModified time.Time `json:"last_modified"`
-	TimeStr      string    `json:"time_str,omitempty"`
-	IsViewable   bool      `json:"is_viewable,omitempty"`
+	Name         string      `json:"name"`
+	Path         string      `json:"path"`
+	IsDir        bool        `json:"is_dir"`
+	Size         int64       `json:"size"`
+	SizeHuman    string      `json:"size_human,omitempty"`
+	LastModified time.Time   `json:"last_modified"`
+	TimeStr      string      `json:"time_str,omitempty"`
+	IsViewable   bool        `json:"is_viewable,omitempty"`
+	TotalSize    int64       `json:"total_size,omitempty"`
+	Commit       *CommitInfo `json:"commit,omitempty"`
+	Checksum     string      `json:"checksum,omitempty"`
 }
 
 // handleAPIList handles API requests for listing files with JSON response
 // It supports query parameters:
 // - path: the directory path to list (defaults to root if not provided)
 // - sort: sort criteria with direction prefix (e.g., +name, -size, +mtime)
+// - checksum: checksum algorithm of files to include, sha256, sha512, md5 or blake3 (omitted if not set)
 func (wb *Web) handleAPIList(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
 	// get path from query parameter, default to root directory
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -677,6 +873,9 @@ func (wb *Web) handleAPIList(w http.ResponseWriter, r *http.Request) {
 		wb.writeJSONError(w, http.StatusForbidden, "access denied to requested path")
 		return
 	}
+	if !wb.requireMountAuth(w, r, path) {
+		return
+	}
 
 	// check if the path exists and is a directory
 	fileInfo, err := fs.Stat(wb.FS, path)
@@ -694,6 +893,19 @@ func (wb *Web) handleAPIList(w http.ResponseWriter, r *http.Request) {
 	sortParam := r.URL.Query().Get("sort")
 	sortBy, sortDir := wb.parseSortParams(sortParam)
 
+	// checksums of files are computed only on request, as it reads the files
+	algo := strings.ToLower(r.URL.Query().Get("checksum"))
+	if _, ok := checksumAlgos[algo]; algo != "" && !ok {
+		wb.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown checksum algorithm %q, supported: %s",
+			algo, strings.Join(checksumAlgoNames(), ", ")))
+		return
+	}
+
+	// the listing is not regenerated if the client has the current one
+	if wb.listingNotModified(w, r, path, sortBy, sortDir) {
+		return
+	}
+
 	// get the file list
 	fileList, err := wb.getFileList(path, sortBy, sortDir)
 	if err != nil {
@@ -710,6 +922,12 @@ func (wb *Web) handleAPIList(w http.ResponseWriter, r *http.Request) {
 	// prepare file list for JSON response
 	files := make([]fileResponse, 0, len(fileList))
 	for _, f := range fileList {
+		var checksum string
+		if algo != "" && !f.IsDir {
+			if checksum, err = wb.checksum(f.Path, algo, f.version); err != nil {
+				log.Printf("[WARN] failed to compute %s checksum of %s: %v", algo, f.Path, err)
+			}
+		}
 		files = append(files, fileResponse{
 			Name:         f.Name,
 			Path:         f.Path,
@@ -719,6 +937,9 @@ func (wb *Web) handleAPIList(w http.ResponseWriter, r *http.Request) {
 			LastModified: f.LastModified,
 			TimeStr:      f.TimeString(),
 			IsViewable:   f.IsViewable(),
+			TotalSize:    f.TotalSize,
+			Commit:       f.Commit,
+			Checksum:     checksum,
 		})
 	}
 
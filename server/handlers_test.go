@@ -697,8 +697,9 @@ func TestHTMLRendering(t *testing.T) {
 		body := rr.Body.String()
 		// check that we're using the iframe for HTML files
 		assert.Contains(t, body, `<iframe src="/view/test.html?theme=light"`)
-		// check that the sandbox attribute is set correctly
-		assert.Contains(t, body, `sandbox="allow-same-origin allow-scripts allow-forms"`)
+		// sanitized html is shown without scripts, the iframe gets no script permissions
+		assert.Contains(t, body, `sandbox="allow-same-origin allow-popups"`)
+		assert.NotContains(t, body, "allow-scripts")
 	})
 }
 
@@ -1001,6 +1002,8 @@ func TestHandleSelectionStatus(t *testing.T) {
 		require.Equal(t, http.StatusOK, rr.Code)
 		require.Contains(t, rr.Body.String(), "Download Selected")
 		require.Contains(t, rr.Body.String(), "2 files selected")
+		assert.Contains(t, rr.Body.String(), `<form action="/diff" method="get" target="_blank">`, "two files can be compared")
+		assert.Contains(t, rr.Body.String(), `name="b" value="file2.txt"`)
 		assert.Empty(t, rr.Header().Get("HX-Trigger"))
 	})
 
@@ -1204,10 +1207,11 @@ func TestHandleViewFile_CSV(t *testing.T) {
 	assert.Equal(t, "text/html", rr.Header().Get("Content-Type"))
 
 	body := rr.Body.String()
-	assert.Contains(t, body, `<div class="csv-content"><table>`)
-	assert.Contains(t, body, "<th>Name</th><th>Age</th><th>City</th>")
-	assert.Contains(t, body, "<td>Alice</td><td>30</td><td>New York</td>")
-	assert.Contains(t, body, "<td>Bob</td><td>25</td><td>London</td>")
+	assert.Contains(t, body, `<div id="csv-table" class="csv-content">`)
+	assert.Contains(t, body, `title="Sort by Name">Name</a><span class="csv-type">text</span></th>`)
+	assert.Contains(t, body, `<th class="num">`, "numeric column")
+	assert.Contains(t, body, `<td>Alice</td><td class="num">30</td><td>New York</td>`)
+	assert.Contains(t, body, `<td>Bob</td><td class="num">25</td><td>London</td>`)
 	assert.NotContains(t, body, "Name,Age,City") // raw csv should not appear
 }
 
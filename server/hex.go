@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	hexPageSize    = 4096  // bytes on a page of the hex view
+	hexRowSize     = 16    // bytes in a row of the hex view
+	magicProbeSize = 32774 // bytes read to detect the file type, up to the ISO 9660 signature
+)
+
+// magicSignatures are file types recognized by the bytes at an offset, checked in order
+var magicSignatures = []struct {
+	offset int
+	magic  []byte
+	name   string
+}{
+	{0, []byte("\x7fELF"), "ELF executable"},
+	{0, []byte("MZ"), "DOS/Windows executable"},
+	{0, []byte{0xfe, 0xed, 0xfa, 0xce}, "Mach-O executable, 32-bit"},
+	{0, []byte{0xce, 0xfa, 0xed, 0xfe}, "Mach-O executable, 32-bit"},
+	{0, []byte{0xfe, 0xed, 0xfa, 0xcf}, "Mach-O executable, 64-bit"},
+	{0, []byte{0xcf, 0xfa, 0xed, 0xfe}, "Mach-O executable, 64-bit"},
+	{0, []byte{0xca, 0xfe, 0xba, 0xbe}, "Mach-O universal binary or Java class"},
+	{0, []byte("\x00asm"), "WebAssembly module"},
+	{0, []byte{0x27, 0x05, 0x19, 0x56}, "U-Boot image"},
+	{0, []byte{0xd0, 0x0d, 0xfe, 0xed}, "Device tree blob"},
+	{0, []byte("ANDROID!"), "Android boot image"},
+	{0, []byte("UBI#"), "UBI image"},
+	{0, []byte("hsqs"), "SquashFS filesystem"},
+	{0, []byte{0x85, 0x19, 0x03, 0x20}, "JFFS2 filesystem"},
+	{0x24, []byte{0x18, 0x28, 0x6f, 0x01}, "Linux ARM zImage kernel"},
+	{0x202, []byte("HdrS"), "Linux x86 kernel"},
+	{1080, []byte{0x53, 0xef}, "ext2/3/4 filesystem"},
+	{0x8001, []byte("CD001"), "ISO 9660 image"},
+	{0, []byte("SQLite format 3\x00"), "SQLite database"},
+	{0, []byte{0xd4, 0xc3, 0xb2, 0xa1}, "pcap capture"},
+	{0, []byte{0xa1, 0xb2, 0xc3, 0xd4}, "pcap capture"},
+	{0, []byte{0x0a, 0x0d, 0x0d, 0x0a}, "pcapng capture"},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "PNG image"},
+	{0, []byte{0xff, 0xd8, 0xff}, "JPEG image"},
+	{0, []byte("GIF8"), "GIF image"},
+	{0, []byte("%PDF-"), "PDF document"},
+	{0, []byte("PK\x03\x04"), "ZIP archive"},
+	{0, []byte{0x1f, 0x8b}, "gzip compressed data"},
+	{0, []byte("BZh"), "bzip2 compressed data"},
+	{0, []byte("\xfd7zXZ\x00"), "xz compressed data"},
+	{0, []byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd compressed data"},
+	{0, []byte{0x04, 0x22, 0x4d, 0x18}, "LZ4 compressed data"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "7-Zip archive"},
+	{0, []byte("Rar!\x1a\x07"), "RAR archive"},
+	{257, []byte("ustar"), "tar archive"},
+	{0, []byte("070701"), "cpio archive"},
+	{0, []byte("070707"), "cpio archive"},
+}
+
+// detectFileType names the type of the file by its magic number, falling back to the content type
+// sniffed by http.DetectContentType. header is the beginning of the file, up to magicProbeSize bytes.
+func detectFileType(header []byte) string {
+	for _, sig := range magicSignatures {
+		if len(header) >= sig.offset+len(sig.magic) && bytes.Equal(header[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.name
+		}
+	}
+	if len(header) == 0 {
+		return "empty"
+	}
+	return http.DetectContentType(header)
+}
+
+// hexRow is a row of the hex view
+type hexRow struct {
+	Offset int64     // offset of the first byte
+	Bytes  []hexByte // up to hexRowSize bytes
+	Pad    string    // spaces in place of missing bytes of the last row
+	Text   string    // printable ASCII of the bytes, others shown as dots
+}
+
+// hexByte is a byte of the hex view
+type hexByte struct {
+	Offset int64
+	Hex    string
+	Sep    string // space after the byte, wider in the middle of the row
+}
+
+// hexViewData is a page of the hex view of a file
+type hexViewData struct {
+	FileName    string
+	FilePath    string
+	Theme       string
+	Size        int64
+	SizeText    string
+	FileType    string
+	Offset      int64 // offset of the page
+	End         int64 // offset after the last byte of the page
+	Prev        int64 // offset of the previous page, negative if there is none
+	Next        int64 // offset of the next page, negative if there is none
+	Last        int64 // offset of the last page
+	OffsetWidth int   // hex digits in offsets
+	LastByte    int64 // offset of the last byte of the page, the end of the range downloaded by default
+	Rows        []hexRow
+}
+
+// parseOffset parses a byte offset, decimal or hex with 0x prefix
+func parseOffset(s string) (int64, error) {
+	v, err := strconv.ParseInt(strings.TrimSpace(s), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q: %w", s, err)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("negative offset %d", v)
+	}
+	return v, nil
+}
+
+// hexView reads the page of the file at the offset, aligned to a row and limited to the last page
+func hexView(rs io.ReadSeeker, info fs.FileInfo, offset int64) (hexViewData, error) {
+	size := info.Size()
+	last := max(0, (size-1)/hexPageSize*hexPageSize)
+	offset = min(offset/hexRowSize*hexRowSize, last)
+	data := hexViewData{FileName: info.Name(), Size: size, SizeText: FileInfo{Size: size}.SizeToString(),
+		Offset: offset, Prev: -1, Next: -1, Last: last, OffsetWidth: max(8, len(strconv.FormatInt(size, 16)))}
+	if offset > 0 {
+		data.Prev = max(0, offset-hexPageSize)
+	}
+	if offset+hexPageSize < size {
+		data.Next = offset + hexPageSize
+	}
+
+	header := make([]byte, magicProbeSize)
+	n, err := io.ReadFull(rs, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return hexViewData{}, fmt.Errorf("read header: %w", err)
+	}
+	data.FileType = detectFileType(header[:n])
+
+	if _, err = rs.Seek(offset, io.SeekStart); err != nil {
+		return hexViewData{}, fmt.Errorf("seek to %d: %w", offset, err)
+	}
+	page := make([]byte, hexPageSize)
+	n, err = io.ReadFull(rs, page)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return hexViewData{}, fmt.Errorf("read page: %w", err)
+	}
+	page = page[:n]
+	data.End, data.LastByte = offset+int64(n), max(offset, offset+int64(n)-1)
+
+	const rowWidth = hexRowSize*3 + 1 // two digits and a space per byte, one more space in the middle
+	for i := 0; i < len(page); i += hexRowSize {
+		chunk := page[i:min(i+hexRowSize, len(page))]
+		row := hexRow{Offset: offset + int64(i), Bytes: make([]hexByte, len(chunk))}
+		text, width := make([]byte, len(chunk)), 0
+		for j, b := range chunk {
+			row.Bytes[j] = hexByte{Offset: row.Offset + int64(j), Hex: fmt.Sprintf("%02x", b), Sep: " "}
+			if j == hexRowSize/2-1 {
+				row.Bytes[j].Sep = "  "
+			}
+			width += 2 + len(row.Bytes[j].Sep)
+			text[j] = '.'
+			if b >= 0x20 && b < 0x7f {
+				text[j] = b
+			}
+		}
+		row.Pad, row.Text = strings.Repeat(" ", rowWidth-width), string(text)
+		data.Rows = append(data.Rows, row)
+	}
+	return data, nil
+}
+
+// renderHexView renders a page of the hex view of the file, at the offset query parameter
+func (wb *Web) renderHexView(w http.ResponseWriter, r *http.Request, file fs.File, filePath string, info fs.FileInfo,
+	theme string) {
+	rs, ok := file.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "file can't be viewed as hex", http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, wb.etag(r, fileETag(info, "")), info.ModTime()) {
+		return
+	}
+	var offset int64
+	if v := r.URL.Query().Get("offset"); v != "" {
+		var err error
+		if offset, err = parseOffset(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	data, err := hexView(rs, info, offset)
+	if err != nil {
+		log.Printf("[WARN] failed to read %s for hex view: %v", filePath, err)
+		http.Error(w, "error reading file", http.StatusInternalServerError)
+		return
+	}
+	data.FilePath, data.Theme = filePath, theme
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := wb.templates.fileTemplate.ExecuteTemplate(w, "file-hex", data); err != nil {
+		log.Printf("[ERROR] failed to execute file-hex template: %v", err)
+		http.Error(w, "error rendering file view", http.StatusInternalServerError)
+	}
+}
+
+// serveByteRange sends the bytes of the file between the from and to query parameters, inclusive,
+// as a download of its own. to defaults to the end of the file and is limited to it.
+func serveByteRange(w http.ResponseWriter, r *http.Request, file fs.File, info fs.FileInfo) {
+	rs, ok := file.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "file can't be read in parts", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	from, err := parseOffset(query.Get("from"))
+	if query.Get("from") == "" {
+		from, err = 0, nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to := info.Size() - 1
+	if v := query.Get("to"); v != "" {
+		if to, err = parseOffset(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to = min(to, info.Size()-1)
+	}
+	if from > to {
+		http.Error(w, fmt.Sprintf("invalid byte range %d-%d of %d bytes", from, to, info.Size()), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if _, err = rs.Seek(from, io.SeekStart); err != nil {
+		http.Error(w, "error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s.%x-%x", info.Name(), from, to)))
+	w.Header().Set("Content-Length", strconv.FormatInt(to-from+1, 10))
+	if _, err = io.CopyN(w, rs, to-from+1); err != nil {
+		log.Printf("[DEBUG] failed to send bytes %d-%d of %s: %v", from, to, info.Name(), err)
+	}
+}
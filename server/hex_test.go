@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFileType(t *testing.T) {
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar")
+	iso := make([]byte, magicProbeSize)
+	copy(iso[0x8001:], "CD001")
+
+	tbl := []struct {
+		header []byte
+		want   string
+	}{
+		{[]byte("\x7fELF\x02\x01\x01"), "ELF executable"},
+		{[]byte{0x27, 0x05, 0x19, 0x56, 0, 0}, "U-Boot image"},
+		{[]byte("\x89PNG\r\n\x1a\n...."), "PNG image"},
+		{[]byte("PK\x03\x04rest"), "ZIP archive"},
+		{[]byte("SQLite format 3\x00"), "SQLite database"},
+		{tar, "tar archive"},
+		{iso, "ISO 9660 image"},
+		{[]byte("hello world"), "text/plain; charset=utf-8"},
+		{[]byte{0x01, 0x02, 0x03}, "application/octet-stream"},
+		{nil, "empty"},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.want, detectFileType(tt.header))
+	}
+}
+
+func TestHexView(t *testing.T) {
+	dir := t.TempDir()
+	firmware := bytes.Repeat([]byte{0xab}, 10001)
+	copy(firmware, "\x7fELF")
+	copy(firmware[4096:], "Hello<>")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "firmware.bin"), firmware, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("plain text"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.bin"), []byte("secret"), 0o600))
+
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Exclude: []string{"secret.bin"}}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	rr := get("/view/firmware.bin?mode=hex")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "ELF executable")
+	assert.Contains(t, body, `<span class="hex-offset">00000000</span>  <span data-o="0">7f</span> <span data-o="1">45</span>`)
+	assert.Contains(t, body, `<span class="hex-text">.ELF............</span>`)
+	assert.Contains(t, body, `<span data-o="7">ab</span>  <span data-o="8">ab</span>`, "wider gap in the middle of a row")
+	assert.NotContains(t, body, `data-o="4096"`, "one page only")
+	assert.Contains(t, body, "offset=4096")
+	assert.Contains(t, body, `name="to" value="0xfff"`)
+
+	// offsets are aligned to a row, in hex or decimal
+	rr = get("/view/firmware.bin?mode=hex&offset=0x1005")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body = rr.Body.String()
+	assert.Contains(t, body, `<span class="hex-offset">00001000</span>`)
+	assert.Contains(t, body, `<span class="hex-text">Hello&lt;&gt;.........</span>`)
+	assert.Contains(t, body, "offset=0\"", "link to the first page")
+
+	// last page has a short row padded to the text column
+	rr = get("/view/firmware.bin?mode=hex&offset=999999")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body = rr.Body.String()
+	assert.Contains(t, body, `<span class="hex-offset">00002000</span>`)
+	assert.Contains(t, body, `<span data-o="10000">ab</span> `+strings.Repeat(" ", 15*3+1)+` <span class="hex-text">.</span>`)
+	assert.Contains(t, body, `name="to" value="0x2710"`)
+
+	assert.Equal(t, http.StatusBadRequest, get("/view/firmware.bin?mode=hex&offset=-5").Code)
+	assert.Equal(t, http.StatusForbidden, get("/view/secret.bin?mode=hex").Code)
+
+	// any file can be viewed as hex
+	rr = get("/view/notes.txt?mode=hex")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "text/plain; charset=utf-8")
+	assert.Contains(t, rr.Body.String(), `<span data-o="0">70</span>`)
+}
+
+func TestByteRangeDownload(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dump.bin"), []byte("0123456789abcdef"), 0o600))
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test"}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	rr := get("/dump.bin?from=0x2&to=5")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2345", rr.Body.String())
+	assert.Equal(t, "4", rr.Header().Get("Content-Length"))
+	assert.Equal(t, `attachment; filename="dump.bin.2-5"`, rr.Header().Get("Content-Disposition"))
+
+	rr = get("/dump.bin?from=10&to=1000")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "abcdef", rr.Body.String(), "limited to the end of the file")
+
+	rr = get("/dump.bin?to=1")
+	assert.Equal(t, "01", rr.Body.String())
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, get("/dump.bin?from=20").Code)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, get("/dump.bin?from=5&to=2").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/dump.bin?from=x").Code)
+}
+
+func TestFileModalHex(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.bin"), []byte{0, 1, 2, 3}, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fake.txt"), []byte{0, 1, 2, 3}, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("text"), 0o600))
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test"}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	modal := func(query string) string {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/partials/file-modal?"+query, http.NoBody))
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	assert.Contains(t, modal("path=data.bin"), `src="/view/data.bin?mode=hex`)
+	assert.Contains(t, modal("path=fake.txt"), `src="/view/fake.txt?mode=hex`, "binary content with text extension")
+	body := modal("path=notes.txt")
+	assert.NotContains(t, body, "mode=hex&")
+	assert.Contains(t, body, "View as hex")
+	assert.Contains(t, modal("path=notes.txt&mode=hex"), `src="/view/notes.txt?mode=hex`)
+
+	// listing links binary files to the hex view
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	assert.Contains(t, rr.Body.String(), `hx-vals='{"path": "data.bin", "mode": "hex"}'`)
+	assert.NotContains(t, rr.Body.String(), `hx-vals='{"path": "notes.txt", "mode": "hex"}'`)
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-pkgz/lcw/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics collects prometheus metrics for both the web and SFTP servers.
+// All methods are safe to call on a nil receiver, which makes metrics optional for callers.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests   *prometheus.CounterVec
+	httpDuration   *prometheus.HistogramVec
+	downloadBytes  *prometheus.CounterVec
+	zipArchives    *prometheus.CounterVec
+	zipDuration    prometheus.Histogram
+	uploads        *prometheus.CounterVec
+	sftpAuth       *prometheus.CounterVec
+	sftpAuthOK     *prometheus.CounterVec
+	sftpRateLimits prometheus.Counter
+	sftpSessions   prometheus.Gauge
+	authLockouts   *prometheus.CounterVec
+}
+
+// NewMetrics makes metrics with a private registry, including go runtime and process collectors
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weblist_http_requests_total",
+			Help: "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "weblist_http_request_duration_seconds",
+			Help:    "HTTP request duration by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		downloadBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weblist_download_bytes_total",
+			Help: "Bytes of file content sent to clients, by source (download, view, zip).",
+		}, []string{"source"}),
+		zipArchives: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weblist_zip_archives_total",
+			Help: "Number of ZIP archives built for multi-file downloads, by result.",
+		}, []string{"result"}),
+		zipDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "weblist_zip_build_duration_seconds",
+			Help:    "Time spent building and streaming ZIP archives.",
+			Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300},
+		}),
+		uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weblist_uploads_total",
+			Help: "Number of upload requests by outcome (success, too_large, conflict, rejected, error).",
+		}, []string{"result"}),
+		sftpAuth: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weblist_sftp_auth_attempts_total",
+			Help: "Number of SFTP authentication attempts by method.",
+		}, []string{"method"}),
+		sftpAuthOK: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weblist_sftp_auth_successes_total",
+			Help: "Number of successful SFTP authentications by method.",
+		}, []string{"method"}),
+		sftpRateLimits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "weblist_sftp_auth_rate_limited_total",
+			Help: "Number of SFTP authentication attempts rejected by the rate limiter.",
+		}),
+		sftpSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "weblist_sftp_active_sessions",
+			Help: "Number of active SFTP sessions.",
+		}),
+		authLockouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weblist_auth_lockouts_total",
+			Help: "Number of lockouts after repeated failed authentication, by kind (ip, user) and protocol.",
+		}, []string{"kind", "protocol"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.downloadBytes,
+		m.zipArchives, m.zipDuration, m.uploads,
+		m.sftpAuth, m.sftpAuthOK, m.sftpRateLimits, m.sftpSessions, m.authLockouts,
+	)
+	return m
+}
+
+// Handler returns the HTTP handler exposing collected metrics in prometheus format
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts requests and measures their duration. The route label comes from the matched
+// mux pattern rather than the raw path, keeping label cardinality bounded.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		m.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		m.httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(st).Seconds())
+	})
+}
+
+// watchBinaryCache exposes hit, miss and hit ratio stats of the binary detection cache
+func (m *Metrics) watchBinaryCache(cache lcw.LoadingCache[bool]) {
+	if m == nil || cache == nil {
+		return
+	}
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "weblist_binary_cache_hits_total",
+			Help: "Number of binary detection cache hits.",
+		}, func() float64 { return float64(cache.Stat().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "weblist_binary_cache_misses_total",
+			Help: "Number of binary detection cache misses.",
+		}, func() float64 { return float64(cache.Stat().Misses) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "weblist_binary_cache_hit_ratio",
+			Help: "Ratio of binary detection cache hits to all lookups.",
+		}, func() float64 {
+			stat := cache.Stat()
+			if stat.Hits+stat.Misses == 0 {
+				return 0
+			}
+			return float64(stat.Hits) / float64(stat.Hits+stat.Misses)
+		}),
+	)
+}
+
+// addDownloadBytes records bytes of file content sent from the given source
+func (m *Metrics) addDownloadBytes(source string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.downloadBytes.WithLabelValues(source).Add(float64(n))
+}
+
+// observeZip records a finished ZIP archive and the time spent building it
+func (m *Metrics) observeZip(started time.Time, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.zipArchives.WithLabelValues(result).Inc()
+	m.zipDuration.Observe(time.Since(started).Seconds())
+}
+
+// observeUpload records an upload request outcome derived from the response status code
+func (m *Metrics) observeUpload(status int) {
+	if m == nil {
+		return
+	}
+	var result string
+	switch {
+	case status < http.StatusBadRequest:
+		result = "success"
+	case status == http.StatusRequestEntityTooLarge:
+		result = "too_large"
+	case status == http.StatusConflict:
+		result = "conflict"
+	case status < http.StatusInternalServerError:
+		result = "rejected"
+	default:
+		result = "error"
+	}
+	m.uploads.WithLabelValues(result).Inc()
+}
+
+// sftpAuthAttempt records an SFTP authentication attempt and its success for the given method
+func (m *Metrics) sftpAuthAttempt(method string, ok bool) {
+	if m == nil {
+		return
+	}
+	m.sftpAuth.WithLabelValues(method).Inc()
+	if ok {
+		m.sftpAuthOK.WithLabelValues(method).Inc()
+	}
+}
+
+// sftpRateLimited records an SFTP authentication attempt rejected by the rate limiter
+func (m *Metrics) sftpRateLimited() {
+	if m == nil {
+		return
+	}
+	m.sftpRateLimits.Inc()
+}
+
+// authLockout records a lockout of an address or an account after repeated failed authentication
+func (m *Metrics) authLockout(kind, protocol string) {
+	if m == nil {
+		return
+	}
+	m.authLockouts.WithLabelValues(kind, protocol).Inc()
+}
+
+// sftpSessionStarted increments active SFTP sessions and returns a function decrementing it back
+func (m *Metrics) sftpSessionStarted() (done func()) {
+	if m == nil {
+		return func() {}
+	}
+	m.sftpSessions.Inc()
+	return m.sftpSessions.Dec
+}
+
+// statusWriter captures the response status code and the number of body bytes written
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// WriteHeader captures the status code before passing it on
+func (s *statusWriter) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts body bytes written
+func (s *statusWriter) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (flush, deadlines)
+func (s *statusWriter) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// Flush sends buffered data to the client, for wrappers checking http.Flusher rather than unwrapping
+func (s *statusWriter) Flush() {
+	_ = http.NewResponseController(s.ResponseWriter).Flush()
+}
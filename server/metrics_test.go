@@ -0,0 +1,193 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lcw/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrapeMetrics returns the metrics endpoint output
+func scrapeMetrics(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+	return rr.Body.String()
+}
+
+func TestMetrics_Router(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.Metrics = NewMetrics()
+	srv.EnableMultiSelect = true
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	// download a file, view an image-like file and build a zip
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/file1.txt", http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/non-existent.txt", http.NoBody))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	form := url.Values{"selected-files": {"file1.txt", "dir1"}}
+	req := httptest.NewRequest(http.MethodPost, "/download-selected", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+
+	assert.Contains(t, body, `weblist_http_requests_total{method="GET",route="GET /{path...}",status="200"} 1`)
+	assert.Contains(t, body, `weblist_http_requests_total{method="GET",route="GET /{path...}",status="404"} 1`)
+	assert.Contains(t, body, `weblist_download_bytes_total{source="download"} 13`, "size of file1.txt")
+	assert.Contains(t, body, `weblist_download_bytes_total{source="zip"}`)
+	assert.Contains(t, body, `weblist_zip_archives_total{result="success"} 1`)
+	assert.Contains(t, body, `weblist_zip_build_duration_seconds_count 1`)
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestMetrics_RouterWithoutMetrics(t *testing.T) {
+	srv := setupTestServer(t)
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "falls through to the download handler")
+}
+
+func TestMetrics_RouterAuth(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.Metrics = NewMetrics()
+	srv.Auth, srv.SessionSecret = "password", "session-secret"
+	access, err := NewAccessList(AccessOpts{Allow: []string{"api=10.0.0.0/8"}})
+	require.NoError(t, err)
+	srv.Access = access
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	get := func(addr string, basicAuth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody)
+		req.RemoteAddr = addr
+		if basicAuth {
+			req.SetBasicAuth("weblist", "password")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("10.0.0.1:1000", false)
+	assert.NotEqual(t, http.StatusOK, rr.Code, "login required")
+	assert.NotContains(t, rr.Body.String(), "weblist_http_requests_total")
+
+	rr = get("10.0.0.1:1000", true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "weblist_http_requests_total")
+
+	assert.Equal(t, http.StatusForbidden, get("192.168.1.1:1000", true).Code, "api rules apply")
+}
+
+func TestMetrics_SeparateListener(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.Metrics = NewMetrics()
+	srv.MetricsListen = ":9100"
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "metrics are not served on the main listener")
+}
+
+func TestMetrics_Upload(t *testing.T) {
+	tmpDir := t.TempDir()
+	srv := &Web{
+		Config:  Config{RootDir: tmpDir, EnableUpload: true, UploadMaxSize: 1024},
+		FS:      os.DirFS(tmpDir),
+		Metrics: NewMetrics(),
+	}
+
+	upload := func(name, content string) int {
+		rr := httptest.NewRecorder()
+		srv.handleUpload(rr, createMultipartRequest(t, map[string]string{name: content}, nil))
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, upload("a.txt", "hello"))
+	assert.Equal(t, http.StatusConflict, upload("a.txt", "hello"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload("b.txt", strings.Repeat("x", 2048)))
+	assert.Equal(t, http.StatusBadRequest, upload("..", "x"))
+
+	body := scrapeMetrics(t, srv.Metrics)
+	assert.Contains(t, body, `weblist_uploads_total{result="success"} 1`)
+	assert.Contains(t, body, `weblist_uploads_total{result="conflict"} 1`)
+	assert.Contains(t, body, `weblist_uploads_total{result="too_large"} 1`)
+	assert.Contains(t, body, `weblist_uploads_total{result="rejected"} 1`)
+}
+
+func TestMetrics_SFTPAndCache(t *testing.T) {
+	m := NewMetrics()
+	m.sftpAuthAttempt("password", false)
+	m.sftpAuthAttempt("password", true)
+	m.sftpAuthAttempt("publickey", true)
+	m.sftpRateLimited()
+	m.authLockout("ip", "sftp")
+	done := m.sftpSessionStarted()
+	m.sftpSessionStarted()
+
+	cache, err := lcw.NewLruCache(lcw.NewOpts[bool]().MaxKeys(10))
+	require.NoError(t, err)
+	m.watchBinaryCache(cache)
+	for range 3 {
+		_, err = cache.Get("key", func() (bool, error) { return true, nil })
+		require.NoError(t, err)
+	}
+
+	body := scrapeMetrics(t, m)
+	assert.Contains(t, body, `weblist_sftp_auth_attempts_total{method="password"} 2`)
+	assert.Contains(t, body, `weblist_sftp_auth_successes_total{method="password"} 1`)
+	assert.Contains(t, body, `weblist_sftp_auth_successes_total{method="publickey"} 1`)
+	assert.Contains(t, body, "weblist_sftp_auth_rate_limited_total 1")
+	assert.Contains(t, body, `weblist_auth_lockouts_total{kind="ip",protocol="sftp"} 1`)
+	assert.Contains(t, body, "weblist_sftp_active_sessions 2")
+	assert.Contains(t, body, "weblist_binary_cache_hits_total 2")
+	assert.Contains(t, body, "weblist_binary_cache_misses_total 1")
+	assert.Contains(t, body, "weblist_binary_cache_hit_ratio 0.6666666666666666")
+
+	done()
+	assert.Contains(t, scrapeMetrics(t, m), "weblist_sftp_active_sessions 1")
+}
+
+func TestMetrics_NilSafe(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.addDownloadBytes("download", 10)
+		m.observeZip(time.Now(), nil)
+		m.observeUpload(http.StatusOK)
+		m.sftpAuthAttempt("password", true)
+		m.sftpRateLimited()
+		m.authLockout("user", "http")
+		m.sftpSessionStarted()()
+		m.watchBinaryCache(nil)
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	rr := httptest.NewRecorder()
+	m.Middleware(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+}
@@ -0,0 +1,416 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Mount is a directory served as a top-level virtual directory next to other mounts.
+// With mounts configured, the server root lists mounts only and every path starts with a mount name.
+type Mount struct {
+	Name            string   // name of the top-level virtual directory
+	RootDir         string   // location of the mount, a local directory or a storage URL
+	FS              Storage  // storage locked to RootDir
+	Exclude         []string // patterns to exclude, relative to the mount
+	Auth            string   // password for this mount, required in addition to the global auth if set
+	Users           []string // users allowed to access this mount, anyone passing the global auth if empty
+	EnableUpload    bool     // enable file upload into this mount
+	UploadMaxSize   int64    // max upload size in bytes
+	UploadOverwrite bool     // allow overwriting existing files on upload
+}
+
+// findMount returns the mount holding the slash-separated path and the path relative to the mount root.
+// nil is returned for the virtual root and for paths outside any mount.
+func findMount(mounts []Mount, p string) (*Mount, string) {
+	p = path.Clean(strings.TrimPrefix(filepath.ToSlash(p), "/"))
+	name, rest, _ := strings.Cut(p, "/")
+	for i := range mounts {
+		if mounts[i].Name == name {
+			if rest == "" {
+				rest = "."
+			}
+			return &mounts[i], rest
+		}
+	}
+	return nil, ""
+}
+
+// mountExcluded checks the path against excludes of the mount holding it
+func mountExcluded(mounts []Mount, p string) bool {
+	m, rel := findMount(mounts, p)
+	return m != nil && rel != "." && matchesExcludes(rel, m.Exclude)
+}
+
+// mountFS presents mounts as top-level directories of a single read-only filesystem.
+// The root is virtual, everything below a mount name is served by the mount's filesystem.
+type mountFS struct {
+	mounts []Mount
+	start  time.Time // reported as the mtime of the virtual root
+}
+
+// newMountFS makes a filesystem combining the mounts
+func newMountFS(mounts []Mount) *mountFS {
+	return &mountFS{mounts: mounts, start: time.Now()}
+}
+
+// Open implements fs.FS
+func (m *mountFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		entries, err := m.ReadDir(".")
+		if err != nil {
+			return nil, err
+		}
+		return &virtualDir{info: m.rootInfo(), entries: entries}, nil
+	}
+	mnt, rel := findMount(m.mounts, name)
+	if mnt == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f, err := mnt.FS.Open(rel)
+	if err != nil || rel != "." {
+		return f, err
+	}
+	return &mountRootFile{File: f, name: mnt.Name}, nil
+}
+
+// Stat implements fs.StatFS, mount roots are reported under the mount name
+func (m *mountFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return m.rootInfo(), nil
+	}
+	mnt, rel := findMount(m.mounts, name)
+	if mnt == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	info, err := fs.Stat(mnt.FS, rel)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "." {
+		return renamedInfo{FileInfo: info, name: mnt.Name}, nil
+	}
+	return info, nil
+}
+
+// ReadDir implements fs.ReadDirFS, the root lists mounts sorted by name
+func (m *mountFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		mnt, rel := findMount(m.mounts, name)
+		if mnt == nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+		}
+		return fs.ReadDir(mnt.FS, rel)
+	}
+
+	res := make([]fs.DirEntry, 0, len(m.mounts))
+	for _, mnt := range m.mounts {
+		info, err := m.Stat(mnt.Name)
+		if err != nil {
+			continue // an unavailable mount is hidden rather than breaking the whole root listing
+		}
+		res = append(res, fs.FileInfoToDirEntry(info))
+	}
+	slices.SortFunc(res, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return res, nil
+}
+
+// Create implements Storage, files are created inside mounts only
+func (m *mountFS) Create(name string) (io.WriteCloser, error) {
+	mnt, rel, err := m.writeTarget("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return mnt.FS.Create(rel)
+}
+
+// Rename implements Storage, both names must be inside the same mount
+func (m *mountFS) Rename(oldName, newName string) error {
+	mnt, oldRel, err := m.writeTarget("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newMnt, newRel, err := m.writeTarget("rename", newName)
+	if err != nil {
+		return err
+	}
+	if mnt != newMnt {
+		return &fs.PathError{Op: "rename", Path: newName, Err: errors.New("can't rename across mounts")}
+	}
+	return mnt.FS.Rename(oldRel, newRel)
+}
+
+// Remove implements Storage
+func (m *mountFS) Remove(name string) error {
+	mnt, rel, err := m.writeTarget("remove", name)
+	if err != nil {
+		return err
+	}
+	return mnt.FS.Remove(rel)
+}
+
+// writeTarget returns the mount and relative path for a write, the root and mount roots are not writable
+func (m *mountFS) writeTarget(op, name string) (*Mount, string, error) {
+	if !fs.ValidPath(name) {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	mnt, rel := findMount(m.mounts, name)
+	if mnt == nil || rel == "." {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return mnt, rel, nil
+}
+
+// rootInfo describes the virtual root directory
+func (m *mountFS) rootInfo() fs.FileInfo {
+	return &virtualFileInfo{name: ".", mode: fs.ModeDir | 0o555, modTime: m.start, isDir: true}
+}
+
+// renamedInfo reports a file info under a different name, used for mount roots
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (r renamedInfo) Name() string { return r.name }
+
+// mountRootFile is an open mount root directory, reported under the mount name
+type mountRootFile struct {
+	fs.File
+	name string
+}
+
+func (f *mountRootFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return renamedInfo{FileInfo: info, name: f.name}, nil
+}
+
+// ReadDir implements fs.ReadDirFile
+func (f *mountRootFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d, ok := f.File.(fs.ReadDirFile); ok {
+		return d.ReadDir(n)
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not implemented")}
+}
+
+// virtualDir is an open virtual root directory of mountFS
+type virtualDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *virtualDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *virtualDir) Close() error               { return nil }
+
+func (d *virtualDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *virtualDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
+
+// mountAuthCookie returns the name of the session cookie for the mount
+func mountAuthCookie(name string) string {
+	return "auth-" + name
+}
+
+// mountScope returns the session token scope for the mount, so a mount session can't be used as the global one
+func mountScope(name string) string {
+	return "mount-" + name
+}
+
+// hasMountAuth returns true if any mount has its own password
+func (wb *Web) hasMountAuth() bool {
+	return slices.ContainsFunc(wb.Mounts, func(m Mount) bool { return m.Auth != "" })
+}
+
+// hasMountSession returns true if the request carries a valid session for any password-protected mount
+func (wb *Web) hasMountSession(r *http.Request) bool {
+	for _, m := range wb.Mounts {
+		if m.Auth == "" {
+			continue
+		}
+		if c, err := r.Cookie(mountAuthCookie(m.Name)); err == nil && wb.validateScopedToken(c.Value, mountScope(m.Name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// mountAllowed reports whether the request may access the path. Paths inside a mount limited to users need
+// one of them, and paths inside a mount with its own password need the mount session cookie or basic auth
+// with the mount password and an allowed user, the latter sets the cookie. basic auth is rejected from
+// locked out addresses and users, and its failures are counted by the auth guard.
+func (wb *Web) mountAllowed(w http.ResponseWriter, r *http.Request, p string) bool {
+	m, _ := findMount(wb.Mounts, p)
+	if m == nil {
+		return true
+	}
+	if !wb.mountUserAllowed(r, m) {
+		return false
+	}
+	if m.Auth == "" {
+		return true
+	}
+	if c, err := r.Cookie(mountAuthCookie(m.Name)); err == nil && wb.validateScopedToken(c.Value, mountScope(m.Name)) {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok || wb.Guard.locked(wb.guardIP(r), username) {
+		return false
+	}
+	// failures count as the login form's do, mount passwords can't be guessed at full speed over basic auth
+	if !m.userAllowed(username) || subtle.ConstantTimeCompare([]byte(password), []byte(m.Auth)) != 1 {
+		wb.loginFailed(r, username)
+		return false
+	}
+	wb.Guard.succeeded(wb.guardIP(r), username)
+	wb.setMountSession(w, r, m.Name)
+	return true
+}
+
+// mountUserAllowed checks the user of the request may access the mount
+func (wb *Web) mountUserAllowed(r *http.Request, m *Mount) bool {
+	return len(m.Users) == 0 || m.userAllowed(wb.currentUser(r))
+}
+
+// userAllowed checks the user is one of the mount users, anyone is allowed if they are not set
+func (m *Mount) userAllowed(user string) bool {
+	return len(m.Users) == 0 || (user != "" && slices.Contains(m.Users, user))
+}
+
+// requireMountAuth checks access to the mount holding the path. If denied, it responds with 403 to users
+// not allowed to access the mount, with 401 for API and upload requests, and redirects to the mount login
+// page otherwise.
+func (wb *Web) requireMountAuth(w http.ResponseWriter, r *http.Request, p string) bool {
+	if wb.mountAllowed(w, r, p) {
+		return true
+	}
+	m, _ := findMount(wb.Mounts, p)
+	if !wb.mountUserAllowed(r, m) {
+		log.Printf("[WARN] access to mount %s denied for user %q", m.Name, wb.currentUser(r))
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/upload" {
+			wb.writeJSONError(w, http.StatusForbidden, "access denied to "+m.Name)
+			return false
+		}
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return false
+	}
+	loginURL := "/login?mount=" + url.QueryEscape(m.Name)
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/upload":
+		wb.writeJSONError(w, http.StatusUnauthorized, "password required for "+m.Name)
+	case wb.isHTMXRequest(r):
+		w.Header().Set("HX-Redirect", loginURL)
+		w.WriteHeader(http.StatusUnauthorized)
+	default:
+		http.Redirect(w, r, loginURL, http.StatusSeeOther)
+	}
+	return false
+}
+
+// setMountSession sets the session cookie for the mount
+func (wb *Web) setMountSession(w http.ResponseWriter, r *http.Request, name string) {
+	http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
+		Name:     mountAuthCookie(name),
+		Value:    wb.generateScopedToken(mountScope(name)),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   wb.isRequestSecure(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   wb.getSessionMaxAge(),
+	})
+}
+
+// uploadSettings holds upload settings applying to a directory
+type uploadSettings struct {
+	enabled   bool
+	maxSize   int64
+	overwrite bool
+	storage   Storage // storage to write to, nil if it's not writable
+	relPath   string  // directory path relative to the storage root
+}
+
+// uploadSettingsFor returns upload settings for the directory, taken from its mount if mounts are configured.
+// uploads into the virtual root of mounts are never enabled.
+func (wb *Web) uploadSettingsFor(dir string) uploadSettings {
+	if len(wb.Mounts) == 0 {
+		return uploadSettings{enabled: wb.EnableUpload, maxSize: wb.UploadMaxSize, overwrite: wb.UploadOverwrite,
+			storage: wb.storage(), relPath: dir}
+	}
+	m, rel := findMount(wb.Mounts, dir)
+	if m == nil {
+		return uploadSettings{}
+	}
+	return uploadSettings{enabled: m.EnableUpload, maxSize: m.UploadMaxSize, overwrite: m.UploadOverwrite,
+		storage: m.FS, relPath: rel}
+}
+
+// uploadAvailable returns true if uploads are enabled anywhere, globally or in any mount
+func (wb *Web) uploadAvailable() bool {
+	if len(wb.Mounts) == 0 {
+		return wb.EnableUpload
+	}
+	return slices.ContainsFunc(wb.Mounts, func(m Mount) bool { return m.EnableUpload })
+}
+
+// maxUploadSize returns the largest upload size allowed anywhere, used to bound the request body before
+// the target directory is known
+func (wb *Web) maxUploadSize() int64 {
+	if len(wb.Mounts) == 0 {
+		return wb.UploadMaxSize
+	}
+	var res int64
+	for _, m := range wb.Mounts {
+		if m.EnableUpload {
+			res = max(res, m.UploadMaxSize)
+		}
+	}
+	return res
+}
+
+// servedFrom describes served directories for logging
+func servedFrom(rootDir string, mounts []Mount) string {
+	if len(mounts) == 0 {
+		return rootDir
+	}
+	res := make([]string, 0, len(mounts))
+	for _, m := range mounts {
+		res = append(res, m.Name+"="+m.RootDir)
+	}
+	return strings.Join(res, ", ")
+}
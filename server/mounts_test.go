@@ -0,0 +1,474 @@
+package server
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupMountDirs creates directories for releases, logs and docs mounts with a few files
+func setupMountDirs(t *testing.T) (releases, logs, docs string) {
+	t.Helper()
+	base := t.TempDir()
+	releases, logs, docs = filepath.Join(base, "releases"), filepath.Join(base, "logs"), filepath.Join(base, "docs")
+	for _, f := range []struct{ path, content string }{
+		{filepath.Join(releases, "v1", "app.tar.gz"), "release v1"},
+		{filepath.Join(releases, "tmp", "build.log"), "partial"},
+		{filepath.Join(logs, "app.log"), "log line"},
+		{filepath.Join(docs, "readme.md"), "# docs"},
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(f.path), 0o750))
+		require.NoError(t, os.WriteFile(f.path, []byte(f.content), 0o600))
+	}
+	return releases, logs, docs
+}
+
+// mustLocalStorage makes local storage for the directory
+func mustLocalStorage(t *testing.T, dir string) Storage {
+	t.Helper()
+	s, err := NewLocalStorage(dir)
+	require.NoError(t, err)
+	return s
+}
+
+// setupMountServer makes a web server with releases (upload enabled, tmp excluded), logs (password protected)
+// and docs mounts
+func setupMountServer(t *testing.T) *Web {
+	t.Helper()
+	releases, logs, docs := setupMountDirs(t)
+	mounts := []Mount{
+		{Name: "releases", RootDir: releases, FS: mustLocalStorage(t, releases), Exclude: []string{"tmp"},
+			EnableUpload: true, UploadMaxSize: 1024},
+		{Name: "logs", RootDir: logs, FS: mustLocalStorage(t, logs), Auth: "logs-secret"},
+		{Name: "docs", RootDir: docs, FS: mustLocalStorage(t, docs)},
+	}
+	srv := &Web{
+		Config: Config{ListenAddr: ":0", Theme: "light", InsecureCookies: true, SessionTTL: time.Hour,
+			SessionSecret: "test-secret"},
+		FS:     newMountFS(mounts),
+		Mounts: mounts,
+	}
+	require.NoError(t, srv.initTemplates())
+	return srv
+}
+
+func TestFindMount(t *testing.T) {
+	mounts := []Mount{{Name: "releases"}, {Name: "logs"}}
+	tests := []struct {
+		path, mount, rel string
+	}{
+		{"releases", "releases", "."},
+		{"releases/v1/app.tar.gz", "releases", "v1/app.tar.gz"},
+		{"/logs/", "logs", "."},
+		{".", "", ""},
+		{"", "", ""},
+		{"unknown/file", "", ""},
+		{"releasesx", "", ""},
+	}
+	for _, tc := range tests {
+		m, rel := findMount(mounts, tc.path)
+		if tc.mount == "" {
+			assert.Nil(t, m, tc.path)
+			continue
+		}
+		require.NotNil(t, m, tc.path)
+		assert.Equal(t, tc.mount, m.Name, tc.path)
+		assert.Equal(t, tc.rel, rel, tc.path)
+	}
+
+	assert.True(t, mountExcluded([]Mount{{Name: "releases", Exclude: []string{"tmp"}}}, "releases/tmp/build.log"))
+	assert.False(t, mountExcluded([]Mount{{Name: "releases", Exclude: []string{"tmp"}}}, "releases/v1"))
+	assert.False(t, mountExcluded([]Mount{{Name: "tmp", Exclude: []string{"tmp"}}}, "tmp"), "excludes apply inside the mount")
+}
+
+func TestMountFS(t *testing.T) {
+	releases, logs, _ := setupMountDirs(t)
+	gone := filepath.Join(t.TempDir(), "gone")
+	require.NoError(t, os.Mkdir(gone, 0o750))
+	goneStorage := mustLocalStorage(t, gone)
+	require.NoError(t, os.Remove(gone))
+	mfs := newMountFS([]Mount{
+		{Name: "releases", FS: mustLocalStorage(t, releases)},
+		{Name: "logs", FS: mustLocalStorage(t, logs)},
+		{Name: "missing", FS: goneStorage},
+	})
+
+	require.NoError(t, fstest.TestFS(mfs, "releases/v1/app.tar.gz", "releases/tmp/build.log", "logs/app.log"))
+
+	entries, err := fs.ReadDir(mfs, ".")
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		assert.True(t, e.IsDir())
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"logs", "releases"}, names, "sorted, unavailable mount hidden")
+
+	info, err := fs.Stat(mfs, "releases")
+	require.NoError(t, err)
+	assert.Equal(t, "releases", info.Name())
+	assert.True(t, info.IsDir())
+
+	data, err := fs.ReadFile(mfs, "logs/app.log")
+	require.NoError(t, err)
+	assert.Equal(t, "log line", string(data))
+
+	_, err = mfs.Open("unknown/file")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = mfs.Open("../etc/passwd")
+	require.ErrorIs(t, err, fs.ErrInvalid)
+}
+
+func TestMounts_Listing(t *testing.T) {
+	srv := setupMountServer(t)
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	list := func(path string) (status int, files []string) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/list?path="+url.QueryEscape(path), http.NoBody))
+		if rr.Code != http.StatusOK {
+			return rr.Code, nil
+		}
+		var resp struct {
+			Files []fileResponse `json:"files"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		for _, f := range resp.Files {
+			files = append(files, f.Path)
+		}
+		return rr.Code, files
+	}
+
+	status, files := list("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"docs", "logs", "releases"}, files, "mounts are top-level directories")
+
+	status, files = list("releases")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{".", "releases/v1"}, files, "parent entry and v1, tmp is excluded by the mount")
+
+	status, _ = list("releases/tmp")
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = list("logs")
+	assert.Equal(t, http.StatusUnauthorized, status, "password-protected mount")
+	status, _ = list("other")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// download from a mount and the html listing with breadcrumbs
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/releases/v1/app.tar.gz", http.NoBody))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "release v1", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/?path=releases/v1", http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "app.tar.gz")
+	assert.Contains(t, rr.Body.String(), `path=releases`, "breadcrumb to the mount")
+	assert.Contains(t, rr.Body.String(), `id="upload-btn"`, "upload enabled in releases")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/releases/tmp/build.log", http.NoBody))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestMounts_Auth(t *testing.T) {
+	srv := setupMountServer(t)
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	// browser requests are redirected to the mount login page
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/?path=logs", http.NoBody))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/login?mount=logs", rr.Header().Get("Location"))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/logs/app.log", http.NoBody))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/partials/dir-contents?path=logs", http.NoBody)
+	req.Header.Set("HX-Request", "true")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "/login?mount=logs", rr.Header().Get("HX-Redirect"))
+
+	// login page for the mount
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login?mount=logs", http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Login to logs")
+	assert.Contains(t, rr.Body.String(), `name="mount" value="logs"`)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"mount": {"logs"}, "password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = login("wrong")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid password")
+	assert.Empty(t, rr.Result().Cookies())
+
+	rr = login("logs-secret")
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/?path=logs", rr.Header().Get("Location"))
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "auth-logs", cookies[0].Name)
+
+	// the mount session opens the mount but is not a global session
+	req = httptest.NewRequest(http.MethodGet, "/logs/app.log", http.NoBody)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "log line", rr.Body.String())
+	assert.False(t, srv.validateSessionToken(cookies[0].Value), "mount token is not valid as a global one")
+	assert.False(t, srv.validateScopedToken(srv.generateSessionToken(), mountScope("logs")), "global token doesn't open mounts")
+	assert.False(t, srv.validateScopedToken(srv.generateScopedToken(mountScope("docs")), mountScope("logs")))
+
+	// basic auth with the mount password works for API clients
+	req = httptest.NewRequest(http.MethodGet, "/api/list?path=logs", http.NoBody)
+	req.SetBasicAuth("weblist", "logs-secret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "logs/app.log")
+
+	// files of a locked mount are skipped in zip downloads
+	form := url.Values{"selected-files": {"logs/app.log", "docs/readme.md"}}
+	req = httptest.NewRequest(http.MethodPost, "/download-selected", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	srv.handleDownloadSelected(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "readme.md")
+	assert.NotContains(t, rr.Body.String(), "app.log")
+
+	// global login is rejected, there is no global password
+	form = url.Values{"username": {"weblist"}, "password": {""}}
+	req = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), "Invalid username or password")
+	assert.Empty(t, rr.Result().Cookies())
+
+	// logout clears the mount session
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/logout", http.NoBody))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/", rr.Header().Get("Location"))
+	require.Len(t, rr.Result().Cookies(), 2)
+	assert.Equal(t, "auth-logs", rr.Result().Cookies()[1].Name)
+	assert.Negative(t, rr.Result().Cookies()[1].MaxAge)
+}
+
+func TestMounts_BasicAuthLockout(t *testing.T) {
+	srv := setupMountServer(t)
+	srv.Guard = NewAuthGuard(AuthGuardOpts{MaxIPFailures: 2, MaxUserFailures: 100})
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	list := func(addr, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/list?path=logs", http.NoBody)
+		req.RemoteAddr = addr
+		req.SetBasicAuth("weblist", password)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, list("10.0.0.1:1000", "logs-secret").Code)
+	assert.Equal(t, http.StatusUnauthorized, list("10.0.0.1:1000", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, list("10.0.0.1:1000", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, list("10.0.0.1:1000", "logs-secret").Code, "address locked out")
+	assert.Equal(t, http.StatusOK, list("10.0.0.2:1000", "logs-secret").Code, "other addresses not locked out")
+
+	// the lockout applies to the mount login form as well
+	form := url.Values{"mount": {"logs"}, "password": {"logs-secret"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "10.0.0.1:1000"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), lockedOutMessage)
+}
+
+func TestMounts_AuthDottedName(t *testing.T) {
+	_, logs, _ := setupMountDirs(t)
+	mounts := []Mount{{Name: "logs.v1", RootDir: logs, FS: mustLocalStorage(t, logs), Auth: "logs-secret"}}
+	srv := &Web{Config: Config{ListenAddr: ":0", Theme: "light", InsecureCookies: true, SessionTTL: time.Hour,
+		SessionSecret: "test-secret"}, FS: newMountFS(mounts), Mounts: mounts}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	form := url.Values{"mount": {"logs.v1"}, "password": {"logs-secret"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	// the session of the mount opens it, a mount name with a dot doesn't break the token
+	req = httptest.NewRequest(http.MethodGet, "/logs.v1/app.log", http.NoBody)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "log line", rr.Body.String())
+	assert.False(t, srv.validateScopedToken(cookies[0].Value, mountScope("logs")))
+}
+
+func TestMounts_Users(t *testing.T) {
+	srv := setupMountServer(t)
+	srv.Mounts[1].Users = []string{"alice"} // logs, with the mount password
+	srv.Mounts[2].Users = []string{"alice", "bob"}
+	proxy, err := NewProxyAuth(ProxyAuthOpts{UserHeader: "X-Forwarded-User", Trusted: []string{"10.0.0.1"}})
+	require.NoError(t, err)
+	srv.Proxy = proxy
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	get := func(target, user string, basic ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.RemoteAddr = "10.0.0.1:1000"
+		req.Header.Set("X-Forwarded-User", user)
+		if len(basic) == 2 {
+			req.SetBasicAuth(basic[0], basic[1])
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, get("/docs/readme.md", "bob").Code)
+	assert.Equal(t, http.StatusForbidden, get("/docs/readme.md", "carol").Code)
+	assert.Equal(t, http.StatusForbidden, get("/api/list?path=docs", "carol").Code)
+	assert.Equal(t, http.StatusOK, get("/releases/v1/app.tar.gz", "carol").Code, "mount without users")
+
+	// the mount password doesn't open the mount for other users
+	assert.Equal(t, http.StatusForbidden, get("/logs/app.log", "bob", "bob", "logs-secret").Code)
+	assert.Equal(t, http.StatusSeeOther, get("/logs/app.log", "alice").Code, "mount password still required")
+	rr := get("/logs/app.log", "alice", "alice", "logs-secret")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "log line", rr.Body.String())
+
+	// files of mounts of other users are skipped in zip downloads
+	form := url.Values{"selected-files": {"docs/readme.md", "releases/v1/app.tar.gz"}}
+	req := httptest.NewRequest(http.MethodPost, "/download-selected", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "10.0.0.1:1000"
+	req.Header.Set("X-Forwarded-User", "carol")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "app.tar.gz")
+	assert.NotContains(t, rr.Body.String(), "readme.md")
+
+	// SFTP sessions of other users don't see the mount
+	names := func(user string) []string {
+		infos, err := (&jailedFilesystem{fsys: srv.FS, mounts: srv.Mounts, user: user}).listRoot()
+		require.NoError(t, err)
+		res := make([]string, 0, len(infos))
+		for _, info := range infos {
+			res = append(res, info.Name())
+		}
+		return res
+	}
+	assert.Equal(t, []string{"..", "docs", "releases"}, names("bob"))
+	assert.Equal(t, []string{"..", "releases"}, names("carol"))
+}
+
+func TestMounts_Upload(t *testing.T) {
+	srv := setupMountServer(t)
+
+	upload := func(path, name, content string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.handleUpload(rr, createMultipartRequest(t, map[string]string{name: content}, map[string]string{"path": path}))
+		return rr
+	}
+
+	rr := upload("releases/v1", "new.txt", "hello")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	data, err := os.ReadFile(filepath.Join(srv.Mounts[0].RootDir, "v1", "new.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	rr = upload("releases", "big.txt", strings.Repeat("x", 2000))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "mount upload limit")
+
+	rr = upload("docs", "new.txt", "hello")
+	assert.Equal(t, http.StatusForbidden, rr.Code, "upload is not enabled for docs")
+	_, err = os.Stat(filepath.Join(srv.Mounts[2].RootDir, "new.txt"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	rr = upload(".", "new.txt", "hello")
+	assert.Equal(t, http.StatusForbidden, rr.Code, "no uploads to the root of mounts")
+
+	rr = upload("releases/tmp", "new.txt", "hello")
+	assert.Equal(t, http.StatusForbidden, rr.Code, "excluded by the mount")
+
+	srv.Mounts[1].EnableUpload, srv.Mounts[1].UploadMaxSize = true, 1024
+	rr = upload("logs", "new.txt", "hello")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "password-protected mount")
+
+	// the upload button is shown only where uploads are enabled
+	req := httptest.NewRequest(http.MethodGet, "/partials/dir-contents?path=docs", http.NoBody)
+	req.Header.Set("HX-Request", "true")
+	rr = httptest.NewRecorder()
+	srv.handleDirContents(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "uploadBtn.parentElement.style.display = 'none'", "upload button hidden in docs")
+}
+
+func TestMounts_SFTP(t *testing.T) {
+	srv := setupMountServer(t)
+	jailed := &jailedFilesystem{fsys: srv.FS, mounts: srv.Mounts}
+
+	names := func(infos []os.FileInfo) []string {
+		res := make([]string, 0, len(infos))
+		for _, info := range infos {
+			res = append(res, info.Name())
+		}
+		return res
+	}
+
+	infos, err := jailed.listRoot()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"..", "docs", "releases"}, names(infos), "password-protected mount is hidden")
+
+	lister, err := jailed.Filelist(&sftp.Request{Method: "List", Filepath: "/releases"})
+	require.NoError(t, err)
+	infos = make([]os.FileInfo, 10)
+	n, _ := lister.ListAt(infos, 0)
+	assert.Equal(t, []string{"..", "v1"}, names(infos[:n]), "tmp excluded by the mount")
+
+	_, err = jailed.Fileread(&sftp.Request{Method: "Get", Filepath: "/docs/readme.md"})
+	require.NoError(t, err)
+	_, err = jailed.Fileread(&sftp.Request{Method: "Get", Filepath: "/logs/app.log"})
+	require.Error(t, err)
+	_, err = jailed.Fileread(&sftp.Request{Method: "Get", Filepath: "/releases/tmp/build.log"})
+	require.Error(t, err)
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const maxNotebookSize = 20 * 1024 * 1024 // larger notebooks are shown as text, rendering reads them whole
+
+// notebookPolicy sanitizes HTML written by notebooks, i.e. rendered markdown and HTML outputs, which can
+// contain scripts. only classes of highlighted code, the markdown wrapper and pandas data frames are kept,
+// so outputs can't take the look or behavior of page elements with their classes.
+var notebookPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(chroma|line|cl|ln|lnt|hl|err|[a-z][a-z0-9]?)$`)).OnElements("pre", "code", "span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^markdown-content$`)).OnElements("div")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^dataframe$`)).OnElements("table")
+	p.AllowAttrs("border", "align", "valign").OnElements("table", "th", "td", "tr")
+	p.AllowDataURIImages()
+	return p
+}()
+
+// ansiEscape matches terminal color codes in tracebacks
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+
+// notebook is a Jupyter notebook, nbformat 4, with the fields used for rendering
+type notebook struct {
+	NBFormat int            `json:"nbformat"`
+	Cells    []notebookCell `json:"cells"`
+	Metadata struct {
+		KernelSpec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+}
+
+// notebookCell is a markdown, code or raw cell of a notebook
+type notebookCell struct {
+	CellType       string                                `json:"cell_type"`
+	Source         notebookText                          `json:"source"`
+	ExecutionCount *int                                  `json:"execution_count"`
+	Outputs        []notebookOutput                      `json:"outputs"`
+	Attachments    map[string]map[string]json.RawMessage `json:"attachments"` // images pasted into markdown, by name and MIME type
+}
+
+// notebookOutput is an output of a code cell, one of stream, display_data, execute_result or error
+type notebookOutput struct {
+	OutputType     string                     `json:"output_type"`
+	Name           string                     `json:"name"` // stdout or stderr for stream
+	Text           notebookText               `json:"text"`
+	Data           map[string]json.RawMessage `json:"data"` // representations of the output by MIME type
+	ExecutionCount *int                       `json:"execution_count"`
+	EName          string                     `json:"ename"`
+	EValue         string                     `json:"evalue"`
+	Traceback      []string                   `json:"traceback"`
+}
+
+// notebookText is a text of a notebook, stored either as a string or as a list of lines
+type notebookText string
+
+// UnmarshalJSON accepts both a string and a list of strings
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*t = notebookText(strings.Join(lines, ""))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("notebook text: %w", err)
+	}
+	*t = notebookText(s)
+	return nil
+}
+
+// renderNotebook renders a Jupyter notebook as HTML. markdown cells are rendered as markdown files are,
+// code cells are highlighted if syntax highlighting is enabled, and outputs are shown as text, images or
+// sanitized HTML. the theme controls the chroma style, as for markdown and code.
+func (wb *Web) renderNotebook(content, theme string) (string, error) {
+	var nb notebook
+	if err := json.Unmarshal([]byte(content), &nb); err != nil {
+		return "", fmt.Errorf("notebook parse: %w", err)
+	}
+	if nb.NBFormat < 4 {
+		return "", fmt.Errorf("unsupported notebook format %d", nb.NBFormat)
+	}
+	lang := nb.Metadata.LanguageInfo.Name
+	if lang == "" {
+		lang = nb.Metadata.KernelSpec.Language
+	}
+	if lang == "" {
+		lang = "python"
+	}
+
+	var buf strings.Builder
+	buf.WriteString(`<div class="notebook">`)
+	for _, cell := range nb.Cells {
+		switch cell.CellType {
+		case "markdown":
+			buf.WriteString(`<div class="nb-cell nb-markdown">`)
+			buf.WriteString(wb.renderNotebookMarkdown(string(cell.Source), cell.Attachments, theme))
+			buf.WriteString("</div>")
+		case "code":
+			buf.WriteString(`<div class="nb-cell nb-code"><div class="nb-input">`)
+			buf.WriteString(notebookPrompt("In", cell.ExecutionCount, true))
+			buf.WriteString(`<div class="nb-source">`)
+			buf.WriteString(wb.renderNotebookCode(string(cell.Source), lang, theme))
+			buf.WriteString("</div></div>")
+			for _, out := range cell.Outputs {
+				buf.WriteString(`<div class="nb-output">`)
+				buf.WriteString(notebookPrompt("Out", out.ExecutionCount, out.OutputType == "execute_result"))
+				buf.WriteString(`<div class="nb-output-body">`)
+				buf.WriteString(wb.renderNotebookOutput(out, theme))
+				buf.WriteString("</div></div>")
+			}
+			buf.WriteString("</div>")
+		default: // raw cells are shown as they are
+			fmt.Fprintf(&buf, `<div class="nb-cell nb-raw"><pre>%s</pre></div>`, template.HTMLEscapeString(string(cell.Source)))
+		}
+	}
+	buf.WriteString("</div>")
+	return buf.String(), nil
+}
+
+// notebookPrompt renders the prompt of a cell input or output, e.g. "In [3]:", empty if not shown
+func notebookPrompt(name string, count *int, show bool) string {
+	switch {
+	case !show:
+		return `<div class="nb-prompt"></div>`
+	case count == nil:
+		return fmt.Sprintf(`<div class="nb-prompt">%s&nbsp;[&nbsp;]:</div>`, name)
+	default:
+		return fmt.Sprintf(`<div class="nb-prompt">%s&nbsp;[%d]:</div>`, name, *count)
+	}
+}
+
+// renderNotebookMarkdown renders a markdown cell, with its attached images inlined as data URIs
+func (wb *Web) renderNotebookMarkdown(source string, attachments map[string]map[string]json.RawMessage, theme string) string {
+	for name, data := range attachments {
+		for _, mimeType := range []string{"image/png", "image/jpeg", "image/gif"} {
+			if uri, ok := notebookImageURI(mimeType, data[mimeType]); ok {
+				source = strings.ReplaceAll(source, "attachment:"+name, uri)
+				break
+			}
+		}
+	}
+	rendered, err := wb.renderMarkdown(source, theme)
+	if err != nil {
+		log.Printf("[WARN] failed to render notebook markdown: %v", err)
+		return "<pre>" + template.HTMLEscapeString(source) + "</pre>"
+	}
+	return notebookPolicy.Sanitize(rendered)
+}
+
+// renderNotebookCode renders the source of a code cell, highlighted for the notebook language
+func (wb *Web) renderNotebookCode(source, lang, theme string) string {
+	if wb.EnableSyntaxHighlighting {
+		highlighted, err := wb.highlightCode(source, lang, theme)
+		if err == nil {
+			return highlighted
+		}
+		log.Printf("[WARN] failed to highlight notebook code: %v", err)
+	}
+	return "<pre>" + template.HTMLEscapeString(source) + "</pre>"
+}
+
+// renderNotebookOutput renders the richest representation of a cell output the viewer can show
+func (wb *Web) renderNotebookOutput(out notebookOutput, theme string) string {
+	switch out.OutputType {
+	case "stream":
+		return fmt.Sprintf(`<pre class="nb-%s">%s</pre>`, template.HTMLEscapeString(out.Name), template.HTMLEscapeString(string(out.Text)))
+	case "error":
+		traceback := ansiEscape.ReplaceAllString(strings.Join(out.Traceback, "\n"), "")
+		if traceback == "" {
+			traceback = out.EName + ": " + out.EValue
+		}
+		return `<pre class="nb-error">` + template.HTMLEscapeString(traceback) + "</pre>"
+	}
+
+	for _, mimeType := range []string{"image/png", "image/jpeg", "image/gif"} {
+		if uri, ok := notebookImageURI(mimeType, out.Data[mimeType]); ok {
+			return fmt.Sprintf(`<img src="%s" alt="output">`, uri)
+		}
+	}
+	if text, ok := notebookData(out.Data["image/svg+xml"]); ok {
+		// svg is shown as an image, scripts of images are not run
+		return fmt.Sprintf(`<img src="data:image/svg+xml;base64,%s" alt="output">`, base64.StdEncoding.EncodeToString([]byte(text)))
+	}
+	if text, ok := notebookData(out.Data["text/html"]); ok {
+		return `<div class="nb-html">` + notebookPolicy.Sanitize(text) + "</div>"
+	}
+	if text, ok := notebookData(out.Data["text/markdown"]); ok {
+		return wb.renderNotebookMarkdown(text, nil, theme)
+	}
+	if raw, ok := out.Data["application/json"]; ok {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, raw, "", "  "); err == nil {
+			return "<pre>" + template.HTMLEscapeString(pretty.String()) + "</pre>"
+		}
+	}
+	for _, mimeType := range []string{"text/latex", "text/plain"} {
+		if text, ok := notebookData(out.Data[mimeType]); ok {
+			return "<pre>" + template.HTMLEscapeString(text) + "</pre>"
+		}
+	}
+	return ""
+}
+
+// notebookData returns the text of an output representation, false if there is none
+func notebookData(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 {
+		return "", false
+	}
+	var text notebookText
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return "", false
+	}
+	return string(text), true
+}
+
+// notebookImageURI makes a data URI of a base64 encoded image, false if there is no image or it's not
+// valid base64
+func notebookImageURI(mimeType string, raw json.RawMessage) (string, bool) {
+	text, ok := notebookData(raw)
+	if !ok {
+		return "", false
+	}
+	encoded := strings.Join(strings.Fields(text), "") // encoders wrap long lines
+	if _, err := base64.StdEncoding.DecodeString(encoded); err != nil {
+		return "", false
+	}
+	return "data:" + mimeType + ";base64," + encoded, true
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngPixel is a 1x1 transparent PNG
+const pngPixel = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+const testNotebook = `{
+ "nbformat": 4,
+ "nbformat_minor": 5,
+ "metadata": {"kernelspec": {"language": "python", "name": "python3"}, "language_info": {"name": "python"}},
+ "cells": [
+  {"cell_type": "markdown", "metadata": {}, "source": ["# Analysis\n", "\n", "Some **bold** text <script>alert('md')</script>\n", "![plot](attachment:plot.png)"],
+   "attachments": {"plot.png": {"image/png": "` + pngPixel + `"}}},
+  {"cell_type": "code", "execution_count": 1, "metadata": {}, "source": "import pandas as pd\ndf = pd.read_csv('data.csv')",
+   "outputs": [
+    {"output_type": "stream", "name": "stdout", "text": ["loaded 3 rows\n"]},
+    {"output_type": "stream", "name": "stderr", "text": "warning <x>\n"}
+   ]},
+  {"cell_type": "code", "execution_count": 2, "metadata": {}, "source": ["df"],
+   "outputs": [
+    {"output_type": "execute_result", "execution_count": 2, "metadata": {},
+     "data": {"text/plain": ["   a  b\n", "0  1  2"],
+              "text/html": ["<table class=\"dataframe\" border=\"1\"><thead><tr><th>a</th></tr></thead>",
+                            "<tbody><tr><td onclick=\"alert(1)\">1</td></tr></tbody></table><script>alert('out')</script>"]}}
+   ]},
+  {"cell_type": "code", "execution_count": 3, "metadata": {}, "source": "df.plot()",
+   "outputs": [
+    {"output_type": "display_data", "metadata": {}, "data": {"image/png": "` + pngPixel + `\n", "text/plain": ["<Figure>"]}},
+    {"output_type": "display_data", "metadata": {}, "data": {"application/json": {"key": [1, 2]}}},
+    {"output_type": "error", "ename": "ValueError", "evalue": "bad",
+     "traceback": ["\u001b[0;31mValueError\u001b[0m Traceback", "\u001b[0;31mValueError\u001b[0m: bad"]}
+   ]},
+  {"cell_type": "code", "execution_count": null, "metadata": {}, "source": "", "outputs": []},
+  {"cell_type": "raw", "metadata": {}, "source": "raw <b>text</b>"}
+ ]
+}`
+
+func TestNotebookPolicyClasses(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`<pre class="chroma"><code><span class="kn">import</span> <span class="nn">os</span></code></pre>`,
+			`<pre class="chroma"><code><span class="kn">import</span> <span class="nn">os</span></code></pre>`},
+		{`<div class="markdown-content"><table class="dataframe"><tr><td>1</td></tr></table></div>`,
+			`<div class="markdown-content"><table class="dataframe"><tr><td>1</td></tr></table></div>`},
+		{`<div class="nb-prompt">x</div>`, `<div>x</div>`},
+		{`<div class="modal hidden">x</div>`, `<div>x</div>`},
+		{`<span class="kn hidden">x</span>`, `<span>x</span>`},
+		{`<span class="pager-toolbar">x</span>`, `<span>x</span>`},
+		{`<table class="dataframe nb-html"><tr><td>1</td></tr></table>`, `<table><tr><td>1</td></tr></table>`},
+		{`<p class="k">x</p>`, `<p>x</p>`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, notebookPolicy.Sanitize(tt.in), tt.in)
+	}
+}
+
+func TestRenderNotebook(t *testing.T) {
+	wb := &Web{Config: Config{EnableSyntaxHighlighting: true}}
+	res, err := wb.renderNotebook(testNotebook, "light")
+	require.NoError(t, err)
+
+	// markdown cells
+	assert.Contains(t, res, "<h1>Analysis</h1>")
+	assert.Contains(t, res, "<strong>bold</strong>")
+	assert.Contains(t, res, `<img src="data:image/png;base64,`+pngPixel+`" alt="plot">`, "attachment inlined")
+
+	// code cells and outputs
+	assert.Contains(t, res, `<div class="nb-prompt">In&nbsp;[1]:</div>`)
+	assert.Contains(t, res, `<span class="kn">import</span>`, "highlighted as python")
+	assert.Contains(t, res, `<pre class="nb-stdout">loaded 3 rows`+"\n</pre>")
+	assert.Contains(t, res, `<pre class="nb-stderr">warning &lt;x&gt;`)
+	assert.Contains(t, res, `<div class="nb-prompt">Out&nbsp;[2]:</div>`)
+	assert.Contains(t, res, `<table class="dataframe" border="1">`, "html output kept")
+	assert.Contains(t, res, `<td>1</td>`)
+	assert.Contains(t, res, `<img src="data:image/png;base64,`+pngPixel+`" alt="output">`, "image preferred to text")
+	assert.NotContains(t, res, "&lt;Figure&gt;")
+	assert.Contains(t, res, "{\n  &#34;key&#34;: [\n    1,\n    2\n  ]\n}")
+	assert.Contains(t, res, `<pre class="nb-error">ValueError Traceback`+"\nValueError: bad</pre>", "colors removed")
+	assert.Contains(t, res, `<div class="nb-prompt">In&nbsp;[&nbsp;]:</div>`, "not executed cell")
+	assert.Contains(t, res, `<pre>raw &lt;b&gt;text&lt;/b&gt;</pre>`)
+
+	// scripts are removed
+	assert.NotContains(t, res, "<script")
+	assert.NotContains(t, res, "alert(&#39;out&#39;)")
+	assert.NotContains(t, res, "onclick")
+
+	// code is not highlighted if highlighting is disabled
+	wb.EnableSyntaxHighlighting = false
+	res, err = wb.renderNotebook(testNotebook, "light")
+	require.NoError(t, err)
+	assert.Contains(t, res, "<pre>import pandas as pd\ndf = pd.read_csv(&#39;data.csv&#39;)</pre>")
+
+	_, err = wb.renderNotebook("not json", "light")
+	require.Error(t, err)
+	_, err = wb.renderNotebook(`{"nbformat": 3, "worksheets": []}`, "light")
+	require.Error(t, err)
+}
+
+func TestRenderNotebookOutputSVG(t *testing.T) {
+	wb := &Web{}
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`
+	res := wb.renderNotebookOutput(notebookOutput{OutputType: "display_data",
+		Data: map[string]json.RawMessage{"image/svg+xml": json.RawMessage(`"` + strings.ReplaceAll(svg, `"`, `\"`) + `"`)}}, "light")
+	assert.Equal(t, `<img src="data:image/svg+xml;base64,`+base64.StdEncoding.EncodeToString([]byte(svg))+`" alt="output">`, res,
+		"svg shown as an image, not inline")
+
+	res = wb.renderNotebookOutput(notebookOutput{OutputType: "display_data",
+		Data: map[string]json.RawMessage{"image/png": json.RawMessage(`"not base64!"`), "text/plain": json.RawMessage(`"fallback"`)}}, "light")
+	assert.Equal(t, "<pre>fallback</pre>", res, "invalid image skipped")
+}
+
+func TestViewNotebook(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "analysis.ipynb"), []byte(testNotebook), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.ipynb"), []byte(`{"cells": [`), 0o600))
+	// a notebook with large outputs is rendered, not paged
+	big := strings.Replace(testNotebook, `"source": "df.plot()"`, `"source": "# `+strings.Repeat("x", pagedViewSize)+`"`, 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.ipynb"), []byte(big), 0o600))
+
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test"}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	rr := get("/view/analysis.ipynb")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<div class="notebook">`)
+	assert.NotContains(t, rr.Body.String(), "<script>alert")
+
+	rr = get("/view/broken.ipynb")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<pre>{&#34;cells&#34;: [</pre>`, "shown as text if not valid")
+
+	rr = get("/view/big.ipynb")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<div class="notebook">`)
+	assert.NotContains(t, rr.Body.String(), `class="pager-toolbar"`)
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie = "oidc-state"
+	oidcStateTTL    = 10 * time.Minute // time to log in at the provider
+	oidcCallback    = "/oidc/callback"
+)
+
+// OIDCOpts defines options for single sign-on with an OpenID Connect provider
+type OIDCOpts struct {
+	Issuer         string   // issuer URL, the provider is discovered from it
+	ClientID       string   // client ID registered at the provider
+	ClientSecret   string   // client secret, empty for public clients
+	RedirectURL    string   // callback URL registered at the provider, built from the request if empty
+	Name           string   // provider name shown on the login button
+	Scopes         []string // scopes requested in addition to openid, email and profile
+	UserClaim      string   // claim with the user name, "email" if empty
+	GroupsClaim    string   // claim with the groups of the user, "groups" if empty
+	AllowedDomains []string // email domains allowed to log in, any if empty
+	AllowedGroups  []string // groups allowed to log in, any if empty
+}
+
+// OIDCAuth logs users in with the authorization code flow with PKCE and maps claims to weblist users
+type OIDCAuth struct {
+	opts     OIDCOpts
+	endpoint oauth2.Endpoint
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCAuth discovers the provider of the issuer and makes OIDC login with it
+func NewOIDCAuth(ctx context.Context, opts OIDCOpts) (*OIDCAuth, error) {
+	provider, err := oidc.NewProvider(ctx, opts.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %w", opts.Issuer, err)
+	}
+	if opts.UserClaim == "" {
+		opts.UserClaim = "email"
+	}
+	if opts.GroupsClaim == "" {
+		opts.GroupsClaim = "groups"
+	}
+	if opts.Name == "" {
+		opts.Name = "SSO"
+	}
+	return &OIDCAuth{
+		opts:     opts,
+		endpoint: provider.Endpoint(),
+		verifier: provider.Verifier(&oidc.Config{ClientID: opts.ClientID}),
+	}, nil
+}
+
+// Name returns the provider name shown on the login button
+func (o *OIDCAuth) Name() string {
+	if o == nil {
+		return ""
+	}
+	return o.opts.Name
+}
+
+// oauthConfig returns OAuth2 config with the callback URL of the request's host unless it's configured
+func (o *OIDCAuth) oauthConfig(r *http.Request, secure bool) *oauth2.Config {
+	redirectURL := o.opts.RedirectURL
+	if redirectURL == "" {
+		scheme := "http"
+		if secure || r.TLS != nil {
+			scheme = "https"
+		}
+		redirectURL = scheme + "://" + r.Host + oidcCallback
+	}
+	return &oauth2.Config{
+		ClientID:     o.opts.ClientID,
+		ClientSecret: o.opts.ClientSecret,
+		Endpoint:     o.endpoint,
+		RedirectURL:  redirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID, "email", "profile"}, o.opts.Scopes...),
+	}
+}
+
+// user verifies the ID token and returns the user it maps to, or an error if the user isn't allowed.
+// the user name comes from the user claim, email must be verified if the provider reports it.
+func (o *OIDCAuth) user(ctx context.Context, rawIDToken, nonce string) (string, error) {
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", fmt.Errorf("invalid ID token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return "", errors.New("ID token nonce mismatch")
+	}
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return "", fmt.Errorf("failed to parse ID token claims: %w", err)
+	}
+
+	user, _ := claims[o.opts.UserClaim].(string)
+	if user == "" {
+		return "", fmt.Errorf("ID token has no %q claim", o.opts.UserClaim)
+	}
+	email, _ := claims["email"].(string)
+	if verified, ok := claims["email_verified"].(bool); ok && !verified && email != "" {
+		return "", fmt.Errorf("email %s is not verified", email)
+	}
+
+	if len(o.opts.AllowedDomains) > 0 {
+		_, domain, _ := strings.Cut(email, "@")
+		if !slices.ContainsFunc(o.opts.AllowedDomains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+			return "", fmt.Errorf("email domain of %s is not allowed", user)
+		}
+	}
+	if len(o.opts.AllowedGroups) > 0 {
+		groups := claimStrings(claims[o.opts.GroupsClaim])
+		if !slices.ContainsFunc(groups, func(g string) bool { return slices.Contains(o.opts.AllowedGroups, g) }) {
+			return "", fmt.Errorf("user %s is not in allowed groups", user)
+		}
+	}
+	return user, nil
+}
+
+// claimStrings returns a claim with a string or a list of strings as a list
+func claimStrings(v any) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []any:
+		res := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// handleOIDCLogin redirects to the provider. State, nonce and PKCE verifier are kept in a signed cookie
+// until the callback, so no server-side storage is needed.
+func (wb *Web) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, nonce, verifier := uuid.NewString(), uuid.NewString(), oauth2.GenerateVerifier()
+	value := state + "." + nonce + "." + verifier
+	http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
+		Name:     oidcStateCookie,
+		Value:    value + "." + wb.signOIDCState(value),
+		Path:     "/oidc/",
+		HttpOnly: true,
+		Secure:   wb.isRequestSecure(r),
+		SameSite: http.SameSiteLaxMode, // sent on the top-level redirect back from the provider
+		MaxAge:   int(oidcStateTTL.Seconds()),
+	})
+	cfg := wb.OIDC.oauthConfig(r, wb.isRequestSecure(r))
+	http.Redirect(w, r, cfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+// handleOIDCCallback exchanges the authorization code, checks the user is allowed and sets the session cookie
+func (wb *Web) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	// the state cookie is single use
+	http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
+		Name: oidcStateCookie, Value: "", Path: "/oidc/", HttpOnly: true, Secure: wb.isRequestSecure(r), MaxAge: -1,
+	})
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		log.Printf("[WARN] OIDC login failed at provider: %s %s", errCode, r.URL.Query().Get("error_description"))
+		wb.renderLoginError(w, "Login with "+wb.OIDC.Name()+" failed")
+		return
+	}
+
+	state, nonce, verifier, ok := wb.oidcState(r)
+	if !ok || subtle.ConstantTimeCompare([]byte(state), []byte(r.URL.Query().Get("state"))) != 1 {
+		wb.renderLoginError(w, "Login expired, please try again")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	cfg := wb.OIDC.oauthConfig(r, wb.isRequestSecure(r))
+	token, err := cfg.Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Printf("[WARN] OIDC code exchange failed: %v", err)
+		wb.renderLoginError(w, "Login with "+wb.OIDC.Name()+" failed")
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		log.Printf("[WARN] OIDC token response has no ID token")
+		wb.renderLoginError(w, "Login with "+wb.OIDC.Name()+" failed")
+		return
+	}
+
+	user, err := wb.OIDC.user(ctx, rawIDToken, nonce)
+	if err != nil {
+		log.Printf("[WARN] OIDC login rejected: %v", err)
+		wb.renderLoginError(w, "Access denied")
+		return
+	}
+
+	log.Printf("[INFO] OIDC login of %s", user)
+	wb.setSessionCookie(w, r, user)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// oidcState returns state, nonce and PKCE verifier of the login from the signed state cookie
+func (wb *Web) oidcState(r *http.Request) (state, nonce, verifier string, ok bool) {
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return "", "", "", false
+	}
+	parts := strings.Split(c.Value, ".") // none of the parts contains a dot
+	if len(parts) != 4 {
+		return "", "", "", false
+	}
+	value := strings.Join(parts[:3], ".")
+	if subtle.ConstantTimeCompare([]byte(parts[3]), []byte(wb.signOIDCState(value))) != 1 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+func (wb *Web) signOIDCState(value string) string {
+	h := hmac.New(sha256.New, []byte(wb.SessionSecret))
+	h.Write([]byte("oidc-state:" + value))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
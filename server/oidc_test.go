@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/coreos/go-oidc/v3/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIssuer is a local OIDC provider issuing ID tokens with preset claims for authorization codes
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant // pending authorization codes
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	res := &mockIssuer{key: key, codes: map[string]mockGrant{}}
+
+	discovery := &oidctest.Server{PublicKeys: []oidctest.PublicKey{{PublicKey: key.Public(), KeyID: "test-key", Algorithm: oidc.RS256}}}
+	mux := http.NewServeMux()
+	mux.Handle("/", discovery)
+	mux.HandleFunc("POST /token", res.handleToken)
+	res.Server = httptest.NewServer(mux)
+	discovery.SetIssuer(res.URL)
+	t.Cleanup(res.Close)
+	return res
+}
+
+// authorize plays the user logging in at the provider, it returns the code for the redirect back to weblist
+func (m *mockIssuer) authorize(t *testing.T, authURL string, claims map[string]any) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	q := u.Query()
+	require.Equal(t, "S256", q.Get("code_challenge_method"))
+	require.Equal(t, "weblist-client", q.Get("client_id"))
+
+	all := map[string]any{"iss": m.URL, "aud": "weblist-client", "sub": "user-1", "nonce": q.Get("nonce"),
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+	maps.Copy(all, claims)
+	code = "code-" + q.Get("state")
+	m.mu.Lock()
+	m.codes[code] = mockGrant{challenge: q.Get("code_challenge"), claims: all}
+	m.mu.Unlock()
+	return code, q.Get("state")
+}
+
+// handleToken exchanges a code for the ID token, checking the PKCE verifier
+func (m *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	grant, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+	claims, _ := json.Marshal(grant.claims)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600,
+		"id_token": oidctest.SignIDToken(m.key, "test-key", oidc.RS256, string(claims))})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	setup := func(t *testing.T, opts OIDCOpts) (*Web, http.Handler) {
+		t.Helper()
+		opts.Issuer, opts.ClientID, opts.ClientSecret = issuer.URL, "weblist-client", "secret"
+		auth, err := NewOIDCAuth(context.Background(), opts)
+		require.NoError(t, err)
+		srv := &Web{Config: Config{RootDir: "testdata", SessionSecret: "session-secret", Title: "Test"},
+			FS: os.DirFS("testdata"), OIDC: auth}
+		require.NoError(t, srv.initTemplates())
+		router, err := srv.router()
+		require.NoError(t, err)
+		return srv, router
+	}
+
+	// login goes through the whole flow and returns the response of the callback
+	login := func(t *testing.T, router http.Handler, claims map[string]any) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://files.example.com/oidc/login", http.NoBody))
+		require.Equal(t, http.StatusFound, rr.Code)
+		authURL := rr.Header().Get("Location")
+		assert.Contains(t, authURL, url.QueryEscape("http://files.example.com/oidc/callback"), "callback built from the request")
+		stateCookies := rr.Result().Cookies()
+		require.Len(t, stateCookies, 1)
+
+		code, state := issuer.authorize(t, authURL, claims)
+		req := httptest.NewRequest(http.MethodGet, "http://files.example.com/oidc/callback?code="+code+"&state="+state, http.NoBody)
+		req.AddCookie(stateCookies[0])
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	authCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == "auth" {
+				return c
+			}
+		}
+		return nil
+	}
+
+	t.Run("login sets session of the user", func(t *testing.T) {
+		srv, router := setup(t, OIDCOpts{Name: "Company"})
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+		assert.Equal(t, "/login", rr.Header().Get("Location"))
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", http.NoBody))
+		assert.Contains(t, rr.Body.String(), "Sign in with Company")
+		assert.NotContains(t, rr.Body.String(), `type="password"`, "no password login without auth password")
+
+		rr = login(t, router, map[string]any{"email": "alice@example.com", "email_verified": true})
+		require.Equal(t, http.StatusSeeOther, rr.Code, rr.Body.String())
+		assert.Equal(t, "/", rr.Header().Get("Location"))
+		cookie := authCookie(rr)
+		require.NotNil(t, cookie)
+
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.AddCookie(cookie)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "alice@example.com", srv.currentUser(req))
+
+		// basic auth with an empty password is not accepted without auth password
+		req = httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.SetBasicAuth("weblist", "")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+	})
+
+	t.Run("allowed domains and groups", func(t *testing.T) {
+		opts := OIDCOpts{UserClaim: "preferred_username", AllowedDomains: []string{"Example.com"},
+			AllowedGroups: []string{"devops", "admins"}}
+		_, router := setup(t, opts)
+
+		rr := login(t, router, map[string]any{"preferred_username": "bob", "email": "bob@example.com",
+			"groups": []string{"staff", "admins"}})
+		require.Equal(t, http.StatusSeeOther, rr.Code, rr.Body.String())
+		require.NotNil(t, authCookie(rr))
+
+		tests := []struct {
+			name   string
+			claims map[string]any
+		}{
+			{name: "other domain", claims: map[string]any{"preferred_username": "eve", "email": "eve@evil.com", "groups": []string{"admins"}}},
+			{name: "no allowed group", claims: map[string]any{"preferred_username": "carol", "email": "carol@example.com", "groups": "staff"}},
+			{name: "no groups", claims: map[string]any{"preferred_username": "dave", "email": "dave@example.com"}},
+			{name: "unverified email", claims: map[string]any{"preferred_username": "frank", "email": "frank@example.com",
+				"email_verified": false, "groups": []string{"admins"}}},
+			{name: "no user claim", claims: map[string]any{"email": "grace@example.com", "groups": []string{"admins"}}},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				_, router := setup(t, opts) // own login rate limiter
+				rr := login(t, router, tc.claims)
+				assert.Equal(t, http.StatusOK, rr.Code)
+				assert.Contains(t, rr.Body.String(), "Access denied")
+				assert.Nil(t, authCookie(rr))
+			})
+		}
+	})
+
+	t.Run("bad callback", func(t *testing.T) {
+		_, router := setup(t, OIDCOpts{})
+
+		// state without the cookie of the login
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/oidc/callback?code=x&state=y", http.NoBody))
+		assert.Contains(t, rr.Body.String(), "Login expired")
+		assert.Nil(t, authCookie(rr))
+
+		// tampered state cookie
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/oidc/login", http.NoBody))
+		cookie := rr.Result().Cookies()[0]
+		authURL, err := url.Parse(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		tampered := "a"
+		if cookie.Value[0] == 'a' {
+			tampered = "b"
+		}
+		cookie.Value = tampered + cookie.Value[1:]
+		req := httptest.NewRequest(http.MethodGet, "/oidc/callback?code=x&state="+authURL.Query().Get("state"), http.NoBody)
+		req.AddCookie(cookie)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Contains(t, rr.Body.String(), "Login expired")
+
+		// unknown code is rejected by the provider
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/oidc/login", http.NoBody))
+		authURL, err = url.Parse(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		req = httptest.NewRequest(http.MethodGet, "/oidc/callback?code=unknown&state="+authURL.Query().Get("state"), http.NoBody)
+		req.AddCookie(rr.Result().Cookies()[0])
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Contains(t, rr.Body.String(), "Login with SSO failed")
+
+		// error reported by the provider
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/oidc/callback?error=access_denied", http.NoBody))
+		assert.Contains(t, rr.Body.String(), "Login with SSO failed")
+	})
+
+	t.Run("password login is kept", func(t *testing.T) {
+		srv, router := setup(t, OIDCOpts{})
+		srv.Auth = "password"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", http.NoBody))
+		assert.Contains(t, rr.Body.String(), "Sign in with SSO")
+		assert.Contains(t, rr.Body.String(), `type="password"`)
+
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.AddCookie(&http.Cookie{Name: "auth", Value: srv.generateSessionToken()})
+		assert.Equal(t, "weblist", srv.currentUser(req), "password session is of the configured user")
+	})
+
+	_, err := NewOIDCAuth(context.Background(), OIDCOpts{Issuer: issuer.URL + "/missing", ClientID: "weblist-client"})
+	require.Error(t, err)
+}
+
+func TestUserSessionToken(t *testing.T) {
+	srv := &Web{Config: Config{SessionSecret: "secret"}}
+	token := srv.generateUserSessionToken("alice@example.com")
+	assert.True(t, srv.validateSessionToken(token))
+	assert.False(t, srv.validateScopedToken(token, "docs"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.AddCookie(&http.Cookie{Name: "auth", Value: token})
+	assert.Equal(t, "alice@example.com", srv.sessionUser(req))
+
+	// the user is signed
+	other := base64.RawURLEncoding.EncodeToString([]byte("admin"))
+	tokenID, rest, _ := strings.Cut(token, ".")
+	uuidPart, _, _ := strings.Cut(tokenID, "!")
+	req = httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.AddCookie(&http.Cookie{Name: "auth", Value: uuidPart + "!" + other + "." + rest})
+	assert.Empty(t, srv.sessionUser(req))
+}
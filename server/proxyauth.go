@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ProxyAuthOpts defines options for authentication by a reverse proxy, e.g. oauth2-proxy or Authelia
+type ProxyAuthOpts struct {
+	UserHeader    string   // header with the authenticated user, e.g. X-Forwarded-User
+	GroupsHeader  string   // header with comma-separated groups of the user, groups are not checked if empty
+	AllowedGroups []string // groups allowed in, any if empty
+	Trusted       []string // addresses or CIDRs of proxies allowed to set the headers
+}
+
+// ProxyAuth takes the user from headers set by a trusted reverse proxy which has authenticated it.
+// the headers are ignored on requests coming from any other address, as clients could set them.
+type ProxyAuth struct {
+	userHeader    string
+	groupsHeader  string
+	allowedGroups []string
+	trusted       []netip.Prefix
+}
+
+// NewProxyAuth makes authentication by trusted proxy headers, at least one trusted proxy is required
+func NewProxyAuth(opts ProxyAuthOpts) (*ProxyAuth, error) {
+	if opts.UserHeader == "" {
+		return nil, fmt.Errorf("user header is required")
+	}
+	if len(opts.Trusted) == 0 {
+		return nil, fmt.Errorf("at least one trusted proxy is required")
+	}
+	res := &ProxyAuth{userHeader: opts.UserHeader, groupsHeader: opts.GroupsHeader, allowedGroups: opts.AllowedGroups}
+	for _, t := range opts.Trusted {
+		prefix, err := parseTrustedProxy(t)
+		if err != nil {
+			return nil, err
+		}
+		res.trusted = append(res.trusted, prefix)
+	}
+	return res, nil
+}
+
+// parseTrustedProxy parses an address or CIDR of a trusted proxy
+func parseTrustedProxy(s string) (netip.Prefix, error) {
+	prefix, err := parsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+	}
+	return prefix, nil
+}
+
+// parsePrefix parses an address or CIDR, a single address is a prefix of its full length
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// user returns the user set by a trusted proxy, empty if the request didn't come from one,
+// the header is missing or the user is not in allowed groups
+func (p *ProxyAuth) user(r *http.Request) string {
+	if p == nil || !p.isTrusted(peerAddr(r)) {
+		return ""
+	}
+	user := strings.TrimSpace(r.Header.Get(p.userHeader))
+	if user == "" {
+		return ""
+	}
+	if len(p.allowedGroups) > 0 && !slices.ContainsFunc(p.groups(r), func(g string) bool { return slices.Contains(p.allowedGroups, g) }) {
+		return ""
+	}
+	return user
+}
+
+// groups returns groups of the user from the groups header
+func (p *ProxyAuth) groups(r *http.Request) []string {
+	if p.groupsHeader == "" {
+		return nil
+	}
+	var res []string
+	for _, v := range r.Header.Values(p.groupsHeader) {
+		for g := range strings.SplitSeq(v, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				res = append(res, g)
+			}
+		}
+	}
+	return res
+}
+
+// isTrusted checks the address of the connection belongs to a trusted proxy
+func (p *ProxyAuth) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(p.trusted, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+type peerAddrKey struct{}
+
+// keepPeerAddr keeps the address of the connection before rest.RealIP replaces RemoteAddr with the client address
+// from forwarding headers, as only the connection tells whether the request came through a trusted proxy
+func keepPeerAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerAddrKey{}, r.RemoteAddr)))
+	})
+}
+
+// peerAddr returns the address of the connection the request came from
+func peerAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(peerAddrKey{}).(string); ok {
+		return addr
+	}
+	return r.RemoteAddr
+}
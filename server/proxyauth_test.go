@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProxyAuth(t *testing.T) {
+	_, err := NewProxyAuth(ProxyAuthOpts{Trusted: []string{"10.0.0.1"}})
+	require.ErrorContains(t, err, "user header is required")
+	_, err = NewProxyAuth(ProxyAuthOpts{UserHeader: "X-Forwarded-User"})
+	require.ErrorContains(t, err, "trusted proxy is required")
+	_, err = NewProxyAuth(ProxyAuthOpts{UserHeader: "X-Forwarded-User", Trusted: []string{"10.0.0.0/33"}})
+	require.ErrorContains(t, err, `invalid trusted proxy "10.0.0.0/33"`)
+
+	p, err := NewProxyAuth(ProxyAuthOpts{UserHeader: "X-Forwarded-User", Trusted: []string{"10.0.0.0/8", "::1"}})
+	require.NoError(t, err)
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3:5000", true},
+		{"[::ffff:10.1.2.3]:5000", true},
+		{"[::1]:5000", true},
+		{"192.168.1.5:5000", false},
+		{"[::2]:5000", false},
+		{"garbage", false},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, p.isTrusted(tc.addr), tc.addr)
+	}
+}
+
+func TestProxyAuth(t *testing.T) {
+	setup := func(t *testing.T, auth string, opts ProxyAuthOpts) http.Handler {
+		t.Helper()
+		opts.UserHeader, opts.Trusted = "X-Forwarded-User", []string{"10.0.0.0/8"}
+		proxy, err := NewProxyAuth(opts)
+		require.NoError(t, err)
+		srv := &Web{Config: Config{RootDir: "testdata", Auth: auth, SessionSecret: "session-secret", Title: "Test"},
+			FS: os.DirFS("testdata"), Proxy: proxy}
+		require.NoError(t, srv.initTemplates())
+		router, err := srv.router()
+		require.NoError(t, err)
+		return router
+	}
+
+	get := func(router http.Handler, target, peer string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.RemoteAddr = peer
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("user of trusted proxy", func(t *testing.T) {
+		router := setup(t, "", ProxyAuthOpts{})
+		rr := get(router, "/", "10.0.0.1:4000", map[string]string{"X-Forwarded-User": "alice", "X-Forwarded-For": "203.0.113.7"})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `<span class="current-user" title="Signed in as alice">alice</span>`)
+		assert.NotContains(t, rr.Body.String(), `href="/logout"`, "logout is up to the proxy")
+
+		rr = get(router, "/", "10.0.0.1:4000", nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "proxy didn't set the user")
+	})
+
+	t.Run("headers of untrusted client ignored", func(t *testing.T) {
+		router := setup(t, "", ProxyAuthOpts{})
+		rr := get(router, "/", "192.168.1.5:4000", map[string]string{"X-Forwarded-User": "alice", "X-Forwarded-For": "10.0.0.1"})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Empty(t, rr.Header().Get("Location"), "no login page to redirect to")
+
+		rr = get(router, "/login", "192.168.1.5:4000", nil)
+		assert.NotEqual(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("allowed groups", func(t *testing.T) {
+		router := setup(t, "", ProxyAuthOpts{GroupsHeader: "X-Forwarded-Groups", AllowedGroups: []string{"admins"}})
+		rr := get(router, "/", "10.0.0.1:4000", map[string]string{"X-Forwarded-User": "alice", "X-Forwarded-Groups": "dev, admins"})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		rr = get(router, "/", "10.0.0.1:4000", map[string]string{"X-Forwarded-User": "bob", "X-Forwarded-Groups": "dev"})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		rr = get(router, "/", "10.0.0.1:4000", map[string]string{"X-Forwarded-User": "bob"})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("password login for direct clients", func(t *testing.T) {
+		router := setup(t, "password", ProxyAuthOpts{})
+		rr := get(router, "/", "10.0.0.1:4000", map[string]string{"X-Forwarded-User": "alice"})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = get(router, "/", "192.168.1.5:4000", map[string]string{"X-Forwarded-User": "alice"})
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+		assert.Equal(t, "/login", rr.Header().Get("Location"))
+		rr = get(router, "/login", "192.168.1.5:4000", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Timeout limits metadata requests to S3, object downloads and uploads are not limited
+const s3Timeout = 30 * time.Second
+
+// s3PartSize is the part size of multipart uploads, minio-go buffers a part in memory per upload
+const s3PartSize = 16 << 20
+
+// S3Opts defines options for S3-compatible object storage
+type S3Opts struct {
+	Endpoint  string // host[:port] of the S3 API, e.g. s3.amazonaws.com
+	Bucket    string // bucket to serve
+	Prefix    string // key prefix the served tree starts at, empty for the whole bucket
+	Region    string // bucket region, detected if empty
+	AccessKey string // access key, AWS credentials from env or ~/.aws/credentials are used if empty
+	SecretKey string // secret key
+	Insecure  bool   // use plain HTTP instead of HTTPS
+
+	Transport http.RoundTripper // custom transport, e.g. with private CA certificates, default transport if nil
+}
+
+// s3Storage serves objects of an S3 bucket as files. Directories are key prefixes delimited by "/",
+// a directory exists as long as any object is stored under it.
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string    // key prefix with a trailing slash, or empty
+	start  time.Time // reported as the mtime of directories, S3 has none
+}
+
+// NewS3Storage makes a storage serving objects of S3-compatible object storage. It checks the bucket is accessible.
+func NewS3Storage(ctx context.Context, opts S3Opts) (Storage, error) {
+	creds := credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, "")
+	if opts.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{}, &credentials.FileAWSCredentials{}, &credentials.EnvMinio{},
+		})
+	}
+	client, err := minio.New(opts.Endpoint, &minio.Options{Creds: creds, Secure: !opts.Insecure, Region: opts.Region,
+		Transport: opts.Transport})
+	if err != nil {
+		return nil, fmt.Errorf("failed to make S3 client for %s: %w", opts.Endpoint, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+	ok, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access bucket %s: %w", opts.Bucket, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("bucket %s doesn't exist", opts.Bucket)
+	}
+
+	prefix := strings.Trim(opts.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3Storage{client: client, bucket: opts.Bucket, prefix: prefix, start: time.Now()}, nil
+}
+
+// Open implements fs.FS, a file is streamed from S3 as it's read
+func (s *s3Storage) Open(name string) (fs.File, error) {
+	info, err := s.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &virtualDir{info: info, entries: entries}, nil
+	}
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: s.mapErr(err)}
+	}
+	return &s3File{Object: obj, info: info}, nil
+}
+
+// Stat implements fs.StatFS
+func (s *s3Storage) Stat(name string) (fs.FileInfo, error) {
+	return s.stat("stat", name)
+}
+
+// ReadDir implements fs.ReadDirFS, objects become files and common prefixes become directories
+func (s *s3Storage) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	dirKey := s.dirKey(name)
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	var res []fs.DirEntry
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: dirKey}) {
+		if obj.Err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: s.mapErr(obj.Err)}
+		}
+		entryName := strings.TrimPrefix(obj.Key, dirKey)
+		if entryName == "" {
+			continue // directory marker object of the listed directory itself
+		}
+		if dirName, ok := strings.CutSuffix(entryName, "/"); ok {
+			res = append(res, fs.FileInfoToDirEntry(s.dirInfo(dirName)))
+			continue
+		}
+		res = append(res, fs.FileInfoToDirEntry(s.objectInfo(obj)))
+	}
+
+	if len(res) == 0 && name != "." {
+		// an empty listing is either a missing directory or a file
+		info, err := s.stat("readdir", name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+		}
+	}
+	slices.SortFunc(res, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return res, nil
+}
+
+// Create implements Storage. The object is written when the writer is closed, S3 has no partial objects.
+// the existence check is repeated by the upload itself with a conditional write where S3 supports it.
+func (s *s3Storage) Create(name string) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, err := s.stat("create", name); err == nil {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+
+	opts := minio.PutObjectOptions{ContentType: mime.TypeByExtension(path.Ext(name)), PartSize: s3PartSize}
+	opts.SetMatchETagExcept("*")
+	pr, pw := io.Pipe()
+	w := &s3Writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := s.client.PutObject(context.Background(), s.bucket, s.key(name), pr, -1, opts)
+		if err != nil {
+			err = &fs.PathError{Op: "create", Path: name, Err: s.mapErr(err)}
+		}
+		_ = pr.CloseWithError(err) // unblock the writer if the upload failed early
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// Rename implements Storage, S3 has no rename and the object is copied and then deleted
+func (s *s3Storage) Rename(oldName, newName string) error {
+	for _, name := range []string{oldName, newName} {
+		if !fs.ValidPath(name) || name == "." {
+			return &fs.PathError{Op: "rename", Path: name, Err: fs.ErrInvalid}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	dst := minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(newName)}
+	src := minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(oldName)}
+	if _, err := s.client.CopyObject(ctx, dst, src); err != nil {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: s.mapErr(err)}
+	}
+	return s.Remove(oldName)
+}
+
+// Remove implements Storage
+func (s *s3Storage) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	if err := s.client.RemoveObject(ctx, s.bucket, s.key(name), minio.RemoveObjectOptions{}); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: s.mapErr(err)}
+	}
+	return nil
+}
+
+// stat returns info of an object, or of a directory if there is no object and something is stored under the name
+func (s *s3Storage) stat(op, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return s.dirInfo("."), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	obj, err := s.client.StatObject(ctx, s.bucket, s.key(name), minio.StatObjectOptions{})
+	if err == nil {
+		return s.objectInfo(obj), nil
+	}
+	if err = s.mapErr(err); !errors.Is(err, fs.ErrNotExist) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.dirKey(name), MaxKeys: 1}) {
+		if obj.Err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: s.mapErr(obj.Err)}
+		}
+		return s.dirInfo(path.Base(name)), nil
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// key returns the object key of the file
+func (s *s3Storage) key(name string) string {
+	return s.prefix + name
+}
+
+// dirKey returns the key prefix of objects in the directory
+func (s *s3Storage) dirKey(name string) string {
+	if name == "." {
+		return s.prefix
+	}
+	return s.prefix + name + "/"
+}
+
+func (s *s3Storage) dirInfo(name string) fs.FileInfo {
+	return &virtualFileInfo{name: name, mode: fs.ModeDir | 0o755, modTime: s.start, isDir: true}
+}
+
+// objectInfo describes an object, mtime is truncated to seconds as listings have more precision than HEAD
+func (s *s3Storage) objectInfo(obj minio.ObjectInfo) fs.FileInfo {
+	return &s3ObjectInfo{virtualFileInfo: virtualFileInfo{name: path.Base(obj.Key), size: obj.Size, mode: 0o644,
+		modTime: obj.LastModified.Truncate(time.Second)}, etag: obj.ETag}
+}
+
+// mapErr translates S3 error codes to fs errors, so callers can check them with errors.Is
+func (s *s3Storage) mapErr(err error) error {
+	resp := minio.ToErrorResponse(err)
+	switch {
+	case resp.Code == minio.NoSuchKey || resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	case resp.Code == "PreconditionFailed" || resp.StatusCode == http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %w", fs.ErrExist, err)
+	case resp.Code == "AccessDenied" || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %w", fs.ErrPermission, err)
+	}
+	return err
+}
+
+// s3ObjectInfo describes an object, its ETag changes when the object is overwritten within the same second
+type s3ObjectInfo struct {
+	virtualFileInfo
+	etag string
+}
+
+// contentVersion returns the ETag and the size of the object
+func (i *s3ObjectInfo) contentVersion() string {
+	if i.etag == "" {
+		return ""
+	}
+	return fmt.Sprintf("etag:%s:%d", i.etag, i.size)
+}
+
+// s3File is an object opened for reading, it supports Seek and ReadAt needed for ranges and SFTP
+type s3File struct {
+	*minio.Object
+	info fs.FileInfo
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// s3Writer streams written content to a background PutObject
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) { return w.pw.Write(p) }
+
+// Close completes the upload and returns its error
+func (w *s3Writer) Close() error {
+	_ = w.pw.Close()
+	return <-w.done
+}
+
+// Abort cancels the upload, the object is not created with the content written so far
+func (w *s3Writer) Abort(err error) error {
+	_ = w.pw.CloseWithError(err)
+	<-w.done // fails with the error, as the upload gets it reading the pipe
+	return nil
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupS3Storage starts a fake S3 server with the bucket holding the files and returns storage serving the prefix
+func setupS3Storage(t *testing.T, prefix string, files map[string]string) Storage {
+	t.Helper()
+	backend := s3mem.New()
+	require.NoError(t, backend.CreateBucket("test-bucket"))
+	// plain HTTP makes minio-go sign uploads chunk by chunk, which the fake server doesn't decode for parts
+	ts := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	t.Cleanup(ts.Close)
+
+	opts := S3Opts{Endpoint: strings.TrimPrefix(ts.URL, "https://"), Bucket: "test-bucket", Region: "us-east-1",
+		AccessKey: "key", SecretKey: "secret", Transport: ts.Client().Transport}
+	bucket, err := NewS3Storage(context.Background(), opts)
+	require.NoError(t, err)
+	for key, content := range files {
+		if strings.HasSuffix(key, "/") { // directory marker, can't be created through the storage
+			_, err = backend.PutObject("test-bucket", key, map[string]string{"Last-Modified": time.Now().Format(http.TimeFormat)},
+				strings.NewReader(""), 0, nil)
+			require.NoError(t, err)
+			continue
+		}
+		w, err := bucket.Create(key)
+		require.NoError(t, err)
+		_, err = io.WriteString(w, content)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+
+	opts.Prefix = prefix
+	s, err := NewS3Storage(context.Background(), opts)
+	require.NoError(t, err)
+	return s
+}
+
+func TestS3Storage(t *testing.T) {
+	s := setupS3Storage(t, "/served/", map[string]string{
+		"served/readme.md":         "# readme",
+		"served/docs/guide.txt":    "guide",
+		"served/docs/deep/a.txt":   "deep",
+		"served/empty/":            "", // directory marker
+		"other/secret.txt":         "outside of the prefix",
+		"servedx/not-a-prefix.txt": "outside of the prefix",
+	})
+
+	require.NoError(t, fstest.TestFS(s, "readme.md", "docs/guide.txt", "docs/deep/a.txt", "empty"))
+
+	entries, err := fs.ReadDir(s, ".")
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"docs", "empty", "readme.md"}, names)
+
+	info, err := fs.Stat(s, "docs/guide.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "guide.txt", info.Name())
+	assert.Equal(t, int64(5), info.Size())
+	assert.False(t, info.IsDir())
+
+	info, err = fs.Stat(s, "docs")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	_, err = fs.Stat(s, "missing.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fs.ReadDir(s, "missing")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fs.ReadDir(s, "readme.md")
+	require.Error(t, err)
+	_, err = s.Open("../other/secret.txt")
+	require.ErrorIs(t, err, fs.ErrInvalid)
+
+	t.Run("seek and read at", func(t *testing.T) {
+		f, err := s.Open("readme.md")
+		require.NoError(t, err)
+		defer f.Close()
+		rs, ok := f.(io.ReadSeeker)
+		require.True(t, ok)
+		_, err = rs.Seek(2, io.SeekStart)
+		require.NoError(t, err)
+		data, err := io.ReadAll(rs)
+		require.NoError(t, err)
+		assert.Equal(t, "readme", string(data))
+
+		buf := make([]byte, 4)
+		_, err = f.(io.ReaderAt).ReadAt(buf, 3)
+		require.NoError(t, err)
+		assert.Equal(t, "eadm", string(buf))
+	})
+
+	t.Run("create, rename and remove", func(t *testing.T) {
+		w, err := s.Create("docs/new.txt")
+		require.NoError(t, err)
+		_, err = io.WriteString(w, "new content")
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		data, err := fs.ReadFile(s, "docs/new.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "new content", string(data))
+
+		_, err = s.Create("docs/new.txt")
+		require.ErrorIs(t, err, fs.ErrExist)
+
+		require.NoError(t, s.Rename("docs/new.txt", "docs/guide.txt"))
+		data, err = fs.ReadFile(s, "docs/guide.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "new content", string(data))
+		_, err = fs.Stat(s, "docs/new.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		require.NoError(t, s.Remove("docs/guide.txt"))
+		_, err = fs.Stat(s, "docs/guide.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+func TestS3Storage_MissingBucket(t *testing.T) {
+	ts := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	defer ts.Close()
+	_, err := NewS3Storage(context.Background(), S3Opts{Endpoint: strings.TrimPrefix(ts.URL, "http://"),
+		Bucket: "missing", Region: "us-east-1", AccessKey: "key", SecretKey: "secret", Insecure: true})
+	require.ErrorContains(t, err, "bucket missing doesn't exist")
+}
+
+func TestS3Storage_WebServer(t *testing.T) {
+	s := setupS3Storage(t, "", map[string]string{"docs/guide.txt": "guide"})
+	srv := &Web{Config: Config{ListenAddr: ":0", Theme: "light", EnableUpload: true, UploadMaxSize: 1 << 20},
+		FS: s}
+	require.NoError(t, srv.initTemplates())
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/docs/guide.txt", http.NoBody))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "guide", rr.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/docs/guide.txt", http.NoBody)
+	req.Header.Set("Range", "bytes=1-3")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "uid", rr.Body.String())
+
+	// uploads go to the bucket, including overwrites through a temporary object
+	rr = httptest.NewRecorder()
+	srv.handleUpload(rr, createMultipartRequest(t, map[string]string{"new.txt": "uploaded"}, map[string]string{"path": "docs"}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	data, err := fs.ReadFile(s, "docs/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "uploaded", string(data))
+
+	rr = httptest.NewRecorder()
+	srv.handleUpload(rr, createMultipartRequest(t, map[string]string{"new.txt": "again"}, map[string]string{"path": "docs"}))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	srv.UploadOverwrite = true
+	rr = httptest.NewRecorder()
+	srv.handleUpload(rr, createMultipartRequest(t, map[string]string{"new.txt": "replaced"}, map[string]string{"path": "docs"}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	data, err = fs.ReadFile(s, "docs/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", string(data))
+	entries, err := fs.ReadDir(s, "docs")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary objects left")
+
+	rr = httptest.NewRecorder()
+	srv.handleUpload(rr, createMultipartRequest(t, map[string]string{"new.txt": "x"}, map[string]string{"path": "missing"}))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
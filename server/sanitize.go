@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// htmlPolicy sanitizes HTML files shown in the view, scripts, event handlers, frames, forms and other active
+// content are removed. classes, simple styles and data URI images are kept for the page to look close to
+// the original.
+var htmlPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Globally()
+	p.AllowAttrs("border", "align", "valign", "width", "height", "colspan", "rowspan").OnElements("table", "th", "td", "tr", "img")
+	p.AllowStyles("color", "background-color", "text-align", "vertical-align", "font-weight", "font-style", "font-size",
+		"text-decoration", "white-space", "width", "max-width", "height", "margin", "padding", "border").Globally()
+	p.AllowDataURIImages()
+	p.SkipElementsContent("head", "title")
+	return p
+}()
+
+// htmlSandboxPolicy is the CSP of HTML files served as they are, their scripts run in an origin of their own
+// without access to cookies and pages of the server
+const htmlSandboxPolicy = "sandbox allow-scripts allow-forms allow-popups"
+
+// svgElements are the SVG elements kept by sanitizeSVG, others are removed with their content
+var svgElements = func() map[string]bool {
+	res := map[string]bool{}
+	for _, name := range strings.Fields(`svg g defs symbol use title desc switch view a image
+		path rect circle ellipse line polyline polygon text tspan textPath style
+		clipPath mask pattern marker linearGradient radialGradient stop
+		filter feBlend feColorMatrix feComponentTransfer feComposite feConvolveMatrix feDiffuseLighting
+		feDisplacementMap feDistantLight feDropShadow feFlood feFuncA feFuncB feFuncG feFuncR feGaussianBlur
+		feImage feMerge feMergeNode feMorphology feOffset fePointLight feSpecularLighting feSpotLight feTile
+		feTurbulence animate animateMotion animateTransform set mpath`) {
+		res[name] = true
+	}
+	return res
+}()
+
+var (
+	// cssURL matches url() references in styles, only references to fragments of the document are kept
+	cssURL = regexp.MustCompile(`(?i)url\(\s*((?:[^()]|\([^()]*\))*)\)`)
+	// cssImport matches @import rules of style elements
+	cssImport = regexp.MustCompile(`(?i)@import[^;]*;?`)
+)
+
+// svgNamespace is the default namespace of SVG documents
+const svgNamespace = "http://www.w3.org/2000/svg"
+
+// sanitizeSVG makes an SVG image safe to show in a page. it keeps the SVG elements known to draw,
+// and removes scripts, foreign objects, event handlers, links with scripts and styles loading other
+// resources. elements and attributes of other namespaces, e.g. editor metadata, are removed too.
+func sanitizeSVG(content []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	var buf strings.Builder
+	skip := 0        // depth inside a removed element
+	inStyle := false // inside a style element, its text is a style sheet
+	root := false
+	var open []xml.Name // elements not closed yet
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("svg parse: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(open) == 0 && (t.Name.Local != "svg" || t.Name.Space != "") {
+				return "", fmt.Errorf("svg parse: root element is <%s>, not <svg>", xmlName(t.Name))
+			}
+			open = append(open, t.Name)
+			if skip > 0 || !svgElementAllowed(t) {
+				skip++
+				continue
+			}
+			buf.WriteString("<" + t.Name.Local)
+			if !root && !slices.ContainsFunc(t.Attr, func(a xml.Attr) bool { return a.Name == xml.Name{Local: "xmlns"} }) {
+				buf.WriteString(` xmlns="` + svgNamespace + `"`) // images are drawn only with the namespace
+			}
+			root = true
+			for _, attr := range t.Attr {
+				value, ok := svgAttrValue(t.Name.Local, attr)
+				if !ok {
+					continue
+				}
+				buf.WriteString(" " + xmlName(attr.Name) + `="`)
+				_ = xml.EscapeText(&buf, []byte(value)) // writes to strings.Builder never fail
+				buf.WriteString(`"`)
+			}
+			buf.WriteString(">")
+			inStyle = t.Name.Local == "style"
+		case xml.EndElement:
+			// raw tokens aren't checked by the decoder, an unbalanced end tag would close elements of the page
+			if len(open) == 0 || open[len(open)-1] != t.Name {
+				return "", fmt.Errorf("svg parse: unexpected end element </%s>", xmlName(t.Name))
+			}
+			open = open[:len(open)-1]
+			if skip > 0 {
+				skip--
+				continue
+			}
+			buf.WriteString("</" + t.Name.Local + ">")
+			inStyle = false
+		case xml.CharData:
+			if skip > 0 {
+				continue
+			}
+			text := string(t)
+			if inStyle {
+				text = sanitizeCSS(text)
+			}
+			_ = xml.EscapeText(&buf, []byte(text))
+		}
+	}
+	if !root {
+		return "", errors.New("svg parse: no svg element")
+	}
+	if len(open) > 0 {
+		return "", fmt.Errorf("svg parse: element <%s> not closed", xmlName(open[len(open)-1]))
+	}
+	return buf.String(), nil
+}
+
+// svgElementAllowed checks if an SVG element is kept. animations are removed if they change links or
+// event handlers, as they could set them to scripts.
+func svgElementAllowed(el xml.StartElement) bool {
+	if el.Name.Space != "" || !svgElements[el.Name.Local] {
+		return false
+	}
+	switch el.Name.Local {
+	case "animate", "animateMotion", "animateTransform", "set":
+		for _, attr := range el.Attr {
+			if attr.Name.Local != "attributeName" {
+				continue
+			}
+			name := strings.ToLower(strings.TrimSpace(attr.Value))
+			if strings.HasSuffix(name, "href") || strings.HasPrefix(name, "on") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// svgAttrValue returns the sanitized value of an attribute, false if the attribute is removed
+func svgAttrValue(element string, attr xml.Attr) (string, bool) {
+	switch attr.Name.Space {
+	case "", "xml", "xmlns", "xlink":
+	default:
+		return "", false
+	}
+	name := strings.ToLower(attr.Name.Local)
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return "", false
+	case attr.Name.Space == "xmlns" && attr.Value != "http://www.w3.org/1999/xlink":
+		return "", false // only the namespace of links is used
+	case name == "href":
+		return attr.Value, safeSVGLink(element, attr.Value)
+	case name == "style":
+		return sanitizeCSS(attr.Value), true
+	case element == "animate" || element == "set" || element == "animateTransform" || element == "animateMotion":
+		// values of animations are checked as links, a value can't be a script if it isn't one
+		if name == "to" || name == "from" || name == "values" || name == "by" {
+			return attr.Value, !strings.Contains(strings.ToLower(stripURL(attr.Value)), "script:")
+		}
+	}
+	return attr.Value, true
+}
+
+// safeSVGLink checks if a link of an SVG element is safe: a fragment of the document, a relative link,
+// a http(s) link, or a raster data image for images
+func safeSVGLink(element, link string) bool {
+	link = strings.ToLower(stripURL(link))
+	scheme, _, found := strings.Cut(link, ":")
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return true // fragment or relative link
+	}
+	switch scheme {
+	case "http", "https":
+		return element != "use" // use would include an external document
+	case "data":
+		return (element == "image" || element == "feImage") && (strings.HasPrefix(link, "data:image/png") ||
+			strings.HasPrefix(link, "data:image/jpeg") || strings.HasPrefix(link, "data:image/gif") ||
+			strings.HasPrefix(link, "data:image/webp"))
+	}
+	return false
+}
+
+// stripURL removes whitespace and control characters browsers ignore in URLs, e.g. "java\tscript:"
+func stripURL(link string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, link)
+}
+
+// sanitizeCSS removes imports and references to anything but fragments of the document from styles.
+// styles with escapes are removed, as escapes could spell url( or @import the patterns don't match.
+func sanitizeCSS(css string) string {
+	if strings.Contains(css, `\`) {
+		return ""
+	}
+	css = cssImport.ReplaceAllString(css, "")
+	return cssURL.ReplaceAllStringFunc(css, func(ref string) string {
+		target := strings.Trim(stripURL(cssURL.FindStringSubmatch(ref)[1]), `'"`)
+		if strings.HasPrefix(target, "#") {
+			return ref
+		}
+		return "none"
+	})
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{name: "drawing kept",
+			in:   `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><g><rect width="5" height="5" fill="red"/></g></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><g><rect width="5" height="5" fill="red"></rect></g></svg>`},
+		{name: "script removed", in: `<svg><script>alert(1)</script><circle r="1"/></svg>`, want: `<svg xmlns="http://www.w3.org/2000/svg"><circle r="1"></circle></svg>`},
+		{name: "event handlers removed", in: `<svg onload="alert(1)"><rect ONCLICK="alert(1)" width="1"/></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><rect width="1"></rect></svg>`},
+		{name: "script links removed", in: `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a href="javascript:alert(1)">x</a>` +
+			`<a xlink:href="java&#x09;script:alert(1)">y</a><a href="/docs">z</a></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><a>x</a><a>y</a><a href="/docs">z</a></svg>`},
+		{name: "foreign object removed with content",
+			in:   `<svg><foreignObject><body><iframe src="x"/></body></foreignObject><path d="M0 0"/></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><path d="M0 0"></path></svg>`},
+		{name: "editor namespaces removed",
+			in: `<svg xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" inkscape:version="1.0"><inkscape:grid/>` +
+				`<g inkscape:label="layer"/></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><g></g></svg>`},
+		{name: "external use removed", in: `<svg><use href="https://example.com/a.svg#x"/><use href="#local"/></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><use></use><use href="#local"></use></svg>`},
+		{name: "data images", in: `<svg><image href="data:image/png;base64,AAAA"/><image href="data:image/svg+xml;base64,AAAA"/>` +
+			`<a href="data:text/html,x">x</a></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><image href="data:image/png;base64,AAAA"></image><image></image><a>x</a></svg>`},
+		{name: "styles", in: `<svg><style>@import url(https://example.com/a.css); rect { fill: url(#grad); ` +
+			`background: url('https://example.com/t.png') }</style><rect style="fill: url(javascript:alert(1))"/></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><style> rect { fill: url(#grad); background: none }</style><rect style="fill: none"></rect></svg>`},
+		{name: "escaped css removed", in: `<svg><style>@\69mport "https://example.com/a.css"; rect { fill: \75 rl(https://example.com/t) }</style>` +
+			`<rect style="fill: \75rl(https://example.com/t)" width="1"/></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><style></style><rect style="" width="1"></rect></svg>`},
+		{name: "animations of links removed", in: `<svg><a><set attributeName="href" to="javascript:alert(1)"/>` +
+			`<animate attributeName="xlink:href" values="javascript:alert(1)"/><animate attributeName="opacity" from="0" to="1"/></a></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><a><animate attributeName="opacity" from="0" to="1"></animate></a></svg>`},
+		{name: "script animation values removed", in: `<svg><animate attributeName="fill" values="javascript:alert(1)"/></svg>`,
+			want: `<svg xmlns="http://www.w3.org/2000/svg"><animate attributeName="fill"></animate></svg>`},
+		{name: "text escaped", in: `<svg><text>a &lt;b&gt; &amp; c</text></svg>`, want: `<svg xmlns="http://www.w3.org/2000/svg"><text>a &lt;b&gt; &amp; c</text></svg>`},
+		{name: "not svg root", in: `<html><svg/></html>`, wantErr: "root element is <html>, not <svg>"},
+		{name: "no elements", in: `<!-- empty -->`, wantErr: "no svg element"},
+		{name: "unbalanced end", in: `<svg><g></svg>`, wantErr: "unexpected end element </svg>"},
+		{name: "not closed", in: `<svg><g></g>`, wantErr: "element <svg> not closed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := sanitizeSVG([]byte(tt.in))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, res)
+		})
+	}
+}
+
+func TestHTMLPolicy(t *testing.T) {
+	in := `<html><head><title>Report</title><script>alert(1)</script></head><body onload="alert(1)">` +
+		`<h1 class="title" style="color: red">Report</h1><a href="javascript:alert(1)">bad</a><a href="https://example.com">good</a>` +
+		`<iframe src="https://example.com"></iframe><form action="/x"><input name="a"></form>` +
+		`<img src="data:image/png;base64,AAAA" width="10"><table border="1"><tr><td>1</td></tr></table></body></html>`
+	res := htmlPolicy.Sanitize(in)
+	assert.NotContains(t, res, "script")
+	assert.NotContains(t, res, "onload")
+	assert.NotContains(t, res, "iframe")
+	assert.NotContains(t, res, "<form")
+	assert.NotContains(t, res, "<input")
+	assert.NotContains(t, res, "<title>")
+	assert.Contains(t, res, `<h1 class="title" style="color: red">Report</h1>`)
+	assert.Contains(t, res, `<a href="https://example.com" rel="nofollow">good</a>`)
+	assert.Contains(t, res, `<img src="data:image/png;base64,AAAA" width="10">`)
+	assert.Contains(t, res, `<table border="1">`)
+}
+
+func TestViewHTMLModes(t *testing.T) {
+	dir := t.TempDir()
+	page := `<html><body><h1>Report</h1><script>document.title = "owned"</script></body></html>`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "report.html"), []byte(page), 0o600))
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><style>body { display: none }</style><circle r="5"/></svg>`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "logo.svg"), []byte(svg), 0o600))
+
+	serve := func(mode, target string) *httptest.ResponseRecorder {
+		srv := &Web{Config: Config{RootDir: dir, Title: "Test", Theme: "light", HTMLMode: mode}, FS: os.DirFS(dir)}
+		router, err := srv.router()
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		require.Equal(t, http.StatusOK, rr.Code, target)
+		return rr
+	}
+
+	t.Run("sanitize", func(t *testing.T) {
+		rr := serve("sanitize", "/view/report.html")
+		assert.Contains(t, rr.Header().Values("Content-Security-Policy"), "script-src 'none'", "added to the default policy")
+		body := rr.Body.String()
+		assert.Contains(t, body, `<div class="html-content">`)
+		assert.Contains(t, body, "<h1>Report</h1>")
+		assert.NotContains(t, body, `document.title = "owned"`)
+
+		body = serve("sanitize", "/partials/file-modal?path=report.html").Body.String()
+		assert.Contains(t, body, `sandbox="allow-same-origin allow-popups"`)
+	})
+
+	t.Run("sandbox", func(t *testing.T) {
+		rr := serve("sandbox", "/view/report.html")
+		assert.Equal(t, htmlSandboxPolicy, rr.Header().Get("Content-Security-Policy"))
+		assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+		assert.Equal(t, page, rr.Body.String(), "served as it is")
+
+		body := serve("sandbox", "/partials/file-modal?path=report.html").Body.String()
+		assert.Contains(t, body, `sandbox="allow-scripts allow-forms allow-popups"`)
+		assert.NotContains(t, body, "allow-same-origin")
+	})
+
+	t.Run("text", func(t *testing.T) {
+		rr := serve("text", "/view/report.html")
+		body := rr.Body.String()
+		assert.NotContains(t, body, `<div class="html-content">`)
+		assert.Contains(t, body, "&lt;h1&gt;Report&lt;/h1&gt;")
+
+		body = serve("text", "/partials/file-modal?path=report.html").Body.String()
+		assert.NotContains(t, body, "sandbox=")
+	})
+
+	t.Run("svg", func(t *testing.T) {
+		rr := serve("sanitize", "/view/logo.svg")
+		assert.Contains(t, rr.Header().Values("Content-Security-Policy"), "script-src 'none'")
+		body := rr.Body.String()
+		assert.Contains(t, body, `<a href="?mode=image&theme=light" class="active">Image</a>`)
+		// the image is not inlined, its styles can't apply to the page
+		assert.NotContains(t, body, "display: none")
+		assert.NotContains(t, body, "alert(1)")
+		img := regexp.MustCompile(`<div class="svg-content"><img src="data:image/svg\+xml;base64,([^"]+)" alt="logo.svg"></div>`).
+			FindStringSubmatch(body)
+		require.Len(t, img, 2)
+		decoded, err := base64.StdEncoding.DecodeString(img[1])
+		require.NoError(t, err)
+		assert.Equal(t, `<svg xmlns="http://www.w3.org/2000/svg"><style>body { display: none }</style><circle r="5"></circle></svg>`,
+			string(decoded), "sanitized")
+
+		rr = serve("sanitize", "/view/logo.svg?mode=tree")
+		assert.Contains(t, rr.Body.String(), `<div class="data-tree">`)
+		assert.NotContains(t, rr.Header().Values("Content-Security-Policy"), "script-src 'none'", "tree view keeps its script")
+	})
+}
@@ -30,17 +30,34 @@ var content embed.FS
 // Web represents the web server.
 type Web struct {
 	Config
-	FS fs.FS
+	FS       fs.FS
+	Mounts   []Mount       // optional, served as top-level directories in place of FS
+	Git      *GitRepo      // optional, FS is the repository at its default ref and users can switch refs
+	OIDC     *OIDCAuth     // optional, login with an OpenID Connect provider in addition to or instead of password
+	Proxy    *ProxyAuth    // optional, users authenticated by a trusted reverse proxy
+	TOTP     *TOTPStore    // optional, password login requires a one-time code as the second factor
+	Sessions *SessionStore // optional, login sessions are tracked on the server and can be revoked
+	Guard    *AuthGuard    // optional, failed logins are slowed down and locked out, shared with SFTP
+	Access   *AccessList   // optional, clients are allowed or denied by address
+	Throttle *Throttle     // optional, limits bandwidth and concurrent downloads, shared with SFTP
+	Metrics  *Metrics      // optional, metrics are not collected if nil
+	Audit    *AuditLogger  // optional, file access is not audited if nil
 
 	// cached templates
 	templates struct {
-		initialized   bool
-		indexTemplate *template.Template
-		fileTemplate  *template.Template
-		loginTemplate *template.Template
+		initialized      bool
+		indexTemplate    *template.Template
+		fileTemplate     *template.Template
+		loginTemplate    *template.Template
+		sessionsTemplate *template.Template
 	}
 
-	binaryCache lcw.LoadingCache[bool] // caches binary detection results by path+mtime
+	binaryCache   lcw.LoadingCache[bool]         // caches binary detection results by path+mtime
+	checksumCache lcw.LoadingCache[string]       // caches file checksums by algorithm, path and content version
+	viewCache     lcw.LoadingCache[viewFileData] // caches rendered text views by git ref, path, mtime, size and theme
+	lineIndexes   lcw.LoadingCache[*lineIndex]   // keeps line indexes of paged text files by git ref and path
+	csvTables     lcw.LoadingCache[*csvTable]    // keeps parsed tables of the table view by git ref, path, mtime and size
+	dirIndex      *dirIndex                      // recursive mtime and size per directory, nil unless RecursiveMtime is set
 }
 
 // Config represents server configuration.
@@ -62,14 +79,24 @@ type Config struct {
 	BrandName                string        // company or organization name for branding
 	BrandColor               string        // color for navbar
 	EnableSyntaxHighlighting bool          // whether to enable syntax highlighting for code files
+	HTMLMode                 string        // how HTML files are viewed: sanitize (default), sandbox or text
 	CustomFooter             string        // custom footer text (can contain HTML)
 	InsecureCookies          bool          // allow cookies without secure flag
 	SessionTTL               time.Duration // session timeout duration
 	EnableMultiSelect        bool          // enable multi-file selection and download
 	RecursiveMtime           bool          // calculate directory mtime from newest nested file
+	RecursiveMtimeRefresh    time.Duration // interval between background rescans of the recursive mtime index
 	EnableUpload             bool          // enable file upload support
 	UploadMaxSize            int64         // max upload size in bytes
 	UploadOverwrite          bool          // allow overwriting existing files on upload
+	MetricsListen            string        // separate address for the metrics endpoint, served on ListenAddr if empty
+	TLSCert                  string        // TLS certificate file, HTTPS is served if set, reloaded on change
+	TLSKey                   string        // TLS private key file
+	TLSSelfSigned            bool          // serve HTTPS with a generated self-signed certificate if TLSCert is not set
+	TLSRedirect              string        // address of a plain HTTP listener redirecting to HTTPS, disabled if empty
+	TLSClientCA              string        // CA certificates file verifying client certificates, subject becomes the user
+	Compress                 bool          // compress text responses and serve precompressed siblings of files
+	CompressMinSize          int           // smallest response compressed in bytes, 1024 if not set
 }
 
 // Run starts the web server.
@@ -94,6 +121,11 @@ func (wb *Web) Run(ctx context.Context) error {
 		log.Printf("[INFO] generated random session secret during startup")
 	}
 
+	// mounts replace the single root filesystem with their combination
+	if len(wb.Mounts) > 0 {
+		wb.FS = newMountFS(wb.Mounts)
+	}
+
 	// initialize binary detection cache
 	if wb.binaryCache == nil {
 		var cacheErr error
@@ -101,6 +133,56 @@ func (wb *Web) Run(ctx context.Context) error {
 		if cacheErr != nil {
 			return fmt.Errorf("failed to create binary cache: %w", cacheErr)
 		}
+		wb.Metrics.watchBinaryCache(wb.binaryCache)
+	}
+
+	// initialize rendered views cache, limited by the size of the content
+	if wb.viewCache == nil {
+		var cacheErr error
+		wb.viewCache, cacheErr = lcw.NewLruCache(lcw.NewOpts[viewFileData]().MaxKeys(1000),
+			lcw.NewOpts[viewFileData]().MaxValSize(4*1024*1024), lcw.NewOpts[viewFileData]().MaxCacheSize(64*1024*1024))
+		if cacheErr != nil {
+			return fmt.Errorf("failed to create view cache: %w", cacheErr)
+		}
+	}
+
+	// initialize line indexes of paged text files
+	if wb.lineIndexes == nil {
+		var cacheErr error
+		wb.lineIndexes, cacheErr = lcw.NewLruCache(lcw.NewOpts[*lineIndex]().MaxKeys(lineIndexCacheCount))
+		if cacheErr != nil {
+			return fmt.Errorf("failed to create line index cache: %w", cacheErr)
+		}
+	}
+
+	// initialize parsed tables of the table view, limited by the size of their files
+	if wb.csvTables == nil {
+		var cacheErr error
+		wb.csvTables, cacheErr = lcw.NewLruCache(lcw.NewOpts[*csvTable]().MaxKeys(csvTableCacheCount),
+			lcw.NewOpts[*csvTable]().MaxCacheSize(4*maxCSVSize))
+		if cacheErr != nil {
+			return fmt.Errorf("failed to create table cache: %w", cacheErr)
+		}
+	}
+
+	// initialize checksum cache
+	if wb.checksumCache == nil {
+		var cacheErr error
+		wb.checksumCache, cacheErr = lcw.NewLruCache(lcw.NewOpts[string]().MaxKeys(10000))
+		if cacheErr != nil {
+			return fmt.Errorf("failed to create checksum cache: %w", cacheErr)
+		}
+	}
+
+	// serve metrics on a separate address if requested
+	if wb.Metrics != nil && wb.MetricsListen != "" {
+		go wb.runMetricsServer(ctx)
+	}
+
+	// build the recursive mtime index in the background, listings walk directories until it is ready
+	if wb.RecursiveMtime && wb.dirIndex == nil {
+		wb.dirIndex = newDirIndex(wb.FS, wb.shouldExclude)
+		go wb.dirIndex.run(ctx, wb.RecursiveMtimeRefresh)
 	}
 
 	router, err := wb.router()
@@ -110,7 +192,7 @@ func (wb *Web) Run(ctx context.Context) error {
 
 	readTimeout := 10 * time.Second
 	writeTimeout := 30 * time.Second
-	if wb.EnableUpload {
+	if wb.uploadAvailable() {
 		readTimeout = 5 * time.Minute
 		writeTimeout = 5 * time.Minute
 	}
@@ -122,13 +204,25 @@ func (wb *Web) Run(ctx context.Context) error {
 		ReadTimeout:       readTimeout,
 		WriteTimeout:      writeTimeout,
 	}
+	if wb.tlsEnabled() {
+		if srv.TLSConfig, err = wb.tlsConfig(); err != nil {
+			return fmt.Errorf("failed to configure TLS: %w", err)
+		}
+		if wb.TLSRedirect != "" {
+			go wb.runRedirectServer(ctx)
+		}
+	}
 
 	// channel to capture server errors
 	serverErrors := make(chan error, 1)
 
 	// start server in a goroutine
 	go func() {
-		log.Printf("[INFO] starting server on %s with theme: %s, serving from: %s", wb.ListenAddr, wb.Theme, wb.RootDir)
+		log.Printf("[INFO] starting server on %s with theme: %s, serving from: %s", wb.ListenAddr, wb.Theme, servedFrom(wb.RootDir, wb.Mounts))
+		if srv.TLSConfig != nil {
+			serverErrors <- srv.ListenAndServeTLS("", "") // certificate comes from TLSConfig
+			return
+		}
 		serverErrors <- srv.ListenAndServe()
 	}()
 
@@ -197,6 +291,13 @@ func (wb *Web) initTemplates() error {
 	}
 	wb.templates.loginTemplate = loginTemplate
 
+	// parse sessions template
+	sessionsTemplate, err := template.New("sessions.html").Funcs(funcMap).ParseFS(content, "templates/sessions.html")
+	if err != nil {
+		return fmt.Errorf("failed to parse sessions template: %w", err)
+	}
+	wb.templates.sessionsTemplate = sessionsTemplate
+
 	wb.templates.initialized = true
 	return nil
 }
@@ -211,7 +312,9 @@ func (wb *Web) router() (http.Handler, error) {
 	mux := http.NewServeMux()
 	router := routegroup.New(mux)
 
-	router.Use(rest.Trace, rest.RealIP, rest.Recoverer(lgr.Default()))
+	router.Use(rest.Trace, keepPeerAddr, rest.RealIP, rest.Recoverer(lgr.Default()))
+	router.Use(wb.Metrics.Middleware)    // pass-through if metrics are disabled
+	router.Use(wb.Access.middleware("")) // global address rules, pass-through if not set
 	router.Use(rest.Throttle(1000))
 	router.Use(http.NewCrossOriginProtection().Handler)
 
@@ -225,9 +328,11 @@ func (wb *Web) router() (http.Handler, error) {
 	authLimiter.SetMessage("Too many login attempts, please try again later")
 	authLimiter.SetTokenBucketExpirationTTL(10 * time.Minute) // reset after 10 minutes
 
-	router.Use(logger.New(logger.Log(lgr.Default()), logger.Prefix("[DEBUG]")).Handler)
+	// event streams skip the request logger, its writer can't be unwrapped to lift the write deadline
+	router.Use(skipPrefix("/events/", logger.New(logger.Log(lgr.Default()), logger.Prefix("[DEBUG]")).Handler))
 	router.Use(rest.AppInfo("weblist", "umputun", wb.Version), rest.Ping)
 	router.Use(wb.securityHeadersMiddleware) // add security headers to all responses
+	router.Use(wb.compressMiddleware)        // compress text responses, pass-through if disabled
 
 	// serve static assets from embedded filesystem
 	assetsFS, err := fs.Sub(content, "assets")
@@ -237,9 +342,10 @@ func (wb *Web) router() (http.Handler, error) {
 
 	// register upload route in its own group without SizeLimit, so large uploads are allowed.
 	// the upload handler applies its own MaxBytesReader with UploadMaxSize.
-	if wb.EnableUpload {
+	if wb.uploadAvailable() {
 		router.Group().Route(func(uploadGroup *routegroup.Bundle) {
-			if wb.Auth != "" {
+			uploadGroup.Use(wb.Access.middleware(accessUpload))
+			if wb.authEnabled() {
 				uploadGroup.Use(wb.authMiddleware)
 			}
 			uploadGroup.HandleFunc("POST /upload", wb.handleUpload)
@@ -250,8 +356,9 @@ func (wb *Web) router() (http.Handler, error) {
 	router.Group().Route(func(main *routegroup.Bundle) {
 		main.Use(rest.SizeLimit(1024 * 1024)) // 1M max request size
 
-		// add authentication routes if Auth is set
-		if wb.Auth != "" {
+		// add authentication routes if Auth or OIDC is set or any mount has its own password.
+		// users authenticated by a proxy have no login page.
+		if wb.Auth != "" || wb.OIDC != nil || wb.hasMountAuth() {
 			main.HandleFunc("GET /login", wb.handleLoginPage)
 
 			// apply the stricter rate limiter to login submission endpoint
@@ -262,19 +369,49 @@ func (wb *Web) router() (http.Handler, error) {
 
 			main.HandleFunc("GET /logout", wb.handleLogout)
 		}
+		if wb.OIDC != nil {
+			main.HandleFunc("GET /oidc/login", wb.handleOIDCLogin)
+			main.Handle("GET "+oidcCallback, tollbooth.LimitFuncHandler(authLimiter, wb.handleOIDCCallback))
+		}
 
 		main.Group().Route(func(auth *routegroup.Bundle) {
-			if wb.Auth != "" {
+			if wb.authEnabled() {
 				auth.Use(wb.authMiddleware)
 			}
-			auth.HandleFunc("GET /", wb.handleRoot)
-			auth.HandleFunc("GET /partials/dir-contents", wb.handleDirContents)
-			auth.HandleFunc("GET /partials/file-modal", wb.handleFileModal)              // handle modal content
-			auth.HandleFunc("POST /partials/selection-status", wb.handleSelectionStatus) // handle selection update
-			auth.HandleFunc("POST /download-selected", wb.handleDownloadSelected)        // handle multi-file download
-			auth.HandleFunc("GET /view/{path...}", wb.handleViewFile)                    // handle file viewing
-			auth.HandleFunc("GET /api/list", wb.handleAPIList)                           // handle JSON API for file listing
-			auth.HandleFunc("GET /{path...}", wb.handleDownload)                         // handle file downloads with just the path
+			browse := auth.With(wb.Access.middleware(accessBrowse))
+			browse.HandleFunc("GET /", wb.handleRoot)
+			browse.HandleFunc("GET /partials/dir-contents", wb.handleDirContents)
+			browse.HandleFunc("GET /partials/file-modal", wb.handleFileModal)              // handle modal content
+			browse.HandleFunc("POST /partials/selection-status", wb.handleSelectionStatus) // handle selection update
+			if wb.Git != nil {
+				browse.HandleFunc("GET /ref", wb.handleSelectRef) // switch the served git ref
+			}
+			if wb.Sessions != nil {
+				browse.HandleFunc("GET /sessions", wb.handleSessions)
+				browse.HandleFunc("POST /sessions/revoke", wb.handleRevokeSession)
+				browse.HandleFunc("POST /sessions/revoke-all", wb.handleRevokeAllSessions)
+			}
+
+			download := auth.With(wb.Access.middleware(accessDownload))
+			download.HandleFunc("POST /download-selected", wb.handleDownloadSelected) // handle multi-file download
+			download.HandleFunc("GET /partials/checksum", wb.handleChecksumPartial)   // handle checksum in the file modal
+			download.HandleFunc("GET /partials/text-window", wb.handleTextWindow)     // handle lines of a large text file
+			download.HandleFunc("GET /events/tail", wb.handleTextTail)                // handle lines appended to a text file
+			download.HandleFunc("GET /diff", wb.handleDiff)                           // handle comparison of two files
+			download.HandleFunc("GET /partials/csv-table", wb.handleCSVTable)         // handle pages of the table view
+			download.HandleFunc("GET /csv-export", wb.handleCSVExport)                // handle json export of the table view
+			download.HandleFunc("GET /view/{path...}", wb.handleViewFile)             // handle file viewing
+			download.HandleFunc("GET /{path...}", wb.handleDownload)                  // handle file downloads with just the path
+
+			api := auth.With(wb.Access.middleware(accessAPI))
+			api.HandleFunc("GET /api/list", wb.handleAPIList)         // handle JSON API for file listing
+			api.HandleFunc("GET /api/checksum", wb.handleAPIChecksum) // handle JSON API for file checksums
+
+			// metrics on the main listener need auth and pass the api rules, as they show paths and users.
+			// scrapers log in with basic auth, or metrics get a listener of their own.
+			if wb.Metrics != nil && wb.MetricsListen == "" {
+				api.Handle("GET /metrics", wb.Metrics.Handler())
+			}
 		})
 	})
 
@@ -288,7 +425,7 @@ func (wb *Web) router() (http.Handler, error) {
 		if path == "favicon.ico" { // special case for favicon.ico which maps to favicon.png
 			path = "favicon.png"
 		}
-		http.ServeFileFS(w, r, assetsFS, path)
+		serveAsset(w, r, assetsFS, path)
 	})
 
 	// serve favicon.ico at root level without auth, browsers request this path conventionally
@@ -299,13 +436,41 @@ func (wb *Web) router() (http.Handler, error) {
 	return router, nil
 }
 
+// runMetricsServer serves the metrics endpoint on MetricsListen until the context is canceled
+func (wb *Web) runMetricsServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", wb.Metrics.Handler())
+	srv := &http.Server{
+		Addr:              wb.MetricsListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second) //nolint:contextcheck // parent is canceled
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("[WARN] metrics server shutdown failed: %v", err)
+		}
+	}()
+
+	log.Printf("[INFO] starting metrics server on %s", wb.MetricsListen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[WARN] metrics server failed: %v", err)
+	}
+}
+
 // authMiddleware enforces authentication for protected routes.
 // It uses a multi-tiered authentication approach:
 // 1. Login page (/login) and static assets are always accessible without authentication
 // 2. Checks for a valid authentication cookie first
 // 3. Falls back to HTTP Basic Auth with username "weblist" and password from config
 // 4. On successful Basic Auth, sets a cookie for future requests to avoid repeated authentication
-// 5. Redirects unauthenticated requests to the login page
+// 5. Accepts a client certificate verified by the TLS client CA, no cookie is needed as it's sent on every connection
+// 6. Accepts the user set in headers by a trusted reverse proxy, rejects the request if there is no own login
+// 7. Redirects unauthenticated requests to the login page
 // This middleware belongs after all other middleware but before route handlers.
 func (wb *Web) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -315,12 +480,27 @@ func (wb *Web) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// check if user is authenticated via cookie
-		if wb.isAuthenticatedByCookie(r) {
+		// check if user is authenticated via cookie, active sessions are extended with the session store
+		if token, touched, ok := wb.activeSession(r); ok {
+			if touched {
+				wb.writeSessionCookie(w, r, token)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// check if user is authenticated via a verified client certificate or a trusted proxy
+		if clientCertUser(r) != "" || wb.Proxy.user(r) != "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		// without own login, users come only through the proxy
+		if wb.Auth == "" && wb.OIDC == nil {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
 		// check if user is authenticated via basic auth
 		if wb.tryBasicAuth(w, r) {
 			next.ServeHTTP(w, r)
@@ -332,6 +512,20 @@ func (wb *Web) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// skipPrefix applies the middleware to requests except those with the path prefix
+func skipPrefix(prefix string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
 // securityHeadersMiddleware adds security-related HTTP headers to all responses
 func (wb *Web) securityHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionTouchInterval = time.Minute // limits how often activity of a session is recorded and its cookie is extended
+	sessionSaveInterval  = time.Minute // how often recorded activity is saved and expired sessions are removed
+)
+
+// SessionOpts defines options of the server-side session store
+type SessionOpts struct {
+	File   string        // file keeping sessions over restarts, in memory only if empty
+	TTL    time.Duration // sessions expire after this time without requests
+	Admins []string      // users who can see and revoke sessions of all users
+}
+
+// SessionStore keeps sessions of the login cookie, so they can be listed and revoked.
+// a session is valid while its signed token is valid and it's in the store.
+type SessionStore struct {
+	opts SessionOpts
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	dirty    bool // activity recorded since the last save
+}
+
+// Session is a login session of a user
+type Session struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// NewSessionStore makes the session store, loading sessions from the file if it's set and exists
+func NewSessionStore(opts SessionOpts) (*SessionStore, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	res := &SessionStore{opts: opts, sessions: map[string]*Session{}}
+	if opts.File == "" {
+		return res, nil
+	}
+	data, err := os.ReadFile(opts.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sessions file: %w", err)
+	}
+	var sessions []*Session
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to parse sessions file %s: %w", opts.File, err)
+	}
+	for _, s := range sessions {
+		if time.Since(s.LastSeen) <= opts.TTL {
+			res.sessions[s.ID] = s
+		}
+	}
+	return res, nil
+}
+
+// add records a new session of the user started by the request
+func (s *SessionStore) add(id, user string, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sessions[id] = &Session{ID: id, User: user, IP: clientIP(r.RemoteAddr), UserAgent: r.UserAgent(), Created: now, LastSeen: now}
+	s.save()
+}
+
+// touch checks the session is active and records the request, at most once in sessionTouchInterval.
+// touched is true if the activity was recorded, an expired session is removed.
+func (s *SessionStore) touch(id string, r *http.Request) (ok, touched bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, found := s.sessions[id]
+	if !found {
+		return false, false
+	}
+	if time.Since(sess.LastSeen) > s.opts.TTL {
+		delete(s.sessions, id)
+		s.dirty = true
+		return false, false
+	}
+	if time.Since(sess.LastSeen) < sessionTouchInterval {
+		return true, false
+	}
+	sess.LastSeen, sess.IP = time.Now(), clientIP(r.RemoteAddr)
+	s.dirty = true // saved by Run, not on each request
+	return true, true
+}
+
+// Run saves recorded activity and removes expired sessions periodically until the context is canceled,
+// activity is saved on exit as well
+func (s *SessionStore) Run(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	ticker := time.NewTicker(sessionSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.cleanup(time.Now())
+			return
+		case <-ticker.C:
+			s.cleanup(time.Now())
+		}
+	}
+}
+
+// cleanup removes sessions expired by now and saves the store if it changed
+func (s *SessionStore) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.sessions {
+		if now.Sub(sess.LastSeen) > s.opts.TTL {
+			delete(s.sessions, id)
+			s.dirty = true
+		}
+	}
+	if s.dirty {
+		s.save()
+	}
+}
+
+// get returns the session by ID
+func (s *SessionStore) get(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	return *sess, true
+}
+
+// list returns active sessions of the user, or of all users if user is empty, the recently used first
+func (s *SessionStore) list(user string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := []Session{}
+	for _, sess := range s.sessions {
+		if time.Since(sess.LastSeen) > s.opts.TTL || (user != "" && sess.User != user) {
+			continue
+		}
+		res = append(res, *sess)
+	}
+	slices.SortFunc(res, func(a, b Session) int { return b.LastSeen.Compare(a.LastSeen) })
+	return res
+}
+
+// revoke removes the session, it's rejected on the next request
+func (s *SessionStore) revoke(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return
+	}
+	delete(s.sessions, id)
+	s.save()
+}
+
+// revokeUser removes all sessions of the user and returns how many were removed
+func (s *SessionStore) revokeUser(user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for id, sess := range s.sessions {
+		if sess.User == user {
+			delete(s.sessions, id)
+			count++
+		}
+	}
+	if count > 0 {
+		s.save()
+	}
+	return count
+}
+
+// isAdmin checks the user can see and revoke sessions of all users
+func (s *SessionStore) isAdmin(user string) bool {
+	return user != "" && slices.Contains(s.opts.Admins, user)
+}
+
+// save writes sessions to the file if it's set, the caller holds the lock.
+// failures are logged only, sessions keep working in memory.
+func (s *SessionStore) save() {
+	s.dirty = false
+	if s.opts.File == "" {
+		return
+	}
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	slices.SortFunc(sessions, func(a, b *Session) int { return cmp.Compare(a.ID, b.ID) })
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.opts.File, data)
+	}
+	if err != nil {
+		log.Printf("[WARN] failed to save sessions: %v", err)
+	}
+}
+
+// sessionID returns the ID of the session token, the random part of the token ID without scope and user
+func sessionID(token string) string {
+	tokenID, _, _ := strings.Cut(token, ".")
+	if _, rest, found := strings.Cut(tokenID, "~"); found {
+		tokenID = rest
+	}
+	id, _, _ := strings.Cut(tokenID, "!")
+	return id
+}
+
+// setSessionCookie starts a session of the user and sets its cookie. the user is empty for the configured
+// user of the password login, such sessions are recorded with the configured user.
+func (wb *Web) setSessionCookie(w http.ResponseWriter, r *http.Request, user string) {
+	token := wb.generateUserSessionToken(user)
+	if wb.Sessions != nil {
+		if user == "" {
+			user = wb.getAuthUser()
+		}
+		wb.Sessions.add(sessionID(token), user, r)
+	}
+	wb.writeSessionCookie(w, r, token)
+}
+
+// writeSessionCookie sets the auth cookie with the token, expiring after the session TTL
+func (wb *Web) writeSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{ //nolint:gosec // G124: Secure follows the transport, plain HTTP is a supported deployment
+		Name:     "auth",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   wb.isRequestSecure(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   wb.getSessionMaxAge(),
+	})
+}
+
+// activeSession returns the token of the auth cookie if it's valid and, with the session store, not revoked
+// or expired. touched is true if the store recorded the request, the cookie should be extended then.
+func (wb *Web) activeSession(r *http.Request) (token string, touched, ok bool) {
+	cookie, err := r.Cookie("auth")
+	if err != nil || !wb.validateSessionToken(cookie.Value) {
+		return "", false, false
+	}
+	if wb.Sessions == nil {
+		return cookie.Value, false, true
+	}
+	if ok, touched = wb.Sessions.touch(sessionID(cookie.Value), r); !ok {
+		return "", false, false
+	}
+	return cookie.Value, touched, true
+}
+
+// sessionsPageData holds data for the sessions page
+type sessionsPageData struct {
+	Theme        string
+	HideFooter   bool
+	Title        string
+	BrandName    string
+	BrandColor   string
+	CustomFooter string
+	User         string    // user of the current session
+	Current      string    // ID of the current session
+	Admin        bool      // sessions of all users are listed
+	Sessions     []Session // active sessions
+}
+
+// handleSessions renders the page with active sessions of the user, or of all users for admins
+func (wb *Web) handleSessions(w http.ResponseWriter, r *http.Request) {
+	data := sessionsPageData{
+		Theme:        wb.Theme,
+		HideFooter:   wb.HideFooter,
+		Title:        wb.Title,
+		BrandName:    wb.BrandName,
+		BrandColor:   wb.BrandColor,
+		CustomFooter: wb.CustomFooter,
+	}
+	if token, _, ok := wb.activeSession(r); ok {
+		data.Current = sessionID(token)
+		if sess, found := wb.Sessions.get(data.Current); found {
+			data.User = sess.User
+		}
+	}
+	data.Admin = wb.Sessions.isAdmin(data.User)
+	switch {
+	case data.Admin:
+		data.Sessions = wb.Sessions.list("")
+	case data.User != "":
+		data.Sessions = wb.Sessions.list(data.User)
+	}
+
+	if err := wb.templates.sessionsTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleRevokeSession revokes a session of the user, or any session for admins
+func (wb *Web) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	token, _, ok := wb.activeSession(r)
+	if !ok {
+		http.Error(w, "session required", http.StatusForbidden)
+		return
+	}
+	current, _ := wb.Sessions.get(sessionID(token))
+	target, found := wb.Sessions.get(r.FormValue("id"))
+	if !found || (target.User != current.User && !wb.Sessions.isAdmin(current.User)) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	wb.Sessions.revoke(target.ID)
+	log.Printf("[INFO] session of %s from %s revoked by %s", target.User, target.IP, current.User)
+	if target.ID == current.ID {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+}
+
+// handleRevokeAllSessions signs the user out everywhere, including the current session
+func (wb *Web) handleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	token, _, ok := wb.activeSession(r)
+	if !ok {
+		http.Error(w, "session required", http.StatusForbidden)
+		return
+	}
+	current, _ := wb.Sessions.get(sessionID(token))
+	count := wb.Sessions.revokeUser(current.User)
+	log.Printf("[INFO] %s signed out everywhere, %d sessions revoked", current.User, count)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sessions.json")
+	store, err := NewSessionStore(SessionOpts{File: file, TTL: time.Hour, Admins: []string{"admin"}})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.RemoteAddr = "192.168.1.5:4000"
+	req.Header.Set("User-Agent", "test-browser")
+	store.add("s1", "alice", req)
+	store.add("s2", "alice", req)
+	store.add("s3", "bob", req)
+
+	sess, ok := store.get("s1")
+	require.True(t, ok)
+	assert.Equal(t, "192.168.1.5", sess.IP)
+	assert.Equal(t, "test-browser", sess.UserAgent)
+	assert.Len(t, store.list("alice"), 2)
+	assert.Len(t, store.list(""), 3)
+
+	ok, touched := store.touch("s1", req)
+	assert.True(t, ok)
+	assert.False(t, touched, "activity recorded once a minute")
+	store.sessions["s1"].LastSeen = time.Now().Add(-2 * time.Minute)
+	ok, touched = store.touch("s1", req)
+	assert.True(t, ok)
+	assert.True(t, touched)
+	store.sessions["s2"].LastSeen = time.Now().Add(-2 * time.Hour)
+	ok, _ = store.touch("s2", req)
+	assert.False(t, ok, "expired after inactivity")
+	ok, _ = store.touch("missing", req)
+	assert.False(t, ok)
+
+	store.revoke("s1")
+	ok, _ = store.touch("s1", req)
+	assert.False(t, ok)
+
+	store.add("s4", "bob", req)
+	assert.Equal(t, 2, store.revokeUser("bob"))
+	assert.Empty(t, store.list(""))
+
+	assert.True(t, store.isAdmin("admin"))
+	assert.False(t, store.isAdmin("alice"))
+	assert.False(t, store.isAdmin(""))
+
+	// sessions survive restart, expired ones are dropped
+	store.add("s5", "alice", req)
+	store.add("s6", "alice", req)
+	store.sessions["s6"].LastSeen = time.Now().Add(-2 * time.Hour)
+	store.save()
+	st, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+	store, err = NewSessionStore(SessionOpts{File: file, TTL: time.Hour})
+	require.NoError(t, err)
+	_, ok = store.get("s5")
+	assert.True(t, ok)
+	_, ok = store.get("s6")
+	assert.False(t, ok)
+}
+
+func TestSessionID(t *testing.T) {
+	wb := &Web{Config: Config{SessionSecret: "secret"}}
+	token := wb.generateUserSessionToken("alice@example.com")
+	id := sessionID(token)
+	assert.Len(t, id, 36)
+	assert.True(t, strings.HasPrefix(token, id+"!"))
+	assert.Equal(t, id, sessionID(strings.Replace(token, id, "scope~"+id, 1)))
+	assert.Len(t, sessionID(wb.generateSessionToken()), 36)
+}
+
+func TestSessionsWeb(t *testing.T) {
+	setup := func(t *testing.T, admins ...string) (*Web, http.Handler) {
+		t.Helper()
+		store, err := NewSessionStore(SessionOpts{TTL: time.Hour, Admins: admins})
+		require.NoError(t, err)
+		srv := &Web{Config: Config{RootDir: "testdata", Auth: "password", SessionSecret: "session-secret", Title: "Test"},
+			FS: os.DirFS("testdata"), Sessions: store}
+		require.NoError(t, srv.initTemplates())
+		router, err := srv.router()
+		require.NoError(t, err)
+		return srv, router
+	}
+
+	do := func(router http.Handler, method, target string, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := func(t *testing.T, router http.Handler) *http.Cookie {
+		t.Helper()
+		rr := do(router, http.MethodPost, "/login", nil, url.Values{"username": {"weblist"}, "password": {"password"}})
+		require.Equal(t, http.StatusSeeOther, rr.Code)
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == "auth" {
+				return c
+			}
+		}
+		require.Fail(t, "no auth cookie")
+		return nil
+	}
+
+	// userCookie makes a session cookie of a named user, as OIDC login does
+	userCookie := func(srv *Web, user string) *http.Cookie {
+		rr := httptest.NewRecorder()
+		srv.setSessionCookie(rr, httptest.NewRequest(http.MethodGet, "/", http.NoBody), user)
+		return rr.Result().Cookies()[0]
+	}
+
+	t.Run("logout revokes copies of the cookie", func(t *testing.T) {
+		_, router := setup(t)
+		cookie := login(t, router)
+		assert.Equal(t, http.StatusOK, do(router, http.MethodGet, "/", cookie, nil).Code)
+
+		stolen := *cookie
+		do(router, http.MethodGet, "/logout", cookie, nil)
+		rr := do(router, http.MethodGet, "/", &stolen, nil)
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+		assert.Equal(t, "/login", rr.Header().Get("Location"))
+	})
+
+	t.Run("list and revoke", func(t *testing.T) {
+		srv, router := setup(t)
+		first, second := login(t, router), login(t, router)
+		other := userCookie(srv, "alice")
+
+		rr := do(router, http.MethodGet, "/", first, nil)
+		assert.Contains(t, rr.Body.String(), `href="/sessions"`)
+
+		rr = do(router, http.MethodGet, "/sessions", first, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		body := rr.Body.String()
+		assert.Equal(t, 2, strings.Count(body, `action="/sessions/revoke"`), "own sessions only")
+		assert.Contains(t, body, "current session")
+		assert.NotContains(t, body, sessionID(other.Value))
+
+		rr = do(router, http.MethodPost, "/sessions/revoke", first, url.Values{"id": {sessionID(other.Value)}})
+		assert.Equal(t, http.StatusNotFound, rr.Code, "session of another user")
+
+		rr = do(router, http.MethodPost, "/sessions/revoke", first, url.Values{"id": {sessionID(second.Value)}})
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+		assert.Equal(t, "/sessions", rr.Header().Get("Location"))
+		assert.Equal(t, http.StatusSeeOther, do(router, http.MethodGet, "/", second, nil).Code, "revoked immediately")
+		assert.Equal(t, http.StatusOK, do(router, http.MethodGet, "/", first, nil).Code)
+		assert.Equal(t, http.StatusOK, do(router, http.MethodGet, "/", other, nil).Code)
+	})
+
+	t.Run("sign out everywhere", func(t *testing.T) {
+		srv, router := setup(t)
+		first, second := login(t, router), login(t, router)
+		other := userCookie(srv, "alice")
+
+		rr := do(router, http.MethodPost, "/sessions/revoke-all", first, url.Values{})
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+		assert.Equal(t, "/login", rr.Header().Get("Location"))
+		assert.Equal(t, http.StatusSeeOther, do(router, http.MethodGet, "/", first, nil).Code)
+		assert.Equal(t, http.StatusSeeOther, do(router, http.MethodGet, "/", second, nil).Code)
+		assert.Equal(t, http.StatusOK, do(router, http.MethodGet, "/", other, nil).Code, "other users stay")
+	})
+
+	t.Run("admin sees all sessions", func(t *testing.T) {
+		srv, router := setup(t, "admin")
+		admin, alice := userCookie(srv, "admin"), userCookie(srv, "alice")
+		login(t, router)
+
+		rr := do(router, http.MethodGet, "/sessions", admin, nil)
+		assert.Equal(t, 3, strings.Count(rr.Body.String(), `action="/sessions/revoke"`))
+		assert.Contains(t, rr.Body.String(), "of all users")
+
+		rr = do(router, http.MethodPost, "/sessions/revoke", admin, url.Values{"id": {sessionID(alice.Value)}})
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+		assert.Equal(t, http.StatusSeeOther, do(router, http.MethodGet, "/", alice, nil).Code)
+	})
+
+	t.Run("active session is extended", func(t *testing.T) {
+		srv, router := setup(t)
+		srv.SessionTTL = time.Hour
+		cookie := login(t, router)
+		rr := do(router, http.MethodGet, "/", cookie, nil)
+		assert.Empty(t, rr.Result().Cookies(), "recently extended")
+
+		srv.Sessions.sessions[sessionID(cookie.Value)].LastSeen = time.Now().Add(-30 * time.Minute)
+		rr = do(router, http.MethodGet, "/", cookie, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Len(t, rr.Result().Cookies(), 1)
+		assert.Equal(t, cookie.Value, rr.Result().Cookies()[0].Value)
+		assert.Equal(t, 3600, rr.Result().Cookies()[0].MaxAge)
+
+		srv.Sessions.sessions[sessionID(cookie.Value)].LastSeen = time.Now().Add(-2 * time.Hour)
+		assert.Equal(t, http.StatusSeeOther, do(router, http.MethodGet, "/", cookie, nil).Code, "idle session expired")
+	})
+}
+
+func TestSessionStore_SaveAndCleanup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sessions.json")
+	store, err := NewSessionStore(SessionOpts{File: file, TTL: time.Hour})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	store.add("s1", "alice", req)
+	store.add("s2", "bob", req)
+	saved, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	// activity is not saved on each request
+	store.sessions["s1"].LastSeen = time.Now().Add(-2 * time.Minute)
+	_, touched := store.touch("s1", req)
+	require.True(t, touched)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, string(saved), string(data))
+
+	// cleanup removes expired sessions and saves the activity
+	store.sessions["s2"].LastSeen = time.Now().Add(-2 * time.Hour)
+	store.cleanup(time.Now())
+	_, ok := store.get("s2")
+	assert.False(t, ok)
+	reloaded, err := NewSessionStore(SessionOpts{File: file, TTL: time.Hour})
+	require.NoError(t, err)
+	assert.Len(t, reloaded.sessions, 1)
+	assert.WithinDuration(t, time.Now(), reloaded.sessions["s1"].LastSeen, time.Minute)
+
+	// activity is saved when Run stops
+	store.sessions["s1"].LastSeen = time.Now().Add(-2 * time.Minute)
+	_, touched = store.touch("s1", req)
+	require.True(t, touched)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store.Run(ctx)
+	assert.False(t, store.dirty)
+	assert.NotPanics(t, func() { (*SessionStore)(nil).Run(ctx) })
+}
+
+func TestSessionStore_BasicAuth(t *testing.T) {
+	store, err := NewSessionStore(SessionOpts{TTL: time.Hour})
+	require.NoError(t, err)
+	srv := &Web{Config: Config{RootDir: "testdata", Auth: "password", SessionSecret: "session-secret", Title: "Test"},
+		FS: os.DirFS("testdata"), Sessions: store}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	for range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.SetBasicAuth("weblist", "password")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Result().Cookies(), "no session cookie for basic auth")
+	}
+	assert.Empty(t, store.list(""), "basic auth requests don't start sessions")
+}
@@ -17,6 +17,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -26,18 +27,13 @@ import (
 // SFTP represents the SFTP server.
 type SFTP struct {
 	Config
-	FS fs.FS
-
-	// simple rate limiter for authentication attempts
-	ipAttempts   map[string]ipAttemptsInfo
-	ipAttemptsMu sync.Mutex
-}
-
-// ipAttemptsInfo tracks authentication attempts from an IP
-type ipAttemptsInfo struct {
-	count     int       // number of attempts
-	firstSeen time.Time // when the first attempt was seen
-	lastSeen  time.Time // when the most recent attempt was seen
+	FS       fs.FS
+	Mounts   []Mount      // optional, served as top-level directories in place of FS
+	Metrics  *Metrics     // optional, metrics are not collected if nil
+	Audit    *AuditLogger // optional, file access is not audited if nil
+	Guard    *AuthGuard   // optional brute-force protection shared with HTTP, default one is made if nil
+	Access   *AccessList  // optional, clients are allowed or denied by address
+	Throttle *Throttle    // optional, limits bandwidth and concurrent downloads, shared with HTTP
 }
 
 // Run starts the SFTP server.
@@ -47,6 +43,17 @@ func (s *SFTP) Run(ctx context.Context) error {
 		return err
 	}
 
+	// mounts replace the single root filesystem with their combination
+	if len(s.Mounts) > 0 {
+		s.FS = newMountFS(s.Mounts)
+	}
+
+	// failed attempts are tracked even if the guard is not shared with the HTTP server
+	if s.Guard == nil {
+		s.Guard = NewAuthGuard(AuthGuardOpts{Delay: time.Second, Metrics: s.Metrics, Audit: s.Audit})
+		go s.Guard.Run(ctx)
+	}
+
 	// configure SSH server
 	config, err := s.setupSSHServerConfig()
 	if err != nil {
@@ -109,6 +116,12 @@ func (s *SFTP) Run(ctx context.Context) error {
 func (s *SFTP) handleConnection(conn net.Conn, config *ssh.ServerConfig) {
 	defer conn.Close()
 
+	// reject clients not allowed by address rules before spending anything on the handshake
+	if !s.Access.allowedConn(conn.RemoteAddr()) {
+		log.Printf("[WARN] SFTP access denied for %s", conn.RemoteAddr())
+		return
+	}
+
 	// apply idle timeout to the connection
 	timeoutConn := &timeoutConn{
 		Conn:         conn,
@@ -147,12 +160,12 @@ func (s *SFTP) handleConnection(conn net.Conn, config *ssh.ServerConfig) {
 		}
 
 		// handle session requests in a goroutine
-		go s.handleSession(channel, requests)
+		go s.handleSession(channel, requests, sshConn)
 	}
 }
 
-// handleSession processes a single SSH session
-func (s *SFTP) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+// handleSession processes a single SSH session, conn identifies the authenticated user and client
+func (s *SFTP) handleSession(channel ssh.Channel, requests <-chan *ssh.Request, conn ssh.ConnMetadata) {
 	defer channel.Close()
 
 	for req := range requests {
@@ -176,7 +189,7 @@ func (s *SFTP) handleSession(channel ssh.Channel, requests <-chan *ssh.Request)
 			replyRequest(req, true, "")
 
 			// start SFTP server
-			s.startSFTPServer(channel)
+			s.startSFTPServer(channel, conn)
 			return
 
 		case "shell":
@@ -215,12 +228,17 @@ func replyRequest(req *ssh.Request, accept bool, logMsg string) {
 }
 
 // startSFTPServer starts the SFTP server on the given channel
-func (s *SFTP) startSFTPServer(channel ssh.Channel) {
+func (s *SFTP) startSFTPServer(channel ssh.Channel, conn ssh.ConnMetadata) {
 	// create a jailed filesystem that restricts access to the root directory
 	jailed := &jailedFilesystem{
 		rootDir:  s.RootDir,
 		excludes: s.Exclude,
+		mounts:   s.Mounts,
 		fsys:     s.FS,
+		auditLog: s.Audit,
+		throttle: s.Throttle,
+		user:     conn.User(),
+		remoteIP: clientIP(conn.RemoteAddr().String()),
 	}
 
 	// create handlers for our custom jailed filesystem
@@ -239,8 +257,9 @@ func (s *SFTP) startSFTPServer(channel ssh.Channel) {
 	)
 
 	defer server.Close()
+	defer s.Metrics.sftpSessionStarted()()
 
-	log.Printf("[INFO] Starting SFTP subsystem with root directory: %s", s.RootDir)
+	log.Printf("[INFO] Starting SFTP subsystem with root directory: %s", servedFrom(s.RootDir, s.Mounts))
 
 	// start the SFTP server - this will block until the channel is closed
 	if err := server.Serve(); err != nil {
@@ -262,9 +281,22 @@ func (s *SFTP) startSFTPServer(channel ssh.Channel) {
 // This is the core security boundary for the SFTP server, ensuring that remote
 // users cannot access unauthorized files or modify content.
 type jailedFilesystem struct {
-	rootDir  string   // physical root directory path
-	excludes []string // patterns to exclude
-	fsys     fs.FS    // filesystem interface
+	rootDir  string       // physical root directory path
+	excludes []string     // patterns to exclude
+	mounts   []Mount      // mounts served as top-level directories, empty for a single root
+	fsys     fs.FS        // filesystem interface
+	auditLog *AuditLogger // optional audit log for file access
+	throttle *Throttle    // optional bandwidth and concurrent downloads limits
+	user     string       // authenticated user of the session, for audit
+	remoteIP string       // client address of the session, for audit
+}
+
+// audit records a file access by the session user, nothing is recorded if auditing is disabled
+func (j *jailedFilesystem) audit(action, path string, size int64, result string) {
+	if j.auditLog == nil {
+		return
+	}
+	j.auditLog.Log(AuditRecord{User: j.user, IP: j.remoteIP, Protocol: "sftp", Action: action, Path: path, Bytes: size, Result: result})
 }
 
 // Fileread implements sftp.FileCmder.Fileread
@@ -276,6 +308,7 @@ func (j *jailedFilesystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
 	secPath, err := j.securePath(r.Filepath)
 	if err != nil {
 		log.Printf("[WARN] SFTP: Denied file read access to %s: %v", r.Filepath, err)
+		j.audit("read", r.Filepath, 0, "denied")
 		return nil, fmt.Errorf("access denied: %w", err)
 	}
 
@@ -288,17 +321,13 @@ func (j *jailedFilesystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
 
 		// improve error message for user
 		if os.IsNotExist(err) {
+			j.audit("read", r.Filepath, 0, "not_found")
 			return nil, fmt.Errorf("file not found: %s", r.Filepath)
 		}
+		j.audit("read", r.Filepath, 0, "error")
 		return nil, err
 	}
 
-	// check if file implements ReaderAt directly
-	if ra, ok := file.(io.ReaderAt); ok {
-		log.Printf("[DEBUG] SFTP: Allowed read access to %s (secure path: %s) using native ReaderAt", r.Filepath, secPath)
-		return ra, nil
-	}
-
 	// get file info to check size
 	info, err := file.Stat()
 	if err != nil {
@@ -306,9 +335,64 @@ func (j *jailedFilesystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
 			log.Printf("[DEBUG] SFTP: Error closing file %s after stat error: %v", secPath, err)
 		}
 		log.Printf("[DEBUG] SFTP: Error getting file info for %s: %v", secPath, err)
+		j.audit("read", r.Filepath, 0, "error")
+		return nil, err
+	}
+
+	// all SFTP clients share the user, so concurrent downloads are limited by address
+	limiters, done, err := j.throttle.start(j.remoteIP)
+	if err != nil {
+		_ = file.Close()
+		log.Printf("[WARN] SFTP: Rejected read of %s from %s: %v", r.Filepath, j.remoteIP, err)
+		j.audit("read", r.Filepath, 0, "rejected")
 		return nil, err
 	}
 
+	ra, err := j.readerAt(file, info, r.Filepath, secPath)
+	if err != nil {
+		done()
+		j.audit("read", r.Filepath, 0, "error")
+		return nil, err
+	}
+	// the read is recorded when the client closes the file, with the amount actually transferred
+	return &auditedReaderAt{ReaderAt: &throttledReaderAt{ReaderAt: ra, limiters: limiters, done: done},
+		onClose: func(n int64) { j.audit("read", r.Filepath, n, "ok") }}, nil
+}
+
+// auditedReaderAt counts bytes read from a file and reports them on close
+type auditedReaderAt struct {
+	io.ReaderAt
+	read    atomic.Int64
+	once    sync.Once
+	onClose func(n int64)
+}
+
+// ReadAt reads from the underlying reader and counts the bytes read
+func (a *auditedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := a.ReaderAt.ReadAt(p, off)
+	a.read.Add(int64(n))
+	return n, err
+}
+
+// Close reports the bytes read and closes the underlying reader if it can be closed, repeated calls do nothing
+func (a *auditedReaderAt) Close() (err error) {
+	a.once.Do(func() {
+		a.onClose(a.read.Load())
+		if c, ok := a.ReaderAt.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+	return err
+}
+
+// readerAt makes io.ReaderAt of the opened file
+func (j *jailedFilesystem) readerAt(file fs.File, info fs.FileInfo, reqPath, secPath string) (io.ReaderAt, error) {
+	// check if file implements ReaderAt directly
+	if ra, ok := file.(io.ReaderAt); ok {
+		log.Printf("[DEBUG] SFTP: Allowed read access to %s (secure path: %s) using native ReaderAt", reqPath, secPath)
+		return ra, nil
+	}
+
 	// for small files (under 10MB), just read the entire file into memory
 	// this is more efficient for small files that are frequently accessed
 	// while large files use a buffered approach to avoid excessive memory usage
@@ -323,12 +407,12 @@ func (j *jailedFilesystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
 			return nil, err
 		}
 
-		log.Printf("[DEBUG] SFTP: Allowed read access to %s (secure path: %s) using memory ReaderAt for small file", r.Filepath, secPath)
+		log.Printf("[DEBUG] SFTP: Allowed read access to %s (secure path: %s) using memory ReaderAt for small file", reqPath, secPath)
 		return &memReaderAt{data: data}, nil
 	}
 
 	// for large files, use a buffered reader to avoid loading everything into memory
-	log.Printf("[DEBUG] SFTP: Allowed read access to %s (secure path: %s) using buffered reader for large file", r.Filepath, secPath)
+	log.Printf("[DEBUG] SFTP: Allowed read access to %s (secure path: %s) using buffered reader for large file", reqPath, secPath)
 	return &bufferedFileReaderAt{
 		file:     file,
 		fileSize: info.Size(),
@@ -339,12 +423,19 @@ func (j *jailedFilesystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
 // Filewrite implements sftp.FileCmder.Filewrite
 func (j *jailedFilesystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
 	log.Printf("[WARN] SFTP: Rejected write attempt to %s (server is read-only)", r.Filepath)
+	j.audit("upload", r.Filepath, 0, "denied")
 	return nil, fmt.Errorf("write operations not permitted - server is in read-only mode")
 }
 
 // Filecmd implements sftp.FileCmder.Filecmd
 func (j *jailedFilesystem) Filecmd(r *sftp.Request) error {
 	log.Printf("[WARN] SFTP: Rejected file operation %s on %s (server is read-only)", r.Method, r.Filepath)
+	switch r.Method {
+	case "Remove", "Rmdir":
+		j.audit("delete", r.Filepath, 0, "denied")
+	default:
+		j.audit(strings.ToLower(r.Method), r.Filepath, 0, "denied")
+	}
 	return fmt.Errorf("operation not permitted - server is in read-only mode")
 }
 
@@ -571,9 +662,14 @@ func (j *jailedFilesystem) securePath(requestPath string) (string, error) {
 }
 
 // shouldExclude checks if a path should be excluded based on exclusion patterns,
-// using the same component matching as the web listing
+// using the same component matching as the web listing, and files being uploaded. Mounts with their own password
+// are hidden entirely, as SFTP sessions can't provide a second password, and so are mounts of other users.
 func (j *jailedFilesystem) shouldExclude(path string) bool {
-	return matchesExcludes(path, j.excludes)
+	if matchesExcludes(path, j.excludes) || mountExcluded(j.mounts, path) || isUploadTemp(path) {
+		return true
+	}
+	m, _ := findMount(j.mounts, path)
+	return m != nil && (m.Auth != "" || !m.userAllowed(j.user))
 }
 
 // loadOrGenerateHostKey loads an existing SSH host key or generates a new one if it doesn't exist
@@ -774,7 +870,16 @@ func (s *SFTP) setupPublicKeyAuth(config *ssh.ServerConfig) {
 
 	log.Printf("[INFO] Loaded %d authorized keys for public key authentication", len(authKeys))
 	config.PublicKeyCallback = func(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+		remoteIP := c.RemoteAddr().(*net.TCPAddr).IP.String()
+		if s.Guard.locked(remoteIP, c.User()) {
+			log.Printf("[WARN] SFTP authentication locked out for user %s from %s", c.User(), remoteIP)
+			s.Metrics.sftpRateLimited()
+			return nil, fmt.Errorf("too many authentication attempts")
+		}
+
 		if subtle.ConstantTimeCompare([]byte(c.User()), []byte(s.SFTPUser)) != 1 {
+			s.Metrics.sftpAuthAttempt("publickey", false)
+			time.Sleep(s.Guard.failed(remoteIP, c.User(), "sftp"))
 			return nil, fmt.Errorf("unknown user %s", c.User())
 		}
 
@@ -784,28 +889,29 @@ func (s *SFTP) setupPublicKeyAuth(config *ssh.ServerConfig) {
 			authKeyStr := string(ssh.MarshalAuthorizedKey(authorizedKey))
 			if pubKeyStr == authKeyStr {
 				log.Printf("[DEBUG] Public key authentication successful for %s from %s", c.User(), c.RemoteAddr())
+				s.Metrics.sftpAuthAttempt("publickey", true)
+				s.Guard.succeeded(remoteIP, c.User())
 				return &ssh.Permissions{}, nil
 			}
 		}
+		s.Metrics.sftpAuthAttempt("publickey", false)
 
+		// clients offer each of their keys in turn, so a rejected key counts towards the lockout without delay
 		log.Printf("[WARN] Public key authentication failed for %s from %s", c.User(), c.RemoteAddr())
+		s.Guard.failed(remoteIP, c.User(), "sftp")
 		return nil, fmt.Errorf("unauthorized public key")
 	}
 }
 
 // setupSSHServerConfig configures the SSH server
 func (s *SFTP) setupSSHServerConfig() (*ssh.ServerConfig, error) {
-	// initialize the attempts tracking map
-	s.ipAttempts = make(map[string]ipAttemptsInfo)
-
 	config := &ssh.ServerConfig{
 		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-			// apply rate limiting based on source IP
+			// reject locked out addresses and accounts before checking the password
 			remoteIP := c.RemoteAddr().(*net.TCPAddr).IP.String()
-			if !s.checkAuthRateLimit(remoteIP) {
-				log.Printf("[WARN] SFTP rate limit exceeded for IP %s", remoteIP)
-				// adding a small delay to slow down brute force attempts
-				time.Sleep(2 * time.Second)
+			if s.Guard.locked(remoteIP, c.User()) {
+				log.Printf("[WARN] SFTP authentication locked out for user %s from %s", c.User(), remoteIP)
+				s.Metrics.sftpRateLimited()
 				return nil, fmt.Errorf("too many authentication attempts")
 			}
 
@@ -816,11 +922,14 @@ func (s *SFTP) setupSSHServerConfig() (*ssh.ServerConfig, error) {
 			}
 
 			if subtle.ConstantTimeCompare([]byte(c.User()), []byte(s.SFTPUser)) == 1 && subtle.ConstantTimeCompare(pass, []byte(s.Auth)) == 1 {
-				// successful login - clear rate limiting record
-				s.resetAuthRateLimit(remoteIP)
+				// successful login - forget previous failures
+				s.Guard.succeeded(remoteIP, c.User())
+				s.Metrics.sftpAuthAttempt("password", true)
 				return &ssh.Permissions{}, nil
 			}
+			s.Metrics.sftpAuthAttempt("password", false)
 			log.Printf("[WARN] SFTP password authentication failed for user %s from %s", c.User(), c.RemoteAddr())
+			time.Sleep(s.Guard.failed(remoteIP, c.User(), "sftp"))
 			return nil, fmt.Errorf("authentication failed")
 		},
 		// set a custom server version string - helps hide implementation details
@@ -845,48 +954,6 @@ func (s *SFTP) setupSSHServerConfig() (*ssh.ServerConfig, error) {
 	return config, nil
 }
 
-// checkAuthRateLimit implements a security mechanism to prevent brute-force attacks.
-// It tracks authentication attempts by IP address with the following rules:
-// 1. Maximum of 5 attempts allowed within a 10-minute sliding window
-// 2. Window starts with the first authentication attempt
-// 3. After 10 minutes of inactivity, the counter resets for that IP
-// 4. Successful authentication will reset the counter immediately
-//
-// Returns true if the attempt is allowed, false if it exceeds the rate limit.
-// This is a critical security feature to protect against password guessing.
-func (s *SFTP) checkAuthRateLimit(remoteIP string) bool {
-	s.ipAttemptsMu.Lock()
-	defer s.ipAttemptsMu.Unlock()
-
-	now := time.Now()
-	info, exists := s.ipAttempts[remoteIP]
-
-	// if no previous attempts or window has expired, reset
-	if !exists || now.Sub(info.firstSeen) > 10*time.Minute {
-		s.ipAttempts[remoteIP] = ipAttemptsInfo{
-			count:     1,
-			firstSeen: now,
-			lastSeen:  now,
-		}
-		return true
-	}
-
-	// update attempt record
-	info.count++
-	info.lastSeen = now
-	s.ipAttempts[remoteIP] = info
-
-	// enforce max 5 attempts in a 10-minute window
-	return info.count <= 5
-}
-
-// resetAuthRateLimit clears rate limiting data for an IP after successful auth
-func (s *SFTP) resetAuthRateLimit(remoteIP string) {
-	s.ipAttemptsMu.Lock()
-	defer s.ipAttemptsMu.Unlock()
-	delete(s.ipAttempts, remoteIP)
-}
-
 // loadAuthorizedKeys reads and parses an authorized_keys file
 func loadAuthorizedKeys(authorizedKeysFile string) ([]ssh.PublicKey, error) {
 	// basic validation - just make sure the path isn't empty
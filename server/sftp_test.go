@@ -18,50 +18,47 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-func TestSFTPAuthRateLimit(t *testing.T) {
-	// create a test SFTP server with rate limiting
+func TestSFTPAuthGuard(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "test_sftp_key")
 	sftpServer := &SFTP{
-		Config: Config{
-			SFTPUser:    "testuser",
-			Auth:        "password123",
-			RootDir:     "testdata",
-			SFTPKeyFile: filepath.Join(os.TempDir(), "test_sftp_key"),
-		},
-		FS:         os.DirFS("testdata"),
-		ipAttempts: make(map[string]ipAttemptsInfo),
+		Config: Config{SFTPUser: "testuser", Auth: "password123", RootDir: "testdata", SFTPKeyFile: keyPath},
+		FS:     os.DirFS("testdata"),
+		Guard:  NewAuthGuard(AuthGuardOpts{MaxIPFailures: 3, MaxUserFailures: 10}),
 	}
+	config, err := sftpServer.setupSSHServerConfig()
+	require.NoError(t, err)
 
-	// test the checkAuthRateLimit function directly
-	testIP := "192.168.1.1"
+	conn := &testConnMetadata{user: "testuser", addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 2022}}
+	_, err = config.PasswordCallback(conn, []byte("wrong"))
+	require.EqualError(t, err, "authentication failed")
+	_, err = config.PasswordCallback(conn, []byte("password123"))
+	require.NoError(t, err, "success resets failures")
 
-	// first 5 attempts should succeed
-	for i := range 5 {
-		allowed := sftpServer.checkAuthRateLimit(testIP)
-		assert.True(t, allowed, "Attempt %d should be allowed", i+1)
+	for range 3 {
+		_, err = config.PasswordCallback(conn, []byte("wrong"))
+		require.EqualError(t, err, "authentication failed")
 	}
+	_, err = config.PasswordCallback(conn, []byte("password123"))
+	require.EqualError(t, err, "too many authentication attempts", "locked out even with the right password")
 
-	// 6th attempt should be blocked
-	allowed := sftpServer.checkAuthRateLimit(testIP)
-	assert.False(t, allowed, "6th attempt should be blocked by rate limit")
-
-	// check the recorded attempts
-	sftpServer.ipAttemptsMu.Lock()
-	info, exists := sftpServer.ipAttempts[testIP]
-	sftpServer.ipAttemptsMu.Unlock()
-
-	assert.True(t, exists, "IP should be tracked in rate limiter")
-	assert.Equal(t, 6, info.count, "Attempt count should be 6")
-
-	// test that resetAuthRateLimit clears the record
-	sftpServer.resetAuthRateLimit(testIP)
-
-	sftpServer.ipAttemptsMu.Lock()
-	_, exists = sftpServer.ipAttempts[testIP]
-	sftpServer.ipAttemptsMu.Unlock()
+	other := &testConnMetadata{user: "testuser", addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 2022}}
+	_, err = config.PasswordCallback(other, []byte("password123"))
+	require.NoError(t, err, "other addresses are not locked")
+}
 
-	assert.False(t, exists, "IP record should be cleared after successful auth")
+// testConnMetadata implements ssh.ConnMetadata for auth callbacks
+type testConnMetadata struct {
+	user string
+	addr net.Addr
 }
 
+func (c *testConnMetadata) User() string          { return c.user }
+func (c *testConnMetadata) SessionID() []byte     { return nil }
+func (c *testConnMetadata) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+func (c *testConnMetadata) ServerVersion() []byte { return []byte("SSH-2.0-WebList-SFTP") }
+func (c *testConnMetadata) RemoteAddr() net.Addr  { return c.addr }
+func (c *testConnMetadata) LocalAddr() net.Addr   { return c.addr }
+
 func TestLoadAuthorizedKeys(t *testing.T) {
 	// create a temporary file with an authorized_keys content
 	tmpFile, err := os.CreateTemp("", "auth_keys_test")
@@ -179,6 +176,12 @@ func TestShouldExcludeForSFTP(t *testing.T) {
 			exclude:  []string{"docs/private"},
 			expected: false,
 		},
+		{
+			name:     "file being uploaded",
+			path:     "docs/.weblist-upload-1234-report.pdf",
+			exclude:  []string{},
+			expected: true,
+		},
 	}
 
 	// create a jailed filesystem for testing
@@ -1152,53 +1155,6 @@ func TestListerAt(t *testing.T) {
 // Since ssh.Request.Reply field is not exported, we can't properly test the replyRequest function
 // in isolation. The integration tests above verify that it works correctly in the real flow.
 
-// TestAuthRateLimit tests the rate limiting functionality
-func TestAuthRateLimit(t *testing.T) {
-	s := &SFTP{
-		Config: Config{
-			SFTPUser:    "testuser",
-			SFTPAddress: "127.0.0.1:0",
-			Auth:        "testpass",
-		},
-		ipAttempts: make(map[string]ipAttemptsInfo),
-	}
-
-	ip := "192.168.1.1"
-
-	// first attempt should be allowed
-	allowed := s.checkAuthRateLimit(ip)
-	assert.True(t, allowed)
-
-	// record should exist now
-	s.ipAttemptsMu.Lock()
-	info, exists := s.ipAttempts[ip]
-	s.ipAttemptsMu.Unlock()
-	assert.True(t, exists)
-	assert.Equal(t, 1, info.count)
-
-	// make more attempts up to the limit
-	for range 4 {
-		allowed = s.checkAuthRateLimit(ip)
-		assert.True(t, allowed)
-	}
-
-	// the next attempt should be blocked (6th attempt)
-	allowed = s.checkAuthRateLimit(ip)
-	assert.False(t, allowed)
-
-	// test resetting limit
-	s.resetAuthRateLimit(ip)
-
-	s.ipAttemptsMu.Lock()
-	_, exists = s.ipAttempts[ip]
-	s.ipAttemptsMu.Unlock()
-	assert.False(t, exists)
-
-	// should be allowed again
-	allowed = s.checkAuthRateLimit(ip)
-	assert.True(t, allowed)
-}
-
 // TestReadlink tests the Readlink implementation
 func TestReadlink(t *testing.T) {
 	// create jailed filesystem
@@ -1449,9 +1405,6 @@ func TestSetupSSHServerConfig(t *testing.T) {
 			assert.Equal(t, "SSH-2.0-WebList-SFTP", config.ServerVersion)
 			assert.Equal(t, false, config.NoClientAuth)
 			assert.Equal(t, 6, config.MaxAuthTries)
-
-			// verify the auth rate limiter is initialized
-			assert.NotNil(t, s.ipAttempts)
 		})
 	}
 }
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Storage is a writable filesystem holding served files. Handlers read through the fs.FS side,
+// uploads write with Create and Rename. Names are slash-separated and relative to the storage root.
+type Storage interface {
+	fs.StatFS
+	fs.ReadDirFS
+
+	// Create makes a new file for writing, it fails with fs.ErrExist if the file already exists.
+	// the file is complete once the returned writer is closed without an error. writers completing the
+	// file on Close may implement Abort(err error) error, which is called on failures instead.
+	Create(name string) (io.WriteCloser, error)
+	// Rename moves a file, replacing the target if it exists
+	Rename(oldName, newName string) error
+	// Remove deletes a file
+	Remove(name string) error
+}
+
+// aborter is implemented by writers committing the content on Close, e.g. S3 uploads.
+// Abort discards the content written so far instead.
+type aborter interface {
+	Abort(err error) error
+}
+
+// abortWrite discards a file being written after a failure, the content of writers without Abort is
+// completed by Close and should be removed by the caller
+func abortWrite(w io.WriteCloser, err error) {
+	if a, ok := w.(aborter); ok {
+		_ = a.Abort(err)
+		return
+	}
+	_ = w.Close()
+}
+
+// errSymlinkTarget is returned by local storage for writes replacing a symlink
+var errSymlinkTarget = errors.New("refusing to replace a symlink")
+
+// localStorage is a storage on the local disk, locked to the root directory by rootFS
+type localStorage struct {
+	*rootFS
+}
+
+// NewLocalStorage makes a storage serving the local directory
+func NewLocalStorage(root string) (Storage, error) {
+	rfs, err := newRootFS(root)
+	if err != nil {
+		return nil, err
+	}
+	return &localStorage{rootFS: rfs}, nil
+}
+
+// Create implements Storage, O_EXCL makes it fail on existing files and symlinks alike
+func (l *localStorage) Create(name string) (io.WriteCloser, error) {
+	full, err := l.writePath("create", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644) //nolint:gosec // parent is validated by writePath
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", name, err)
+	}
+	return f, nil
+}
+
+// Rename implements Storage. Replacing a symlink is rejected, even though rename doesn't follow it.
+func (l *localStorage) Rename(oldName, newName string) error {
+	src, err := l.writePath("rename", oldName)
+	if err != nil {
+		return err
+	}
+	dst, err := l.writePath("rename", newName)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(dst); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return &fs.PathError{Op: "rename", Path: newName, Err: errSymlinkTarget}
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("rename %s to %s: %w", oldName, newName, err)
+	}
+	return nil
+}
+
+// Remove implements Storage
+func (l *localStorage) Remove(name string) error {
+	full, err := l.writePath("remove", name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(full); err != nil {
+		return fmt.Errorf("remove %s: %w", name, err)
+	}
+	return nil
+}
+
+// writePath returns the physical path for a write. The parent directory must resolve inside the root,
+// the file itself is not resolved, so a symlink in its place is never followed.
+func (l *localStorage) writePath(op, name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if err := l.validate(op, path.Dir(name)); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(name)), nil
+}
+
+// storage returns the writable storage of served files. FS is used if it supports writes,
+// a read-only FS falls back to local storage at RootDir. nil means nothing is writable.
+func (wb *Web) storage() Storage {
+	if wb.Git != nil {
+		return nil // repository is served read-only
+	}
+	if s, ok := wb.FS.(Storage); ok {
+		return s
+	}
+	if wb.RootDir == "" {
+		return nil
+	}
+	s, err := NewLocalStorage(wb.RootDir)
+	if err != nil {
+		log.Printf("[WARN] failed to open storage at %s: %v", wb.RootDir, err)
+		return nil
+	}
+	return s
+}
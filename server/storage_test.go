@@ -0,0 +1,64 @@
+package server
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "sub"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o600))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")))
+
+	s, err := NewLocalStorage(root)
+	require.NoError(t, err)
+
+	write := func(name, content string) error {
+		w, err := s.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, content); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	require.NoError(t, write("sub/new.txt", "new"))
+	data, err := fs.ReadFile(s, "sub/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	require.ErrorIs(t, write("sub/new.txt", "again"), fs.ErrExist)
+	require.ErrorIs(t, write("link.txt", "through the link"), fs.ErrExist, "symlink is not followed")
+	require.ErrorIs(t, write("escape/new.txt", "outside"), fs.ErrNotExist, "parent resolves outside of the root")
+	require.ErrorIs(t, write("../new.txt", "outside"), fs.ErrInvalid)
+	require.ErrorIs(t, write("missing/new.txt", "x"), fs.ErrNotExist)
+
+	require.NoError(t, write("sub/other.txt", "other"))
+	require.NoError(t, s.Rename("sub/other.txt", "sub/new.txt"))
+	data, err = fs.ReadFile(s, "sub/new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(data))
+
+	require.NoError(t, write("tmp.txt", "tmp"))
+	require.ErrorIs(t, s.Rename("tmp.txt", "link.txt"), errSymlinkTarget)
+	require.ErrorIs(t, s.Rename("tmp.txt", "escape/secret.txt"), fs.ErrNotExist)
+	require.NoError(t, s.Remove("tmp.txt"))
+	_, err = fs.Stat(s, "tmp.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.ErrorIs(t, s.Remove("escape/secret.txt"), fs.ErrNotExist)
+
+	secret, err := os.ReadFile(filepath.Join(outside, "secret.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(secret), "nothing outside of the root changed")
+}
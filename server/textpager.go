@@ -0,0 +1,516 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// pagedViewSize is the size of text files viewed in windows of lines instead of being rendered whole
+const pagedViewSize = 1024 * 1024
+
+const (
+	pagerDefaultLines   = 500              // lines in a window if not requested
+	pagerMaxLines       = 5000             // most lines in a window
+	pagerSearchContext  = 5                // lines shown above a search match
+	pagerSearchTimeout  = 10 * time.Second // search stops here, the client continues from the line reached
+	maxPagerLineLength  = 16 * 1024        // longer lines are cut in windows, searches and tail events
+	lineIndexStep       = 1000             // line index keeps the offset of every lineIndexStep-th line
+	tailInterval        = time.Second      // how often the tailed file is checked for appended lines
+	tailMaxRead         = 1024 * 1024      // most bytes sent by the tail on one check
+	tailPingEvery       = 15               // checks without new lines between keep-alive comments
+	lineIndexCacheCount = 100              // line indexes of the most recently paged files kept
+)
+
+// lineIndex keeps byte offsets of every lineIndexStep-th line of a text file, so a window of lines is read
+// without scanning the file from its start. the index of a growing file, e.g. a log, is extended with
+// appended lines only, a file which got smaller or changed without growing is indexed again.
+type lineIndex struct {
+	mu      sync.Mutex
+	size    int64     // bytes indexed
+	mtime   time.Time // mtime of the file when indexed
+	lines   int       // complete lines in indexed bytes
+	tail    int64     // offset of the incomplete last line
+	offsets []int64   // offsets[i] is the start of line i*lineIndexStep, zero-based
+}
+
+// update indexes the file up to its current size. it's cancelled with the context, keeping the lines
+// indexed so far, the next update continues from there.
+func (li *lineIndex) update(ctx context.Context, rs io.ReadSeeker, size int64, mtime time.Time) error {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	if li.offsets == nil || size < li.size || (size == li.size && !mtime.Equal(li.mtime)) {
+		li.size, li.lines, li.tail, li.offsets = 0, 0, 0, []int64{0}
+	}
+	li.mtime = mtime
+	if size == li.size {
+		return nil
+	}
+	if _, err := rs.Seek(li.size, io.SeekStart); err != nil {
+		return fmt.Errorf("seek to %d: %w", li.size, err)
+	}
+
+	buf := make([]byte, 64*1024)
+	r := io.LimitReader(rs, size-li.size)
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("index lines: %w", err)
+		}
+		n, err := r.Read(buf)
+		for chunk, base := buf[:n], li.size; len(chunk) > 0; {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				break
+			}
+			li.lines++
+			li.tail = base + int64(i) + 1
+			if li.lines%lineIndexStep == 0 {
+				li.offsets = append(li.offsets, li.tail)
+			}
+			base, chunk = li.tail, chunk[i+1:]
+		}
+		li.size += int64(n)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read lines: %w", err)
+		}
+	}
+}
+
+// total returns the number of lines in the indexed part of the file, the last one may be incomplete
+func (li *lineIndex) total() (lines int, size int64) {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	if li.size > li.tail {
+		return li.lines + 1, li.size
+	}
+	return li.lines, li.size
+}
+
+// seekLine positions the reader at the line n, zero-based, and returns a reader of lines from there
+func (li *lineIndex) seekLine(rs io.ReadSeeker, n int) (*bufio.Reader, error) {
+	li.mu.Lock()
+	i := min(n/lineIndexStep, len(li.offsets)-1)
+	offset, skip := li.offsets[i], n-i*lineIndexStep
+	li.mu.Unlock()
+
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek to %d: %w", offset, err)
+	}
+	br := bufio.NewReaderSize(rs, 64*1024)
+	for range skip {
+		if _, err := readLine(br); err != nil {
+			return nil, err
+		}
+	}
+	return br, nil
+}
+
+// window returns up to count lines of the file starting with the line n, zero-based
+func (li *lineIndex) window(rs io.ReadSeeker, n, count int) ([]string, error) {
+	br, err := li.seekLine(rs, n)
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0, min(count, pagerDefaultLines))
+	for len(lines) < count {
+		line, err := readLine(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// search looks for the first line containing the query, case-insensitive, starting with the line n,
+// zero-based. it returns the line found, -1 if there is none, and the number of lines searched through,
+// which is less than the rest of the file if the context is done first.
+func (li *lineIndex) search(ctx context.Context, rs io.ReadSeeker, n int, query string) (match, searched int, err error) {
+	br, err := li.seekLine(rs, n)
+	if errors.Is(err, io.EOF) {
+		return -1, n, nil
+	}
+	if err != nil {
+		return -1, n, err
+	}
+	query = strings.ToLower(query)
+	for line := n; ; line++ {
+		if line%lineIndexStep == 0 && ctx.Err() != nil {
+			return -1, line, nil
+		}
+		text, err := readLine(br)
+		if errors.Is(err, io.EOF) {
+			return -1, line, nil
+		}
+		if err != nil {
+			return -1, line, err
+		}
+		if strings.Contains(strings.ToLower(text), query) {
+			return line, line + 1, nil
+		}
+	}
+}
+
+// readLine reads the next line without its line ending. lines longer than maxPagerLineLength are cut.
+// it returns io.EOF only if there is nothing left to read.
+func readLine(br *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if room := maxPagerLineLength - len(line); room > 0 {
+			line = append(line, chunk[:min(len(chunk), room)]...)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && (!errors.Is(err, io.EOF) || (len(chunk) == 0 && len(line) == 0)) {
+			return "", err // io.EOF is checked by callers
+		}
+		return cleanLine(line), nil
+	}
+}
+
+// cleanLine strips the line ending, invalid UTF-8 and carriage returns from the line
+func cleanLine(line []byte) string {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return strings.ReplaceAll(strings.ToValidUTF8(string(line), "�"), "\r", "")
+}
+
+// textWindow is a window of lines of a paged text file
+type textWindow struct {
+	First    int           // number of the first line shown, one-based, zero if there are no lines
+	Last     int           // number of the last line shown
+	Total    int           // lines in the file
+	Size     int64         // size of the file the window is made for, the tail continues from here
+	Match    int           // line of the search match, zero if not found
+	Searched int           // last line searched through
+	Content  template.HTML // rendered lines
+}
+
+// pagedViewData is the paged view of a large text file, its lines are loaded by the page
+type pagedViewData struct {
+	FileName string
+	FilePath string
+	Theme    string
+	Size     string
+}
+
+// renderPagedView renders the view of a large text file with controls to page, search and tail it
+func (wb *Web) renderPagedView(w http.ResponseWriter, filePath string, info fs.FileInfo, theme string) {
+	data := pagedViewData{
+		FileName: info.Name(),
+		FilePath: filePath,
+		Theme:    theme,
+		Size:     FileInfo{Size: info.Size()}.SizeToString(),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := wb.templates.fileTemplate.ExecuteTemplate(w, "file-pager", data); err != nil {
+		log.Printf("[ERROR] failed to execute file-pager template: %v", err)
+		http.Error(w, "error rendering file view", http.StatusInternalServerError)
+	}
+}
+
+// pagerFile opens the text file requested by the path query parameter for paging. errors are reported
+// with http.Error, except for the mount login which is handled by requireMountAuth.
+func (wb *Web) pagerFile(w http.ResponseWriter, r *http.Request) (p string, file fs.File, info fs.FileInfo, ok bool) {
+	p = r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(w, "file path not provided", http.StatusBadRequest)
+		return "", nil, nil, false
+	}
+	// clean the path to avoid directory traversal
+	p = filepath.ToSlash(filepath.Clean(p))
+
+	if wb.shouldExclude(p) {
+		http.Error(w, "access denied to requested file", http.StatusForbidden)
+		return "", nil, nil, false
+	}
+	if !wb.requireMountAuth(w, r, p) {
+		return "", nil, nil, false
+	}
+	info, err := fs.Stat(wb.FS, p)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return "", nil, nil, false
+	}
+	if info.IsDir() || !DetermineContentType(p).IsText {
+		http.Error(w, "not a text file", http.StatusBadRequest)
+		return "", nil, nil, false
+	}
+	if file, err = wb.FS.Open(p); err != nil {
+		http.Error(w, "error opening file", http.StatusInternalServerError)
+		return "", nil, nil, false
+	}
+	return p, file, info, true
+}
+
+// lineIndexOf returns the line index of the file, brought up to date with its size. indexes are kept
+// by git ref and path, so a growing file is indexed only for its appended part.
+func (wb *Web) lineIndexOf(r *http.Request, p string, rs io.ReadSeeker, info fs.FileInfo) (*lineIndex, error) {
+	idx := &lineIndex{}
+	// fallback to a fresh index if cache not initialized (e.g., in tests)
+	if wb.lineIndexes != nil {
+		var err error
+		idx, err = wb.lineIndexes.Get(wb.selectedRef(r)+":"+p, func() (*lineIndex, error) { return &lineIndex{}, nil })
+		if err != nil {
+			return nil, fmt.Errorf("get line index: %w", err)
+		}
+	}
+	if err := idx.update(r.Context(), rs, info.Size(), info.ModTime()); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// handleTextWindow renders a window of lines of a large text file for the paged view
+// It supports query parameters:
+// - path: the file path
+// - line: the first line, one-based, or "end" for the last window
+// - count: number of lines, 500 by default, up to 5000
+// - search: text to find from the line, the window is moved to the first match
+// - theme: light or dark, for syntax highlighting
+func (wb *Web) handleTextWindow(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
+	p, file, info, ok := wb.pagerFile(w, r)
+	if !ok {
+		return
+	}
+	defer func() { _ = file.Close() }()
+	rs, ok := file.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "file can't be paged", http.StatusInternalServerError)
+		return
+	}
+
+	idx, err := wb.lineIndexOf(r, p, rs, info)
+	if err != nil {
+		log.Printf("[WARN] failed to index lines of %s: %v", p, err)
+		http.Error(w, "error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	count, err := strconv.Atoi(query.Get("count"))
+	if err != nil || count <= 0 {
+		count = pagerDefaultLines
+	}
+	count = min(count, pagerMaxLines)
+	win := textWindow{}
+	win.Total, win.Size = idx.total()
+	first, err := strconv.Atoi(query.Get("line"))
+	if err != nil || first < 1 {
+		first = 1
+	}
+	if query.Get("line") == "end" || first > win.Total {
+		first = max(1, win.Total-count+1)
+	}
+
+	if search := query.Get("search"); search != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), pagerSearchTimeout)
+		match, searched, err := idx.search(ctx, rs, first-1, search)
+		cancel()
+		if err != nil {
+			log.Printf("[WARN] failed to search %s: %v", p, err)
+			http.Error(w, "error reading file", http.StatusInternalServerError)
+			return
+		}
+		win.Searched = searched
+		if match >= 0 {
+			win.Match = match + 1
+			first = max(1, win.Match-pagerSearchContext)
+		}
+	}
+
+	lines, err := idx.window(rs, first-1, count)
+	if err != nil {
+		log.Printf("[WARN] failed to read lines of %s: %v", p, err)
+		http.Error(w, "error reading file", http.StatusInternalServerError)
+		return
+	}
+	if len(lines) > 0 {
+		win.First, win.Last = first, first+len(lines)-1
+	}
+
+	theme := query.Get("theme")
+	if theme == "" {
+		theme = wb.Theme
+	}
+	win.Content = template.HTML(wb.renderTextLines(lines, win.First, info.Name(), theme, win.Match)) //nolint:gosec // escaped by chroma
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := wb.templates.fileTemplate.ExecuteTemplate(w, "text-window", win); err != nil {
+		log.Printf("[ERROR] failed to execute text-window template: %v", err)
+		http.Error(w, "error rendering file view", http.StatusInternalServerError)
+	}
+}
+
+// renderTextLines renders the lines with their numbers starting with first, marking the line mark.
+// lines are highlighted if syntax highlighting is enabled, this way only the visible window is tokenized.
+func (wb *Web) renderTextLines(lines []string, first int, fileName, theme string, mark int) string {
+	lexer := lexers.Fallback
+	if wb.EnableSyntaxHighlighting {
+		if l := lexers.Get(fileName); l != nil {
+			lexer = l
+		}
+	}
+	style := codeStyle(theme)
+	formatter := html.New(html.WithClasses(true), html.WithLineNumbers(true), html.BaseLineNumber(first),
+		html.HighlightLines([][2]int{{mark, mark}}))
+
+	var code strings.Builder
+	for _, line := range lines {
+		code.WriteString(line)
+		code.WriteByte('\n')
+	}
+	var buf strings.Builder
+	buf.WriteString(`<div class="highlight-wrapper">`)
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err == nil {
+		err = formatter.Format(&buf, style, iterator)
+	}
+	if err != nil {
+		log.Printf("[WARN] failed to render lines of %s: %v", fileName, err)
+		return fmt.Sprintf(`<div class="highlight-wrapper"><pre class="chroma">%s</pre></div>`, template.HTMLEscapeString(code.String()))
+	}
+	buf.WriteString("</div>")
+	return buf.String()
+}
+
+// handleTextTail streams lines appended to a text file as server-sent events, like tail -f.
+// the stream starts at the offset query parameter, usually the size of the file the last window was
+// made for, or at Last-Event-ID on reconnect, as each event has the offset it ends at as its id.
+// appended lines are sent in "lines" events, one data field per line. "truncated" event is sent if
+// the file got smaller, e.g. rotated, and the stream continues from its start.
+func (wb *Web) handleTextTail(w http.ResponseWriter, r *http.Request) {
+	wb = wb.forRequest(r) // files of the git ref selected by the user
+	p, file, info, ok := wb.pagerFile(w, r)
+	if !ok {
+		return
+	}
+	_ = file.Close() // the file is opened again on each change, to read it as it's now
+
+	offset, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if err != nil {
+		offset, err = strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	}
+	if err != nil || offset < 0 || offset > info.Size() {
+		offset = info.Size()
+	}
+
+	// the request logger is skipped for event streams, the stream is logged when it ends
+	start := time.Now()
+	defer func() {
+		log.Printf("[DEBUG] tail of %s for %s ended after %s", p, clientIP(r.RemoteAddr), time.Since(start))
+	}()
+
+	// the stream lasts as long as the client wants it, past the server write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+	for idle := 0; ; idle++ {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := fs.Stat(wb.FS, p)
+		if err != nil {
+			_, _ = fmt.Fprint(w, "event: gone\ndata: file not found\n\n")
+			_ = rc.Flush()
+			return
+		}
+		var event string
+		switch {
+		case info.Size() < offset:
+			offset, event = 0, fmt.Sprintf("id: 0\nevent: truncated\ndata: %d\n\n", info.Size())
+		case info.Size() > offset:
+			var lines []string
+			if lines, offset, err = wb.appendedLines(p, offset, info.Size()); err != nil {
+				log.Printf("[WARN] failed to tail %s: %v", p, err)
+				return
+			}
+			if len(lines) > 0 {
+				event = fmt.Sprintf("id: %d\nevent: lines\ndata: %s\n\n", offset, strings.Join(lines, "\ndata: "))
+			}
+		}
+		if event == "" && idle < tailPingEvery {
+			continue
+		}
+		if event == "" {
+			event = ": ping\n\n"
+		}
+		idle = 0
+		if _, err := io.WriteString(w, event); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// appendedLines reads complete lines of the file between offset and size, up to tailMaxRead bytes,
+// and returns them with the offset the next read starts at. an incomplete last line is left for the
+// next read, unless it fills the whole read.
+func (wb *Web) appendedLines(p string, offset, size int64) (lines []string, next int64, err error) {
+	f, err := wb.FS.Open(p)
+	if err != nil {
+		return nil, offset, fmt.Errorf("open %s: %w", p, err)
+	}
+	defer func() { _ = f.Close() }()
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return nil, offset, fmt.Errorf("%s is not seekable", p)
+	}
+	if _, err = rs.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, fmt.Errorf("seek to %d: %w", offset, err)
+	}
+	buf := make([]byte, min(size-offset, tailMaxRead))
+	n, err := io.ReadFull(rs, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, offset, fmt.Errorf("read %s: %w", p, err)
+	}
+	buf = buf[:n]
+	if end := bytes.LastIndexByte(buf, '\n'); end >= 0 {
+		buf = buf[:end+1]
+	} else if n < tailMaxRead {
+		return nil, offset, nil
+	}
+	for line := range bytes.Lines(buf) {
+		lines = append(lines, cleanLine(line[:min(len(line), maxPagerLineLength)]))
+	}
+	return lines, offset + int64(len(buf)), nil
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// numberedLines returns lines "line 1" to "line n"
+func numberedLines(from, to int) string {
+	var sb strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+	return sb.String()
+}
+
+func TestLineIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(path, []byte(numberedLines(1, 2500)+"partial"), 0o600))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+
+	idx := &lineIndex{}
+	require.NoError(t, idx.update(context.Background(), f, info.Size(), info.ModTime()))
+	total, size := idx.total()
+	assert.Equal(t, 2501, total, "incomplete last line counted")
+	assert.Equal(t, info.Size(), size)
+	assert.Len(t, idx.offsets, 3)
+
+	for _, n := range []int{0, 998, 999, 1000, 2001} {
+		lines, err := idx.window(f, n, 3)
+		require.NoError(t, err)
+		assert.Equal(t, []string{fmt.Sprintf("line %d", n+1), fmt.Sprintf("line %d", n+2), fmt.Sprintf("line %d", n+3)}, lines, n)
+	}
+	lines, err := idx.window(f, 2499, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"line 2500", "partial"}, lines)
+	lines, err = idx.window(f, 3000, 10)
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+
+	// appended lines extend the index
+	require.NoError(t, os.WriteFile(path, []byte(numberedLines(1, 2500)+"partial\n"+numberedLines(2502, 3100)), 0o600))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, idx.update(context.Background(), f, info.Size(), info.ModTime()))
+	total, _ = idx.total()
+	assert.Equal(t, 3100, total)
+	assert.Len(t, idx.offsets, 4)
+	lines, err = idx.window(f, 2999, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"line 3000", "line 3001"}, lines)
+
+	// smaller file is indexed again
+	require.NoError(t, os.WriteFile(path, []byte("first\r\nsecond\n"), 0o600))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, idx.update(context.Background(), f, info.Size(), info.ModTime()))
+	total, _ = idx.total()
+	assert.Equal(t, 2, total)
+	lines, err = idx.window(f, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, lines)
+}
+
+func TestLineIndexSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(path, []byte(numberedLines(1, 3000)+"ERROR: disk full\n"+numberedLines(3002, 3010)), 0o600))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	idx := &lineIndex{}
+	require.NoError(t, idx.update(context.Background(), f, info.Size(), info.ModTime()))
+
+	match, searched, err := idx.search(context.Background(), f, 0, "error: DISK")
+	require.NoError(t, err)
+	assert.Equal(t, 3000, match)
+	assert.Equal(t, 3001, searched)
+
+	match, searched, err = idx.search(context.Background(), f, 3001, "error")
+	require.NoError(t, err)
+	assert.Equal(t, -1, match)
+	assert.Equal(t, 3010, searched, "searched to the end")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	match, searched, err = idx.search(ctx, f, 0, "error")
+	require.NoError(t, err)
+	assert.Equal(t, -1, match)
+	assert.Equal(t, 0, searched, "stopped with the context")
+}
+
+func TestReadLineLong(t *testing.T) {
+	long := strings.Repeat("x", maxPagerLineLength*5)
+	br := bufio.NewReaderSize(strings.NewReader(long+"\nnext"), 4096)
+	line, err := readLine(br)
+	require.NoError(t, err)
+	assert.Len(t, line, maxPagerLineLength)
+	line, err = readLine(br)
+	require.NoError(t, err)
+	assert.Equal(t, "next", line)
+	_, err = readLine(br)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestPagedView(t *testing.T) {
+	dir := t.TempDir()
+	big := numberedLines(1, 150000) // about 1.9MB
+	require.Greater(t, len(big), pagedViewSize)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.go"), []byte(big), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "small.txt"), []byte("small file"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "image.png"), []byte("png"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o600))
+
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Exclude: []string{"secret.txt"}, EnableSyntaxHighlighting: true},
+		FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	// large file gets the pager instead of its content
+	rr := get("/view/big.go")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `class="pager-toolbar"`)
+	assert.NotContains(t, rr.Body.String(), "line 1000")
+	assert.Less(t, rr.Body.Len(), 20*1024)
+
+	rr = get("/view/small.txt")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "small file")
+
+	rr = get("/partials/text-window?path=big.go&line=1000&count=10")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `data-first="1000" data-last="1009" data-total="150000"`)
+	assert.Contains(t, body, fmt.Sprintf(`data-size="%d"`, len(big)))
+	assert.Contains(t, body, "1000</span>")
+	assert.Contains(t, body, `<span class="mi">1009</span>`, "window highlighted")
+	assert.NotContains(t, body, "line</span> <span class=\"mi\">1010<")
+
+	rr = get("/partials/text-window?path=big.go&line=end")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `data-first="149501" data-last="150000"`)
+
+	rr = get("/partials/text-window?path=big.go&line=99999999&count=5")
+	assert.Contains(t, rr.Body.String(), `data-first="149996" data-last="150000"`, "past the end shows the last lines")
+
+	rr = get("/partials/text-window?path=big.go&line=100&search=line+120000&count=20")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `data-first="119995" data-last="120014"`)
+	assert.Contains(t, rr.Body.String(), `data-match="120000" data-searched="120000"`)
+	assert.Contains(t, rr.Body.String(), `class="line hl"`)
+
+	rr = get("/partials/text-window?path=big.go&line=120001&search=line+120000&count=20")
+	assert.Contains(t, rr.Body.String(), `data-match="0" data-searched="150000"`)
+	assert.Contains(t, rr.Body.String(), `data-first="120001"`, "window stays if not found")
+
+	assert.Equal(t, http.StatusForbidden, get("/partials/text-window?path=secret.txt").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/partials/text-window?path=image.png").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/partials/text-window").Code)
+	assert.Equal(t, http.StatusNotFound, get("/partials/text-window?path=missing.txt").Code)
+	assert.Equal(t, http.StatusForbidden, get("/events/tail?path=secret.txt").Code)
+}
+
+func TestTextTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(path, []byte("old line\n"), 0o600))
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test"}, FS: os.DirFS(dir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events/tail?path=app.log&offset=9", http.NoBody)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600) //nolint:gosec // test file
+	require.NoError(t, err)
+	_, err = fh.WriteString("new 1\n\nnew 2\r\nincomplete")
+	require.NoError(t, err)
+	require.NoError(t, fh.Close())
+
+	// reads the next event, skipping keep-alive comments
+	br := bufio.NewReader(resp.Body)
+	readEvent := func() []string {
+		var event []string
+		for {
+			line, err := br.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" && len(event) > 0 {
+				return event
+			}
+			if line != "" && !strings.HasPrefix(line, ":") {
+				event = append(event, line)
+			}
+		}
+	}
+	assert.Equal(t, []string{"id: 23", "event: lines", "data: new 1", "data: ", "data: new 2"}, readEvent())
+
+	// truncated file starts over
+	require.NoError(t, os.WriteFile(path, []byte("rotated\n"), 0o600))
+	assert.Equal(t, []string{"id: 0", "event: truncated"}, readEvent()[:2])
+	assert.Equal(t, []string{"id: 8", "event: lines", "data: rotated"}, readEvent())
+}
+
+func TestTextTailThroughMiddlewares(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(path, []byte("old line\n"), 0o600))
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test", Compress: true}, FS: os.DirFS(dir), Metrics: NewMetrics()}
+	router, err := srv.router()
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(router)
+	ts.Config.WriteTimeout = 1500 * time.Millisecond // shorter than the stream, lifted for it
+	ts.Start()
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events/tail?path=app.log&offset=9", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	appendLine := func(line string) {
+		fh, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600) //nolint:gosec // test file
+		require.NoError(t, err)
+		_, err = fh.WriteString(line + "\n")
+		require.NoError(t, err)
+		require.NoError(t, fh.Close())
+	}
+	br := bufio.NewReader(resp.Body)
+	readData := func() string {
+		for {
+			line, err := br.ReadString('\n')
+			require.NoError(t, err, "event pushed while the stream is open")
+			if strings.HasPrefix(line, "data: ") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}
+
+	// events are flushed through metrics, logger and compression, as the handler is still streaming
+	appendLine("first")
+	assert.Equal(t, "first", readData())
+
+	// the stream outlives the server write timeout
+	time.Sleep(ts.Config.WriteTimeout)
+	appendLine("second")
+	assert.Equal(t, "second", readData())
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// throttleChunk is the largest amount of data written or read at once under a bandwidth limit
+const throttleChunk = 32 * 1024
+
+// downloadRetryAfter is suggested to clients rejected for too many concurrent downloads
+const downloadRetryAfter = 10 * time.Second
+
+// errTooManyDownloads is returned when the client has reached its limit of concurrent downloads
+var errTooManyDownloads = errors.New("too many concurrent downloads")
+
+// ThrottleOpts defines bandwidth and concurrency limits of downloads
+type ThrottleOpts struct {
+	Rate         int64 // bytes per second of a single download, unlimited if zero
+	TotalRate    int64 // bytes per second of all downloads together, HTTP and SFTP, unlimited if zero
+	MaxDownloads int   // concurrent downloads of a client, a user or an address, unlimited if zero
+}
+
+// Throttle limits the bandwidth of downloads over HTTP and SFTP and the number of concurrent downloads of a client.
+// All methods are safe to call on a nil receiver, which makes throttling optional for callers.
+type Throttle struct {
+	opts  ThrottleOpts
+	total *rate.Limiter // shared by all downloads, nil if unlimited
+
+	mu     sync.Mutex
+	active map[string]int // concurrent downloads by client
+}
+
+// NewThrottle makes download limits
+func NewThrottle(opts ThrottleOpts) *Throttle {
+	res := &Throttle{opts: opts, active: map[string]int{}}
+	if opts.TotalRate > 0 {
+		res.total = rate.NewLimiter(rate.Limit(opts.TotalRate), throttleChunk)
+	}
+	return res
+}
+
+// start begins a download of the client, it returns the limiters of the download and a function to call when
+// it's done. errTooManyDownloads is returned if the client has reached its limit of concurrent downloads.
+func (t *Throttle) start(client string) (limiters []*rate.Limiter, done func(), err error) {
+	if t == nil {
+		return nil, func() {}, nil
+	}
+	if t.opts.MaxDownloads > 0 {
+		t.mu.Lock()
+		if t.active[client] >= t.opts.MaxDownloads {
+			t.mu.Unlock()
+			return nil, nil, errTooManyDownloads
+		}
+		t.active[client]++
+		t.mu.Unlock()
+	}
+
+	var once sync.Once
+	done = func() {
+		once.Do(func() {
+			if t.opts.MaxDownloads <= 0 {
+				return
+			}
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.active[client]--; t.active[client] <= 0 {
+				delete(t.active, client)
+			}
+		})
+	}
+
+	if t.opts.Rate > 0 {
+		limiters = append(limiters, rate.NewLimiter(rate.Limit(t.opts.Rate), throttleChunk))
+	}
+	if t.total != nil {
+		limiters = append(limiters, t.total)
+	}
+	return limiters, done, nil
+}
+
+// waitLimiters blocks until all limiters allow n bytes, n is at most throttleChunk
+func waitLimiters(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return fmt.Errorf("bandwidth limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// throttleDownload starts a download of the request limited by the configured bandwidth. it returns the writer
+// to send the content to and a function to call when the download is done. if the client has too many downloads
+// already, it responds with 429 and returns false.
+func (wb *Web) throttleDownload(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func(), bool) {
+	client := wb.identifiedUser(r)
+	if client == "" {
+		client = wb.guardIP(r) // forwarding headers count only from trusted proxies, any client could change them
+	}
+	limiters, done, err := wb.Throttle.start(client)
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(downloadRetryAfter.Seconds())))
+		http.Error(w, "Too many concurrent downloads, please try again later", http.StatusTooManyRequests)
+		return nil, nil, false
+	}
+	if len(limiters) == 0 {
+		return w, done, true
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), limiters: limiters}, done, true
+}
+
+// throttledWriter writes the response no faster than its limiters allow
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+// Write writes p in chunks, waiting for the limiters before each of them
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := min(len(p), throttleChunk)
+		if err := waitLimiters(w.ctx, w.limiters, chunk); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (w *throttledWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// throttledReaderAt reads a file for SFTP no faster than its limiters allow and ends the download on close
+type throttledReaderAt struct {
+	io.ReaderAt
+	limiters []*rate.Limiter
+	done     func()
+}
+
+// ReadAt reads from the underlying reader, waiting for the limiters for the amount read
+func (r *throttledReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+	for left := n; left > 0; left -= throttleChunk {
+		if werr := waitLimiters(context.Background(), r.limiters, min(left, throttleChunk)); werr != nil {
+			return 0, werr
+		}
+	}
+	return n, err
+}
+
+// Close ends the download and closes the underlying reader if it can be closed
+func (r *throttledReaderAt) Close() error {
+	r.done()
+	if c, ok := r.ReaderAt.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
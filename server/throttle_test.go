@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottle(t *testing.T) {
+	th := NewThrottle(ThrottleOpts{Rate: 64 * 1024, TotalRate: 128 * 1024, MaxDownloads: 2})
+
+	limiters, done1, err := th.start("alice")
+	require.NoError(t, err)
+	assert.Len(t, limiters, 2)
+	_, done2, err := th.start("alice")
+	require.NoError(t, err)
+	_, _, err = th.start("alice")
+	require.ErrorIs(t, err, errTooManyDownloads)
+	_, done3, err := th.start("bob")
+	require.NoError(t, err, "limit is per client")
+
+	done1()
+	done1() // second call is ignored
+	_, done4, err := th.start("alice")
+	require.NoError(t, err)
+	_, _, err = th.start("alice")
+	require.ErrorIs(t, err, errTooManyDownloads)
+	done2()
+	done3()
+	done4()
+	assert.Empty(t, th.active)
+
+	var nilThrottle *Throttle
+	limiters, done, err := nilThrottle.start("alice")
+	require.NoError(t, err)
+	assert.Empty(t, limiters)
+	done()
+}
+
+func TestThrottledWriterAndReader(t *testing.T) {
+	th := NewThrottle(ThrottleOpts{Rate: 64 * 1024})
+	data := bytes.Repeat([]byte("x"), 96*1024) // burst of 32K, then 64K at 64K/s
+
+	limiters, done, err := th.start("alice")
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	w := &throttledWriter{ResponseWriter: rr, ctx: t.Context(), limiters: limiters}
+	started := time.Now()
+	n, err := w.Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, data, rr.Body.Bytes())
+	assert.GreaterOrEqual(t, time.Since(started), 900*time.Millisecond)
+	done()
+
+	limiters, done, err = th.start("alice")
+	require.NoError(t, err)
+	closed := false
+	ra := &throttledReaderAt{ReaderAt: &memReaderAt{data: data}, limiters: limiters, done: func() { closed = true; done() }}
+	started = time.Now()
+	buf := make([]byte, len(data))
+	n, err = ra.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.GreaterOrEqual(t, time.Since(started), 900*time.Millisecond)
+	_, err = ra.ReadAt(buf, int64(len(data)))
+	assert.Equal(t, io.EOF, err)
+	require.NoError(t, ra.Close())
+	assert.True(t, closed)
+}
+
+func TestThrottleDownloads(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.bin"), bytes.Repeat([]byte("x"), 1024), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "image.png"), []byte("png"), 0o600))
+	srv := &Web{Config: Config{RootDir: dir, Title: "Test"}, FS: os.DirFS(dir), Throttle: NewThrottle(ThrottleOpts{MaxDownloads: 1})}
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		req.RemoteAddr = "10.0.0.1:1000"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a download in progress of the same client
+	_, done, err := srv.Throttle.start("10.0.0.1")
+	require.NoError(t, err)
+	form := url.Values{"selected-files": {"big.bin"}}
+	selected := httptest.NewRequest(http.MethodPost, "/download-selected", strings.NewReader(form.Encode()))
+	selected.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/big.bin", http.NoBody),
+		httptest.NewRequest(http.MethodGet, "/view/image.png", http.NoBody),
+		selected,
+	} {
+		rr := do(req)
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code, req.URL.Path)
+		assert.Equal(t, "10", rr.Header().Get("Retry-After"))
+	}
+
+	// forwarding headers of the client don't get it a new slot
+	for _, hdr := range []string{"X-Forwarded-For", "X-Real-IP"} {
+		req := httptest.NewRequest(http.MethodGet, "/big.bin", http.NoBody)
+		req.Header.Set(hdr, "1.1.1.1")
+		assert.Equal(t, http.StatusTooManyRequests, do(req).Code, hdr)
+	}
+
+	done()
+	rr := do(httptest.NewRequest(http.MethodGet, "/big.bin", http.NoBody))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1024, rr.Body.Len())
+	assert.Empty(t, srv.Throttle.active, "slot released after the download")
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certCheckInterval limits how often certificate files are checked for changes, the check is done on handshakes
+const certCheckInterval = 10 * time.Second
+
+// tlsEnabled returns true if the server is configured to serve HTTPS
+func (wb *Web) tlsEnabled() bool {
+	return wb.TLSCert != "" || wb.TLSSelfSigned
+}
+
+// tlsConfig makes TLS config serving the configured or self-signed certificate.
+// with client CA, client certificates are verified and required unless password or OIDC login is available as well.
+func (wb *Web) tlsConfig() (*tls.Config, error) {
+	res := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch {
+	case wb.TLSCert != "":
+		reloader, err := newCertReloader(wb.TLSCert, wb.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		res.GetCertificate = reloader.getCertificate
+	default:
+		cert, err := selfSignedCert()
+		if err != nil {
+			return nil, err
+		}
+		res.Certificates = []tls.Certificate{cert}
+	}
+
+	if wb.TLSClientCA != "" {
+		pem, err := os.ReadFile(wb.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", wb.TLSClientCA)
+		}
+		res.ClientCAs = pool
+		res.ClientAuth = tls.RequireAndVerifyClientCert
+		if wb.authEnabled() {
+			res.ClientAuth = tls.VerifyClientCertIfGiven // users without certificate log in with password or OIDC
+		}
+	}
+	return res, nil
+}
+
+// clientCertUser returns the user of a verified client certificate, empty if there is none.
+// the user is the subject common name, or the first email address of the certificate without one.
+func clientCertUser(r *http.Request) string {
+	if r == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
+
+// runRedirectServer redirects plain HTTP requests on TLSRedirect to HTTPS until the context is canceled
+func (wb *Web) runRedirectServer(ctx context.Context) {
+	srv := &http.Server{
+		Addr:              wb.TLSRedirect,
+		Handler:           http.HandlerFunc(wb.handleHTTPSRedirect),
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second) //nolint:contextcheck // parent is canceled
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("[WARN] redirect server shutdown failed: %v", err)
+		}
+	}()
+
+	log.Printf("[INFO] starting HTTP to HTTPS redirect server on %s", wb.TLSRedirect)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[WARN] redirect server failed: %v", err)
+	}
+}
+
+// handleHTTPSRedirect redirects the request to the same host and path on the HTTPS listener
+func (wb *Web) handleHTTPSRedirect(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if _, port, err := net.SplitHostPort(wb.ListenAddr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 address
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// certReloader serves a certificate from files and reloads it once the files change,
+// so a renewed certificate is picked up without restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // newest mtime of the loaded files
+	checked time.Time // last time the files were checked
+}
+
+// newCertReloader loads the certificate and key, failing if they are invalid
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	res := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := res.load(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate, a failed reload keeps serving the loaded certificate
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= certCheckInterval {
+		c.checked = time.Now()
+		if modTime, err := c.filesModTime(); err == nil && !modTime.Equal(c.modTime) {
+			if err := c.load(); err != nil {
+				log.Printf("[WARN] failed to reload TLS certificate, keep serving the loaded one: %v", err)
+			} else {
+				log.Printf("[INFO] reloaded TLS certificate %s", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// load reads the certificate and key, the caller holds the lock unless the reloader is not shared yet
+func (c *certReloader) load() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	c.cert, c.modTime, c.checked = &cert, modTime, time.Now()
+	return nil
+}
+
+func (c *certReloader) filesModTime() (time.Time, error) {
+	var res time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		st, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to check TLS certificate: %w", err)
+		}
+		if st.ModTime().After(res) {
+			res = st.ModTime()
+		}
+	}
+	return res, nil
+}
+
+// selfSignedCert generates a certificate for localhost, the host name and addresses of local interfaces,
+// for LAN use where no certificate authority is available. Browsers warn about it, the logged fingerprint
+// lets users check they accept the right one.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "weblist", Organization: []string{"weblist self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	fingerprint := sha256.Sum256(der)
+	log.Printf("[INFO] generated self-signed TLS certificate for %v %v, SHA-256 fingerprint %s",
+		tmpl.DNSNames, tmpl.IPAddresses, hex.EncodeToString(fingerprint[:]))
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCert is a certificate with its key, signed by parent or self-signed if parent is nil
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func makeTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM writes the certificate and key files and returns their paths
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	first := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "first"}, DNSNames: []string{"localhost"}}, nil)
+	certFile, keyFile := first.writePEM(t, dir, "server")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := reloader.getCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.der, cert.Certificate[0])
+
+	// renewed certificate is picked up on the next check
+	second := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "second"}, DNSNames: []string{"localhost"}}, nil)
+	second.writePEM(t, dir, "server")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	cert, err = reloader.getCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.der, cert.Certificate[0], "files are not checked again within the interval")
+
+	reloader.checked = time.Time{}
+	cert, err = reloader.getCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.der, cert.Certificate[0])
+
+	// broken files keep the loaded certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	reloader.checked = time.Time{}
+	cert, err = reloader.getCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.der, cert.Certificate[0])
+
+	_, err = newCertReloader(certFile, keyFile)
+	require.Error(t, err)
+	_, err = newCertReloader(filepath.Join(dir, "missing.crt"), keyFile)
+	require.Error(t, err)
+}
+
+func TestSelfSignedCert(t *testing.T) {
+	cert, err := selfSignedCert()
+	require.NoError(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Contains(t, parsed.DNSNames, "localhost")
+	require.NoError(t, parsed.VerifyHostname("127.0.0.1"))
+	assert.True(t, parsed.NotAfter.After(time.Now().AddDate(0, 11, 0)))
+}
+
+func TestHandleHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		listen, host, target, want string
+	}{
+		{listen: ":443", host: "files.example.com", target: "/docs?path=a", want: "https://files.example.com/docs?path=a"},
+		{listen: ":8443", host: "files.example.com:8080", target: "/", want: "https://files.example.com:8443/"},
+		{listen: "0.0.0.0:443", host: "192.168.1.10:80", target: "/a%20b.txt", want: "https://192.168.1.10/a%20b.txt"},
+		{listen: ":443", host: "[::1]:80", target: "/", want: "https://[::1]/"},
+		{listen: ":8443", host: "[::1]", target: "/", want: "https://[::1]:8443/"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.want, func(t *testing.T) {
+			wb := &Web{Config: Config{ListenAddr: tc.listen}}
+			req := httptest.NewRequest(http.MethodGet, tc.target, http.NoBody)
+			req.Host = tc.host
+			rr := httptest.NewRecorder()
+			wb.handleHTTPSRedirect(rr, req)
+			assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+			assert.Equal(t, tc.want, rr.Header().Get("Location"))
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test CA"}, IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	alice := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "alice"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca)
+	bob := makeTestCert(t, &x509.Certificate{EmailAddresses: []string{"bob@example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca)
+	stranger := makeTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, nil)
+
+	// startServer serves a handler reporting the current user behind the auth middleware
+	startServer := func(t *testing.T, auth string) *httptest.Server {
+		t.Helper()
+		wb := &Web{Config: Config{TLSSelfSigned: true, TLSClientCA: caFile, Auth: auth, SessionSecret: "secret"}}
+		cfg, err := wb.tlsConfig()
+		require.NoError(t, err)
+		ts := httptest.NewUnstartedServer(wb.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "user: "+wb.currentUser(r))
+		})))
+		ts.TLS = cfg
+		ts.StartTLS()
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	get := func(ts *httptest.Server, cert *testCert) (*http.Response, error) {
+		tlsCfg := &tls.Config{InsecureSkipVerify: true} //nolint:gosec // self-signed server certificate
+		if cert != nil {
+			tlsCfg.Certificates = []tls.Certificate{cert.tlsCert()}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg},
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		return client.Get(ts.URL + "/")
+	}
+
+	readBody := func(t *testing.T, resp *http.Response) string {
+		t.Helper()
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("certificate required without password", func(t *testing.T) {
+		ts := startServer(t, "")
+		resp, err := get(ts, alice)
+		require.NoError(t, err)
+		assert.Equal(t, "user: alice", readBody(t, resp))
+
+		resp, err = get(ts, bob)
+		require.NoError(t, err)
+		assert.Equal(t, "user: bob@example.com", readBody(t, resp), "email is used without common name")
+
+		_, err = get(ts, nil)
+		require.Error(t, err, "handshake fails without certificate")
+		_, err = get(ts, stranger)
+		require.Error(t, err, "handshake fails with certificate of unknown CA")
+	})
+
+	t.Run("certificate or password", func(t *testing.T) {
+		ts := startServer(t, "password")
+		resp, err := get(ts, alice)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "user: alice", readBody(t, resp))
+
+		resp, err = get(ts, nil)
+		require.NoError(t, err)
+		_ = readBody(t, resp)
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Equal(t, "/login", resp.Header.Get("Location"))
+	})
+
+	t.Run("bad client CA", func(t *testing.T) {
+		wb := &Web{Config: Config{TLSSelfSigned: true, TLSClientCA: filepath.Join(dir, "ca.key")}}
+		_, err := wb.tlsConfig()
+		require.ErrorContains(t, err, "no certificates found")
+	})
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpPendingScope   = "2fa"           // scope of the token passed from the password step to the code step
+	totpPendingTTL     = 5 * time.Minute // time to enter the code after the password
+	totpPeriod         = 30              // seconds each code is valid for
+	totpRecoveryCodes  = 10              // number of recovery codes generated on enrollment
+	totpRecoveryLength = 10              // characters of a recovery code, shown in two groups
+)
+
+// TOTPStore keeps TOTP secrets and recovery codes of users in a JSON file.
+// the file is read on start and written on enrollment and use of a recovery code.
+type TOTPStore struct {
+	path string
+
+	mu    sync.Mutex
+	users map[string]*totpUser
+}
+
+// totpUser is the second factor of a user as stored in the file
+type totpUser struct {
+	Secret   string   `json:"secret"`    // base32 TOTP secret
+	Recovery []string `json:"recovery"`  // SHA-256 of unused recovery codes
+	LastStep int64    `json:"last_step"` // time step of the last accepted code, codes can't be reused
+}
+
+// NewTOTPStore loads secrets from the file, a missing file means no user has enrolled yet
+func NewTOTPStore(path string) (*TOTPStore, error) {
+	res := &TOTPStore{path: path, users: map[string]*totpUser{}}
+	data, err := os.ReadFile(path) //nolint:gosec // path is from trusted config
+	if errors.Is(err, fs.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read 2FA file: %w", err)
+	}
+	if err := json.Unmarshal(data, &res.users); err != nil {
+		return nil, fmt.Errorf("failed to parse 2FA file %s: %w", path, err)
+	}
+	return res, nil
+}
+
+// enrolled checks if the user has set up the second factor
+func (s *TOTPStore) enrolled(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.users[user]
+	return ok
+}
+
+// enroll stores the secret of the user and returns new recovery codes, the user must not be enrolled yet
+func (s *TOTPStore) enroll(user, secret string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user]; ok {
+		return nil, fmt.Errorf("user %s is already enrolled", user)
+	}
+
+	codes := make([]string, 0, totpRecoveryCodes)
+	rec := &totpUser{Secret: secret, LastStep: time.Now().Unix() / totpPeriod}
+	for range totpRecoveryCodes {
+		code := newRecoveryCode()
+		codes = append(codes, code)
+		rec.Recovery = append(rec.Recovery, hashRecoveryCode(code))
+	}
+	s.users[user] = rec
+	if err := s.save(); err != nil {
+		delete(s.users, user)
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verify checks the code is the current TOTP code or an unused recovery code of the user.
+// a recovery code is removed once used and an accepted TOTP code can't be used again.
+func (s *TOTPStore) verify(user, code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.users[user]
+	if !ok {
+		return false
+	}
+
+	if step, ok := totpStep(rec.Secret, code, time.Now()); ok {
+		if step <= rec.LastStep {
+			return false // replayed code
+		}
+		rec.LastStep = step
+		if err := s.save(); err != nil {
+			log.Printf("[WARN] failed to save 2FA file: %v", err)
+		}
+		return true
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, h := range rec.Recovery {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+		rec.Recovery = append(rec.Recovery[:i], rec.Recovery[i+1:]...)
+		if err := s.save(); err != nil {
+			log.Printf("[WARN] failed to save 2FA file: %v", err)
+		}
+		log.Printf("[INFO] recovery code used by %s, %d left", user, len(rec.Recovery))
+		return true
+	}
+	return false
+}
+
+// save writes the file, the caller holds the lock
+func (s *TOTPStore) save() error {
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode 2FA file: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write 2FA file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes private data to a temporary file with 0600 permissions and renames it over the path,
+// so readers never see a partly written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after rename
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// totpStep returns the time step the code is valid for, allowing one step of clock skew
+func totpStep(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != 6 {
+		return 0, false
+	}
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	for _, skew := range []int64{0, -1, 1} {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, t, opts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return t.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCode generates a random recovery code like "k3m9p-x2q7r"
+func newRecoveryCode() string {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789" // no look-alike characters
+	b := make([]byte, totpRecoveryLength)
+	_, _ = rand.Read(b) // never fails
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b[:totpRecoveryLength/2]) + "-" + string(b[totpRecoveryLength/2:])
+}
+
+// hashRecoveryCode hashes the code ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// totpEnrollment is shown on the login page of a user setting up the second factor
+type totpEnrollment struct {
+	QR     template.URL // PNG data URL of the otpauth QR code
+	Secret string       // secret for manual entry
+}
+
+// startTOTP continues the password login with the second factor step, or with enrollment
+// if the user has no second factor yet. The pending token proves the password was correct.
+func (wb *Web) startTOTP(w http.ResponseWriter, user string) {
+	pending := wb.generateToken(totpPendingScope, user)
+	data := wb.loginData()
+	data.TOTP = pending
+	if !wb.TOTP.enrolled(user) {
+		enrollment, err := wb.totpEnrollment(pending, user)
+		if err != nil {
+			http.Error(w, "failed to set up 2FA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Enroll = enrollment
+	}
+	wb.renderLogin(w, data)
+}
+
+// handleTOTPSubmit checks the code of the second factor step, enrolls the user on the first login
+// and sets the session cookie
+func (wb *Web) handleTOTPSubmit(w http.ResponseWriter, r *http.Request, pending string) {
+	user, ok := wb.pendingTOTPUser(pending)
+	if !ok {
+		wb.renderLoginError(w, "Login expired, please try again")
+		return
+	}
+	if wb.loginLocked(w, r, user) {
+		return
+	}
+	code := r.FormValue("code")
+
+	var recoveryCodes []string
+	if wb.TOTP.enrolled(user) {
+		if !wb.TOTP.verify(user, code) {
+			log.Printf("[WARN] invalid 2FA code for %s", user)
+			wb.loginFailed(r, user)
+			wb.renderTOTPError(w, pending, nil, "Invalid code")
+			return
+		}
+	} else {
+		key, err := wb.enrollmentKey(pending, user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, valid := totpStep(key.Secret(), code, time.Now()); !valid {
+			wb.loginFailed(r, user)
+			enrollment, err := wb.totpEnrollment(pending, user)
+			if err != nil {
+				http.Error(w, "failed to set up 2FA: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			wb.renderTOTPError(w, pending, enrollment, "Invalid code, check the time on your device")
+			return
+		}
+		if recoveryCodes, err = wb.TOTP.enroll(user, key.Secret()); err != nil {
+			log.Printf("[WARN] 2FA enrollment of %s failed: %v", user, err)
+			wb.renderLoginError(w, "Failed to set up 2FA, please try again")
+			return
+		}
+		log.Printf("[INFO] %s enrolled 2FA", user)
+	}
+
+	wb.Guard.succeeded(wb.guardIP(r), user)
+	wb.setSessionCookie(w, r, "")
+
+	if len(recoveryCodes) > 0 {
+		// recovery codes are shown once, the user continues to the files from there
+		data := wb.loginData()
+		data.RecoveryCodes = recoveryCodes
+		wb.renderLogin(w, data)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// renderTOTPError renders the second factor step again with an error message
+func (wb *Web) renderTOTPError(w http.ResponseWriter, pending string, enrollment *totpEnrollment, errorMsg string) {
+	data := wb.loginData()
+	data.TOTP, data.Enroll, data.Error = pending, enrollment, errorMsg
+	wb.renderLogin(w, data)
+}
+
+// pendingTOTPUser returns the user of a valid pending token issued after the password step
+func (wb *Web) pendingTOTPUser(pending string) (string, bool) {
+	if !wb.validateScopedToken(pending, totpPendingScope) {
+		return "", false
+	}
+	parts := strings.Split(pending, ".")
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)) > totpPendingTTL {
+		return "", false
+	}
+	_, encUser, found := strings.Cut(parts[0], "!")
+	if !found {
+		return "", false
+	}
+	user, err := base64.RawURLEncoding.DecodeString(encUser)
+	if err != nil {
+		return "", false
+	}
+	return string(user), true
+}
+
+// enrollmentKey derives the TOTP key offered for enrollment from the pending token,
+// so the secret shown with the QR code is known on submit without keeping it on the server
+func (wb *Web) enrollmentKey(pending, user string) (*otp.Key, error) {
+	h := hmac.New(sha256.New, []byte(wb.SessionSecret))
+	h.Write([]byte("totp-enroll:" + pending))
+	issuer := wb.Title
+	if issuer == "" {
+		issuer = "weblist"
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: issuer, AccountName: user, Period: totpPeriod, Secret: h.Sum(nil)[:20]})
+	if err != nil {
+		return nil, fmt.Errorf("failed to make 2FA key: %w", err)
+	}
+	return key, nil
+}
+
+// totpEnrollment makes the QR code and secret of the enrollment offered with the pending token
+func (wb *Web) totpEnrollment(pending, user string) (*totpEnrollment, error) {
+	key, err := wb.enrollmentKey(pending, user)
+	if err != nil {
+		return nil, err
+	}
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make QR code: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	return &totpEnrollment{
+		QR:     template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), //nolint:gosec // generated image
+		Secret: key.Secret(),
+	}, nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "2fa.json")
+	store, err := NewTOTPStore(file)
+	require.NoError(t, err)
+	assert.False(t, store.enrolled("alice"))
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "test", AccountName: "alice"})
+	require.NoError(t, err)
+	codes, err := store.enroll("alice", key.Secret())
+	require.NoError(t, err)
+	assert.Len(t, codes, totpRecoveryCodes)
+	assert.Regexp(t, `^[a-z2-9]{5}-[a-z2-9]{5}$`, codes[0])
+	_, err = store.enroll("alice", key.Secret())
+	require.Error(t, err, "enrolled once")
+
+	st, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+	next, err := totp.GenerateCode(key.Secret(), time.Now().Add(totpPeriod*time.Second))
+	require.NoError(t, err)
+	assert.True(t, store.verify("alice", next))
+	assert.False(t, store.verify("alice", next), "code can't be reused")
+	assert.False(t, store.verify("alice", "000000"))
+	assert.False(t, store.verify("bob", next))
+
+	assert.True(t, store.verify("alice", strings.ToUpper(codes[1])), "recovery code ignores case")
+	assert.False(t, store.verify("alice", codes[1]), "recovery code is single use")
+
+	// state survives restart
+	store, err = NewTOTPStore(file)
+	require.NoError(t, err)
+	assert.True(t, store.enrolled("alice"))
+	assert.False(t, store.verify("alice", codes[1]))
+	assert.True(t, store.verify("alice", strings.ReplaceAll(codes[2], "-", "")))
+
+	require.NoError(t, os.WriteFile(file, []byte("{"), 0o600))
+	_, err = NewTOTPStore(file)
+	require.ErrorContains(t, err, "failed to parse 2FA file")
+}
+
+func TestTOTPLogin(t *testing.T) {
+	store, err := NewTOTPStore(filepath.Join(t.TempDir(), "2fa.json"))
+	require.NoError(t, err)
+	srv := &Web{Config: Config{RootDir: "testdata", Auth: "password", SessionSecret: "session-secret", Title: "Test"},
+		FS: os.DirFS("testdata"), TOTP: store}
+	require.NoError(t, srv.initTemplates())
+	router, err := srv.router()
+	require.NoError(t, err)
+
+	requests := 0
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		requests++
+		req.RemoteAddr = "192.168.1." + strconv.Itoa(requests) + ":4000" // own login rate limit
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	// pendingToken returns the pending token of the code form, unescaped as a browser does
+	pendingToken := func(t *testing.T, body string) string {
+		t.Helper()
+		m := regexp.MustCompile(`name="pending" value="([^"]+)"`).FindStringSubmatch(body)
+		require.Len(t, m, 2)
+		return html.UnescapeString(m[1])
+	}
+	authCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == "auth" {
+				return c
+			}
+		}
+		return nil
+	}
+
+	// first login enrolls
+	rr := post(url.Values{"username": {"weblist"}, "password": {"password"}})
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, authCookie(rr), "no session before the second factor")
+	body := rr.Body.String()
+	assert.Contains(t, body, `<img src="data:image/png;base64,`)
+	secret := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindStringSubmatch(body)
+	require.Len(t, secret, 2)
+	pending := pendingToken(t, body)
+
+	rr = post(url.Values{"pending": {pending}, "code": {"123456"}})
+	assert.Contains(t, rr.Body.String(), "Invalid code")
+	assert.Contains(t, rr.Body.String(), secret[1], "enrollment offered again")
+	assert.Nil(t, authCookie(rr))
+
+	code, err := totp.GenerateCode(secret[1], time.Now())
+	require.NoError(t, err)
+	rr = post(url.Values{"pending": {pending}, "code": {code}})
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NotNil(t, authCookie(rr))
+	recovery := regexp.MustCompile(`<li><code>([a-z2-9-]+)</code></li>`).FindAllStringSubmatch(rr.Body.String(), -1)
+	require.Len(t, recovery, totpRecoveryCodes)
+	assert.True(t, store.enrolled("weblist"))
+
+	// next login asks for the code only
+	rr = post(url.Values{"username": {"weblist"}, "password": {"password"}})
+	assert.NotContains(t, rr.Body.String(), "data:image/png")
+	pending = pendingToken(t, rr.Body.String())
+
+	rr = post(url.Values{"pending": {pending}, "code": {code}})
+	assert.Contains(t, rr.Body.String(), "Invalid code", "enrollment code can't be reused")
+	rr = post(url.Values{"pending": {pending}, "code": {recovery[0][1]}})
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.NotNil(t, authCookie(rr))
+
+	// pending token must be valid and fresh
+	rr = post(url.Values{"pending": {"bad.token.value"}, "code": {code}})
+	assert.Contains(t, rr.Body.String(), "Login expired")
+	rr = post(url.Values{"pending": {srv.generateToken("", "weblist")}, "code": {recovery[1][1]}})
+	assert.Contains(t, rr.Body.String(), "Login expired", "session token is not a pending token")
+
+	// basic auth can't skip the second factor
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.SetBasicAuth("weblist", "password")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/login", rr.Header().Get("Location"))
+}
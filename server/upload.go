@@ -5,13 +5,26 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// uploadTempPrefix starts names of files uploaded in overwrite mode until they are complete and renamed,
+// such files are hidden from listings and downloads
+const uploadTempPrefix = ".weblist-upload-"
+
+// isUploadTemp checks the path is a file being uploaded
+func isUploadTemp(p string) bool {
+	return strings.HasPrefix(path.Base(filepath.ToSlash(p)), uploadTempPrefix)
+}
+
 // uploadResponse represents the JSON response for upload operations
 type uploadResponse struct {
 	Uploaded []string `json:"uploaded,omitempty"`
@@ -20,15 +33,26 @@ type uploadResponse struct {
 
 // handleUpload handles file upload requests via multipart/form-data.
 // it accepts one or more files and a target directory path, validates inputs,
-// and writes files to the filesystem under RootDir.
+// and writes files to the storage of served files, or to the storage of the target's mount.
 func (wb *Web) handleUpload(w http.ResponseWriter, r *http.Request) {
-	if !wb.EnableUpload {
+	if !wb.uploadAvailable() {
 		wb.writeJSONError(w, http.StatusForbidden, "upload is disabled")
 		return
 	}
 
-	// apply size limit to the request body
-	r.Body = http.MaxBytesReader(w, r.Body, wb.UploadMaxSize)
+	// record the outcome from the final response status, successful files are audited one by one below
+	var targetPath string // set once the form is parsed
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	w = sw
+	defer func() {
+		wb.Metrics.observeUpload(sw.status)
+		if sw.status >= http.StatusBadRequest {
+			wb.auditHTTP(r, AuditRecord{Action: "upload", Path: targetPath}, sw)
+		}
+	}()
+
+	// apply size limit to the request body, the limit of the target mount is checked once the form is parsed
+	r.Body = http.MaxBytesReader(w, r.Body, wb.maxUploadSize())
 
 	// parse multipart form with 10MB in-memory buffer
 	if err := r.ParseMultipartForm(10 << 20); err != nil { //nolint:gosec // G120: body already bounded by MaxBytesReader above
@@ -48,11 +72,15 @@ func (wb *Web) handleUpload(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	// get and validate target directory path
-	targetPath := r.FormValue("path")
+	targetPath = r.FormValue("path")
 	if targetPath == "" {
 		targetPath = "."
 	}
 
+	if !wb.requireMountAuth(w, r, targetPath) {
+		return
+	}
+
 	cleanPath, err := wb.validateUploadPath(targetPath)
 	if err != nil {
 		if ue, ok := errors.AsType[*uploadError](err); ok {
@@ -71,6 +99,21 @@ func (wb *Web) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// mounts have their own upload settings, the target directory decides which apply
+	settings := wb.uploadSettingsFor(cleanPath)
+	if !settings.enabled {
+		wb.writeJSONError(w, http.StatusForbidden, "upload is disabled for target directory")
+		return
+	}
+	var total int64
+	for _, fh := range files {
+		total += fh.Size
+	}
+	if total > settings.maxSize {
+		wb.writeJSONError(w, http.StatusRequestEntityTooLarge, "file too large")
+		return
+	}
+
 	var uploaded []string
 	for _, fh := range files {
 		// validate filename
@@ -79,7 +122,7 @@ func (wb *Web) handleUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		destPath := filepath.Join(wb.RootDir, cleanPath, fh.Filename)
+		destPath := path.Join(settings.relPath, fh.Filename)
 
 		// open the uploaded file
 		src, err := fh.Open()
@@ -89,8 +132,8 @@ func (wb *Web) handleUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// write the file to disk
-		if err := wb.writeUploadedFile(destPath, src, wb.UploadOverwrite); err != nil {
+		// write the file to storage
+		if err := wb.writeUploadedFile(settings.storage, destPath, src, settings.overwrite); err != nil {
 			_ = src.Close()
 			if ue, ok := errors.AsType[*uploadError](err); ok {
 				wb.writeJSONError(w, ue.status, ue.Error())
@@ -102,8 +145,14 @@ func (wb *Web) handleUpload(w http.ResponseWriter, r *http.Request) {
 		}
 		_ = src.Close()
 
+		// let the recursive mtime index see the new file before its next rescan
+		if wb.dirIndex != nil {
+			wb.dirIndex.touch(path.Join(cleanPath, fh.Filename), fh.Size, time.Now())
+		}
+
 		uploaded = append(uploaded, fh.Filename)
-		log.Printf("[INFO] uploaded file %q to %s", fh.Filename, destPath)
+		log.Printf("[INFO] uploaded file %q to %s", fh.Filename, path.Join(cleanPath, fh.Filename))
+		wb.auditHTTP(r, AuditRecord{Action: "upload", Path: path.Join(cleanPath, fh.Filename), Bytes: fh.Size}, sw)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -121,13 +170,14 @@ type uploadError struct {
 func (e *uploadError) Error() string { return e.msg }
 
 // validateUploadPath cleans and validates the target directory path for upload.
-// it returns the cleaned path relative to RootDir, or an uploadError with an appropriate HTTP status code.
-func (wb *Web) validateUploadPath(path string) (string, error) {
+// it returns the cleaned path relative to the served root, or an uploadError with an appropriate HTTP status code.
+// the target is checked in the storage it's written to, which keeps symlinks from leading outside its root.
+func (wb *Web) validateUploadPath(dir string) (string, error) {
 	// clean the path
-	cleanPath := filepath.ToSlash(filepath.Clean(path))
+	cleanPath := filepath.ToSlash(filepath.Clean(dir))
 
 	// reject absolute paths
-	if filepath.IsAbs(path) {
+	if filepath.IsAbs(dir) {
 		return "", &uploadError{http.StatusBadRequest, "absolute paths are not allowed"}
 	}
 
@@ -141,12 +191,17 @@ func (wb *Web) validateUploadPath(path string) (string, error) {
 		return "", &uploadError{http.StatusForbidden, "access denied to target directory"}
 	}
 
-	// verify the target directory exists and is within RootDir
-	absTarget := filepath.Join(wb.RootDir, cleanPath)
-	absTarget = filepath.Clean(absTarget)
+	// the virtual root of mounts is not backed by any storage
+	if m, _ := findMount(wb.Mounts, cleanPath); len(wb.Mounts) > 0 && m == nil {
+		return "", &uploadError{http.StatusForbidden, "upload is not allowed to the root of mounts"}
+	}
+	settings := wb.uploadSettingsFor(cleanPath)
+	if settings.storage == nil {
+		return "", &uploadError{http.StatusForbidden, "storage is read-only"}
+	}
 
-	// check that target directory exists
-	info, err := os.Stat(absTarget)
+	// check that target directory exists, storage rejects paths resolving outside its root as missing
+	info, err := fs.Stat(settings.storage, settings.relPath)
 	if err != nil {
 		return "", &uploadError{http.StatusBadRequest, fmt.Sprintf("target directory does not exist: %s", cleanPath)}
 	}
@@ -154,21 +209,6 @@ func (wb *Web) validateUploadPath(path string) (string, error) {
 		return "", &uploadError{http.StatusBadRequest, "target path is not a directory"}
 	}
 
-	// resolve symlinks and verify real path is still within RootDir
-	realTarget, err := filepath.EvalSymlinks(absTarget)
-	if err != nil {
-		return "", &uploadError{http.StatusBadRequest, fmt.Sprintf("cannot resolve target path: %s", cleanPath)}
-	}
-	realRoot, err := filepath.EvalSymlinks(wb.RootDir)
-	if err != nil {
-		return "", &uploadError{http.StatusInternalServerError, "cannot resolve root directory"}
-	}
-
-	// ensure resolved target is within resolved root
-	if realTarget != realRoot && !strings.HasPrefix(realTarget, realRoot+string(filepath.Separator)) {
-		return "", &uploadError{http.StatusBadRequest, "path traversal is not allowed"}
-	}
-
 	return cleanPath, nil
 }
 
@@ -183,47 +223,51 @@ func (wb *Web) validateFilename(name string) error {
 	if strings.Contains(name, "/") || strings.Contains(name, "\\") {
 		return fmt.Errorf("contains path separator")
 	}
+	if strings.HasPrefix(name, uploadTempPrefix) {
+		return fmt.Errorf("reserved for files being uploaded")
+	}
 	return nil
 }
 
-// writeUploadedFile writes the uploaded content to the destination path.
-// when overwrite is false, it uses O_EXCL to atomically fail if the file exists.
-// on write failure for non-overwrite mode, newly created files are removed.
-// in overwrite mode, cleanup is skipped to avoid removing files written by concurrent requests.
-func (wb *Web) writeUploadedFile(destPath string, src io.Reader, overwrite bool) error {
-	// reject symlinks in overwrite mode to prevent writing outside RootDir
+// writeUploadedFile writes the uploaded content to the destination path in the storage.
+// when overwrite is false, Create atomically fails if the file exists and a partially written file is removed.
+// in overwrite mode, content goes to a temporary file renamed over the destination once complete,
+// so readers and concurrent uploads never see a half-written file.
+func (wb *Web) writeUploadedFile(storage Storage, destPath string, src io.Reader, overwrite bool) error {
+	name := path.Base(destPath)
+	target := destPath
 	if overwrite {
-		if fi, err := os.Lstat(destPath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
-			return &uploadError{http.StatusBadRequest, fmt.Sprintf("refusing to overwrite symlink: %s", filepath.Base(destPath))}
-		}
+		target = path.Join(path.Dir(destPath), uploadTempPrefix+uuid.NewString()+"-"+name)
 	}
 
-	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
-	if !overwrite {
-		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
-	}
-
-	dst, err := os.OpenFile(destPath, flags, 0o644) //nolint:gosec // path is validated by validateUploadPath
+	dst, err := storage.Create(target)
 	if err != nil {
-		if errors.Is(err, os.ErrExist) {
-			return &uploadError{http.StatusConflict, fmt.Sprintf("file %q already exists", filepath.Base(destPath))}
+		if errors.Is(err, fs.ErrExist) {
+			return &uploadError{http.StatusConflict, fmt.Sprintf("file %q already exists", name)}
 		}
 		return fmt.Errorf("failed to create file: %w", err)
 	}
 
 	if _, err := io.Copy(dst, src); err != nil {
-		_ = dst.Close()
-		if !overwrite {
-			_ = os.Remove(destPath) // safe to remove: O_EXCL guarantees we created this file
-		}
+		abortWrite(dst, err)       // a truncated file is not completed
+		_ = storage.Remove(target) // safe to remove: Create guarantees we made this file
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
 	if err := dst.Close(); err != nil {
-		if !overwrite {
-			_ = os.Remove(destPath) // safe to remove: O_EXCL guarantees we created this file
-		}
+		_ = storage.Remove(target) // safe to remove: Create guarantees we made this file
 		return fmt.Errorf("failed to close file: %w", err)
 	}
+
+	if !overwrite {
+		return nil
+	}
+	if err := storage.Rename(target, destPath); err != nil {
+		_ = storage.Remove(target)
+		if errors.Is(err, errSymlinkTarget) {
+			return &uploadError{http.StatusBadRequest, fmt.Sprintf("refusing to overwrite symlink: %s", name)}
+		}
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -212,6 +213,62 @@ func TestHandleUpload_ExcludedPath(t *testing.T) {
 	assert.Equal(t, http.StatusForbidden, rr.Code)
 }
 
+func TestHandleUpload_TempFilesHidden(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".weblist-upload-1234-a.txt"), []byte("partial"), 0o600))
+
+	srv := &Web{Config: Config{RootDir: tmpDir, Title: "Test", EnableUpload: true, UploadMaxSize: 10 << 20}, FS: os.DirFS(tmpDir)}
+	router, err := srv.router()
+	require.NoError(t, err)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	for _, target := range []string{"/", "/api/list"} {
+		rr := get(target)
+		require.Equal(t, http.StatusOK, rr.Code, target)
+		assert.Contains(t, rr.Body.String(), "a.txt", target)
+		assert.NotContains(t, rr.Body.String(), ".weblist-upload-", target)
+	}
+	assert.Equal(t, http.StatusForbidden, get("/.weblist-upload-1234-a.txt").Code, "not downloadable")
+}
+
+type failingReader struct{ data string }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, fmt.Errorf("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWriteUploadedFile_Aborted(t *testing.T) {
+	s := setupS3Storage(t, "", map[string]string{"old.txt": "old content"})
+	srv := &Web{}
+
+	for _, overwrite := range []bool{false, true} {
+		err := srv.writeUploadedFile(s, "new.txt", &failingReader{data: "partial"}, overwrite)
+		require.Error(t, err)
+		_, err = fs.Stat(s, "new.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist, "truncated object is not created")
+
+		err = srv.writeUploadedFile(s, "old.txt", &failingReader{data: "partial"}, true)
+		require.Error(t, err)
+		data, err := fs.ReadFile(s, "old.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "old content", string(data), "replaced file is kept")
+
+		entries, err := fs.ReadDir(s, ".")
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "no temporary objects left")
+	}
+}
+
 func TestHandleUpload_Subdirectory(t *testing.T) {
 	tmpDir := t.TempDir()
 	subDir := filepath.Join(tmpDir, "subdir")
@@ -364,6 +421,7 @@ func TestValidateFilename(t *testing.T) {
 		{"dot-dot", "../evil", true},
 		{"slash", "sub/file", true},
 		{"backslash", "sub\\file", true},
+		{"upload temp prefix", ".weblist-upload-file.txt", true},
 	}
 
 	for _, tt := range tests {
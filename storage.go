@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/umputun/weblist/server"
+)
+
+// s3Scheme prefixes root and mount locations served from S3-compatible object storage
+const s3Scheme = "s3://"
+
+// gitScheme prefixes a root served from a git repository at a ref
+const gitScheme = "git:"
+
+// isS3Location returns true if the location is an S3 bucket rather than a local directory
+func isS3Location(loc string) bool {
+	return strings.HasPrefix(loc, s3Scheme)
+}
+
+// parseS3Location splits s3://bucket[/prefix] into the bucket and the key prefix
+func parseS3Location(loc string) (bucket, prefix string, err error) {
+	bucket, prefix, _ = strings.Cut(strings.TrimPrefix(loc, s3Scheme), "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("invalid S3 location %q, expected s3://bucket[/prefix]", loc)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// isGitLocation returns true if the location is a git repository rather than a local directory
+func isGitLocation(loc string) bool {
+	return strings.HasPrefix(loc, gitScheme)
+}
+
+// parseGitLocation splits git:/path/repo[@ref] into the repository path and the ref, empty for HEAD.
+// the ref is separated by the last "@", so the path may contain it.
+func parseGitLocation(loc string) (repoPath, ref string, err error) {
+	repoPath = strings.TrimPrefix(loc, gitScheme)
+	if i := strings.LastIndex(repoPath, "@"); i >= 0 {
+		repoPath, ref = repoPath[:i], repoPath[i+1:]
+		if ref == "" {
+			return "", "", fmt.Errorf("invalid git location %q, empty ref after @", loc)
+		}
+	}
+	if repoPath == "" {
+		return "", "", fmt.Errorf("invalid git location %q, expected git:/path/repo[@ref]", loc)
+	}
+	return repoPath, ref, nil
+}
+
+// newGitRepo opens the repository of a git:/path/repo[@ref] location
+func newGitRepo(loc string) (*server.GitRepo, error) {
+	repoPath, ref, err := parseGitLocation(loc)
+	if err != nil {
+		return nil, err
+	}
+	absPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for %s: %w", repoPath, err)
+	}
+	return server.OpenGitRepo(absPath, ref)
+}
+
+// newStorage makes storage for a root or mount location, either a local directory or s3://bucket[/prefix]
+// using the S3 connection options
+func newStorage(ctx context.Context, loc string, o *options) (server.Storage, error) {
+	if !isS3Location(loc) {
+		absDir, err := filepath.Abs(loc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %w", loc, err)
+		}
+		return server.NewLocalStorage(absDir)
+	}
+
+	bucket, prefix, err := parseS3Location(loc)
+	if err != nil {
+		return nil, err
+	}
+	return server.NewS3Storage(ctx, server.S3Opts{
+		Endpoint:  o.S3.Endpoint,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		Region:    o.S3.Region,
+		AccessKey: o.S3.AccessKey,
+		SecretKey: o.S3.SecretKey,
+		Insecure:  o.S3.Insecure,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseS3Location(t *testing.T) {
+	tests := []struct {
+		loc, bucket, prefix string
+		wantErr             bool
+	}{
+		{loc: "s3://releases", bucket: "releases"},
+		{loc: "s3://releases/", bucket: "releases"},
+		{loc: "s3://releases/app/builds/", bucket: "releases", prefix: "app/builds"},
+		{loc: "s3://", wantErr: true},
+		{loc: "s3:///prefix", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.loc, func(t *testing.T) {
+			assert.True(t, isS3Location(tc.loc))
+			bucket, prefix, err := parseS3Location(tc.loc)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.bucket, bucket)
+			assert.Equal(t, tc.prefix, prefix)
+		})
+	}
+	assert.False(t, isS3Location("/srv/s3://data"))
+}
+
+func TestParseGitLocation(t *testing.T) {
+	tests := []struct {
+		loc, repoPath, ref string
+		wantErr            bool
+	}{
+		{loc: "git:/srv/repo", repoPath: "/srv/repo"},
+		{loc: "git:/srv/repo@main", repoPath: "/srv/repo", ref: "main"},
+		{loc: "git:/srv/user@host/repo@v1.2.0", repoPath: "/srv/user@host/repo", ref: "v1.2.0"},
+		{loc: "git:repo@HEAD~1", repoPath: "repo", ref: "HEAD~1"},
+		{loc: "git:/srv/repo@", wantErr: true},
+		{loc: "git:", wantErr: true},
+		{loc: "git:@main", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.loc, func(t *testing.T) {
+			assert.True(t, isGitLocation(tc.loc))
+			repoPath, ref, err := parseGitLocation(tc.loc)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.repoPath, repoPath)
+			assert.Equal(t, tc.ref, ref)
+		})
+	}
+	assert.False(t, isGitLocation("/srv/git:repo"))
+}
+
+func TestNewStorage(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0o600))
+
+	var o options
+	s, err := newStorage(context.Background(), dir, &o)
+	require.NoError(t, err)
+	data, err := fs.ReadFile(s, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	_, err = newStorage(context.Background(), filepath.Join(dir, "missing"), &o)
+	require.Error(t, err)
+	_, err = newStorage(context.Background(), "s3://", &o)
+	require.ErrorContains(t, err, "invalid S3 location")
+}